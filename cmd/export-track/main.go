@@ -0,0 +1,170 @@
+// export-track は保存済みの位置ログを匿名化して、到着判定のテスト用フィクスチャ（internal/judge/testdata/*.csv）の形式で書き出す
+// 実機で記録した走行（マルチパス・トンネルでの欠落・送信間隔のゆらぎを含む）をそのままテストに使うために使う
+// 座標は最初の停車地が -origin に来るように平行移動し、日付は -fixture-date に置き換え、端末IDは書き出さない
+// 停車地は名前を伏せて、平行移動後の座標を標準エラーに表示する
+//
+//	go run ./cmd/export-track -project 1 -date 2025-12-01 -course A便 [-run 3] [-out track.csv] [-db ./app.db]
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/visits"
+	_ "modernc.org/sqlite"
+)
+
+// jst は時刻の書き出しに使う日本標準時
+var jst = time.FixedZone("Asia/Tokyo", 9*60*60)
+
+func main() {
+	// コマンドライン引数
+	projectID := flag.Int64("project", 0, "プロジェクトID（必須）")
+	dateStr := flag.String("date", "", "運行日（YYYY-MM-DD形式、必須）")
+	courseName := flag.String("course", "", "コース名（必須）")
+	runID := flag.Int64("run", 0, "運行ID（省略時は運行日の全ログ）")
+	outPath := flag.String("out", "", "書き出すCSVファイル（省略時は標準出力）")
+	dbPath := flag.String("db", "./app.db", "DBファイルパス")
+
+	// 匿名化の設定
+	originLat := flag.Float64("origin-lat", 35.6812, "最初の停車地を移す緯度")
+	originLon := flag.Float64("origin-lon", 139.7671, "最初の停車地を移す経度")
+	fixtureDate := flag.String("fixture-date", "2025-12-01", "書き出す日付（運行日をこの日に置き換える）")
+	flag.Parse()
+
+	if *projectID == 0 || *dateStr == "" || *courseName == "" {
+		fmt.Fprintln(os.Stderr, "エラー: -project と -date と -course は必須です")
+		flag.Usage()
+		os.Exit(1)
+	}
+	operatingDay, err := time.ParseInLocation("2006-01-02", *dateStr, jst)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "エラー: -date の形式が正しくありません: %v\n", err)
+		os.Exit(1)
+	}
+	targetDay, err := time.ParseInLocation("2006-01-02", *fixtureDate, jst)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "エラー: -fixture-date の形式が正しくありません: %v\n", err)
+		os.Exit(1)
+	}
+	// 運行日の0時からの経過時間を保つ（0時をまたぐ運行は翌日のまま）
+	shift := targetDay.Sub(operatingDay)
+
+	// DB接続（読み取りのみ）
+	db, err := sql.Open("sqlite", "file:"+*dbPath+"?_pragma=busy_timeout(5000)&_pragma=foreign_keys(on)")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "エラー: DB接続に失敗: %v\n", err)
+		os.Exit(1)
+	}
+	defer db.Close()
+
+	queries := database.New(db)
+	ctx := context.Background()
+
+	var run *database.CourseRun
+	if *runID != 0 {
+		r, err := queries.GetCourseRun(ctx, *runID)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "エラー: 運行が見つかりません: %v\n", err)
+			os.Exit(1)
+		}
+		if r.ProjectID != *projectID || r.OperatingDate != *dateStr || r.CourseName != *courseName {
+			fmt.Fprintln(os.Stderr, "エラー: 運行が指定のプロジェクト・運行日・コースのものではありません")
+			os.Exit(1)
+		}
+		run = &r
+	}
+
+	logs, err := visits.ListRunLogs(ctx, queries, *projectID, *dateStr, *courseName, run)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "エラー: 位置ログの取得に失敗: %v\n", err)
+		os.Exit(1)
+	}
+	if len(logs) == 0 {
+		fmt.Fprintln(os.Stderr, "エラー: 位置ログがありません")
+		os.Exit(1)
+	}
+
+	allStops, err := queries.ListRouteStopsByProjectDate(ctx, database.ListRouteStopsByProjectDateParams{
+		ProjectID:     *projectID,
+		OperatingDate: *dateStr,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "エラー: 停車地の取得に失敗: %v\n", err)
+		os.Exit(1)
+	}
+	var stops []database.RouteStop
+	for _, stop := range allStops {
+		if stop.CourseName == *courseName {
+			stops = append(stops, stop)
+		}
+	}
+
+	// 平行移動の基準は座標のある最初の停車地（なければ最初のログ）
+	anchorLat, anchorLon := logs[0].Latitude, logs[0].Longitude
+	for _, stop := range stops {
+		if stop.Latitude.Valid && stop.Longitude.Valid {
+			anchorLat, anchorLon = stop.Latitude.Float64, stop.Longitude.Float64
+			break
+		}
+	}
+	move := func(lat, lon float64) (float64, float64) {
+		return lat - anchorLat + *originLat, lon - anchorLon + *originLon
+	}
+
+	var out io.Writer = os.Stdout
+	if *outPath != "" {
+		f, err := os.Create(*outPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "エラー: ファイルを作成できません: %v\n", err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		out = f
+	}
+
+	w := csv.NewWriter(out)
+	w.Write([]string{"timestamp", "latitude", "longitude", "speed_mps", "accuracy_m"})
+	for _, log := range logs {
+		lat, lon := move(log.Latitude, log.Longitude)
+		w.Write([]string{
+			log.Timestamp.Add(shift).In(jst).Format(time.RFC3339),
+			strconv.FormatFloat(lat, 'f', 7, 64),
+			strconv.FormatFloat(lon, 'f', 7, 64),
+			optionalFloat(log.Speed, 1/3.6, 1), // 受信時に km/h へそろえた速度を端末の m/s に戻す
+			optionalFloat(log.Accuracy, 1, 0),
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		fmt.Fprintf(os.Stderr, "エラー: 書き出しに失敗: %v\n", err)
+		os.Exit(1)
+	}
+
+	// 停車地は名前を伏せて順番と平行移動後の座標だけを表示する
+	fmt.Fprintf(os.Stderr, "位置ログ %d件を書き出しました（%s → %s）\n", len(logs), *dateStr, *fixtureDate)
+	for i, stop := range stops {
+		if !stop.Latitude.Valid || !stop.Longitude.Valid {
+			fmt.Fprintf(os.Stderr, "  %s\t停車地%d\t（座標なし）\n", stop.Sequence, i+1)
+			continue
+		}
+		lat, lon := move(stop.Latitude.Float64, stop.Longitude.Float64)
+		fmt.Fprintf(os.Stderr, "  %s\t停車地%d\t%.7f, %.7f\n", stop.Sequence, i+1, lat, lon)
+	}
+}
+
+// optionalFloat は値に scale を掛けて書き出す（NULLは空欄）
+func optionalFloat(v sql.NullFloat64, scale float64, prec int) string {
+	if !v.Valid {
+		return ""
+	}
+	return strconv.FormatFloat(v.Float64*scale, 'f', prec, 64)
+}
@@ -0,0 +1,124 @@
+// rebuild-visits は保存済みの到着判定（stop_visits）を位置ログから作り直す
+// 判定設定や停車地の座標を変えた後に、画面を開く前にまとめて判定し直すために使う
+// サーバーの稼働中に実行してもよい（受信での判定の更新と同時に保存しようとしたら、読み込みからやり直す）
+//
+//	go run ./cmd/rebuild-visits -project 1 [-date 2025-12-01] [-db ./app.db]
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/visits"
+	_ "modernc.org/sqlite"
+)
+
+func main() {
+	// コマンドライン引数
+	projectID := flag.Int64("project", 0, "プロジェクトID（必須）")
+	dateStr := flag.String("date", "", "運行日（YYYY-MM-DD形式、省略時はすべての運行日）")
+	dbPath := flag.String("db", "./app.db", "DBファイルパス")
+	flag.Parse()
+
+	if *projectID == 0 {
+		fmt.Fprintln(os.Stderr, "エラー: -project は必須です")
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	// DB接続（サーバーの稼働中でも実行できるように書き込み待ちを許す）
+	db, err := sql.Open("sqlite", "file:"+*dbPath+"?_pragma=busy_timeout(5000)&_pragma=foreign_keys(on)")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "エラー: DB接続に失敗: %v\n", err)
+		os.Exit(1)
+	}
+	defer db.Close()
+
+	queries := database.New(db)
+	store := visits.NewStore(queries, db)
+	ctx := context.Background()
+
+	project, err := queries.GetProject(ctx, *projectID)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "エラー: プロジェクトが見つかりません: %v\n", err)
+		os.Exit(1)
+	}
+
+	dates := []string{*dateStr}
+	if *dateStr == "" {
+		dates, err = queries.ListOperatingDatesByProject(ctx, project.ID)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "エラー: 運行日の取得に失敗: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	fmt.Printf("プロジェクト: %s\n", project.Name)
+	rebuilt := 0
+	for _, operatingDate := range dates {
+		stops, err := queries.ListRouteStopsByProjectDate(ctx, database.ListRouteStopsByProjectDateParams{
+			ProjectID:     project.ID,
+			OperatingDate: operatingDate,
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "エラー: 停車地の取得に失敗: %v\n", err)
+			os.Exit(1)
+		}
+
+		// コースごとに分ける（並び順はクエリで保証済み）
+		var courseNames []string
+		byCourse := make(map[string][]database.RouteStop)
+		for _, stop := range stops {
+			if _, ok := byCourse[stop.CourseName]; !ok {
+				courseNames = append(courseNames, stop.CourseName)
+			}
+			byCourse[stop.CourseName] = append(byCourse[stop.CourseName], stop)
+		}
+
+		for _, courseName := range courseNames {
+			runs, err := queries.ListCourseRuns(ctx, database.ListCourseRunsParams{
+				ProjectID:     project.ID,
+				OperatingDate: operatingDate,
+				CourseName:    courseName,
+			})
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "エラー: 運行の取得に失敗: %v\n", err)
+				os.Exit(1)
+			}
+
+			// 運行がなければ運行日の全ログで判定する
+			scopes := []visits.Scope{{Project: project, OperatingDate: operatingDate, CourseName: courseName}}
+			if len(runs) > 0 {
+				scopes = scopes[:0]
+				for i := range runs {
+					scopes = append(scopes, visits.Scope{Project: project, OperatingDate: operatingDate, CourseName: courseName, Run: &runs[i]})
+				}
+			}
+
+			for _, sc := range scopes {
+				result, err := store.Rebuild(ctx, sc, byCourse[courseName])
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "エラー: %s %s の判定に失敗: %v\n", operatingDate, courseName, err)
+					os.Exit(1)
+				}
+				arrived := 0
+				for _, timing := range result.Timings {
+					if timing.Arrived {
+						arrived++
+					}
+				}
+				label := "運行なし"
+				if sc.Run != nil {
+					label = fmt.Sprintf("運行 #%d", sc.Run.ID)
+				}
+				fmt.Printf("  %s %s（%s）: 到着 %d / %d地点\n", operatingDate, courseName, label, arrived, len(byCourse[courseName]))
+				rebuilt++
+			}
+		}
+	}
+	fmt.Printf("完了: %d件の運行を判定し直しました\n", rebuilt)
+}
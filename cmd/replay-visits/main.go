@@ -0,0 +1,228 @@
+// replay-visits は保存済みの位置ログを別の判定設定で判定し直し、案件の現在の設定との違いを表示する
+// 判定設定（到着判定距離・判定滞在時間・判定速度上限など）を案件に適用する前に、どの停車地の判定が変わるかを確かめるために使う
+// 何も保存しない。指定しなかった設定は案件の現在の設定を使う
+//
+//	go run ./cmd/replay-visits -project 1 -date 2025-12-01 [-course A便] -stay 3 -speed 10 [-all] [-db ./app.db]
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/judge"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/visits"
+	_ "modernc.org/sqlite"
+)
+
+// jst は到着・出発時刻の表示に使う日本標準時
+var jst = time.FixedZone("Asia/Tokyo", 9*60*60)
+
+func main() {
+	// コマンドライン引数
+	projectID := flag.Int64("project", 0, "プロジェクトID（必須）")
+	dateStr := flag.String("date", "", "運行日（YYYY-MM-DD形式、必須）")
+	courseName := flag.String("course", "", "コース名（省略時は運行日のすべてのコース）")
+	dbPath := flag.String("db", "./app.db", "DBファイルパス")
+	showAll := flag.Bool("all", false, "判定が変わらない停車地も表示する")
+
+	// 試す判定設定（指定したものだけ案件の設定を置き換える）
+	threshold := flag.Int64("threshold", 0, "到着判定距離（m）")
+	stay := flag.Int64("stay", 0, "判定滞在時間（分）")
+	speed := flag.Float64("speed", 0, "判定速度上限（km/h、0=速度を見ない）")
+	window := flag.Int64("window", 0, "順番のずれの許容（地点数、0=各地点を独立に判定）")
+	maxAccuracy := flag.Float64("max-accuracy", 0, "測位精度の上限（m、0=精度を見ない）")
+	accuracyMode := flag.String("accuracy-mode", "", "精度の悪い測位の扱い（discard・weight）")
+	minFixes := flag.Int64("min-fixes", 0, "出入りを確定する連続の測位数")
+	exitMargin := flag.Int64("exit-margin", 0, "出発とみなすまでの余裕（m）")
+	bearing := flag.Bool("bearing", false, "進行方向を使う")
+	flag.Parse()
+
+	if *projectID == 0 || *dateStr == "" {
+		fmt.Fprintln(os.Stderr, "エラー: -project と -date は必須です")
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	// DB接続（読み取りのみ）
+	db, err := sql.Open("sqlite", "file:"+*dbPath+"?_pragma=busy_timeout(5000)&_pragma=foreign_keys(on)")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "エラー: DB接続に失敗: %v\n", err)
+		os.Exit(1)
+	}
+	defer db.Close()
+
+	queries := database.New(db)
+	ctx := context.Background()
+
+	project, err := queries.GetProject(ctx, *projectID)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "エラー: プロジェクトが見つかりません: %v\n", err)
+		os.Exit(1)
+	}
+
+	current := judge.SettingsFromProject(project)
+	candidate := current
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "threshold":
+			candidate.ThresholdM = *threshold
+		case "stay":
+			candidate.StayMinutes = *stay
+		case "speed":
+			candidate.SpeedLimitKmh = *speed
+		case "window":
+			candidate.SequenceWindow = *window
+		case "max-accuracy":
+			candidate.MaxAccuracyM = *maxAccuracy
+		case "accuracy-mode":
+			candidate.AccuracyMode = *accuracyMode
+		case "min-fixes":
+			candidate.MinFixes = *minFixes
+		case "exit-margin":
+			candidate.ExitMarginM = *exitMargin
+		case "bearing":
+			candidate.UseBearing = *bearing
+		}
+	})
+	if candidate.AccuracyMode != "" && candidate.AccuracyMode != judge.AccuracyDiscard && candidate.AccuracyMode != judge.AccuracyWeight {
+		fmt.Fprintln(os.Stderr, "エラー: -accuracy-mode は discard か weight を指定してください")
+		os.Exit(1)
+	}
+
+	stops, err := queries.ListRouteStopsByProjectDate(ctx, database.ListRouteStopsByProjectDateParams{
+		ProjectID:     project.ID,
+		OperatingDate: *dateStr,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "エラー: 停車地の取得に失敗: %v\n", err)
+		os.Exit(1)
+	}
+
+	// コースごとに分ける（並び順はクエリで保証済み）
+	var courseNames []string
+	byCourse := make(map[string][]database.RouteStop)
+	for _, stop := range stops {
+		if *courseName != "" && stop.CourseName != *courseName {
+			continue
+		}
+		if _, ok := byCourse[stop.CourseName]; !ok {
+			courseNames = append(courseNames, stop.CourseName)
+		}
+		byCourse[stop.CourseName] = append(byCourse[stop.CourseName], stop)
+	}
+	if len(courseNames) == 0 {
+		fmt.Fprintln(os.Stderr, "エラー: 対象のコースがありません")
+		os.Exit(1)
+	}
+
+	fmt.Printf("プロジェクト: %s（運行日 %s）\n", project.Name, *dateStr)
+	fmt.Printf("現在の設定: %s\n", settingsLabel(current))
+	fmt.Printf("試す設定:   %s\n", settingsLabel(candidate))
+
+	flipped, changed := 0, 0
+	for _, name := range courseNames {
+		runs, err := queries.ListCourseRuns(ctx, database.ListCourseRunsParams{
+			ProjectID:     project.ID,
+			OperatingDate: *dateStr,
+			CourseName:    name,
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "エラー: 運行の取得に失敗: %v\n", err)
+			os.Exit(1)
+		}
+
+		// 運行がなければ運行日の全ログで判定する
+		scopes := []*database.CourseRun{nil}
+		if len(runs) > 0 {
+			scopes = scopes[:0]
+			for i := range runs {
+				scopes = append(scopes, &runs[i])
+			}
+		}
+
+		for _, run := range scopes {
+			logs, err := visits.ListRunLogs(ctx, queries, project.ID, *dateStr, name, run)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "エラー: %s の位置ログの取得に失敗: %v\n", name, err)
+				os.Exit(1)
+			}
+			c := judge.Compare(byCourse[name], logs, current, candidate)
+			flipped += c.Flipped
+			changed += c.Changed
+
+			label := "運行なし"
+			if run != nil {
+				label = fmt.Sprintf("運行 #%d", run.ID)
+			}
+			fmt.Printf("\n%s（%s、ログ %d件）: 到着 %d → %d / %d地点、到着・未到着の変化 %d、判定の変化 %d\n",
+				name, label, len(logs), c.CurrentArrived, c.CandidateArrived, len(c.Stops), c.Flipped, c.Changed)
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			for _, d := range c.Stops {
+				if !*showAll && !d.Changed() {
+					continue
+				}
+				fmt.Fprintf(w, "  %s\t%s\t%s\t→ %s\t%s\n", d.Stop.Sequence, d.Stop.StopName, timingLabel(d.Current), timingLabel(d.Candidate), diffLabel(d))
+			}
+			w.Flush()
+		}
+	}
+	fmt.Printf("\n完了: 到着・未到着が変わる停車地 %d、判定が変わる停車地 %d\n", flipped, changed)
+}
+
+// settingsLabel は判定設定を1行で表す
+func settingsLabel(s judge.Settings) string {
+	return fmt.Sprintf("距離 %dm・滞在 %d分・速度上限 %.1fkm/h・順番のずれ %d・精度上限 %.0fm（%s）・連続 %d件・余裕 %dm・進行方向 %v",
+		s.ThresholdM, s.StayMinutes, s.SpeedLimitKmh, s.SequenceWindow, s.MaxAccuracyM, s.AccuracyMode, max(s.MinFixes, 1), s.ExitMarginM, s.UseBearing)
+}
+
+// timingLabel は判定1件の到着・出発を "08:04:50〜08:13:10" のように表す
+func timingLabel(t *judge.StopTiming) string {
+	if t == nil {
+		return "（座標なし）"
+	}
+	if !t.Arrived || t.ArrivalTime == nil {
+		return "未到着"
+	}
+	departure := "滞在中"
+	if t.DepartureTime != nil {
+		departure = t.DepartureTime.In(jst).Format("15:04:05")
+	}
+	return t.ArrivalTime.In(jst).Format("15:04:05") + "〜" + departure
+}
+
+// diffLabel は設定による判定の変化を表す
+func diffLabel(d judge.StopDiff) string {
+	switch {
+	case d.Flipped() && d.Current != nil && d.Current.Arrived:
+		return "到着 → 未到着"
+	case d.Flipped():
+		return "未到着 → 到着"
+	case !d.Changed():
+		return ""
+	}
+	label := ""
+	if shift, ok := d.ArrivalShift(); ok && shift != 0 {
+		label += "到着 " + shiftLabel(shift) + " "
+	}
+	if shift, ok := d.DepartureShift(); ok && shift != 0 {
+		label += "出発 " + shiftLabel(shift)
+	} else if (d.Current.DepartureTime == nil) != (d.Candidate.DepartureTime == nil) {
+		label += "出発の有無が変わる"
+	}
+	return label
+}
+
+// shiftLabel は時刻のずれを符号つきで表す
+func shiftLabel(d time.Duration) string {
+	if d > 0 {
+		return "+" + d.Round(time.Second).String()
+	}
+	return d.Round(time.Second).String()
+}
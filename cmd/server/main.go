@@ -11,8 +11,10 @@ import (
 
 	"github.com/naozine/project_crud_with_auth_tmpl/db"
 	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/geocode"
 	"github.com/naozine/project_crud_with_auth_tmpl/internal/handlers"
 	"github.com/naozine/project_crud_with_auth_tmpl/internal/logger"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/mailer"
 	"github.com/naozine/project_crud_with_auth_tmpl/internal/mdm"
 	appMiddleware "github.com/naozine/project_crud_with_auth_tmpl/internal/middleware"
 
@@ -150,6 +152,9 @@ func main() {
 	// 3. Initialize MDM Client (optional - works without configuration)
 	mdmClient := initMDMClient()
 
+	// 住所検索API（optional - 無効なら住所辞書だけで座標を求める）
+	httpGeocoder := initGeocoder()
+
 	// 4. Initialize Handlers
 	queries := database.New(conn)
 	// projectHandler := handlers.NewProjectHandler(queries) // Moved to RegisterBusinessRoutes
@@ -180,7 +185,7 @@ func main() {
 	ml.RegisterHandlers(e)
 
 	// Register Business Logic Routes (e.g., projects)
-	RegisterBusinessRoutes(e, queries, ml, mdmClient)
+	projectHandler := RegisterBusinessRoutes(e, conn, queries, ml, mdmClient, httpGeocoder)
 
 	// Admin Routes
 	adminGroup := e.Group("/admin")
@@ -205,6 +210,19 @@ func main() {
 	if port == "" {
 		port = "8080"
 	}
+
+	// 運行中のコースをアラートのルールと照らし合わせるワーカー
+	// アラートの通知メールはログイン用メールと同じSMTPの設定で送る（未設定なら送らない）
+	alertMailer := mailer.New(mailer.Config{
+		Host:     mlConfig.SMTPHost,
+		Port:     mlConfig.SMTPPort,
+		Username: mlConfig.SMTPUsername,
+		Password: mlConfig.SMTPPassword,
+		From:     mlConfig.SMTPFrom,
+		FromName: mlConfig.SMTPFromName,
+	})
+	go handlers.NewAlertWorker(projectHandler, alertMailer, mlConfig.ServerAddr).Run(context.Background())
+
 	log.Fatal(e.Start(":" + port))
 }
 
@@ -283,3 +301,18 @@ func initMDMClient() *mdm.Client {
 	log.Printf("MDM client initialized (base URL: %s, cache TTL: %ds)", mdmBaseURL, cacheTTL)
 	return client
 }
+
+// initGeocoder は環境変数から住所検索APIのクライアントを初期化する
+// 外部サービスへ住所を送るため、GEOCODE_HTTP_ENABLED=true のときだけ有効にする
+func initGeocoder() geocode.Geocoder {
+	if os.Getenv("GEOCODE_HTTP_ENABLED") != "true" {
+		log.Println("Geocoding API not enabled. Only the address dictionary will be used.")
+		return nil
+	}
+	baseURL := os.Getenv("GEOCODE_HTTP_URL")
+	if baseURL == "" {
+		baseURL = geocode.DefaultHTTPURL
+	}
+	log.Printf("Geocoding API enabled (URL: %s)", baseURL)
+	return geocode.NewHTTPGeocoder(baseURL)
+}
@@ -1,10 +1,13 @@
 package main
 
 import (
+	"database/sql"
+
 	"github.com/labstack/echo/v4"
 	"github.com/naozine/nz-magic-link/magiclink"
 	"github.com/naozine/project_crud_with_auth_tmpl/internal/appconfig"
 	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/geocode"
 	"github.com/naozine/project_crud_with_auth_tmpl/internal/handlers"
 	"github.com/naozine/project_crud_with_auth_tmpl/internal/mdm"
 	appMiddleware "github.com/naozine/project_crud_with_auth_tmpl/internal/middleware"
@@ -20,10 +23,11 @@ func ConfigureBusinessSettings(config *magiclink.Config) {
 }
 
 // RegisterBusinessRoutes registers routes for business logic features
-func RegisterBusinessRoutes(e *echo.Echo, queries *database.Queries, ml *magiclink.MagicLink, mdmClient *mdm.Client) {
+// 物流案件のハンドラを返す（アラートのワーカーなど、サーバーと一緒に動かす処理で使う）
+func RegisterBusinessRoutes(e *echo.Echo, conn *sql.DB, queries *database.Queries, ml *magiclink.MagicLink, mdmClient *mdm.Client, httpGeocoder geocode.Geocoder) *handlers.ProjectHandler {
 	// Handlers
-	projectHandler := handlers.NewProjectHandler(queries)
-	locationHandler := handlers.NewLocationHandler(queries)
+	projectHandler := handlers.NewProjectHandler(queries, conn, handlers.NewGeocoder(queries, httpGeocoder))
+	locationHandler := handlers.NewLocationHandler(queries, conn)
 	mdmHandler := handlers.NewMDMHandler(mdmClient)
 
 	// Protected Routes (物流案件機能 - projectsとして上書き)
@@ -42,17 +46,73 @@ func RegisterBusinessRoutes(e *echo.Echo, queries *database.Queries, ml *magicli
 	// Logistics Features (Course and Route Management) within a logistics project
 	projectGroup.GET("/:id/courses/upload", projectHandler.UploadRoutesPage)
 	projectGroup.POST("/:id/courses/upload", projectHandler.UploadRoutes)
+	projectGroup.GET("/:id/imports/diff", projectHandler.DiffRouteImports)
+	projectGroup.GET("/:id/imports/:import_id", projectHandler.PreviewRouteImport)
+	projectGroup.POST("/:id/imports/:import_id/commit", projectHandler.CommitRouteImport)
+	projectGroup.POST("/:id/imports/:import_id/discard", projectHandler.DiscardRouteImport)
+	projectGroup.GET("/:id/imports/:import_id/download", projectHandler.DownloadRouteImport)
+	projectGroup.POST("/:id/imports/:import_id/rollback", projectHandler.RollbackRouteImport)
 	projectGroup.GET("/:id/courses", projectHandler.ListCourses)
+	projectGroup.GET("/:id/reports/time-windows", projectHandler.ShowTimeWindowReport)
+	projectGroup.GET("/:id/alerts", projectHandler.ShowAlerts)
+	projectGroup.GET("/:id/alerts/list", projectHandler.GetAlertList) // htmx polling
+	projectGroup.POST("/:id/alerts/rules", projectHandler.SaveAlertRules)
+	projectGroup.POST("/:id/alerts/acknowledge", projectHandler.AcknowledgeAllAlerts)
+	projectGroup.POST("/:id/alerts/:alert_id/acknowledge", projectHandler.AcknowledgeAlert)
+	projectGroup.GET("/:id/geocode-review", projectHandler.ShowGeocodeReview)
+	projectGroup.POST("/:id/geocode-review/:stop_id/confirm", projectHandler.ConfirmGeocode)
+	projectGroup.GET("/:id/location-suggestions", projectHandler.ShowLocationSuggestions)
+	projectGroup.POST("/:id/location-suggestions/accept", projectHandler.AcceptLocationSuggestion)
+	projectGroup.GET("/:id/courses/export", projectHandler.ExportCourses)
+	projectGroup.POST("/:id/courses/new", projectHandler.CreateCourse)
 	projectGroup.GET("/:id/courses/:course_name", projectHandler.ShowCourse)
+	projectGroup.GET("/:id/courses/:course_name/edit", projectHandler.EditCoursePage)
+	projectGroup.POST("/:id/courses/:course_name/update", projectHandler.UpdateCourse)
+	projectGroup.POST("/:id/courses/:course_name/delete", projectHandler.DeleteCourse)
+	projectGroup.POST("/:id/courses/:course_name/assignment", projectHandler.AssignCourse)
 	projectGroup.GET("/:id/courses/:course_name/location", projectHandler.GetCurrentLocation) // htmx polling
-	projectGroup.POST("/:id/courses/:course_name/reset", projectHandler.ResetCourseStatus)
+	projectGroup.POST("/:id/courses/:course_name/runs", projectHandler.StartCourseRun)
+	projectGroup.POST("/:id/courses/:course_name/runs/:run_id/end", projectHandler.EndCourseRun)
+	projectGroup.GET("/:id/courses/:course_name/replay", projectHandler.ShowJudgeReplay)
+	projectGroup.GET("/:id/courses/:course_name/stops/new", projectHandler.NewStopPage)
+	projectGroup.POST("/:id/courses/:course_name/stops/new", projectHandler.CreateStop)
+	projectGroup.POST("/:id/courses/:course_name/stops/reorder", projectHandler.ReorderStops)
+	projectGroup.GET("/:id/courses/:course_name/optimize", projectHandler.ShowOptimizeRoute)
+	projectGroup.POST("/:id/courses/:course_name/optimize", projectHandler.ApplyOptimizedRoute)
 	projectGroup.GET("/:id/courses/:course_name/stops/:stop_id", projectHandler.ShowStop)
+	projectGroup.GET("/:id/courses/:course_name/stops/:stop_id/edit", projectHandler.EditStopPage)
+	projectGroup.POST("/:id/courses/:course_name/stops/:stop_id/update", projectHandler.UpdateStop)
+	projectGroup.POST("/:id/courses/:course_name/stops/:stop_id/delete", projectHandler.DeleteStop)
 	projectGroup.GET("/:id/courses/:course_name/stops/:stop_id/status", projectHandler.GetStopTruckStatus) // htmx polling
+	projectGroup.POST("/:id/courses/:course_name/stops/:stop_id/override", projectHandler.SaveStopOverride)
+	projectGroup.POST("/:id/courses/:course_name/stops/:stop_id/override/clear", projectHandler.ClearStopOverride)
 
 	// Device Management
 	projectGroup.POST("/:id/devices/:device_id/assign", projectHandler.AssignDeviceCourse)
 	projectGroup.POST("/:id/devices/:device_id/delete", projectHandler.DeleteDevice)
 
+	// Master Data (車両・ドライバー・住所辞書)
+	masterGroup := e.Group("/masters")
+	masterGroup.Use(appMiddleware.RequireAuth(ml, "/auth/login"))
+	masterGroup.GET("/vehicles", projectHandler.ListVehicles)
+	masterGroup.POST("/vehicles/new", projectHandler.CreateVehicle)
+	masterGroup.GET("/vehicles/:vehicle_id/edit", projectHandler.EditVehiclePage)
+	masterGroup.POST("/vehicles/:vehicle_id/update", projectHandler.UpdateVehicle)
+	masterGroup.POST("/vehicles/:vehicle_id/delete", projectHandler.DeleteVehicle)
+	masterGroup.GET("/drivers", projectHandler.ListDrivers)
+	masterGroup.POST("/drivers/new", projectHandler.CreateDriver)
+	masterGroup.GET("/drivers/:driver_id/edit", projectHandler.EditDriverPage)
+	masterGroup.POST("/drivers/:driver_id/update", projectHandler.UpdateDriver)
+	masterGroup.POST("/drivers/:driver_id/delete", projectHandler.DeleteDriver)
+	masterGroup.GET("/geocode", projectHandler.ListGeocodeDictionary)
+	masterGroup.POST("/geocode/new", projectHandler.CreateGeocodeDictionaryEntry)
+	masterGroup.POST("/geocode/:entry_id/delete", projectHandler.DeleteGeocodeDictionaryEntry)
+	masterGroup.GET("/locations", projectHandler.ListLocationMasters)
+	masterGroup.POST("/locations/new", projectHandler.CreateLocationMaster)
+	masterGroup.GET("/locations/:location_id/edit", projectHandler.EditLocationMasterPage)
+	masterGroup.POST("/locations/:location_id/update", projectHandler.UpdateLocationMaster)
+	masterGroup.POST("/locations/:location_id/delete", projectHandler.DeleteLocationMaster)
+
 	// API Routes (for external clients like mobile apps)
 	apiGroup := e.Group("/api/v1")
 	// Note: API authentication (e.g., API Key, Bearer Token) would typically be added here
@@ -71,4 +131,6 @@ func RegisterBusinessRoutes(e *echo.Echo, queries *database.Queries, ml *magicli
 	mdmGroup.GET("/apps", mdmHandler.ListMDMApps)
 	mdmGroup.GET("/api-explorer", mdmHandler.APIExplorer)
 	mdmGroup.POST("/api-explorer/execute", mdmHandler.APIExplorerExecute)
+
+	return projectHandler
 }
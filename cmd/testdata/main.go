@@ -23,7 +23,7 @@ func main() {
 	courseName := flag.String("course", "", "コース名（必須）")
 	interval := flag.Int("interval", 10, "ログ生成間隔（秒）")
 	dbPath := flag.String("db", "./app.db", "DBファイルパス")
-	dateStr := flag.String("date", "", "基準日（YYYY-MM-DD形式、省略時は今日）")
+	dateStr := flag.String("date", "", "基準日＝運行日（YYYY-MM-DD形式、省略時は今日）")
 	flag.Parse()
 
 	// バリデーション
@@ -87,8 +87,9 @@ func main() {
 	// 停車地を取得
 	fmt.Println("停車地を取得中...")
 	stops, err := queries.ListRouteStopsByCourse(ctx, database.ListRouteStopsByCourseParams{
-		ProjectID:  *projectID,
-		CourseName: *courseName,
+		ProjectID:     *projectID,
+		OperatingDate: baseDate.Format("2006-01-02"),
+		CourseName:    *courseName,
 	})
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "エラー: 停車地の取得に失敗: %v\n", err)
@@ -0,0 +1,23 @@
+-- +goose Up
+-- ルートCSV取り込みのプレビュー用テーブルを追加
+CREATE TABLE IF NOT EXISTS route_imports (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    project_id INTEGER NOT NULL,
+    filename TEXT NOT NULL,
+    csv_data BLOB NOT NULL,
+    has_header BOOLEAN NOT NULL DEFAULT 1,
+    skip_departure BOOLEAN NOT NULL DEFAULT 0,
+    adjust_time BOOLEAN NOT NULL DEFAULT 0,
+    start_time TEXT,
+    status TEXT NOT NULL DEFAULT 'pending',
+    row_count INTEGER NOT NULL DEFAULT 0,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    committed_at DATETIME,
+    FOREIGN KEY (project_id) REFERENCES projects(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_route_imports_project ON route_imports(project_id);
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_route_imports_project;
+DROP TABLE IF EXISTS route_imports;
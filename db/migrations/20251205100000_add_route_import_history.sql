@@ -0,0 +1,47 @@
+-- +goose Up
+-- ルート取り込みの履歴化（版番号・取り込み者・ロールバック元）と停車地スナップショットを追加
+ALTER TABLE route_imports ADD COLUMN version INTEGER;
+ALTER TABLE route_imports ADD COLUMN imported_by INTEGER;
+ALTER TABLE route_imports ADD COLUMN rollback_of_version INTEGER;
+
+-- 既存の確定済み取り込みに確定順で版番号を振る
+UPDATE route_imports
+SET version = (
+    SELECT COUNT(*) FROM route_imports r2
+    WHERE r2.project_id = route_imports.project_id
+      AND r2.status = 'committed'
+      AND r2.id <= route_imports.id
+)
+WHERE status = 'committed';
+
+CREATE TABLE IF NOT EXISTS route_import_stops (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    import_id INTEGER NOT NULL,
+    course_name TEXT NOT NULL,
+    sequence TEXT NOT NULL,
+    arrival_time TEXT,
+    stop_name TEXT NOT NULL,
+    address TEXT,
+    latitude REAL,
+    longitude REAL,
+    stay_minutes INTEGER DEFAULT 0,
+    weight_kg INTEGER DEFAULT 0,
+    phone_number TEXT,
+    note1 TEXT,
+    note2 TEXT,
+    note3 TEXT,
+    desired_time_start TEXT,
+    desired_time_end TEXT,
+    FOREIGN KEY (import_id) REFERENCES route_imports(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_route_import_stops_import ON route_import_stops(import_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_route_imports_project_version ON route_imports(project_id, version);
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_route_imports_project_version;
+DROP INDEX IF EXISTS idx_route_import_stops_import;
+DROP TABLE IF EXISTS route_import_stops;
+ALTER TABLE route_imports DROP COLUMN rollback_of_version;
+ALTER TABLE route_imports DROP COLUMN imported_by;
+ALTER TABLE route_imports DROP COLUMN version;
@@ -0,0 +1,41 @@
+-- +goose Up
+-- 運行日（YYYY-MM-DD）を導入し、日ごとに配送ルートと位置情報ログを持てるようにする
+ALTER TABLE route_stops ADD COLUMN operating_date TEXT NOT NULL DEFAULT '';
+ALTER TABLE location_logs ADD COLUMN operating_date TEXT NOT NULL DEFAULT '';
+ALTER TABLE photo_metadata ADD COLUMN operating_date TEXT NOT NULL DEFAULT '';
+ALTER TABLE route_imports ADD COLUMN operating_date TEXT NOT NULL DEFAULT '';
+
+-- 既存データはこれまで案件ごとに1日分しかなかったため、最終取り込み日（日本時間）をその運行日とする
+UPDATE route_stops
+SET operating_date = COALESCE(
+    (SELECT date(p.csv_imported_at, '+9 hours') FROM projects p WHERE p.id = route_stops.project_id),
+    date(route_stops.created_at, '+9 hours')
+);
+UPDATE route_imports
+SET operating_date = COALESCE(
+    (SELECT date(p.csv_imported_at, '+9 hours') FROM projects p WHERE p.id = route_imports.project_id),
+    date(route_imports.created_at, '+9 hours')
+);
+UPDATE location_logs
+SET operating_date = COALESCE(
+    (SELECT MAX(rs.operating_date) FROM route_stops rs WHERE rs.project_id = location_logs.project_id),
+    date(location_logs.timestamp, '+9 hours'),
+    ''
+);
+UPDATE photo_metadata
+SET operating_date = COALESCE(
+    (SELECT MAX(rs.operating_date) FROM route_stops rs WHERE rs.project_id = photo_metadata.project_id),
+    date(photo_metadata.taken_at, '+9 hours'),
+    ''
+);
+
+CREATE INDEX IF NOT EXISTS idx_route_stops_project_date ON route_stops(project_id, operating_date, course_name);
+CREATE INDEX IF NOT EXISTS idx_location_logs_project_date_course ON location_logs(project_id, operating_date, course_name);
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_location_logs_project_date_course;
+DROP INDEX IF EXISTS idx_route_stops_project_date;
+ALTER TABLE route_imports DROP COLUMN operating_date;
+ALTER TABLE photo_metadata DROP COLUMN operating_date;
+ALTER TABLE location_logs DROP COLUMN operating_date;
+ALTER TABLE route_stops DROP COLUMN operating_date;
@@ -0,0 +1,19 @@
+-- +goose Up
+-- コースの運行（ラン）。ログを削除せずに判定をやり直せるよう、運行ごとの開始・終了時刻を記録する
+CREATE TABLE IF NOT EXISTS course_runs (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    project_id INTEGER NOT NULL,
+    operating_date TEXT NOT NULL,
+    course_name TEXT NOT NULL,
+    started_at DATETIME NOT NULL,
+    ended_at DATETIME,
+    started_by INTEGER,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (project_id) REFERENCES projects(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_course_runs_project_date_course ON course_runs(project_id, operating_date, course_name);
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_course_runs_project_date_course;
+DROP TABLE IF EXISTS course_runs;
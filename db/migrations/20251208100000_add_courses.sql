@@ -0,0 +1,56 @@
+-- +goose Up
+-- コースを独立したエンティティにし、車両・ドライバー・出発拠点などを保持できるようにする
+CREATE TABLE IF NOT EXISTS courses (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    project_id INTEGER NOT NULL,
+    name TEXT NOT NULL,
+    vehicle_plate TEXT,
+    driver_name TEXT,
+    driver_phone TEXT,
+    capacity_kg INTEGER,
+    depot_name TEXT,
+    depot_address TEXT,
+    depot_latitude REAL,
+    depot_longitude REAL,
+    planned_start_time TEXT,
+    note TEXT,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (project_id) REFERENCES projects(id) ON DELETE CASCADE,
+    UNIQUE(project_id, name)
+);
+
+CREATE INDEX IF NOT EXISTS idx_courses_project ON courses(project_id);
+
+ALTER TABLE route_stops ADD COLUMN course_id INTEGER REFERENCES courses(id) ON DELETE SET NULL;
+ALTER TABLE devices ADD COLUMN course_id INTEGER REFERENCES courses(id) ON DELETE SET NULL;
+ALTER TABLE location_logs ADD COLUMN course_id INTEGER REFERENCES courses(id) ON DELETE SET NULL;
+
+-- 既存のコース名からコースを作成する
+INSERT OR IGNORE INTO courses (project_id, name)
+SELECT project_id, course_name FROM route_stops
+UNION
+SELECT project_id, course_name FROM devices WHERE course_name IS NOT NULL AND course_name != ''
+UNION
+SELECT project_id, course_name FROM location_logs;
+
+UPDATE route_stops
+SET course_id = (SELECT c.id FROM courses c WHERE c.project_id = route_stops.project_id AND c.name = route_stops.course_name);
+UPDATE devices
+SET course_id = (SELECT c.id FROM courses c WHERE c.project_id = devices.project_id AND c.name = devices.course_name);
+UPDATE location_logs
+SET course_id = (SELECT c.id FROM courses c WHERE c.project_id = location_logs.project_id AND c.name = location_logs.course_name);
+
+CREATE INDEX IF NOT EXISTS idx_route_stops_course_id ON route_stops(course_id);
+CREATE INDEX IF NOT EXISTS idx_devices_course_id ON devices(course_id);
+CREATE INDEX IF NOT EXISTS idx_location_logs_course_id ON location_logs(course_id);
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_location_logs_course_id;
+DROP INDEX IF EXISTS idx_devices_course_id;
+DROP INDEX IF EXISTS idx_route_stops_course_id;
+ALTER TABLE location_logs DROP COLUMN course_id;
+ALTER TABLE devices DROP COLUMN course_id;
+ALTER TABLE route_stops DROP COLUMN course_id;
+DROP INDEX IF EXISTS idx_courses_project;
+DROP TABLE IF EXISTS courses;
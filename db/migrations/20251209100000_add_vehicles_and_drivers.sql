@@ -0,0 +1,44 @@
+-- +goose Up
+-- 車両・ドライバーのマスタと、運行日ごとのコースへの割り当て
+CREATE TABLE IF NOT EXISTS vehicles (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    plate TEXT NOT NULL UNIQUE,
+    vehicle_type TEXT,
+    max_load_kg INTEGER,
+    refrigerated BOOLEAN NOT NULL DEFAULT 0,
+    note TEXT,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS drivers (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL,
+    phone TEXT,
+    license_expiry TEXT,
+    note TEXT,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS course_assignments (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    course_id INTEGER NOT NULL,
+    operating_date TEXT NOT NULL,
+    vehicle_id INTEGER,
+    driver_id INTEGER,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (course_id) REFERENCES courses(id) ON DELETE CASCADE,
+    FOREIGN KEY (vehicle_id) REFERENCES vehicles(id) ON DELETE SET NULL,
+    FOREIGN KEY (driver_id) REFERENCES drivers(id) ON DELETE SET NULL,
+    UNIQUE(course_id, operating_date)
+);
+
+CREATE INDEX IF NOT EXISTS idx_course_assignments_date ON course_assignments(operating_date);
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_course_assignments_date;
+DROP TABLE IF EXISTS course_assignments;
+DROP TABLE IF EXISTS drivers;
+DROP TABLE IF EXISTS vehicles;
@@ -0,0 +1,8 @@
+-- +goose Up
+-- 停車地の積降区分（load: 積込、unload: 荷降ろし）。既存データはこれまでの集計どおり積込として扱う
+ALTER TABLE route_stops ADD COLUMN load_direction TEXT NOT NULL DEFAULT 'load';
+ALTER TABLE route_import_stops ADD COLUMN load_direction TEXT NOT NULL DEFAULT 'load';
+
+-- +goose Down
+ALTER TABLE route_import_stops DROP COLUMN load_direction;
+ALTER TABLE route_stops DROP COLUMN load_direction;
@@ -0,0 +1,38 @@
+-- +goose Up
+-- 停車地の並び順（順番列を整数にしたもの。「出発」は0）と運行日を基準にした到着予定日時
+-- 到着予定 "HH:MM" の文字列順では日付をまたぐ運行（22:00 → 03:00 など）を正しく並べられないため
+ALTER TABLE route_stops ADD COLUMN sequence_no INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE route_stops ADD COLUMN planned_at DATETIME;
+
+UPDATE route_stops
+SET sequence_no = CAST(TRIM(sequence) AS INTEGER)
+WHERE TRIM(sequence) GLOB '[0-9]*' AND TRIM(sequence) NOT GLOB '*[^0-9]*';
+
+-- 到着予定を運行日からの経過分に変換し、コースの先頭地点より12時間以上前の時刻は翌日として扱う
+UPDATE route_stops
+SET planned_at = (
+    SELECT strftime('%Y-%m-%d %H:%M:%S', route_stops.operating_date,
+               '+' || (m.minutes + CASE WHEN m.minutes <= m.first_minutes - 720 THEN 1440 ELSE 0 END) || ' minutes') || '+09:00'
+    FROM (
+        SELECT s.id,
+               CAST(substr(s.arrival_time, 1, instr(s.arrival_time, ':') - 1) AS INTEGER) * 60
+                   + CAST(substr(s.arrival_time, instr(s.arrival_time, ':') + 1) AS INTEGER) AS minutes,
+               (SELECT CAST(substr(f.arrival_time, 1, instr(f.arrival_time, ':') - 1) AS INTEGER) * 60
+                           + CAST(substr(f.arrival_time, instr(f.arrival_time, ':') + 1) AS INTEGER)
+                FROM route_stops f
+                WHERE f.project_id = s.project_id AND f.operating_date = s.operating_date
+                  AND f.course_name = s.course_name AND f.arrival_time GLOB '[0-9]*:[0-9][0-9]'
+                ORDER BY f.sequence_no, f.id
+                LIMIT 1) AS first_minutes
+        FROM route_stops s
+    ) m
+    WHERE m.id = route_stops.id
+)
+WHERE operating_date != '' AND arrival_time GLOB '[0-9]*:[0-9][0-9]';
+
+CREATE INDEX IF NOT EXISTS idx_route_stops_course_order ON route_stops(project_id, operating_date, course_name, sequence_no, planned_at);
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_route_stops_course_order;
+ALTER TABLE route_stops DROP COLUMN planned_at;
+ALTER TABLE route_stops DROP COLUMN sequence_no;
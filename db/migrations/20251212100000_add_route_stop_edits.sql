@@ -0,0 +1,30 @@
+-- +goose Up
+-- 画面から停車地を修正した日時（CSV取り込みで上書きするとNULLに戻る）
+ALTER TABLE route_stops ADD COLUMN manually_edited_at DATETIME;
+-- CSV取り込み時に画面からの修正を保持するかどうか
+ALTER TABLE route_imports ADD COLUMN keep_manual_edits BOOLEAN NOT NULL DEFAULT 0;
+
+-- 画面からの停車地の修正履歴
+CREATE TABLE IF NOT EXISTS route_stop_edits (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    project_id INTEGER NOT NULL,
+    operating_date TEXT NOT NULL,
+    route_stop_id INTEGER REFERENCES route_stops(id) ON DELETE SET NULL,
+    course_name TEXT NOT NULL,
+    stop_name TEXT NOT NULL,
+    address TEXT,
+    action TEXT NOT NULL, -- create, update, delete, reorder, move
+    detail TEXT,
+    edited_by INTEGER, -- users.id
+    superseded_by_import_id INTEGER REFERENCES route_imports(id) ON DELETE SET NULL, -- 修正を上書きしたCSV取り込み
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (project_id) REFERENCES projects(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_route_stop_edits_project_date ON route_stop_edits(project_id, operating_date);
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_route_stop_edits_project_date;
+DROP TABLE IF EXISTS route_stop_edits;
+ALTER TABLE route_imports DROP COLUMN keep_manual_edits;
+ALTER TABLE route_stops DROP COLUMN manually_edited_at;
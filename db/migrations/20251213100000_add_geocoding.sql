@@ -0,0 +1,38 @@
+-- +goose Up
+-- 住所から座標を推定した停車地の情報（CSVに座標がある・画面で指定した停車地はNULL）
+ALTER TABLE route_stops ADD COLUMN geocode_source TEXT; -- dictionary, http
+ALTER TABLE route_stops ADD COLUMN geocode_confidence REAL; -- 0〜1
+ALTER TABLE route_stops ADD COLUMN geocode_status TEXT; -- ok: 推定済み、review: 要確認、failed: 推定できず
+
+CREATE INDEX IF NOT EXISTS idx_route_stops_geocode_status ON route_stops(project_id, geocode_status);
+
+-- 住所辞書（オフラインのジオコーディング用。確認済みの停車地の座標も登録する）
+CREATE TABLE IF NOT EXISTS geocode_dictionary (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    normalized_address TEXT NOT NULL UNIQUE, -- 表記ゆれをそろえた住所（検索キー）
+    address TEXT NOT NULL,
+    latitude REAL NOT NULL,
+    longitude REAL NOT NULL,
+    created_by INTEGER, -- users.id
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+-- 外部の住所検索APIの結果のキャッシュ
+CREATE TABLE IF NOT EXISTS geocode_cache (
+    normalized_address TEXT PRIMARY KEY,
+    latitude REAL NOT NULL,
+    longitude REAL NOT NULL,
+    confidence REAL NOT NULL,
+    source TEXT NOT NULL,
+    matched_address TEXT,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS geocode_cache;
+DROP TABLE IF EXISTS geocode_dictionary;
+DROP INDEX IF EXISTS idx_route_stops_geocode_status;
+ALTER TABLE route_stops DROP COLUMN geocode_status;
+ALTER TABLE route_stops DROP COLUMN geocode_confidence;
+ALTER TABLE route_stops DROP COLUMN geocode_source;
@@ -0,0 +1,19 @@
+-- +goose Up
+-- 配送先の位置マスタ（運行日をまたいで使う停車地の座標。取り込み時にCSVの座標より優先する）
+CREATE TABLE IF NOT EXISTS locations (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    normalized_address TEXT NOT NULL UNIQUE, -- 表記ゆれをそろえた住所（照合キー）
+    name TEXT NOT NULL,
+    address TEXT NOT NULL,
+    latitude REAL NOT NULL,
+    longitude REAL NOT NULL,
+    coordinate_source TEXT NOT NULL DEFAULT 'manual', -- learned: 実際の滞在位置から学習, manual: 手入力
+    confidence REAL, -- 学習した座標の信頼度（0〜1）
+    observations INTEGER, -- 学習に使った滞在の回数
+    created_by INTEGER, -- users.id
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS locations;
@@ -0,0 +1,84 @@
+-- +goose Up
+-- 位置マスタを顧客コードでも照合できるようにする（同じ住所に複数の顧客がいる場合があるため、住所の一意制約は顧客コードのない登録だけにする）
+CREATE TABLE locations_new (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    customer_code TEXT, -- 顧客コード（CSVの19列目）。住所より優先して照合する
+    normalized_address TEXT NOT NULL DEFAULT '', -- 表記ゆれをそろえた住所（顧客コードのない登録の照合キー）
+    name TEXT NOT NULL,
+    address TEXT NOT NULL DEFAULT '',
+    latitude REAL NOT NULL,
+    longitude REAL NOT NULL,
+    coordinate_source TEXT NOT NULL DEFAULT 'manual', -- learned: 実際の滞在位置から学習, manual: 手入力
+    confidence REAL, -- 学習した座標の信頼度（0〜1）
+    observations INTEGER, -- 学習に使った滞在の回数
+    phone_number TEXT,
+    contact_name TEXT, -- 先方の担当者
+    gate_instructions TEXT, -- 進入口・荷受け場所などの案内
+    stay_minutes INTEGER, -- 標準の滞在時間（分）
+    geofence_radius_m INTEGER, -- 到着判定の範囲（m）。NULLなら案件の設定
+    note TEXT,
+    override_coordinates BOOLEAN NOT NULL DEFAULT 1, -- 取り込み時にCSVの座標をマスタの座標で上書きする
+    override_phone BOOLEAN NOT NULL DEFAULT 0, -- 取り込み時にCSVの電話番号を上書きする
+    override_stay_minutes BOOLEAN NOT NULL DEFAULT 0, -- 取り込み時にCSVの滞在時間を上書きする
+    created_by INTEGER, -- users.id
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+INSERT INTO locations_new (
+    id, normalized_address, name, address, latitude, longitude, coordinate_source, confidence, observations,
+    created_by, created_at, updated_at
+)
+SELECT id, normalized_address, name, address, latitude, longitude, coordinate_source, confidence, observations,
+    created_by, created_at, updated_at
+FROM locations;
+
+DROP TABLE locations;
+ALTER TABLE locations_new RENAME TO locations;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_locations_customer_code ON locations(customer_code) WHERE customer_code IS NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_locations_normalized_address ON locations(normalized_address) WHERE customer_code IS NULL;
+
+-- 停車地と位置マスタの紐付け、マスタから適用した到着判定の範囲
+ALTER TABLE route_stops ADD COLUMN customer_code TEXT;
+ALTER TABLE route_stops ADD COLUMN location_id INTEGER REFERENCES locations(id) ON DELETE SET NULL;
+ALTER TABLE route_stops ADD COLUMN geofence_radius_m INTEGER;
+ALTER TABLE route_import_stops ADD COLUMN customer_code TEXT;
+
+-- +goose Down
+ALTER TABLE route_import_stops DROP COLUMN customer_code;
+ALTER TABLE route_stops DROP COLUMN geofence_radius_m;
+ALTER TABLE route_stops DROP COLUMN location_id;
+ALTER TABLE route_stops DROP COLUMN customer_code;
+
+DROP INDEX IF EXISTS idx_locations_normalized_address;
+DROP INDEX IF EXISTS idx_locations_customer_code;
+
+CREATE TABLE locations_old (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    normalized_address TEXT NOT NULL UNIQUE,
+    name TEXT NOT NULL,
+    address TEXT NOT NULL,
+    latitude REAL NOT NULL,
+    longitude REAL NOT NULL,
+    coordinate_source TEXT NOT NULL DEFAULT 'manual',
+    confidence REAL,
+    observations INTEGER,
+    created_by INTEGER,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+-- 住所のない・住所が重複する登録は戻せないため捨てる
+INSERT OR IGNORE INTO locations_old (
+    id, normalized_address, name, address, latitude, longitude, coordinate_source, confidence, observations,
+    created_by, created_at, updated_at
+)
+SELECT id, normalized_address, name, address, latitude, longitude, coordinate_source, confidence, observations,
+    created_by, created_at, updated_at
+FROM locations
+WHERE normalized_address != ''
+ORDER BY id;
+
+DROP TABLE locations;
+ALTER TABLE locations_old RENAME TO locations;
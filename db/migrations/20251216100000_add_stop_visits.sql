@@ -0,0 +1,41 @@
+-- +goose Up
+-- 停車地ごとの到着判定の途中経過（位置ログの受信ごとに差分で更新し、画面はこれを読む）
+CREATE TABLE IF NOT EXISTS stop_visits (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    project_id INTEGER NOT NULL,
+    operating_date TEXT NOT NULL,
+    course_name TEXT NOT NULL,
+    course_run_id INTEGER NOT NULL DEFAULT 0, -- course_runs.id（0 は運行なし＝運行日の全ログ）
+    route_stop_id INTEGER NOT NULL,
+    entered_at DATETIME, -- 初めて範囲に入った時刻
+    stay_start_at DATETIME, -- 滞在条件を満たし始めた時刻
+    arrived_at DATETIME, -- 到着確定時の到着時刻
+    departed_at DATETIME, -- 出発時刻
+    in_range_since DATETIME, -- 範囲内に入り続けている開始時刻（範囲外ならNULL）
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (route_stop_id, course_run_id),
+    FOREIGN KEY (project_id) REFERENCES projects(id) ON DELETE CASCADE,
+    FOREIGN KEY (route_stop_id) REFERENCES route_stops(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_stop_visits_project_date_course ON stop_visits(project_id, operating_date, course_name, course_run_id);
+
+-- コース・運行ごとの到着判定の進み具合
+CREATE TABLE IF NOT EXISTS stop_visit_progress (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    project_id INTEGER NOT NULL,
+    operating_date TEXT NOT NULL,
+    course_name TEXT NOT NULL,
+    course_run_id INTEGER NOT NULL DEFAULT 0, -- course_runs.id（0 は運行なし＝運行日の全ログ）
+    scanned_log_id INTEGER NOT NULL DEFAULT 0, -- このIDまでのログは判定済み
+    latest_log_id INTEGER, -- 判定に使った最新（時刻）のログ
+    fingerprint TEXT NOT NULL, -- 判定設定と停車地の座標・範囲から求めた値（変わったら判定し直す）
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (project_id, operating_date, course_name, course_run_id),
+    FOREIGN KEY (project_id) REFERENCES projects(id) ON DELETE CASCADE
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS stop_visit_progress;
+DROP INDEX IF EXISTS idx_stop_visits_project_date_course;
+DROP TABLE IF EXISTS stop_visits;
@@ -0,0 +1,8 @@
+-- +goose Up
+-- 到着判定のエリア（多角形）。大きな物流センターの敷地など、円では表せない範囲を GeoJSON で持つ
+ALTER TABLE locations ADD COLUMN geofence_polygon TEXT;
+ALTER TABLE route_stops ADD COLUMN geofence_polygon TEXT;
+
+-- +goose Down
+ALTER TABLE route_stops DROP COLUMN geofence_polygon;
+ALTER TABLE locations DROP COLUMN geofence_polygon;
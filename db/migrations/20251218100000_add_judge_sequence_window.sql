@@ -0,0 +1,7 @@
+-- +goose Up
+-- 順番を考慮した到着判定。0なら従来どおり停車地ごとに独立して判定し、
+-- 1以上なら次に向かう予定の地点から前後この地点数までの停車地だけを到着の候補にする
+ALTER TABLE projects ADD COLUMN judge_sequence_window INTEGER NOT NULL DEFAULT 0;
+
+-- +goose Down
+ALTER TABLE projects DROP COLUMN judge_sequence_window;
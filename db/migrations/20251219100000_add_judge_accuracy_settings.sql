@@ -0,0 +1,20 @@
+-- +goose Up
+-- 測位精度・連続の測位数・出口の余裕（ヒステリシス）・進行方向を使った到着判定の設定
+ALTER TABLE projects ADD COLUMN judge_max_accuracy_m REAL NOT NULL DEFAULT 0;
+ALTER TABLE projects ADD COLUMN judge_accuracy_mode TEXT NOT NULL DEFAULT 'discard';
+ALTER TABLE projects ADD COLUMN judge_min_fixes INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE projects ADD COLUMN judge_exit_margin_m INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE projects ADD COLUMN judge_use_bearing BOOLEAN NOT NULL DEFAULT 0;
+
+-- 範囲の出入りが確定する前の、今と異なる側の測位が続き始めた時刻とその重みの合計
+ALTER TABLE stop_visits ADD COLUMN pending_since DATETIME;
+ALTER TABLE stop_visits ADD COLUMN pending_weight REAL NOT NULL DEFAULT 0;
+
+-- +goose Down
+ALTER TABLE stop_visits DROP COLUMN pending_weight;
+ALTER TABLE stop_visits DROP COLUMN pending_since;
+ALTER TABLE projects DROP COLUMN judge_use_bearing;
+ALTER TABLE projects DROP COLUMN judge_exit_margin_m;
+ALTER TABLE projects DROP COLUMN judge_min_fixes;
+ALTER TABLE projects DROP COLUMN judge_accuracy_mode;
+ALTER TABLE projects DROP COLUMN judge_max_accuracy_m;
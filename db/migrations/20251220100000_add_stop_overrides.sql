@@ -0,0 +1,46 @@
+-- +goose Up
+-- 停車地の到着・出発の手動での指定（運行ごと）。判定より優先する
+CREATE TABLE IF NOT EXISTS stop_overrides (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    project_id INTEGER NOT NULL,
+    operating_date TEXT NOT NULL,
+    course_name TEXT NOT NULL,
+    course_run_id INTEGER NOT NULL DEFAULT 0, -- course_runs.id（0 は運行なし＝運行日の全ログ）
+    route_stop_id INTEGER NOT NULL,
+    status TEXT NOT NULL, -- arrived: 到着済み / not_arrived: 未到着
+    arrived_at DATETIME,
+    departed_at DATETIME,
+    reason TEXT NOT NULL,
+    updated_by INTEGER, -- users.id
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (route_stop_id, course_run_id),
+    FOREIGN KEY (project_id) REFERENCES projects(id) ON DELETE CASCADE,
+    FOREIGN KEY (route_stop_id) REFERENCES route_stops(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_stop_overrides_project_date_course ON stop_overrides(project_id, operating_date, course_name, course_run_id);
+
+-- 手動での指定の変更履歴（指定・取り消しのたびに1行）
+CREATE TABLE IF NOT EXISTS stop_override_logs (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    project_id INTEGER NOT NULL,
+    operating_date TEXT NOT NULL,
+    course_name TEXT NOT NULL,
+    course_run_id INTEGER NOT NULL DEFAULT 0,
+    route_stop_id INTEGER REFERENCES route_stops(id) ON DELETE SET NULL,
+    stop_name TEXT NOT NULL,
+    action TEXT NOT NULL, -- set: 指定 / clear: 取り消し（判定に戻す）
+    status TEXT, -- 指定した状態（取り消しならNULL）
+    arrived_at DATETIME,
+    departed_at DATETIME,
+    reason TEXT NOT NULL,
+    edited_by INTEGER, -- users.id
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (project_id) REFERENCES projects(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_stop_override_logs_stop ON stop_override_logs(route_stop_id, course_run_id);
+
+-- +goose Down
+DROP TABLE IF EXISTS stop_override_logs;
+DROP TABLE IF EXISTS stop_overrides;
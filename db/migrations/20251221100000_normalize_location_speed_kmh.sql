@@ -0,0 +1,14 @@
+-- +goose Up
+-- 位置ログの速度を km/h にそろえる（受信時に speed_unit の単位から換算する。speed_unit を送らない端末は m/s）
+-- これまで端末から受信した速度は m/s として扱っていたため km/h に換算する（テストデータ＝device_id なしは km/h で保存済み）
+UPDATE location_logs SET speed = speed * 3.6
+WHERE speed IS NOT NULL AND device_id IS NOT NULL;
+
+-- 速度が変わるので到着判定をやり直す
+DELETE FROM stop_visit_progress;
+
+-- +goose Down
+UPDATE location_logs SET speed = speed / 3.6
+WHERE speed IS NOT NULL AND device_id IS NOT NULL;
+
+DELETE FROM stop_visit_progress;
@@ -0,0 +1,39 @@
+-- +goose Up
+-- 案件ごとの遅延アラートのルール（種類ごとに1件）
+CREATE TABLE IF NOT EXISTS alert_rules (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    project_id INTEGER NOT NULL,
+    kind TEXT NOT NULL, -- delay: 到着予測の遅れ / silent: 位置情報の途絶 / stationary: 停車地の外での停止 / time_window: 希望時間帯に間に合わない
+    threshold_minutes INTEGER NOT NULL, -- しきい値（分）
+    enabled BOOLEAN NOT NULL DEFAULT 1,
+    email_to TEXT NOT NULL DEFAULT '', -- 通知メールの宛先（カンマ区切り、空ならメールを送らない）
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (project_id, kind),
+    FOREIGN KEY (project_id) REFERENCES projects(id) ON DELETE CASCADE
+);
+
+-- 発生したアラート（同じ状況で繰り返し通知しないよう dedupe_key で1件にまとめる）
+CREATE TABLE IF NOT EXISTS alerts (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    project_id INTEGER NOT NULL,
+    kind TEXT NOT NULL,
+    operating_date TEXT NOT NULL,
+    course_name TEXT NOT NULL,
+    course_run_id INTEGER NOT NULL DEFAULT 0, -- course_runs.id（0 は運行なし＝運行日の全ログ）
+    route_stop_id INTEGER REFERENCES route_stops(id) ON DELETE SET NULL,
+    dedupe_key TEXT NOT NULL,
+    message TEXT NOT NULL,
+    emailed_at DATETIME, -- メールを送った日時
+    email_error TEXT, -- メールの送信に失敗した理由
+    acknowledged_at DATETIME,
+    acknowledged_by INTEGER, -- users.id
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (project_id, dedupe_key),
+    FOREIGN KEY (project_id) REFERENCES projects(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_alerts_project_created ON alerts(project_id, created_at);
+
+-- +goose Down
+DROP TABLE IF EXISTS alerts;
+DROP TABLE IF EXISTS alert_rules;
@@ -0,0 +1,17 @@
+-- +goose Up
+-- 取り込み確定時点の停車地スナップショットに、位置マスタとの紐付け・到着判定の範囲・住所からの推定結果も保存する
+-- ロールバックで画面やマスタから設定した停車地ごとの到着判定の範囲・エリアが消えないようにする
+ALTER TABLE route_import_stops ADD COLUMN location_id INTEGER REFERENCES locations(id) ON DELETE SET NULL;
+ALTER TABLE route_import_stops ADD COLUMN geofence_radius_m INTEGER;
+ALTER TABLE route_import_stops ADD COLUMN geofence_polygon TEXT;
+ALTER TABLE route_import_stops ADD COLUMN geocode_source TEXT;
+ALTER TABLE route_import_stops ADD COLUMN geocode_confidence REAL;
+ALTER TABLE route_import_stops ADD COLUMN geocode_status TEXT;
+
+-- +goose Down
+ALTER TABLE route_import_stops DROP COLUMN geocode_status;
+ALTER TABLE route_import_stops DROP COLUMN geocode_confidence;
+ALTER TABLE route_import_stops DROP COLUMN geocode_source;
+ALTER TABLE route_import_stops DROP COLUMN geofence_polygon;
+ALTER TABLE route_import_stops DROP COLUMN geofence_radius_m;
+ALTER TABLE route_import_stops DROP COLUMN location_id;
@@ -0,0 +1,7 @@
+-- +goose Up
+-- 到着判定の進み具合に版を持たせ、保存時に読み込んだ版から変わっていないことを確かめる
+-- サーバーの受信での判定の更新と、別のプロセスで動く cmd/rebuild-visits の判定し直しが互いの保存を上書きしないようにする
+ALTER TABLE stop_visit_progress ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+
+-- +goose Down
+ALTER TABLE stop_visit_progress DROP COLUMN version;
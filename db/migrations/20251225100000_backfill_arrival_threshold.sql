@@ -0,0 +1,8 @@
+-- +goose Up
+-- 到着判定距離が未設定（NULL）の案件に、到着判定で使っている既定の100mを入れる
+-- 写真の撮影位置と停車地の照合も到着判定と同じエリアを使うようになったため、未設定のまま照合の範囲が0mから100mに変わらないよう値を明示する
+UPDATE projects SET arrival_threshold_meters = 100
+WHERE arrival_threshold_meters IS NULL;
+
+-- +goose Down
+-- 元が未設定だった案件は区別できないため戻さない（未設定でも到着判定・写真の照合は100mで動く）
@@ -2,8 +2,11 @@
 SELECT * FROM projects ORDER BY created_at DESC;
 
 -- name: CreateProject :one
-INSERT INTO projects (name, api_key, arrival_threshold_meters, judge_stay_time_minutes, judge_speed_limit_kmh)
-VALUES (?, ?, ?, ?, ?)
+INSERT INTO projects (
+    name, api_key, arrival_threshold_meters, judge_stay_time_minutes, judge_speed_limit_kmh, judge_sequence_window,
+    judge_max_accuracy_m, judge_accuracy_mode, judge_min_fixes, judge_exit_margin_m, judge_use_bearing
+)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 RETURNING *;
 
 -- name: GetProject :one
@@ -11,7 +14,9 @@ SELECT * FROM projects WHERE id = ? LIMIT 1;
 
 -- name: UpdateProject :one
 UPDATE projects
-SET name = ?, arrival_threshold_meters = ?, judge_stay_time_minutes = ?, judge_speed_limit_kmh = ?, updated_at = CURRENT_TIMESTAMP
+SET name = ?, arrival_threshold_meters = ?, judge_stay_time_minutes = ?, judge_speed_limit_kmh = ?, judge_sequence_window = ?,
+    judge_max_accuracy_m = ?, judge_accuracy_mode = ?, judge_min_fixes = ?, judge_exit_margin_m = ?, judge_use_bearing = ?,
+    updated_at = CURRENT_TIMESTAMP
 WHERE id = ?
 RETURNING *;
 
@@ -24,49 +29,83 @@ SET csv_filename = ?, csv_imported_at = ?, csv_row_count = ?, updated_at = CURRE
 WHERE id = ?
 RETURNING *;
 
--- name: CreateRouteStop :exec
+-- name: CreateRouteStop :execlastid
 INSERT INTO route_stops (
-    project_id, course_name, sequence, arrival_time, stop_name,
-    address, latitude, longitude, stay_minutes, weight_kg,
+    project_id, operating_date, course_id, course_name, sequence, sequence_no, arrival_time, planned_at,
+    stop_name, address, latitude, longitude, stay_minutes, weight_kg, load_direction,
     phone_number, note1, note2, note3,
-    desired_time_start, desired_time_end
+    desired_time_start, desired_time_end, manually_edited_at,
+    geocode_source, geocode_confidence, geocode_status,
+    customer_code, location_id, geofence_radius_m, geofence_polygon
 )
-VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);
 
--- name: DeleteRouteStopsByProject :exec
-DELETE FROM route_stops WHERE project_id = ?;
+-- name: UpdateRouteStop :exec
+UPDATE route_stops
+SET course_id = ?, course_name = ?, sequence = ?, sequence_no = ?, arrival_time = ?, planned_at = ?, stop_name = ?,
+    address = ?, latitude = ?, longitude = ?, stay_minutes = ?, weight_kg = ?, load_direction = ?,
+    phone_number = ?, note1 = ?, note2 = ?, note3 = ?,
+    desired_time_start = ?, desired_time_end = ?, manually_edited_at = ?,
+    geocode_source = ?, geocode_confidence = ?, geocode_status = ?,
+    customer_code = ?, location_id = ?, geofence_radius_m = ?, geofence_polygon = ?, updated_at = CURRENT_TIMESTAMP
+WHERE id = ?;
 
--- name: ListCoursesByProject :many
-SELECT DISTINCT course_name FROM route_stops WHERE project_id = ? ORDER BY course_name;
+-- name: UpdateRouteStopSchedule :exec
+UPDATE route_stops
+SET course_id = ?, course_name = ?, sequence = ?, sequence_no = ?, planned_at = ?,
+    manually_edited_at = COALESCE(sqlc.narg(manually_edited_at), manually_edited_at), updated_at = CURRENT_TIMESTAMP
+WHERE id = ?;
+
+-- name: UpdateRouteStopArrivalTime :exec
+UPDATE route_stops
+SET arrival_time = ?, manually_edited_at = ?, updated_at = CURRENT_TIMESTAMP
+WHERE id = ?;
+
+-- name: DeleteRouteStop :exec
+DELETE FROM route_stops WHERE id = ?;
+
+-- name: CountRouteStopsByProjectDate :many
+SELECT course_name, COUNT(*) AS stop_count FROM route_stops
+WHERE project_id = ? AND operating_date = ?
+GROUP BY course_name;
+
+-- name: CountRouteStopsByCourseID :one
+SELECT COUNT(*) FROM route_stops WHERE course_id = ?;
+
+-- name: ListOperatingDatesByProject :many
+SELECT DISTINCT operating_date FROM route_stops WHERE project_id = ? ORDER BY operating_date DESC;
+
+-- name: ListOperatingDatesByCourse :many
+SELECT DISTINCT operating_date FROM route_stops
+WHERE project_id = ? AND course_name = ? AND operating_date IN (sqlc.arg(date1), sqlc.arg(date2));
+
+-- name: ListRouteStopsByProjectDate :many
+SELECT * FROM route_stops WHERE project_id = ? AND operating_date = ? ORDER BY course_name, sequence_no, planned_at, id;
 
 -- name: ListRouteStopsByCourse :many
-SELECT * FROM route_stops WHERE project_id = ? AND course_name = ? ORDER BY arrival_time;
+SELECT * FROM route_stops WHERE project_id = ? AND operating_date = ? AND course_name = ?
+ORDER BY sequence_no, planned_at, id;
 
 -- name: GetRouteStopByID :one
 SELECT * FROM route_stops WHERE id = ? LIMIT 1;
 
 -- name: CreateLocationLog :exec
 INSERT INTO location_logs (
-    project_id, course_name, device_id, latitude, longitude, timestamp,
+    project_id, operating_date, course_id, course_name, device_id, latitude, longitude, timestamp,
     accuracy, speed, bearing, battery_level
 )
-VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?);
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);
 
 -- name: ListLocationLogsByCourse :many
 SELECT * FROM location_logs
-WHERE project_id = ? AND course_name = ?
+WHERE project_id = ? AND operating_date = ? AND course_name = ?
 ORDER BY timestamp;
 
--- name: ListLocationLogsByCourseDesc :many
+-- name: ListRecentLocationLogsByCourse :many
 SELECT * FROM location_logs
-WHERE project_id = ? AND course_name = ?
-ORDER BY timestamp DESC;
-
--- name: GetLatestLocationByCourse :one
-SELECT * FROM location_logs
-WHERE project_id = ? AND course_name = ?
-ORDER BY timestamp DESC
-LIMIT 1;
+WHERE project_id = ? AND operating_date = ? AND course_name = ?
+ORDER BY id DESC
+LIMIT ?;
 
 -- name: GetProjectByAPIKey :one
 SELECT * FROM projects WHERE api_key = ? LIMIT 1;
@@ -79,13 +118,13 @@ RETURNING *;
 
 -- name: DeleteLocationLogsByCourse :exec
 DELETE FROM location_logs
-WHERE project_id = ? AND course_name = ?;
+WHERE project_id = ? AND operating_date = ? AND course_name = ?;
 
 -- name: CreatePhotoMetadata :one
 INSERT INTO photo_metadata (
-    project_id, course_name, device_photo_id, latitude, longitude, route_stop_id, taken_at
+    project_id, operating_date, course_name, device_photo_id, latitude, longitude, route_stop_id, taken_at
 )
-VALUES (?, ?, ?, ?, ?, ?, ?)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?)
 RETURNING *;
 
 -- name: GetPhotoMetadataByDeviceID :one
@@ -95,7 +134,7 @@ LIMIT 1;
 
 -- name: ListPhotoMetadataByCourse :many
 SELECT * FROM photo_metadata
-WHERE project_id = ? AND course_name = ?
+WHERE project_id = ? AND operating_date = ? AND course_name = ?
 ORDER BY taken_at;
 
 -- name: ListPhotoMetadataByStop :many
@@ -125,7 +164,7 @@ ORDER BY created_at DESC;
 
 -- name: UpdateDeviceCourseName :one
 UPDATE devices
-SET course_name = ?, last_seen_at = CURRENT_TIMESTAMP
+SET course_id = ?, course_name = ?, last_seen_at = CURRENT_TIMESTAMP
 WHERE project_id = ? AND device_id = ?
 RETURNING *;
 
@@ -137,3 +176,449 @@ WHERE project_id = ? AND device_id = ?;
 -- name: DeleteDevice :exec
 DELETE FROM devices
 WHERE project_id = ? AND device_id = ?;
+
+-- name: CreateRouteImport :one
+INSERT INTO route_imports (
+    project_id, operating_date, filename, csv_data, has_header, skip_departure, adjust_time, start_time,
+    keep_manual_edits
+)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+RETURNING *;
+
+-- name: GetRouteImport :one
+SELECT * FROM route_imports WHERE id = ? LIMIT 1;
+
+-- name: CreateRollbackRouteImport :one
+INSERT INTO route_imports (
+    project_id, operating_date, filename, csv_data, has_header, skip_departure, adjust_time, start_time,
+    keep_manual_edits, rollback_of_version
+)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+RETURNING *;
+
+-- name: CommitRouteImport :execrows
+UPDATE route_imports
+SET status = 'committed', row_count = ?, version = ?, imported_by = ?, committed_at = CURRENT_TIMESTAMP
+WHERE id = ? AND status = 'pending';
+
+-- name: GetNextRouteImportVersion :one
+SELECT CAST(COALESCE(MAX(version), 0) + 1 AS INTEGER) AS next_version
+FROM route_imports
+WHERE project_id = ?;
+
+-- name: GetRouteImportByVersion :one
+SELECT * FROM route_imports
+WHERE project_id = ? AND version = ? AND status = 'committed'
+LIMIT 1;
+
+-- name: ListRouteImportHistory :many
+SELECT
+    ri.id, ri.version, ri.operating_date, ri.filename, ri.has_header, ri.skip_departure, ri.adjust_time, ri.start_time,
+    ri.row_count, ri.committed_at, ri.rollback_of_version,
+    u.name AS imported_by_name
+FROM route_imports ri
+LEFT JOIN users u ON u.id = ri.imported_by
+WHERE ri.project_id = ? AND ri.status = 'committed'
+ORDER BY ri.version DESC;
+
+-- name: CreateRouteImportStop :exec
+INSERT INTO route_import_stops (
+    import_id, course_name, sequence, arrival_time, stop_name, address,
+    latitude, longitude, stay_minutes, weight_kg, load_direction, phone_number,
+    note1, note2, note3, desired_time_start, desired_time_end, customer_code,
+    location_id, geofence_radius_m, geofence_polygon, geocode_source, geocode_confidence, geocode_status
+)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);
+
+-- name: CreateRouteStopEdit :exec
+INSERT INTO route_stop_edits (
+    project_id, operating_date, route_stop_id, course_name, stop_name, address, action, detail, edited_by
+)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?);
+
+-- name: ListRouteStopEditsByCourse :many
+SELECT e.*, u.name AS edited_by_name
+FROM route_stop_edits e
+LEFT JOIN users u ON u.id = e.edited_by
+WHERE e.project_id = ? AND e.operating_date = ? AND e.course_name = ?
+ORDER BY e.created_at DESC, e.id DESC
+LIMIT 50;
+
+-- name: ListActiveRouteStopDeletions :many
+SELECT * FROM route_stop_edits
+WHERE project_id = ? AND operating_date = ? AND action = 'delete' AND superseded_by_import_id IS NULL;
+
+-- name: SupersedeRouteStopEdits :exec
+UPDATE route_stop_edits
+SET superseded_by_import_id = ?
+WHERE project_id = ? AND operating_date = ? AND superseded_by_import_id IS NULL;
+
+-- name: ListRouteImportStops :many
+SELECT * FROM route_import_stops WHERE import_id = ? ORDER BY id;
+
+-- name: DeleteRouteImport :exec
+DELETE FROM route_imports WHERE id = ?;
+
+-- name: DeleteStalePendingRouteImports :exec
+DELETE FROM route_imports
+WHERE project_id = ? AND status = 'pending' AND created_at < datetime('now', '-1 day');
+
+-- name: CreateCourseRun :one
+INSERT INTO course_runs (project_id, operating_date, course_name, started_at, started_by)
+VALUES (?, ?, ?, ?, ?)
+RETURNING *;
+
+-- name: GetCourseRun :one
+SELECT * FROM course_runs WHERE id = ? LIMIT 1;
+
+-- name: ListCourseRuns :many
+SELECT * FROM course_runs
+WHERE project_id = ? AND operating_date = ? AND course_name = ?
+ORDER BY id DESC;
+
+-- name: EndCourseRun :exec
+UPDATE course_runs SET ended_at = ? WHERE id = ? AND ended_at IS NULL;
+
+-- name: ListCoursesByProject :many
+SELECT * FROM courses WHERE project_id = ? ORDER BY name;
+
+-- name: GetCourse :one
+SELECT * FROM courses WHERE id = ? LIMIT 1;
+
+-- name: GetCourseByName :one
+SELECT * FROM courses WHERE project_id = ? AND name = ? LIMIT 1;
+
+-- name: CreateCourse :one
+INSERT INTO courses (
+    project_id, name, vehicle_plate, driver_name, driver_phone, capacity_kg,
+    depot_name, depot_address, depot_latitude, depot_longitude, planned_start_time, note
+)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+RETURNING *;
+
+-- name: UpdateCourse :one
+UPDATE courses
+SET vehicle_plate = ?, driver_name = ?, driver_phone = ?, capacity_kg = ?,
+    depot_name = ?, depot_address = ?, depot_latitude = ?, depot_longitude = ?,
+    planned_start_time = ?, note = ?, updated_at = CURRENT_TIMESTAMP
+WHERE id = ?
+RETURNING *;
+
+-- name: DeleteCourse :exec
+DELETE FROM courses WHERE id = ?;
+
+-- name: ClearDeviceCourse :exec
+UPDATE devices
+SET course_id = NULL, course_name = NULL
+WHERE course_id = ?;
+
+-- name: ListVehicles :many
+SELECT * FROM vehicles ORDER BY plate;
+
+-- name: GetVehicle :one
+SELECT * FROM vehicles WHERE id = ? LIMIT 1;
+
+-- name: CreateVehicle :one
+INSERT INTO vehicles (plate, vehicle_type, max_load_kg, refrigerated, note)
+VALUES (?, ?, ?, ?, ?)
+RETURNING *;
+
+-- name: UpdateVehicle :one
+UPDATE vehicles
+SET plate = ?, vehicle_type = ?, max_load_kg = ?, refrigerated = ?, note = ?, updated_at = CURRENT_TIMESTAMP
+WHERE id = ?
+RETURNING *;
+
+-- name: DeleteVehicle :exec
+DELETE FROM vehicles WHERE id = ?;
+
+-- name: ListDrivers :many
+SELECT * FROM drivers ORDER BY name;
+
+-- name: GetDriver :one
+SELECT * FROM drivers WHERE id = ? LIMIT 1;
+
+-- name: CreateDriver :one
+INSERT INTO drivers (name, phone, license_expiry, note)
+VALUES (?, ?, ?, ?)
+RETURNING *;
+
+-- name: UpdateDriver :one
+UPDATE drivers
+SET name = ?, phone = ?, license_expiry = ?, note = ?, updated_at = CURRENT_TIMESTAMP
+WHERE id = ?
+RETURNING *;
+
+-- name: DeleteDriver :exec
+DELETE FROM drivers WHERE id = ?;
+
+-- name: UpsertCourseAssignment :exec
+INSERT INTO course_assignments (course_id, operating_date, vehicle_id, driver_id)
+VALUES (?, ?, ?, ?)
+ON CONFLICT(course_id, operating_date) DO UPDATE
+SET vehicle_id = excluded.vehicle_id, driver_id = excluded.driver_id, updated_at = CURRENT_TIMESTAMP;
+
+-- name: DeleteCourseAssignment :exec
+DELETE FROM course_assignments WHERE course_id = ? AND operating_date = ?;
+
+-- name: ListCourseAssignmentsByProjectDate :many
+SELECT
+    ca.course_id,
+    v.id AS vehicle_id, v.plate AS vehicle_plate, v.vehicle_type, v.max_load_kg, v.refrigerated,
+    d.id AS driver_id, d.name AS driver_name, d.phone AS driver_phone, d.license_expiry
+FROM course_assignments ca
+JOIN courses c ON c.id = ca.course_id
+LEFT JOIN vehicles v ON v.id = ca.vehicle_id
+LEFT JOIN drivers d ON d.id = ca.driver_id
+WHERE c.project_id = ? AND ca.operating_date = ?;
+
+-- name: ListOtherCourseAssignmentsByDate :many
+SELECT ca.vehicle_id, ca.driver_id, c.project_id, c.name AS course_name
+FROM course_assignments ca
+JOIN courses c ON c.id = ca.course_id
+WHERE ca.operating_date = ? AND ca.course_id != ?;
+
+-- name: ListRouteStopsNeedingGeocodeReview :many
+SELECT * FROM route_stops
+WHERE project_id = ? AND geocode_status IN ('review', 'failed')
+ORDER BY operating_date DESC, course_name, sequence_no, id;
+
+-- name: ConfirmRouteStopGeocode :exec
+UPDATE route_stops
+SET geocode_status = 'ok', updated_at = CURRENT_TIMESTAMP
+WHERE id = ?;
+
+-- name: GetGeocodeDictionaryEntry :one
+SELECT * FROM geocode_dictionary WHERE normalized_address = ?;
+
+-- name: ListGeocodeDictionary :many
+SELECT * FROM geocode_dictionary ORDER BY address;
+
+-- name: UpsertGeocodeDictionaryEntry :exec
+INSERT INTO geocode_dictionary (normalized_address, address, latitude, longitude, created_by)
+VALUES (?, ?, ?, ?, ?)
+ON CONFLICT(normalized_address) DO UPDATE SET
+    address = excluded.address,
+    latitude = excluded.latitude,
+    longitude = excluded.longitude,
+    updated_at = CURRENT_TIMESTAMP;
+
+-- name: DeleteGeocodeDictionaryEntry :exec
+DELETE FROM geocode_dictionary WHERE id = ?;
+
+-- name: GetGeocodeCache :one
+SELECT * FROM geocode_cache WHERE normalized_address = ?;
+
+-- name: PutGeocodeCache :exec
+INSERT OR REPLACE INTO geocode_cache (normalized_address, latitude, longitude, confidence, source, matched_address)
+VALUES (?, ?, ?, ?, ?, ?);
+
+-- name: GetLocation :one
+SELECT * FROM locations WHERE id = ?;
+
+-- name: GetLocationByCustomerCode :one
+SELECT * FROM locations WHERE customer_code = ?;
+
+-- name: GetLocationByNormalizedAddress :one
+SELECT * FROM locations WHERE normalized_address = ? AND customer_code IS NULL;
+
+-- name: ListLocations :many
+SELECT * FROM locations ORDER BY name, address;
+
+-- name: SearchLocations :many
+SELECT * FROM locations
+WHERE name LIKE '%' || CAST(sqlc.arg(keyword) AS TEXT) || '%'
+   OR address LIKE '%' || CAST(sqlc.arg(keyword) AS TEXT) || '%'
+   OR customer_code LIKE '%' || CAST(sqlc.arg(keyword) AS TEXT) || '%'
+ORDER BY name, address;
+
+-- name: CreateLocation :execlastid
+INSERT INTO locations (
+    customer_code, normalized_address, name, address, latitude, longitude, coordinate_source, confidence, observations,
+    phone_number, contact_name, gate_instructions, stay_minutes, geofence_radius_m, geofence_polygon, note,
+    override_coordinates, override_phone, override_stay_minutes, created_by
+)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);
+
+-- name: UpdateLocation :exec
+UPDATE locations
+SET customer_code = ?, normalized_address = ?, name = ?, address = ?, latitude = ?, longitude = ?,
+    coordinate_source = ?, confidence = ?, observations = ?,
+    phone_number = ?, contact_name = ?, gate_instructions = ?, stay_minutes = ?, geofence_radius_m = ?, geofence_polygon = ?, note = ?,
+    override_coordinates = ?, override_phone = ?, override_stay_minutes = ?, updated_at = CURRENT_TIMESTAMP
+WHERE id = ?;
+
+-- name: UpdateLocationLearnedCoordinates :exec
+UPDATE locations
+SET latitude = ?, longitude = ?, coordinate_source = 'learned', confidence = ?, observations = ?, updated_at = CURRENT_TIMESTAMP
+WHERE id = ?;
+
+-- name: DeleteLocation :exec
+DELETE FROM locations WHERE id = ?;
+
+-- name: GetLocationLog :one
+SELECT * FROM location_logs WHERE id = ?;
+
+-- name: ListLocationLogsByCourseAfterID :many
+SELECT * FROM location_logs
+WHERE project_id = ? AND operating_date = ? AND course_name = ? AND id > ?
+ORDER BY timestamp, id;
+
+-- name: GetStopVisitProgress :one
+SELECT * FROM stop_visit_progress
+WHERE project_id = ? AND operating_date = ? AND course_name = ? AND course_run_id = ?;
+
+-- name: CreateStopVisitProgress :execrows
+INSERT INTO stop_visit_progress (project_id, operating_date, course_name, course_run_id, scanned_log_id, latest_log_id, fingerprint)
+VALUES (?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT(project_id, operating_date, course_name, course_run_id) DO NOTHING;
+
+-- name: UpdateStopVisitProgress :execrows
+UPDATE stop_visit_progress
+SET scanned_log_id = ?, latest_log_id = ?, fingerprint = ?, version = version + 1, updated_at = CURRENT_TIMESTAMP
+WHERE project_id = ? AND operating_date = ? AND course_name = ? AND course_run_id = ? AND version = ?;
+
+-- name: ListStopVisits :many
+SELECT * FROM stop_visits
+WHERE project_id = ? AND operating_date = ? AND course_name = ? AND course_run_id = ?;
+
+-- name: ListCourseVisitHistory :many
+SELECT h.operating_date, h.course_run_id, h.arrived_at, h.departed_at,
+       s.stop_name, s.address, s.location_id
+FROM (
+    SELECT v.operating_date, v.course_run_id, v.route_stop_id, v.arrived_at, v.departed_at
+    FROM stop_visits v
+    LEFT JOIN stop_overrides o ON o.route_stop_id = v.route_stop_id AND o.course_run_id = v.course_run_id
+    WHERE v.project_id = sqlc.arg(project_id) AND v.course_name = sqlc.arg(course_name)
+      AND v.operating_date >= sqlc.arg(since) AND v.operating_date < sqlc.arg(before)
+      AND o.id IS NULL
+    UNION ALL
+    SELECT o.operating_date, o.course_run_id, o.route_stop_id, o.arrived_at, o.departed_at
+    FROM stop_overrides o
+    WHERE o.project_id = sqlc.arg(project_id) AND o.course_name = sqlc.arg(course_name)
+      AND o.operating_date >= sqlc.arg(since) AND o.operating_date < sqlc.arg(before)
+      AND o.status = 'arrived'
+) h
+JOIN route_stops s ON s.id = h.route_stop_id
+WHERE h.arrived_at IS NOT NULL
+ORDER BY h.operating_date, h.course_run_id, h.arrived_at;
+
+-- name: UpsertStopVisit :exec
+INSERT INTO stop_visits (
+    project_id, operating_date, course_name, course_run_id, route_stop_id,
+    entered_at, stay_start_at, arrived_at, departed_at, in_range_since, pending_since, pending_weight
+)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT(route_stop_id, course_run_id) DO UPDATE SET
+    entered_at = excluded.entered_at,
+    stay_start_at = excluded.stay_start_at,
+    arrived_at = excluded.arrived_at,
+    departed_at = excluded.departed_at,
+    in_range_since = excluded.in_range_since,
+    pending_since = excluded.pending_since,
+    pending_weight = excluded.pending_weight,
+    updated_at = CURRENT_TIMESTAMP;
+
+-- name: DeleteStopVisits :exec
+DELETE FROM stop_visits
+WHERE project_id = ? AND operating_date = ? AND course_name = ? AND course_run_id = ?;
+
+-- name: ListStopOverrides :many
+SELECT * FROM stop_overrides
+WHERE project_id = ? AND operating_date = ? AND course_name = ? AND course_run_id = ?
+ORDER BY route_stop_id;
+
+-- name: GetStopOverride :one
+SELECT * FROM stop_overrides
+WHERE route_stop_id = ? AND course_run_id = ?;
+
+-- name: UpsertStopOverride :exec
+INSERT INTO stop_overrides (
+    project_id, operating_date, course_name, course_run_id, route_stop_id,
+    status, arrived_at, departed_at, reason, updated_by
+)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT(route_stop_id, course_run_id) DO UPDATE SET
+    status = excluded.status,
+    arrived_at = excluded.arrived_at,
+    departed_at = excluded.departed_at,
+    reason = excluded.reason,
+    updated_by = excluded.updated_by,
+    updated_at = CURRENT_TIMESTAMP;
+
+-- name: DeleteStopOverride :exec
+DELETE FROM stop_overrides
+WHERE route_stop_id = ? AND course_run_id = ?;
+
+-- name: MoveStopOverridesToRun :exec
+UPDATE stop_overrides SET course_run_id = ?
+WHERE project_id = ? AND operating_date = ? AND course_name = ? AND course_run_id = 0;
+
+-- name: MoveStopOverrideLogsToRun :exec
+UPDATE stop_override_logs SET course_run_id = ?
+WHERE project_id = ? AND operating_date = ? AND course_name = ? AND course_run_id = 0;
+
+-- name: CreateStopOverrideLog :exec
+INSERT INTO stop_override_logs (
+    project_id, operating_date, course_name, course_run_id, route_stop_id, stop_name,
+    action, status, arrived_at, departed_at, reason, edited_by
+)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);
+
+-- name: ListStopOverrideLogsByStop :many
+SELECT l.*, u.name AS edited_by_name
+FROM stop_override_logs l
+LEFT JOIN users u ON u.id = l.edited_by
+WHERE l.route_stop_id = ? AND l.course_run_id = ?
+ORDER BY l.created_at DESC, l.id DESC
+LIMIT 50;
+
+-- name: ListAlertRules :many
+SELECT * FROM alert_rules
+WHERE project_id = ?
+ORDER BY id;
+
+-- name: ListEnabledAlertRules :many
+SELECT * FROM alert_rules
+WHERE enabled = 1
+ORDER BY project_id, id;
+
+-- name: UpsertAlertRule :exec
+INSERT INTO alert_rules (project_id, kind, threshold_minutes, enabled, email_to)
+VALUES (?, ?, ?, ?, ?)
+ON CONFLICT(project_id, kind) DO UPDATE SET
+    threshold_minutes = excluded.threshold_minutes,
+    enabled = excluded.enabled,
+    email_to = excluded.email_to,
+    updated_at = CURRENT_TIMESTAMP;
+
+-- name: CreateAlert :one
+INSERT INTO alerts (
+    project_id, kind, operating_date, course_name, course_run_id, route_stop_id, dedupe_key, message
+)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT(project_id, dedupe_key) DO NOTHING
+RETURNING *;
+
+-- name: UpdateAlertEmail :exec
+UPDATE alerts SET emailed_at = ?, email_error = ? WHERE id = ?;
+
+-- name: ListAlertsByProject :many
+SELECT a.*, u.name AS acknowledged_by_name
+FROM alerts a
+LEFT JOIN users u ON u.id = a.acknowledged_by
+WHERE a.project_id = ?
+ORDER BY a.created_at DESC, a.id DESC
+LIMIT 200;
+
+-- name: CountUnacknowledgedAlerts :one
+SELECT COUNT(*) FROM alerts
+WHERE project_id = ? AND acknowledged_at IS NULL;
+
+-- name: AcknowledgeAlert :exec
+UPDATE alerts SET acknowledged_at = CURRENT_TIMESTAMP, acknowledged_by = ?
+WHERE id = ? AND project_id = ? AND acknowledged_at IS NULL;
+
+-- name: AcknowledgeAllAlerts :exec
+UPDATE alerts SET acknowledged_at = CURRENT_TIMESTAMP, acknowledged_by = ?
+WHERE project_id = ? AND acknowledged_at IS NULL;
@@ -10,10 +10,39 @@ CREATE TABLE IF NOT EXISTS projects (
     arrival_threshold_meters INTEGER DEFAULT 100,
     judge_stay_time_minutes INTEGER DEFAULT 0,
     judge_speed_limit_kmh REAL DEFAULT 0,
+    judge_sequence_window INTEGER NOT NULL DEFAULT 0, -- 順番を考慮した判定で候補にする前後の地点数（0なら順番を考慮しない）
+    judge_max_accuracy_m REAL NOT NULL DEFAULT 0, -- この精度（m）より悪い測位を捨てる・軽く扱う（0なら精度を見ない）
+    judge_accuracy_mode TEXT NOT NULL DEFAULT 'discard', -- 精度の悪い測位の扱い（discard: 捨てる / weight: 精度に応じて軽く数える）
+    judge_min_fixes INTEGER NOT NULL DEFAULT 1, -- 範囲の出入りを確定する連続の測位数
+    judge_exit_margin_m INTEGER NOT NULL DEFAULT 0, -- 範囲を出たとみなすまでの余裕（m）
+    judge_use_bearing BOOLEAN NOT NULL DEFAULT 0, -- 停車地から遠ざかる向きに走っていれば余裕の内側でも出発とみなす
     created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
     updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
 );
 
+-- 配送コース（車両・ドライバー・出発拠点）。コース名はCSVの車両名と対応する
+CREATE TABLE IF NOT EXISTS courses (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    project_id INTEGER NOT NULL,
+    name TEXT NOT NULL,
+    vehicle_plate TEXT, -- 車両ナンバー
+    driver_name TEXT,
+    driver_phone TEXT,
+    capacity_kg INTEGER, -- 最大積載量
+    depot_name TEXT, -- 出発拠点
+    depot_address TEXT,
+    depot_latitude REAL,
+    depot_longitude REAL,
+    planned_start_time TEXT, -- 出発予定時刻（HH:MM）
+    note TEXT,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (project_id) REFERENCES projects(id) ON DELETE CASCADE,
+    UNIQUE(project_id, name)
+);
+
+CREATE INDEX IF NOT EXISTS idx_courses_project ON courses(project_id);
+
 -- 配送停車地データ
 CREATE TABLE IF NOT EXISTS route_stops (
     id INTEGER PRIMARY KEY AUTOINCREMENT,
@@ -35,11 +64,28 @@ CREATE TABLE IF NOT EXISTS route_stops (
     desired_time_end TEXT,
     created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
     updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    operating_date TEXT NOT NULL DEFAULT '', -- 運行日（YYYY-MM-DD）。到着予定 "HH:MM" はこの日付を基準に解釈する
+    course_id INTEGER REFERENCES courses(id) ON DELETE SET NULL,
+    load_direction TEXT NOT NULL DEFAULT 'load', -- 積降区分（load: 積込、unload: 荷降ろし）
+    sequence_no INTEGER NOT NULL DEFAULT 0, -- 並び順（順番列を整数にしたもの。「出発」は0）
+    planned_at DATETIME, -- 運行日を基準にした到着予定日時（日付をまたぐ運行は翌日の日時）
+    manually_edited_at DATETIME, -- 画面から修正した日時（CSV取り込みで上書きするとNULLに戻る）
+    geocode_source TEXT, -- 住所から座標を推定した検索元（dictionary, http）。CSVに座標がある・画面で指定した停車地はNULL
+    geocode_confidence REAL, -- 推定の信頼度（0〜1）
+    geocode_status TEXT, -- ok: 推定済み、review: 要確認、failed: 推定できず
+    customer_code TEXT, -- 顧客コード（CSVの19列目）
+    location_id INTEGER REFERENCES locations(id) ON DELETE SET NULL, -- 取り込み時に照合した位置マスタ
+    geofence_radius_m INTEGER, -- 到着判定の範囲（m）。NULLなら案件の設定
+    geofence_polygon TEXT, -- 到着判定のエリア（GeoJSON の多角形）。あれば範囲より優先する
     FOREIGN KEY (project_id) REFERENCES projects(id) ON DELETE CASCADE
 );
 
 CREATE INDEX IF NOT EXISTS idx_route_stops_project ON route_stops(project_id);
 CREATE INDEX IF NOT EXISTS idx_route_stops_course ON route_stops(course_name);
+CREATE INDEX IF NOT EXISTS idx_route_stops_project_date ON route_stops(project_id, operating_date, course_name);
+CREATE INDEX IF NOT EXISTS idx_route_stops_course_id ON route_stops(course_id);
+CREATE INDEX IF NOT EXISTS idx_route_stops_course_order ON route_stops(project_id, operating_date, course_name, sequence_no, planned_at);
+CREATE INDEX IF NOT EXISTS idx_route_stops_geocode_status ON route_stops(project_id, geocode_status);
 
 -- 位置情報ログ
 CREATE TABLE IF NOT EXISTS location_logs (
@@ -51,16 +97,20 @@ CREATE TABLE IF NOT EXISTS location_logs (
     longitude REAL NOT NULL,
     timestamp DATETIME NOT NULL,
     accuracy REAL,
-    speed REAL,
+    speed REAL, -- 速度（km/h）。受信時に km/h へそろえる
     bearing REAL,
     battery_level INTEGER,
     created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    operating_date TEXT NOT NULL DEFAULT '', -- 受信時に判定した運行日
+    course_id INTEGER REFERENCES courses(id) ON DELETE SET NULL,
     FOREIGN KEY (project_id) REFERENCES projects(id) ON DELETE CASCADE
 );
 
 CREATE INDEX IF NOT EXISTS idx_location_logs_project_course ON location_logs(project_id, course_name);
 CREATE INDEX IF NOT EXISTS idx_location_logs_timestamp ON location_logs(timestamp);
 CREATE INDEX IF NOT EXISTS idx_location_logs_device_id ON location_logs(device_id);
+CREATE INDEX IF NOT EXISTS idx_location_logs_project_date_course ON location_logs(project_id, operating_date, course_name);
+CREATE INDEX IF NOT EXISTS idx_location_logs_course_id ON location_logs(course_id);
 
 -- 写真メタデータ（実データは後で同期）
 CREATE TABLE IF NOT EXISTS photo_metadata (
@@ -74,6 +124,7 @@ CREATE TABLE IF NOT EXISTS photo_metadata (
     photo_synced INTEGER DEFAULT 0,
     taken_at DATETIME NOT NULL,
     created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    operating_date TEXT NOT NULL DEFAULT '',
     FOREIGN KEY (project_id) REFERENCES projects(id) ON DELETE CASCADE,
     FOREIGN KEY (route_stop_id) REFERENCES route_stops(id) ON DELETE SET NULL
 );
@@ -91,9 +142,311 @@ CREATE TABLE IF NOT EXISTS devices (
     course_name TEXT,
     last_seen_at DATETIME,
     created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    course_id INTEGER REFERENCES courses(id) ON DELETE SET NULL,
     FOREIGN KEY (project_id) REFERENCES projects(id) ON DELETE CASCADE,
     UNIQUE(project_id, device_id)
 );
 
 CREATE INDEX IF NOT EXISTS idx_devices_project ON devices(project_id);
 CREATE INDEX IF NOT EXISTS idx_devices_device_id ON devices(project_id, device_id);
+CREATE INDEX IF NOT EXISTS idx_devices_course_id ON devices(course_id);
+
+-- ルートCSV取り込み（プレビュー確認用に元ファイルとオプションを保持）
+CREATE TABLE IF NOT EXISTS route_imports (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    project_id INTEGER NOT NULL,
+    filename TEXT NOT NULL,
+    csv_data BLOB NOT NULL,
+    has_header BOOLEAN NOT NULL DEFAULT 1,
+    skip_departure BOOLEAN NOT NULL DEFAULT 0,
+    adjust_time BOOLEAN NOT NULL DEFAULT 0,
+    start_time TEXT,
+    status TEXT NOT NULL DEFAULT 'pending', -- pending, committed
+    row_count INTEGER NOT NULL DEFAULT 0,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    committed_at DATETIME,
+    version INTEGER, -- 確定時に案件内で1から採番
+    imported_by INTEGER, -- users.id
+    rollback_of_version INTEGER, -- ロールバックで作成した場合の元の版
+    operating_date TEXT NOT NULL DEFAULT '', -- 取り込み先の運行日
+    keep_manual_edits BOOLEAN NOT NULL DEFAULT 0, -- 画面から修正した停車地を上書きしない
+    FOREIGN KEY (project_id) REFERENCES projects(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_route_imports_project ON route_imports(project_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_route_imports_project_version ON route_imports(project_id, version);
+
+-- 取り込み確定時点の停車地スナップショット（版の比較・ロールバック用）
+CREATE TABLE IF NOT EXISTS route_import_stops (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    import_id INTEGER NOT NULL,
+    course_name TEXT NOT NULL,
+    sequence TEXT NOT NULL,
+    arrival_time TEXT,
+    stop_name TEXT NOT NULL,
+    address TEXT,
+    latitude REAL,
+    longitude REAL,
+    stay_minutes INTEGER DEFAULT 0,
+    weight_kg INTEGER DEFAULT 0,
+    phone_number TEXT,
+    note1 TEXT,
+    note2 TEXT,
+    note3 TEXT,
+    desired_time_start TEXT,
+    desired_time_end TEXT,
+    load_direction TEXT NOT NULL DEFAULT 'load',
+    customer_code TEXT,
+    location_id INTEGER REFERENCES locations(id) ON DELETE SET NULL, -- 照合していた位置マスタ
+    geofence_radius_m INTEGER, -- 到着判定の範囲（m）
+    geofence_polygon TEXT, -- 到着判定のエリア（GeoJSON の多角形）
+    geocode_source TEXT, -- 住所から座標を推定した検索元
+    geocode_confidence REAL,
+    geocode_status TEXT,
+    FOREIGN KEY (import_id) REFERENCES route_imports(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_route_import_stops_import ON route_import_stops(import_id);
+
+-- 画面からの停車地の修正履歴
+CREATE TABLE IF NOT EXISTS route_stop_edits (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    project_id INTEGER NOT NULL,
+    operating_date TEXT NOT NULL,
+    route_stop_id INTEGER REFERENCES route_stops(id) ON DELETE SET NULL,
+    course_name TEXT NOT NULL,
+    stop_name TEXT NOT NULL,
+    address TEXT,
+    action TEXT NOT NULL, -- create, update, delete, reorder, move
+    detail TEXT,
+    edited_by INTEGER, -- users.id
+    superseded_by_import_id INTEGER REFERENCES route_imports(id) ON DELETE SET NULL, -- 修正を上書きしたCSV取り込み
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (project_id) REFERENCES projects(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_route_stop_edits_project_date ON route_stop_edits(project_id, operating_date);
+
+-- コースの運行（ラン）。判定・表示は選択中の運行の期間内のログのみを対象とする
+CREATE TABLE IF NOT EXISTS course_runs (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    project_id INTEGER NOT NULL,
+    operating_date TEXT NOT NULL,
+    course_name TEXT NOT NULL,
+    started_at DATETIME NOT NULL,
+    ended_at DATETIME, -- NULLなら運行中
+    started_by INTEGER, -- users.id
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (project_id) REFERENCES projects(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_course_runs_project_date_course ON course_runs(project_id, operating_date, course_name);
+
+-- 車両マスタ
+CREATE TABLE IF NOT EXISTS vehicles (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    plate TEXT NOT NULL UNIQUE, -- 車両ナンバー
+    vehicle_type TEXT, -- 車種（2t、4t など）
+    max_load_kg INTEGER,
+    refrigerated BOOLEAN NOT NULL DEFAULT 0, -- 冷蔵・冷凍車
+    note TEXT,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+-- ドライバーマスタ
+CREATE TABLE IF NOT EXISTS drivers (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL,
+    phone TEXT,
+    license_expiry TEXT, -- 免許の有効期限（YYYY-MM-DD）
+    note TEXT,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+-- 運行日ごとのコースへの車両・ドライバーの割り当て
+CREATE TABLE IF NOT EXISTS course_assignments (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    course_id INTEGER NOT NULL,
+    operating_date TEXT NOT NULL,
+    vehicle_id INTEGER,
+    driver_id INTEGER,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (course_id) REFERENCES courses(id) ON DELETE CASCADE,
+    FOREIGN KEY (vehicle_id) REFERENCES vehicles(id) ON DELETE SET NULL,
+    FOREIGN KEY (driver_id) REFERENCES drivers(id) ON DELETE SET NULL,
+    UNIQUE(course_id, operating_date)
+);
+
+CREATE INDEX IF NOT EXISTS idx_course_assignments_date ON course_assignments(operating_date);
+
+-- 住所辞書（オフラインのジオコーディング用。確認済みの停車地の座標も登録する）
+CREATE TABLE IF NOT EXISTS geocode_dictionary (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    normalized_address TEXT NOT NULL UNIQUE, -- 表記ゆれをそろえた住所（検索キー）
+    address TEXT NOT NULL,
+    latitude REAL NOT NULL,
+    longitude REAL NOT NULL,
+    created_by INTEGER, -- users.id
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+-- 外部の住所検索APIの結果のキャッシュ
+CREATE TABLE IF NOT EXISTS geocode_cache (
+    normalized_address TEXT PRIMARY KEY,
+    latitude REAL NOT NULL,
+    longitude REAL NOT NULL,
+    confidence REAL NOT NULL,
+    source TEXT NOT NULL,
+    matched_address TEXT,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+-- 配送先の位置マスタ（運行日をまたいで使う停車地の情報。取り込み時に顧客コードまたは住所で照合し、CSVの値より優先する）
+CREATE TABLE IF NOT EXISTS locations (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    customer_code TEXT, -- 顧客コード（CSVの19列目）。住所より優先して照合する
+    normalized_address TEXT NOT NULL DEFAULT '', -- 表記ゆれをそろえた住所（顧客コードのない登録の照合キー）
+    name TEXT NOT NULL,
+    address TEXT NOT NULL DEFAULT '',
+    latitude REAL NOT NULL,
+    longitude REAL NOT NULL,
+    coordinate_source TEXT NOT NULL DEFAULT 'manual', -- learned: 実際の滞在位置から学習, manual: 手入力
+    confidence REAL, -- 学習した座標の信頼度（0〜1）
+    observations INTEGER, -- 学習に使った滞在の回数
+    phone_number TEXT,
+    contact_name TEXT, -- 先方の担当者
+    gate_instructions TEXT, -- 進入口・荷受け場所などの案内
+    stay_minutes INTEGER, -- 標準の滞在時間（分）
+    geofence_radius_m INTEGER, -- 到着判定の範囲（m）。NULLなら案件の設定
+    geofence_polygon TEXT, -- 到着判定のエリア（GeoJSON の多角形）。あれば範囲より優先する
+    note TEXT,
+    override_coordinates BOOLEAN NOT NULL DEFAULT 1, -- 取り込み時にCSVの座標をマスタの座標で上書きする
+    override_phone BOOLEAN NOT NULL DEFAULT 0, -- 取り込み時にCSVの電話番号を上書きする
+    override_stay_minutes BOOLEAN NOT NULL DEFAULT 0, -- 取り込み時にCSVの滞在時間を上書きする
+    created_by INTEGER, -- users.id
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_locations_customer_code ON locations(customer_code) WHERE customer_code IS NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_locations_normalized_address ON locations(normalized_address) WHERE customer_code IS NULL;
+
+-- 停車地ごとの到着判定の途中経過（位置ログの受信ごとに差分で更新し、画面はこれを読む）
+CREATE TABLE IF NOT EXISTS stop_visits (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    project_id INTEGER NOT NULL,
+    operating_date TEXT NOT NULL,
+    course_name TEXT NOT NULL,
+    course_run_id INTEGER NOT NULL DEFAULT 0, -- course_runs.id（0 は運行なし＝運行日の全ログ）
+    route_stop_id INTEGER NOT NULL,
+    entered_at DATETIME, -- 初めて範囲に入った時刻
+    stay_start_at DATETIME, -- 滞在条件を満たし始めた時刻
+    arrived_at DATETIME, -- 到着確定時の到着時刻
+    departed_at DATETIME, -- 出発時刻
+    in_range_since DATETIME, -- 範囲内に入り続けている開始時刻（範囲外ならNULL）
+    pending_since DATETIME, -- 範囲の出入りが確定する前の、今と異なる側の測位が続き始めた時刻
+    pending_weight REAL NOT NULL DEFAULT 0, -- その測位の重みの合計
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (route_stop_id, course_run_id),
+    FOREIGN KEY (project_id) REFERENCES projects(id) ON DELETE CASCADE,
+    FOREIGN KEY (route_stop_id) REFERENCES route_stops(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_stop_visits_project_date_course ON stop_visits(project_id, operating_date, course_name, course_run_id);
+
+-- コース・運行ごとの到着判定の進み具合
+CREATE TABLE IF NOT EXISTS stop_visit_progress (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    project_id INTEGER NOT NULL,
+    operating_date TEXT NOT NULL,
+    course_name TEXT NOT NULL,
+    course_run_id INTEGER NOT NULL DEFAULT 0, -- course_runs.id（0 は運行なし＝運行日の全ログ）
+    scanned_log_id INTEGER NOT NULL DEFAULT 0, -- このIDまでのログは判定済み
+    latest_log_id INTEGER, -- 判定に使った最新（時刻）のログ
+    fingerprint TEXT NOT NULL, -- 判定設定と停車地の座標・範囲から求めた値（変わったら判定し直す）
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    version INTEGER NOT NULL DEFAULT 1, -- 保存のたびに増やす（読み込んだ版から変わっていれば保存しない）
+    UNIQUE (project_id, operating_date, course_name, course_run_id),
+    FOREIGN KEY (project_id) REFERENCES projects(id) ON DELETE CASCADE
+);
+
+-- 停車地の到着・出発の手動での指定（運行ごと）。判定より優先する
+CREATE TABLE IF NOT EXISTS stop_overrides (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    project_id INTEGER NOT NULL,
+    operating_date TEXT NOT NULL,
+    course_name TEXT NOT NULL,
+    course_run_id INTEGER NOT NULL DEFAULT 0, -- course_runs.id（0 は運行なし＝運行日の全ログ）
+    route_stop_id INTEGER NOT NULL,
+    status TEXT NOT NULL, -- arrived: 到着済み / not_arrived: 未到着
+    arrived_at DATETIME,
+    departed_at DATETIME,
+    reason TEXT NOT NULL,
+    updated_by INTEGER, -- users.id
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (route_stop_id, course_run_id),
+    FOREIGN KEY (project_id) REFERENCES projects(id) ON DELETE CASCADE,
+    FOREIGN KEY (route_stop_id) REFERENCES route_stops(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_stop_overrides_project_date_course ON stop_overrides(project_id, operating_date, course_name, course_run_id);
+
+-- 手動での指定の変更履歴（指定・取り消しのたびに1行）
+CREATE TABLE IF NOT EXISTS stop_override_logs (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    project_id INTEGER NOT NULL,
+    operating_date TEXT NOT NULL,
+    course_name TEXT NOT NULL,
+    course_run_id INTEGER NOT NULL DEFAULT 0,
+    route_stop_id INTEGER REFERENCES route_stops(id) ON DELETE SET NULL,
+    stop_name TEXT NOT NULL,
+    action TEXT NOT NULL, -- set: 指定 / clear: 取り消し（判定に戻す）
+    status TEXT, -- 指定した状態（取り消しならNULL）
+    arrived_at DATETIME,
+    departed_at DATETIME,
+    reason TEXT NOT NULL,
+    edited_by INTEGER, -- users.id
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (project_id) REFERENCES projects(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_stop_override_logs_stop ON stop_override_logs(route_stop_id, course_run_id);
+
+-- 案件ごとの遅延アラートのルール（種類ごとに1件）
+CREATE TABLE IF NOT EXISTS alert_rules (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    project_id INTEGER NOT NULL,
+    kind TEXT NOT NULL, -- delay: 到着予測の遅れ / silent: 位置情報の途絶 / stationary: 停車地の外での停止 / time_window: 希望時間帯に間に合わない
+    threshold_minutes INTEGER NOT NULL, -- しきい値（分）
+    enabled BOOLEAN NOT NULL DEFAULT 1,
+    email_to TEXT NOT NULL DEFAULT '', -- 通知メールの宛先（カンマ区切り、空ならメールを送らない）
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (project_id, kind),
+    FOREIGN KEY (project_id) REFERENCES projects(id) ON DELETE CASCADE
+);
+
+-- 発生したアラート（同じ状況で繰り返し通知しないよう dedupe_key で1件にまとめる）
+CREATE TABLE IF NOT EXISTS alerts (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    project_id INTEGER NOT NULL,
+    kind TEXT NOT NULL,
+    operating_date TEXT NOT NULL,
+    course_name TEXT NOT NULL,
+    course_run_id INTEGER NOT NULL DEFAULT 0, -- course_runs.id（0 は運行なし＝運行日の全ログ）
+    route_stop_id INTEGER REFERENCES route_stops(id) ON DELETE SET NULL,
+    dedupe_key TEXT NOT NULL,
+    message TEXT NOT NULL,
+    emailed_at DATETIME, -- メールを送った日時
+    email_error TEXT, -- メールの送信に失敗した理由
+    acknowledged_at DATETIME,
+    acknowledged_by INTEGER, -- users.id
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (project_id, dedupe_key),
+    FOREIGN KEY (project_id) REFERENCES projects(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_alerts_project_created ON alerts(project_id, created_at);
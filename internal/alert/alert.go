@@ -0,0 +1,252 @@
+// Package alert は運行中のコースの状況を案件のアラートのルールと照らし合わせ、配車担当に知らせる状況を見つける
+// 到着予測の遅れ・位置情報の途絶・停車地の外での停止・希望時間帯に間に合わない見込みの4種類を扱う。
+// 同じ状況を繰り返し知らせないよう、状況ごとに Key を決める（呼び出し側で運行日・コース・運行と組み合わせて1件にまとめる）
+package alert
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/eta"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/geo"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/judge"
+)
+
+// ルールの種類（alert_rules.kind）
+const (
+	KindDelay      = "delay"       // 到着予測が到着予定より N 分以上遅れる
+	KindSilent     = "silent"      // 位置情報が N 分以上届かない
+	KindStationary = "stationary"  // 停車地の外で N 分以上止まっている
+	KindTimeWindow = "time_window" // 希望時間帯の終わりまで N 分を切って、間に合わない見込み
+)
+
+// Kinds はルールの種類（設定画面の表示順）
+var Kinds = []string{KindDelay, KindSilent, KindStationary, KindTimeWindow}
+
+// DefaultThresholdMinutes はルールを作るときのしきい値（分）の初期値
+var DefaultThresholdMinutes = map[string]int64{
+	KindDelay:      15,
+	KindSilent:     10,
+	KindStationary: 15,
+	KindTimeWindow: 30,
+}
+
+// KindLabel はルールの種類の表示
+func KindLabel(kind string) string {
+	switch kind {
+	case KindDelay:
+		return "到着予測の遅れ"
+	case KindSilent:
+		return "位置情報の途絶"
+	case KindStationary:
+		return "停車地の外での停止"
+	case KindTimeWindow:
+		return "希望時間帯に間に合わない見込み"
+	default:
+		return kind
+	}
+}
+
+// stationaryRadiusM は止まっているとみなす位置のばらつき（m）。測位誤差で動いて見える分を含める
+const stationaryRadiusM = 50.0
+
+// stationaryCellDeg は停止した場所を見分けるマス目の大きさ（度。緯度方向で約200m）
+// 測位誤差で止まり始めの時刻や位置が揺れても、同じ場所での停止は同じマス目になり1件にまとまる
+const stationaryCellDeg = 0.002
+
+// jst はメッセージの時刻の表示に使う日本標準時
+var jst = time.FixedZone("Asia/Tokyo", 9*60*60)
+
+// Rule はアラートのルール
+type Rule struct {
+	Kind             string
+	ThresholdMinutes int64
+}
+
+// Course は運行中のコースの状況
+type Course struct {
+	Name        string
+	Stops       []database.RouteStop
+	Timings     map[int64]*judge.StopTiming
+	Current     *judge.CurrentLocationInfo // 最新の位置（位置ログがなければ nil）
+	Predictions map[int64]eta.Prediction   // 未到着の停車地の到着予測
+	WindowEnds  map[int64]time.Time        // 希望時間帯の終わり（希望時間帯の終わりのある停車地だけ）
+	Logs        []database.LocationLog     // 運行期間内の位置ログ（時刻の昇順）
+	Settings    judge.Settings
+}
+
+// Finding は見つかった知らせるべき状況
+type Finding struct {
+	Kind    string
+	StopID  int64  // 停車地に関する状況なら停車地ID（なければ0）
+	Key     string // 同じ状況を見分けるキー（状況が続く間は変わらない）
+	Message string
+}
+
+// Evaluate は now の時点のコースの状況をルールと照らし合わせ、知らせるべき状況を返す
+// 位置ログがまだなければ、途絶（最初の停車地の到着予定から位置情報が届かない）だけを確かめる
+func Evaluate(rules []Rule, c Course, now time.Time) []Finding {
+	var findings []Finding
+	for _, rule := range rules {
+		if c.Current == nil && rule.Kind != KindSilent {
+			continue
+		}
+		threshold := time.Duration(rule.ThresholdMinutes) * time.Minute
+		var f *Finding
+		switch rule.Kind {
+		case KindDelay:
+			f = delayed(c, rule.ThresholdMinutes)
+		case KindSilent:
+			f = silent(c, now, threshold)
+		case KindStationary:
+			f = stationary(c, threshold)
+		case KindTimeWindow:
+			findings = append(findings, windowMisses(c, now, threshold)...)
+		}
+		if f != nil {
+			findings = append(findings, *f)
+		}
+	}
+	return findings
+}
+
+// delayed は到着予測の遅れがしきい値以上の、最も手前の未到着の停車地（遅れは先の停車地へ伝わるため1地点だけ知らせる）
+func delayed(c Course, thresholdMinutes int64) *Finding {
+	for _, stop := range c.Stops {
+		p, ok := c.Predictions[stop.ID]
+		if !ok || !p.HasPlan || int64(p.DelayMinutes) < thresholdMinutes {
+			continue
+		}
+		return &Finding{
+			Kind:   KindDelay,
+			StopID: stop.ID,
+			Key:    fmt.Sprintf("stop:%d", stop.ID),
+			Message: fmt.Sprintf("%s: %s の到着が予定より%d分遅れる見込みです（予定 %s → 予測 %s）",
+				c.Name, stop.StopName, p.DelayMinutes, stop.PlannedAt.Time.In(jst).Format("15:04"), p.ArrivalAt.In(jst).Format("15:04")),
+		}
+	}
+	return nil
+}
+
+// silent は最後の位置ログからしきい値以上たっているか（すべての停車地に到着済みなら運行を終えたとみなす）
+// 位置ログがまだなければ、最初の停車地（出発地点）の到着予定からしきい値以上たっているか
+func silent(c Course, now time.Time, threshold time.Duration) *Finding {
+	if c.Current == nil {
+		return notStarted(c, now, threshold)
+	}
+	last := c.Current.Timestamp
+	if now.Sub(last) < threshold || allArrived(c) {
+		return nil
+	}
+	return &Finding{
+		Kind: KindSilent,
+		Key:  fmt.Sprintf("last:%d", last.Unix()),
+		Message: fmt.Sprintf("%s: 位置情報が%d分以上届いていません（最終 %s）",
+			c.Name, int(now.Sub(last).Minutes()), last.In(jst).Format("15:04")),
+	}
+}
+
+// notStarted は最初の停車地の到着予定からしきい値以上たっても位置ログが1件も届いていないか（到着予定がなければ確かめない）
+func notStarted(c Course, now time.Time, threshold time.Duration) *Finding {
+	if len(c.Stops) == 0 || allArrived(c) {
+		return nil
+	}
+	first := c.Stops[0]
+	if !first.PlannedAt.Valid || now.Sub(first.PlannedAt.Time) < threshold {
+		return nil
+	}
+	planned := first.PlannedAt.Time
+	return &Finding{
+		Kind: KindSilent,
+		Key:  fmt.Sprintf("start:%d", planned.Unix()),
+		Message: fmt.Sprintf("%s: %s の到着予定（%s）から%d分たっても位置情報が届いていません",
+			c.Name, first.StopName, planned.In(jst).Format("15:04"), int(now.Sub(planned).Minutes())),
+	}
+}
+
+// stationary は最新の位置の周りに止まり続けている時間がしきい値以上で、その位置がどの停車地のエリアにも入っていないか
+// 止まっている間は、止まっている場所（マス目）を Key にする。運行中に同じ場所でまた止まっても知らせ直さない
+func stationary(c Course, threshold time.Duration) *Finding {
+	if len(c.Logs) == 0 || allArrived(c) {
+		return nil
+	}
+	latest := c.Logs[len(c.Logs)-1]
+	lat, lon, since := stoppedAt(c.Logs)
+	if latest.Timestamp.Sub(since) < threshold {
+		return nil
+	}
+	for _, stop := range c.Stops {
+		if fence, ok := c.Settings.FenceFor(stop); ok && fence.Contains(lat, lon) {
+			return nil
+		}
+	}
+	return &Finding{
+		Kind: KindStationary,
+		Key:  fmt.Sprintf("cell:%d,%d", int64(math.Floor(lat/stationaryCellDeg)), int64(math.Floor(lon/stationaryCellDeg))),
+		Message: fmt.Sprintf("%s: 停車地の外で%d分以上止まっています（%s から、%.6f, %.6f 付近）",
+			c.Name, int(latest.Timestamp.Sub(since).Minutes()), since.In(jst).Format("15:04"), lat, lon),
+	}
+}
+
+// stoppedAt は最新のログからさかのぼって止まっている場所（それまでの位置の中心）と止まり始めた時刻を返す
+// 中心から stationaryRadiusM 以内の位置が続く間を止まっているとみなす。1点だけ飛んだ位置（マルチパスなど）は飛ばす
+func stoppedAt(logs []database.LocationLog) (lat, lon float64, since time.Time) {
+	latest := logs[len(logs)-1]
+	sumLat, sumLon, n := latest.Latitude, latest.Longitude, 1.0
+	since = latest.Timestamp
+	within := func(log database.LocationLog) bool {
+		return geo.Haversine(log.Latitude, log.Longitude, sumLat/n, sumLon/n)*1000 <= stationaryRadiusM
+	}
+	for i := len(logs) - 2; i >= 0; i-- {
+		log := logs[i]
+		if !within(log) {
+			if i > 0 && within(logs[i-1]) {
+				continue
+			}
+			break
+		}
+		sumLat += log.Latitude
+		sumLon += log.Longitude
+		n++
+		since = log.Timestamp
+	}
+	return sumLat / n, sumLon / n, since
+}
+
+// windowMisses は希望時間帯の終わりまでしきい値を切り（過ぎたものを含む）、到着予測が終わりより後になる未到着の停車地
+func windowMisses(c Course, now time.Time, threshold time.Duration) []Finding {
+	var findings []Finding
+	for _, stop := range c.Stops {
+		end, ok := c.WindowEnds[stop.ID]
+		if !ok {
+			continue
+		}
+		p, ok := c.Predictions[stop.ID]
+		if !ok || !p.ArrivalAt.After(end) || end.Sub(now) > threshold {
+			continue
+		}
+		findings = append(findings, Finding{
+			Kind:   KindTimeWindow,
+			StopID: stop.ID,
+			Key:    fmt.Sprintf("stop:%d", stop.ID),
+			Message: fmt.Sprintf("%s: %s の希望時間帯（%s まで）に間に合わない見込みです（予測 %s）",
+				c.Name, stop.StopName, end.In(jst).Format("15:04"), p.ArrivalAt.In(jst).Format("15:04")),
+		})
+	}
+	return findings
+}
+
+// allArrived はすべての停車地（出発地点を除く）に到着済みか
+func allArrived(c Course) bool {
+	for _, stop := range c.Stops {
+		if stop.Sequence == judge.DepartureSequence {
+			continue
+		}
+		if t := c.Timings[stop.ID]; t == nil || !t.Arrived {
+			return false
+		}
+	}
+	return true
+}
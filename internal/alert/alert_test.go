@@ -0,0 +1,297 @@
+package alert
+
+import (
+	"database/sql"
+	"math"
+	"math/rand"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/eta"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/judge"
+)
+
+// at は 2025-12-01 の時刻（JST）
+func at(clock string) time.Time {
+	t, err := time.ParseInLocation("2006-01-02 15:04:05", "2025-12-01 "+clock, jst)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// 停止の判定に使う地点（営業所・A・B はこのあたり。parkLat/parkLon はどの停車地からも離れた路上）
+const (
+	parkLat = 35.6909000
+	parkLon = 139.7009000
+)
+
+// courseStops は 営業所 → A（09:00 着予定） → B（09:30 着予定）
+func courseStops() []database.RouteStop {
+	stop := func(id int64, sequence, name, planned string, lat, lon float64) database.RouteStop {
+		return database.RouteStop{
+			ID:        id,
+			Sequence:  sequence,
+			StopName:  name,
+			PlannedAt: sql.NullTime{Time: at(planned), Valid: true},
+			Latitude:  sql.NullFloat64{Float64: lat, Valid: true},
+			Longitude: sql.NullFloat64{Float64: lon, Valid: true},
+		}
+	}
+	return []database.RouteStop{
+		stop(1, judge.DepartureSequence, "営業所", "08:30:00", 35.6812000, 139.7671000),
+		stop(2, "1", "A", "09:00:00", 35.6812000, 139.7836888),
+		stop(3, "2", "B", "09:30:00", 35.6817390, 139.8002776),
+	}
+}
+
+// departed は営業所を出発済みの判定結果
+func departed() map[int64]*judge.StopTiming {
+	arrival, departure := at("08:30:00"), at("08:40:00")
+	return map[int64]*judge.StopTiming{1: {StopID: 1, Arrived: true, ArrivalTime: &arrival, DepartureTime: &departure}}
+}
+
+// allDone はすべての停車地に到着済みの判定結果
+func allDone() map[int64]*judge.StopTiming {
+	timings := departed()
+	for _, id := range []int64{2, 3} {
+		arrival := at("09:00:00")
+		timings[id] = &judge.StopTiming{StopID: id, Arrived: true, ArrivalTime: &arrival}
+	}
+	return timings
+}
+
+// parked は from から to まで10秒ごとに (lat, lon) にいるログ（jitterM > 0 なら測位誤差で最大その距離だけ揺らす）
+func parked(from, to string, lat, lon, jitterM float64, rng *rand.Rand) []database.LocationLog {
+	var logs []database.LocationLog
+	for ts := at(from); !ts.After(at(to)); ts = ts.Add(10 * time.Second) {
+		dLat, dLon := 0.0, 0.0
+		if jitterM > 0 {
+			// 1度あたり 緯度 約111km・経度 約90km
+			dLat = (rng.Float64()*2 - 1) * jitterM / 111000 / math.Sqrt2
+			dLon = (rng.Float64()*2 - 1) * jitterM / 90000 / math.Sqrt2
+		}
+		logs = append(logs, database.LocationLog{Latitude: lat + dLat, Longitude: lon + dLon, Timestamp: ts})
+	}
+	return logs
+}
+
+// course は現在位置を最後のログの位置にしたコースの状況
+func course(timings map[int64]*judge.StopTiming, logs []database.LocationLog, predictions map[int64]eta.Prediction, windowEnds map[int64]time.Time) Course {
+	c := Course{
+		Name:        "1便",
+		Stops:       courseStops(),
+		Timings:     timings,
+		Predictions: predictions,
+		WindowEnds:  windowEnds,
+		Logs:        logs,
+		Settings:    judge.Settings{ThresholdM: 100},
+	}
+	if len(logs) > 0 {
+		last := logs[len(logs)-1]
+		c.Current = &judge.CurrentLocationInfo{Latitude: last.Latitude, Longitude: last.Longitude, Timestamp: last.Timestamp}
+	}
+	return c
+}
+
+func predicted(arrival string, delayMinutes int) eta.Prediction {
+	return eta.Prediction{ArrivalAt: at(arrival), HasPlan: true, DelayMinutes: delayMinutes}
+}
+
+func TestEvaluate(t *testing.T) {
+	driving := []database.LocationLog{{Latitude: 35.6812, Longitude: 139.7750, Timestamp: at("09:10:00")}}
+
+	tests := []struct {
+		name   string
+		rule   Rule
+		course Course
+		now    string
+		want   []Finding // Message は比べない
+	}{
+		{
+			name:   "到着予測の遅れがしきい値以上の最も手前の停車地を知らせる",
+			rule:   Rule{Kind: KindDelay, ThresholdMinutes: 15},
+			course: course(departed(), driving, map[int64]eta.Prediction{2: predicted("09:20:00", 20), 3: predicted("09:50:00", 20)}, nil),
+			now:    "09:10:00",
+			want:   []Finding{{Kind: KindDelay, StopID: 2, Key: "stop:2"}},
+		},
+		{
+			name:   "遅れがしきい値未満なら知らせない",
+			rule:   Rule{Kind: KindDelay, ThresholdMinutes: 15},
+			course: course(departed(), driving, map[int64]eta.Prediction{2: predicted("09:10:00", 10)}, nil),
+			now:    "09:10:00",
+		},
+		{
+			name:   "到着予定のない停車地の遅れは知らせない",
+			rule:   Rule{Kind: KindDelay, ThresholdMinutes: 15},
+			course: course(departed(), driving, map[int64]eta.Prediction{2: {ArrivalAt: at("10:00:00"), DelayMinutes: 60}}, nil),
+			now:    "09:10:00",
+		},
+		{
+			name:   "位置情報がしきい値以上届かなければ最後の受信時刻で知らせる",
+			rule:   Rule{Kind: KindSilent, ThresholdMinutes: 10},
+			course: course(departed(), driving, nil, nil),
+			now:    "09:25:00",
+			want:   []Finding{{Kind: KindSilent, Key: "last:" + itoa(at("09:10:00").Unix())}},
+		},
+		{
+			name:   "途絶がしきい値未満なら知らせない",
+			rule:   Rule{Kind: KindSilent, ThresholdMinutes: 10},
+			course: course(departed(), driving, nil, nil),
+			now:    "09:15:00",
+		},
+		{
+			name:   "すべての停車地に到着済みなら途絶を知らせない",
+			rule:   Rule{Kind: KindSilent, ThresholdMinutes: 10},
+			course: course(allDone(), driving, nil, nil),
+			now:    "10:00:00",
+		},
+		{
+			name:   "停車地の外でしきい値以上止まっていれば知らせる",
+			rule:   Rule{Kind: KindStationary, ThresholdMinutes: 15},
+			course: course(departed(), parked("09:00:00", "09:20:00", parkLat, parkLon, 0, nil), nil, nil),
+			now:    "09:20:00",
+			want:   []Finding{{Kind: KindStationary, Key: cellKey(parkLat, parkLon)}},
+		},
+		{
+			name:   "止まっている時間がしきい値未満なら知らせない",
+			rule:   Rule{Kind: KindStationary, ThresholdMinutes: 15},
+			course: course(departed(), parked("09:00:00", "09:10:00", parkLat, parkLon, 0, nil), nil, nil),
+			now:    "09:10:00",
+		},
+		{
+			name:   "停車地のエリア内で止まっていても知らせない",
+			rule:   Rule{Kind: KindStationary, ThresholdMinutes: 15},
+			course: course(departed(), parked("09:00:00", "09:30:00", 35.6812000, 139.7836888, 0, nil), nil, nil),
+			now:    "09:30:00",
+		},
+		{
+			name: "1点だけ飛んだ位置があっても止まり続けているとみなす",
+			rule: Rule{Kind: KindStationary, ThresholdMinutes: 15},
+			course: func() Course {
+				logs := parked("09:00:00", "09:20:00", parkLat, parkLon, 0, nil)
+				logs[30].Latitude += 0.00225 // 約250m先
+				return course(departed(), logs, nil, nil)
+			}(),
+			now:  "09:20:00",
+			want: []Finding{{Kind: KindStationary, Key: cellKey(parkLat, parkLon)}},
+		},
+		{
+			name:   "希望時間帯の終わりが近く、到着予測が終わりより後なら知らせる",
+			rule:   Rule{Kind: KindTimeWindow, ThresholdMinutes: 30},
+			course: course(departed(), driving, map[int64]eta.Prediction{2: predicted("09:40:00", 40)}, map[int64]time.Time{2: at("09:30:00")}),
+			now:    "09:10:00",
+			want:   []Finding{{Kind: KindTimeWindow, StopID: 2, Key: "stop:2"}},
+		},
+		{
+			name:   "希望時間帯の終わりに間に合う見込みなら知らせない",
+			rule:   Rule{Kind: KindTimeWindow, ThresholdMinutes: 30},
+			course: course(departed(), driving, map[int64]eta.Prediction{2: predicted("09:20:00", 20)}, map[int64]time.Time{2: at("09:30:00")}),
+			now:    "09:10:00",
+		},
+		{
+			name:   "希望時間帯の終わりまでしきい値より余裕があれば知らせない",
+			rule:   Rule{Kind: KindTimeWindow, ThresholdMinutes: 30},
+			course: course(departed(), driving, map[int64]eta.Prediction{2: predicted("10:40:00", 100)}, map[int64]time.Time{2: at("10:30:00")}),
+			now:    "09:10:00",
+		},
+		{
+			name:   "位置ログがなければ最初の停車地の到着予定から途絶を知らせる",
+			rule:   Rule{Kind: KindSilent, ThresholdMinutes: 10},
+			course: course(nil, nil, nil, nil),
+			now:    "08:45:00",
+			want:   []Finding{{Kind: KindSilent, Key: "start:" + itoa(at("08:30:00").Unix())}},
+		},
+		{
+			name:   "位置ログがなくても最初の停車地の到着予定からしきい値未満なら知らせない",
+			rule:   Rule{Kind: KindSilent, ThresholdMinutes: 10},
+			course: course(nil, nil, nil, nil),
+			now:    "08:35:00",
+		},
+		{
+			name: "位置ログがなく最初の停車地に到着予定がなければ途絶を知らせない",
+			rule: Rule{Kind: KindSilent, ThresholdMinutes: 10},
+			course: func() Course {
+				c := course(nil, nil, nil, nil)
+				c.Stops[0].PlannedAt = sql.NullTime{}
+				return c
+			}(),
+			now: "12:00:00",
+		},
+		{
+			name:   "位置ログがなければ途絶のほかは知らせない",
+			rule:   Rule{Kind: KindDelay, ThresholdMinutes: 15},
+			course: course(nil, nil, map[int64]eta.Prediction{2: predicted("09:20:00", 20)}, nil),
+			now:    "09:10:00",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Evaluate([]Rule{tt.rule}, tt.course, at(tt.now))
+			if len(got) != len(tt.want) {
+				t.Fatalf("Evaluate = %+v, want %+v", got, tt.want)
+			}
+			for i, w := range tt.want {
+				if got[i].Kind != w.Kind || got[i].StopID != w.StopID || got[i].Key != w.Key {
+					t.Errorf("[%d] = %s 停車地 %d %q, want %s 停車地 %d %q", i, got[i].Kind, got[i].StopID, got[i].Key, w.Kind, w.StopID, w.Key)
+				}
+				if got[i].Message == "" {
+					t.Errorf("[%d] Message が空", i)
+				}
+			}
+		})
+	}
+}
+
+// 停めたトラックの位置が測位誤差で揺れても、毎分の確認で同じ停止を同じ状況（Key）として扱う
+// 別の場所へ動いて止まれば別の状況として知らせる
+func TestStationaryJitter(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	logs := parked("09:00:00", "09:40:00", parkLat, parkLon, 30, rng)
+	// 500m ほど先へ移動して、また40分止まる
+	moved := parked("09:41:00", "10:20:00", parkLat+0.0045, parkLon, 30, rng)
+	logs = append(logs, moved...)
+
+	rules := []Rule{{Kind: KindStationary, ThresholdMinutes: 15}}
+	var keys []string
+	seen := map[string]bool{}
+	firstAt := ""
+	for now := at("09:01:00"); !now.After(at("10:20:00")); now = now.Add(time.Minute) {
+		var upto []database.LocationLog
+		for _, log := range logs {
+			if !log.Timestamp.After(now) {
+				upto = append(upto, log)
+			}
+		}
+		for _, f := range Evaluate(rules, course(departed(), upto, nil, nil), now) {
+			if firstAt == "" {
+				firstAt = now.In(jst).Format("15:04")
+			}
+			if !seen[f.Key] {
+				seen[f.Key] = true
+				keys = append(keys, f.Key)
+			}
+		}
+	}
+
+	if firstAt != "09:15" {
+		t.Errorf("最初に知らせた時刻 = %q, want %q", firstAt, "09:15")
+	}
+	if len(keys) != 2 {
+		t.Fatalf("停止の Key = %v, want 2件（止まった場所ごとに1件）", keys)
+	}
+	if keys[0] != cellKey(parkLat, parkLon) {
+		t.Errorf("最初の停止の Key = %q, want %q", keys[0], cellKey(parkLat, parkLon))
+	}
+}
+
+func cellKey(lat, lon float64) string {
+	return "cell:" + itoa(int64(math.Floor(lat/stationaryCellDeg))) + "," + itoa(int64(math.Floor(lon/stationaryCellDeg)))
+}
+
+func itoa(v int64) string {
+	return strconv.FormatInt(v, 10)
+}
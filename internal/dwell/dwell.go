@@ -0,0 +1,159 @@
+// Package dwell は位置ログから車両が停まっていた場所（滞在）を検出し、停車地の実際の位置を推定する
+// 停車地の座標がずれていると到着判定の範囲から外れるため、過去の運行で実際に停まった位置から座標の修正案を作る
+package dwell
+
+import (
+	"sort"
+	"time"
+
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/geo"
+)
+
+// Point は位置ログの1点
+type Point struct {
+	Latitude  float64
+	Longitude float64
+	Time      time.Time
+}
+
+// Episode は一定範囲内に一定時間以上とどまった滞在
+type Episode struct {
+	Latitude  float64 // 滞在中の位置の重心
+	Longitude float64
+	Start     time.Time
+	End       time.Time
+	Points    int
+}
+
+// Duration は滞在時間
+func (e Episode) Duration() time.Duration {
+	return e.End.Sub(e.Start)
+}
+
+// Detect は時刻順の位置ログから滞在を検出する
+// ある点から radiusM 以内に続く点を1つの滞在とし、その時間が minDuration 以上のものを返す
+func Detect(points []Point, radiusM float64, minDuration time.Duration) []Episode {
+	var episodes []Episode
+	for i := 0; i < len(points); {
+		j := i + 1
+		for j < len(points) && distanceM(points[i].Latitude, points[i].Longitude, points[j].Latitude, points[j].Longitude) <= radiusM {
+			j++
+		}
+		if points[j-1].Time.Sub(points[i].Time) < minDuration {
+			i++
+			continue
+		}
+		var lat, lon float64
+		for _, p := range points[i:j] {
+			lat += p.Latitude
+			lon += p.Longitude
+		}
+		n := float64(j - i)
+		episodes = append(episodes, Episode{
+			Latitude:  lat / n,
+			Longitude: lon / n,
+			Start:     points[i].Time,
+			End:       points[j-1].Time,
+			Points:    j - i,
+		})
+		i = j
+	}
+	return episodes
+}
+
+// Nearby は予定の地点から searchRadiusM 以内の滞在のうち、最も長いものを返す（荷役の停車は信号待ちなどより長い）
+func Nearby(episodes []Episode, lat, lon, searchRadiusM float64) (Episode, bool) {
+	var best Episode
+	found := false
+	for _, e := range episodes {
+		if distanceM(lat, lon, e.Latitude, e.Longitude) > searchRadiusM {
+			continue
+		}
+		if !found || e.Duration() > best.Duration() {
+			best, found = e, true
+		}
+	}
+	return best, found
+}
+
+// Observation は1回の運行で停車地の近くに滞在した位置
+type Observation struct {
+	Latitude  float64
+	Longitude float64
+	Duration  time.Duration
+}
+
+// Suggestion は複数回の滞在から推定した停車地の位置
+type Suggestion struct {
+	Latitude   float64
+	Longitude  float64
+	Support    int     // 推定に使った滞在の回数（最も多く集まった位置の回数）
+	Visits     int     // 分析した運行の回数（ログのある運行日）
+	Confidence float64 // 0〜1
+}
+
+// fullSupport はこの回数以上同じ位置に滞在していれば回数による信頼度の割り引きをしない
+const fullSupport = 3
+
+// Suggest は滞在の位置をまとめ、最も多く集まった位置を停車地の位置として推定する
+// clusterRadiusM 以内に集まった滞在を同じ位置とみなし、滞在時間で重み付けした重心を返す
+// 信頼度は「同じ位置に滞在した割合（visits に対する回数）」を回数が少ないほど割り引いた値
+func Suggest(observations []Observation, visits int, clusterRadiusM float64) (Suggestion, bool) {
+	if len(observations) == 0 {
+		return Suggestion{}, false
+	}
+	if visits < len(observations) {
+		visits = len(observations)
+	}
+
+	// 近くの滞在が最も多い（同数なら滞在時間の合計が長い）滞在を中心にする
+	type candidate struct {
+		members []int
+		total   time.Duration
+	}
+	candidates := make([]candidate, len(observations))
+	for i, o := range observations {
+		for j, p := range observations {
+			if distanceM(o.Latitude, o.Longitude, p.Latitude, p.Longitude) <= clusterRadiusM {
+				candidates[i].members = append(candidates[i].members, j)
+				candidates[i].total += p.Duration
+			}
+		}
+	}
+	sort.SliceStable(candidates, func(a, b int) bool {
+		if len(candidates[a].members) != len(candidates[b].members) {
+			return len(candidates[a].members) > len(candidates[b].members)
+		}
+		return candidates[a].total > candidates[b].total
+	})
+	best := candidates[0]
+
+	var lat, lon, weight float64
+	for _, i := range best.members {
+		w := observations[i].Duration.Minutes()
+		if w <= 0 {
+			w = 1
+		}
+		lat += observations[i].Latitude * w
+		lon += observations[i].Longitude * w
+		weight += w
+	}
+
+	support := len(best.members)
+	confidence := float64(support) / float64(visits)
+	if support < fullSupport {
+		confidence *= float64(support) / fullSupport
+	}
+	return Suggestion{
+		Latitude:   lat / weight,
+		Longitude:  lon / weight,
+		Support:    support,
+		Visits:     visits,
+		Confidence: confidence,
+	}, true
+}
+
+// distanceM は2点間の距離（メートル）
+func distanceM(lat1, lon1, lat2, lon2 float64) float64 {
+	return geo.Haversine(lat1, lon1, lat2, lon2) * 1000
+}
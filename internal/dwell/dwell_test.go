@@ -0,0 +1,126 @@
+package dwell
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+var base = time.Date(2025, 12, 1, 9, 0, 0, 0, time.UTC)
+
+// track は 10秒ごとの位置ログ。positions の各地点に count 点ずつとどまる
+func track(positions ...stay) []Point {
+	var points []Point
+	t := base
+	for _, s := range positions {
+		for i := 0; i < s.count; i++ {
+			points = append(points, Point{Latitude: s.lat, Longitude: s.lon, Time: t})
+			t = t.Add(10 * time.Second)
+		}
+	}
+	return points
+}
+
+type stay struct {
+	lat, lon float64
+	count    int
+}
+
+func TestDetect(t *testing.T) {
+	// 地点 A に5分（31点）、移動中の1点、地点 B（約1.1km北）に1分（7点）、地点 C（さらに約1.1km北）に10分（61点）
+	points := track(
+		stay{35.6800, 139.7600, 31},
+		stay{35.6850, 139.7600, 1},
+		stay{35.6900, 139.7600, 7},
+		stay{35.7000, 139.7600, 61},
+	)
+	episodes := Detect(points, 30, 3*time.Minute)
+	if len(episodes) != 2 {
+		t.Fatalf("Detect = %d件, want 2件（短い停車は除く）: %+v", len(episodes), episodes)
+	}
+	if e := episodes[0]; e.Points != 31 || e.Duration() != 5*time.Minute || math.Abs(e.Latitude-35.68) > 1e-9 {
+		t.Errorf("1件目 = %+v, want 地点 A に5分", e)
+	}
+	if e := episodes[1]; e.Points != 61 || e.Duration() != 10*time.Minute || math.Abs(e.Latitude-35.70) > 1e-9 {
+		t.Errorf("2件目 = %+v, want 地点 C に10分", e)
+	}
+
+	// 位置は範囲内の点の重心
+	jitter := []Point{
+		{Latitude: 35.6800, Longitude: 139.7600, Time: base},
+		{Latitude: 35.6801, Longitude: 139.7600, Time: base.Add(2 * time.Minute)},
+		{Latitude: 35.6799, Longitude: 139.7600, Time: base.Add(4 * time.Minute)},
+	}
+	if got := Detect(jitter, 30, 3*time.Minute); len(got) != 1 || math.Abs(got[0].Latitude-35.68) > 1e-9 {
+		t.Errorf("Detect(揺れ) = %+v, want 重心 35.68 の1件", got)
+	}
+}
+
+func TestNearby(t *testing.T) {
+	episodes := []Episode{
+		{Latitude: 35.6800, Longitude: 139.7600, Start: base, End: base.Add(2 * time.Minute)},
+		{Latitude: 35.6802, Longitude: 139.7600, Start: base.Add(time.Hour), End: base.Add(time.Hour + 15*time.Minute)},
+		{Latitude: 35.7000, Longitude: 139.7600, Start: base.Add(2 * time.Hour), End: base.Add(3 * time.Hour)},
+	}
+	got, ok := Nearby(episodes, 35.6801, 139.7600, 100)
+	if !ok || got.Duration() != 15*time.Minute {
+		t.Errorf("Nearby = %+v, %v, want 範囲内で最も長い15分の滞在", got, ok)
+	}
+	if _, ok := Nearby(episodes, 35.6500, 139.7600, 100); ok {
+		t.Errorf("範囲内に滞在がなければ false")
+	}
+}
+
+func TestSuggest(t *testing.T) {
+	obs := func(lat float64, minutes int) Observation {
+		return Observation{Latitude: lat, Longitude: 139.7600, Duration: time.Duration(minutes) * time.Minute}
+	}
+	tests := []struct {
+		name           string
+		observations   []Observation
+		visits         int
+		wantLat        float64
+		wantSupport    int
+		wantConfidence float64
+	}{
+		{
+			name:         "最も多く集まった位置を滞在時間で重み付けした重心",
+			observations: []Observation{obs(35.6800, 10), obs(35.6801, 30), obs(35.6900, 60)},
+			visits:       3,
+			// (35.6800×10 + 35.6801×30) ÷ 40
+			wantLat:        35.680075,
+			wantSupport:    2,
+			wantConfidence: 2.0 / 3 * 2 / 3,
+		},
+		{
+			name:           "3回以上同じ位置なら回数で割り引かない",
+			observations:   []Observation{obs(35.6800, 10), obs(35.6800, 10), obs(35.6800, 10)},
+			visits:         4,
+			wantLat:        35.6800,
+			wantSupport:    3,
+			wantConfidence: 0.75,
+		},
+		{
+			name:           "運行の回数が滞在より少なければ滞在の回数にする",
+			observations:   []Observation{obs(35.6800, 10)},
+			visits:         0,
+			wantLat:        35.6800,
+			wantSupport:    1,
+			wantConfidence: 1.0 / 3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := Suggest(tt.observations, tt.visits, 30)
+			if !ok {
+				t.Fatal("Suggest = false")
+			}
+			if math.Abs(got.Latitude-tt.wantLat) > 1e-9 || got.Support != tt.wantSupport || math.Abs(got.Confidence-tt.wantConfidence) > 1e-9 {
+				t.Errorf("Suggest = %+v, want 緯度 %v 回数 %d 信頼度 %v", got, tt.wantLat, tt.wantSupport, tt.wantConfidence)
+			}
+		})
+	}
+	if _, ok := Suggest(nil, 3, 30); ok {
+		t.Errorf("滞在がなければ false")
+	}
+}
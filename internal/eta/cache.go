@@ -0,0 +1,59 @@
+package eta
+
+import (
+	"sync"
+	"time"
+)
+
+// LegCache は過去の運行から学んだ区間の所要時間を、案件・コース・運行日ごとに一定時間キャッシュする
+// 過去の運行日の到着・出発はほとんど変わらないため、画面のポーリングのたびに学び直さない
+type LegCache struct {
+	ttl     time.Duration
+	mu      sync.Mutex
+	entries map[legCacheKey]legCacheEntry
+}
+
+type legCacheKey struct {
+	projectID     int64
+	courseName    string
+	operatingDate string
+}
+
+type legCacheEntry struct {
+	legs      Legs
+	fetchedAt time.Time
+}
+
+// NewLegCache は新しいキャッシュを作成する（ttl が0以下なら10分）
+func NewLegCache(ttl time.Duration) *LegCache {
+	if ttl <= 0 {
+		ttl = 10 * time.Minute
+	}
+	return &LegCache{ttl: ttl, entries: make(map[legCacheKey]legCacheEntry)}
+}
+
+// Get はキャッシュされた区間の所要時間を返す（なければ・期限切れなら false）
+func (c *LegCache) Get(projectID int64, courseName, operatingDate string) (Legs, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[legCacheKey{projectID, courseName, operatingDate}]
+	if !ok || time.Since(e.fetchedAt) > c.ttl {
+		return nil, false
+	}
+	return e.legs, true
+}
+
+// Set は区間の所要時間をキャッシュに保存する（期限切れのものは消す）
+func (c *LegCache) Set(projectID int64, courseName, operatingDate string, legs Legs) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for key, e := range c.entries {
+		if now.Sub(e.fetchedAt) > c.ttl {
+			delete(c.entries, key)
+		}
+	}
+	c.entries[legCacheKey{projectID, courseName, operatingDate}] = legCacheEntry{legs: legs, fetchedAt: now}
+}
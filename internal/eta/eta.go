@@ -0,0 +1,212 @@
+// Package eta はコースの残りのすべての停車地の到着予測を求める
+// 現在の遅れを、停車地ごとの滞在予定と区間（前の停車地の出発 → 次の停車地の到着）の所要時間で先の停車地へ伝える。
+// 区間の所要時間は同じコースの過去の運行から学び、なければ到着予定の間隔、それもなければ直線距離から近似する
+package eta
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/judge"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/optimizer"
+)
+
+// 区間の所要時間の根拠（Prediction.Source）
+const (
+	SourceHistory  = "history"  // 同じコースの過去の運行
+	SourcePlan     = "plan"     // 到着予定の間隔
+	SourceDistance = "distance" // 直線距離 × 迂回係数 ÷ 平均速度
+)
+
+// maxLegDuration は過去の運行の区間として使う所要時間の上限（休憩や運行の中断を含む区間を除く）
+const maxLegDuration = 6 * time.Hour
+
+// StopKey は日をまたいで同じ停車地を見分けるキー（位置マスタに紐づいていればその地点、なければ地点名と住所）
+func StopKey(locationID sql.NullInt64, stopName, address string) string {
+	if locationID.Valid {
+		return fmt.Sprintf("location:%d", locationID.Int64)
+	}
+	normalize := func(s string) string {
+		return strings.Join(strings.Fields(s), "")
+	}
+	return "stop:" + normalize(stopName) + "\x00" + normalize(address)
+}
+
+// Visit は過去の運行での停車地の到着・出発
+type Visit struct {
+	Run        string // 運行の識別（同じ運行の到着・出発をまとめる）
+	StopKey    string
+	ArrivedAt  time.Time
+	DepartedAt *time.Time
+}
+
+type legKey struct {
+	from, to string
+}
+
+// Legs は過去の運行から学んだ区間ごとの所要時間
+type Legs map[legKey]time.Duration
+
+// Learn は過去の運行の到着・出発（運行ごとに到着時刻の昇順）から、続けて訪れた停車地の区間ごとの所要時間の中央値を求める
+func Learn(visits []Visit) Legs {
+	samples := make(map[legKey][]time.Duration)
+	for i := 1; i < len(visits); i++ {
+		prev, cur := visits[i-1], visits[i]
+		if prev.Run != cur.Run || prev.DepartedAt == nil || prev.StopKey == cur.StopKey {
+			continue
+		}
+		d := cur.ArrivedAt.Sub(*prev.DepartedAt)
+		if d < 0 || d > maxLegDuration {
+			continue
+		}
+		key := legKey{from: prev.StopKey, to: cur.StopKey}
+		samples[key] = append(samples[key], d)
+	}
+
+	legs := make(Legs, len(samples))
+	for key, ds := range samples {
+		sort.Slice(ds, func(i, j int) bool { return ds[i] < ds[j] })
+		legs[key] = ds[len(ds)/2]
+	}
+	return legs
+}
+
+// Prediction は停車地の到着予測
+type Prediction struct {
+	ArrivalAt    time.Time
+	DepartureAt  time.Time // 到着予測に滞在予定を足した出発予測
+	HasPlan      bool      // 到着予定があるか
+	DelayMinutes int       // 到着予定からの遅れ（正: 遅れ、負: 早い）
+	Source       string    // 直前の区間の所要時間の根拠
+}
+
+// Predict は最新の位置と到着・出発の判定から、最後に到着した停車地より後の未到着の停車地すべての到着予測を返す
+// 位置ログがなければ nil
+func Predict(stops []database.RouteStop, timings map[int64]*judge.StopTiming, current *judge.CurrentLocationInfo, legs Legs) map[int64]Prediction {
+	if current == nil {
+		return nil
+	}
+	now := current.Timestamp
+	last := -1
+	if current.FromStop != "" {
+		last = current.FromStopIdx
+	}
+
+	predictions := make(map[int64]Prediction)
+	var prev *database.RouteStop
+	var departure time.Time
+	inTransit := false // 最後に到着した停車地をすでに出発している
+	if last >= 0 {
+		prev = &stops[last]
+		departure = lastDeparture(*prev, timings[prev.ID], now)
+		inTransit = timings[prev.ID] != nil && timings[prev.ID].DepartureTime != nil
+	}
+
+	for i := last + 1; i < len(stops); i++ {
+		stop := stops[i]
+		if timing := timings[stop.ID]; timing != nil && timing.Arrived {
+			continue
+		}
+		if stop.Sequence == judge.DepartureSequence {
+			continue
+		}
+
+		var arrival time.Time
+		source := SourceDistance
+		switch {
+		case current.IsWithinRange && current.ToStopIdx == i:
+			// すでに範囲内にいる（到着の確定待ち）
+			arrival = now
+		case prev == nil:
+			// まだどこにも到着していない → 現在位置から向かう
+			arrival = now.Add(travelFrom(current, stop))
+		default:
+			var leg time.Duration
+			leg, source = legDuration(legs, *prev, stop)
+			arrival = departure.Add(leg)
+			if inTransit && len(predictions) == 0 {
+				// 走行中の区間は、現在位置から直線距離で向かった場合より早くは着かない
+				arrival = maxTime(arrival, now.Add(travelFrom(current, stop)))
+			}
+		}
+
+		p := Prediction{ArrivalAt: arrival, Source: source}
+		p.DepartureAt = arrival.Add(stayDuration(stop))
+		if stop.PlannedAt.Valid {
+			p.HasPlan = true
+			p.DelayMinutes = int(arrival.Sub(stop.PlannedAt.Time).Round(time.Minute).Minutes())
+		}
+		predictions[stop.ID] = p
+
+		prev = &stops[i]
+		departure = p.DepartureAt
+	}
+	return predictions
+}
+
+// lastDeparture は最後に到着した停車地の出発時刻（滞在中なら滞在予定を終えるまで、少なくとも現在まで）
+func lastDeparture(stop database.RouteStop, timing *judge.StopTiming, now time.Time) time.Time {
+	if timing == nil || timing.ArrivalTime == nil {
+		return now
+	}
+	if timing.DepartureTime != nil {
+		return *timing.DepartureTime
+	}
+	return maxTime(now, timing.ArrivalTime.Add(stayDuration(stop)))
+}
+
+// legDuration は2つの停車地の間の所要時間と根拠
+func legDuration(legs Legs, from, to database.RouteStop) (time.Duration, string) {
+	key := legKey{
+		from: StopKey(from.LocationID, from.StopName, from.Address.String),
+		to:   StopKey(to.LocationID, to.StopName, to.Address.String),
+	}
+	if d, ok := legs[key]; ok {
+		return d, SourceHistory
+	}
+	if from.PlannedAt.Valid && to.PlannedAt.Valid {
+		if d := to.PlannedAt.Time.Sub(from.PlannedAt.Time) - stayDuration(from); d > 0 {
+			return d, SourcePlan
+		}
+	}
+	if hasCoordinates(from) && hasCoordinates(to) {
+		return distanceModel.TravelTime(
+			optimizer.Stop{Latitude: from.Latitude.Float64, Longitude: from.Longitude.Float64},
+			optimizer.Stop{Latitude: to.Latitude.Float64, Longitude: to.Longitude.Float64},
+		), SourceDistance
+	}
+	return 0, SourcePlan
+}
+
+// distanceModel は過去の運行も到着予定もない区間の所要時間の近似（ルート最適化と同じ移動モデル）
+var distanceModel = optimizer.HaversineMatrix{SpeedKmh: optimizer.DefaultSpeedKmh, DetourFactor: optimizer.DefaultDetourFactor}
+
+// travelFrom は現在位置から停車地までの所要時間の近似（座標がなければ0）
+func travelFrom(current *judge.CurrentLocationInfo, stop database.RouteStop) time.Duration {
+	if !hasCoordinates(stop) {
+		return 0
+	}
+	return distanceModel.TravelTime(
+		optimizer.Stop{Latitude: current.Latitude, Longitude: current.Longitude},
+		optimizer.Stop{Latitude: stop.Latitude.Float64, Longitude: stop.Longitude.Float64},
+	)
+}
+
+func stayDuration(stop database.RouteStop) time.Duration {
+	return time.Duration(stop.StayMinutes.Int64) * time.Minute
+}
+
+func hasCoordinates(stop database.RouteStop) bool {
+	return stop.Latitude.Valid && stop.Longitude.Valid && (stop.Latitude.Float64 != 0 || stop.Longitude.Float64 != 0)
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
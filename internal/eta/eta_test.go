@@ -0,0 +1,243 @@
+package eta
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/judge"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/optimizer"
+)
+
+var jst = time.FixedZone("JST", 9*60*60)
+
+// at は 2025-12-01 の時刻（JST）
+func at(clock string) time.Time {
+	t, err := time.ParseInLocation("2006-01-02 15:04", "2025-12-01 "+clock, jst)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func ptr(t time.Time) *time.Time {
+	return &t
+}
+
+// stop は停車地（planned が空なら到着予定なし、lat/lon が0なら座標なし）
+func stop(id int64, sequence, name, planned string, stayMinutes int64, lat, lon float64) database.RouteStop {
+	s := database.RouteStop{
+		ID:          id,
+		Sequence:    sequence,
+		StopName:    name,
+		StayMinutes: sql.NullInt64{Int64: stayMinutes, Valid: stayMinutes > 0},
+		Latitude:    sql.NullFloat64{Float64: lat, Valid: lat != 0},
+		Longitude:   sql.NullFloat64{Float64: lon, Valid: lon != 0},
+	}
+	if planned != "" {
+		s.PlannedAt = sql.NullTime{Time: at(planned), Valid: true}
+	}
+	return s
+}
+
+func optimizerStop(s database.RouteStop) optimizer.Stop {
+	return optimizer.Stop{Latitude: s.Latitude.Float64, Longitude: s.Longitude.Float64}
+}
+
+func key(s database.RouteStop) string {
+	return StopKey(s.LocationID, s.StopName, s.Address.String)
+}
+
+func TestLearn(t *testing.T) {
+	visit := func(run, stop, arrived, departed string) Visit {
+		v := Visit{Run: run, StopKey: stop, ArrivedAt: at(arrived)}
+		if departed != "" {
+			v.DepartedAt = ptr(at(departed))
+		}
+		return v
+	}
+	tests := []struct {
+		name   string
+		visits []Visit
+		want   map[legKey]time.Duration
+	}{
+		{
+			name: "区間ごとの所要時間の中央値",
+			visits: []Visit{
+				visit("1", "A", "09:00", "09:10"), visit("1", "B", "09:20", "09:30"),
+				visit("2", "A", "09:00", "09:10"), visit("2", "B", "09:40", "09:50"),
+				visit("3", "A", "09:00", "09:10"), visit("3", "B", "09:30", "09:40"),
+			},
+			want: map[legKey]time.Duration{{"A", "B"}: 20 * time.Minute},
+		},
+		{
+			name: "別の運行にまたがる到着・出発は区間にしない",
+			visits: []Visit{
+				visit("1", "A", "09:00", "09:10"),
+				visit("2", "B", "09:20", "09:30"),
+			},
+			want: map[legKey]time.Duration{},
+		},
+		{
+			name: "出発していない停車地からの区間は使わない",
+			visits: []Visit{
+				visit("1", "A", "09:00", ""), visit("1", "B", "09:20", "09:30"), visit("1", "C", "09:45", ""),
+			},
+			want: map[legKey]time.Duration{{"B", "C"}: 15 * time.Minute},
+		},
+		{
+			name: "休憩などで長すぎる区間と同じ停車地への再到着は使わない",
+			visits: []Visit{
+				visit("1", "A", "06:00", "06:10"), visit("1", "B", "12:30", "12:40"),
+				visit("1", "B", "12:50", "13:00"),
+			},
+			want: map[legKey]time.Duration{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Learn(tt.visits)
+			if len(got) != len(tt.want) {
+				t.Fatalf("Learn = %v, want %v", got, tt.want)
+			}
+			for k, d := range tt.want {
+				if got[k] != d {
+					t.Errorf("区間 %s → %s = %v, want %v", k.from, k.to, got[k], d)
+				}
+			}
+		})
+	}
+}
+
+func TestLegDuration(t *testing.T) {
+	a := stop(1, "1", "A", "09:00", 10, 35.6812, 139.7671)
+	b := stop(2, "2", "B", "09:30", 10, 35.6812, 139.7836888)
+	noPlan := func(s database.RouteStop) database.RouteStop {
+		s.PlannedAt = sql.NullTime{}
+		return s
+	}
+	noCoords := func(s database.RouteStop) database.RouteStop {
+		s.Latitude, s.Longitude = sql.NullFloat64{}, sql.NullFloat64{}
+		return s
+	}
+	history := Legs{{key(a), key(b)}: 12 * time.Minute}
+
+	tests := []struct {
+		name       string
+		legs       Legs
+		from, to   database.RouteStop
+		want       time.Duration
+		wantSource string
+	}{
+		{name: "過去の運行があればその所要時間", legs: history, from: a, to: b, want: 12 * time.Minute, wantSource: SourceHistory},
+		{name: "過去の運行がなければ到着予定の間隔から滞在予定を引く", from: a, to: b, want: 20 * time.Minute, wantSource: SourcePlan},
+		{name: "到着予定もなければ直線距離から近似する", from: noPlan(a), to: noPlan(b), want: distanceModel.TravelTime(
+			optimizerStop(a), optimizerStop(b)), wantSource: SourceDistance},
+		{name: "座標もなければ0", from: noCoords(noPlan(a)), to: noCoords(noPlan(b)), want: 0, wantSource: SourcePlan},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, source := legDuration(tt.legs, tt.from, tt.to)
+			if got != tt.want || source != tt.wantSource {
+				t.Errorf("legDuration = %v (%s), want %v (%s)", got, source, tt.want, tt.wantSource)
+			}
+		})
+	}
+	if d, _ := legDuration(nil, noPlan(a), noPlan(b)); d <= 0 {
+		t.Errorf("直線距離からの近似 = %v, want > 0", d)
+	}
+}
+
+func TestPredict(t *testing.T) {
+	// 営業所 → A（09:00 着・10分滞在） → B（09:30 着・10分滞在） → C（10:00 着）。座標なし（予定の間隔で近似）
+	stops := []database.RouteStop{
+		stop(1, judge.DepartureSequence, "営業所", "08:30", 0, 0, 0),
+		stop(2, "1", "A", "09:00", 10, 0, 0),
+		stop(3, "2", "B", "09:30", 10, 0, 0),
+		stop(4, "3", "C", "10:00", 0, 0, 0),
+	}
+	arrived := func(id int64, arrival, departure string) *judge.StopTiming {
+		timing := &judge.StopTiming{StopID: id, Arrived: true, ArrivalTime: ptr(at(arrival))}
+		if departure != "" {
+			timing.DepartureTime = ptr(at(departure))
+		}
+		return timing
+	}
+	type want struct {
+		arrival string
+		delay   int
+		source  string
+	}
+
+	tests := []struct {
+		name    string
+		timings map[int64]*judge.StopTiming
+		current *judge.CurrentLocationInfo
+		legs    Legs
+		want    map[int64]want
+	}{
+		{
+			name:    "A での遅れが先の停車地へそのまま伝わる",
+			timings: map[int64]*judge.StopTiming{1: arrived(1, "08:30", "08:40"), 2: arrived(2, "09:15", "")},
+			current: &judge.CurrentLocationInfo{Timestamp: at("09:20"), FromStop: "A", FromStopIdx: 1, ToStop: "B", ToStopIdx: 2},
+			want: map[int64]want{
+				3: {"09:45", 15, SourcePlan},
+				4: {"10:15", 15, SourcePlan},
+			},
+		},
+		{
+			name:    "過去の運行の区間が予定より短ければ遅れを取り戻す",
+			timings: map[int64]*judge.StopTiming{1: arrived(1, "08:30", "08:40"), 2: arrived(2, "09:15", "")},
+			current: &judge.CurrentLocationInfo{Timestamp: at("09:20"), FromStop: "A", FromStopIdx: 1, ToStop: "B", ToStopIdx: 2},
+			legs:    Legs{{key(stops[1]), key(stops[2])}: 10 * time.Minute},
+			want: map[int64]want{
+				3: {"09:35", 5, SourceHistory},
+				4: {"10:05", 5, SourcePlan},
+			},
+		},
+		{
+			name:    "滞在予定を過ぎても出発していなければ現在から出発する",
+			timings: map[int64]*judge.StopTiming{1: arrived(1, "08:30", "08:40"), 2: arrived(2, "09:00", "")},
+			current: &judge.CurrentLocationInfo{Timestamp: at("09:30"), FromStop: "A", FromStopIdx: 1, ToStop: "B", ToStopIdx: 2},
+			want: map[int64]want{
+				3: {"09:50", 20, SourcePlan},
+				4: {"10:20", 20, SourcePlan},
+			},
+		},
+		{
+			name:    "範囲内で到着の確定を待っている停車地は現在を到着とする",
+			timings: map[int64]*judge.StopTiming{1: arrived(1, "08:30", "08:40"), 2: arrived(2, "09:00", "09:10")},
+			current: &judge.CurrentLocationInfo{Timestamp: at("09:25"), FromStop: "A", FromStopIdx: 1, ToStop: "B", ToStopIdx: 2, IsWithinRange: true},
+			want: map[int64]want{
+				3: {"09:25", -5, SourceDistance},
+				4: {"09:55", -5, SourcePlan},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Predict(stops, tt.timings, tt.current, tt.legs)
+			if len(got) != len(tt.want) {
+				t.Fatalf("予測した停車地の数 = %d, want %d (%v)", len(got), len(tt.want), got)
+			}
+			for id, w := range tt.want {
+				p, ok := got[id]
+				if !ok {
+					t.Fatalf("停車地 %d の予測がない", id)
+				}
+				if clock := p.ArrivalAt.In(jst).Format("15:04"); clock != w.arrival || p.DelayMinutes != w.delay || p.Source != w.source {
+					t.Errorf("停車地 %d = %s (遅れ %d分, %s), want %s (遅れ %d分, %s)",
+						id, clock, p.DelayMinutes, p.Source, w.arrival, w.delay, w.source)
+				}
+			}
+		})
+	}
+
+	if got := Predict(stops, nil, nil, nil); got != nil {
+		t.Errorf("位置ログがない場合の Predict = %v, want nil", got)
+	}
+}
@@ -22,3 +22,23 @@ func Haversine(lat1, lon1, lat2, lon2 float64) float64 {
 func degreesToRadians(deg float64) float64 {
 	return deg * math.Pi / 180
 }
+
+// Bearing は地点1から地点2への方位（真北から時計回りの度、0〜360）を計算する
+func Bearing(lat1, lon1, lat2, lon2 float64) float64 {
+	lat1Rad := degreesToRadians(lat1)
+	lat2Rad := degreesToRadians(lat2)
+	dLon := degreesToRadians(lon2 - lon1)
+
+	y := math.Sin(dLon) * math.Cos(lat2Rad)
+	x := math.Cos(lat1Rad)*math.Sin(lat2Rad) - math.Sin(lat1Rad)*math.Cos(lat2Rad)*math.Cos(dLon)
+	return math.Mod(math.Atan2(y, x)*180/math.Pi+360, 360)
+}
+
+// BearingDiff は2つの方位の差（度、0〜180）を返す
+func BearingDiff(a, b float64) float64 {
+	d := math.Mod(math.Abs(a-b), 360)
+	if d > 180 {
+		d = 360 - d
+	}
+	return d
+}
@@ -0,0 +1,216 @@
+package geo
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+)
+
+// Point は緯度・経度の組
+type Point struct {
+	Lat float64
+	Lon float64
+}
+
+// Polygon は多角形（最初の輪が外周、残りは穴）
+type Polygon [][]Point
+
+// MultiPolygon は1つ以上の多角形からなるエリア
+type MultiPolygon []Polygon
+
+// geoJSON は GeoJSON のうちエリアの読み取りに使う項目
+type geoJSON struct {
+	Type        string          `json:"type"`
+	Coordinates json.RawMessage `json:"coordinates"`
+	Geometry    *geoJSON        `json:"geometry"`
+	Features    []geoJSON       `json:"features"`
+}
+
+// ParseGeoJSON は GeoJSON（Polygon・MultiPolygon、またはそれを含む Feature・FeatureCollection）からエリアを読み取る
+// 座標は GeoJSON の決まりどおり [経度, 緯度] の順
+func ParseGeoJSON(data string) (MultiPolygon, error) {
+	var g geoJSON
+	if err := json.Unmarshal([]byte(data), &g); err != nil {
+		return nil, fmt.Errorf("GeoJSONとして読み取れません: %w", err)
+	}
+	area, err := g.polygons()
+	if err != nil {
+		return nil, err
+	}
+	if len(area) == 0 {
+		return nil, fmt.Errorf("GeoJSONに多角形（Polygon・MultiPolygon）がありません")
+	}
+	return area, nil
+}
+
+func (g geoJSON) polygons() (MultiPolygon, error) {
+	switch g.Type {
+	case "Polygon":
+		var coords [][][]float64
+		if err := json.Unmarshal(g.Coordinates, &coords); err != nil {
+			return nil, fmt.Errorf("Polygon の座標が不正です: %w", err)
+		}
+		p, err := toPolygon(coords)
+		if err != nil {
+			return nil, err
+		}
+		return MultiPolygon{p}, nil
+	case "MultiPolygon":
+		var coords [][][][]float64
+		if err := json.Unmarshal(g.Coordinates, &coords); err != nil {
+			return nil, fmt.Errorf("MultiPolygon の座標が不正です: %w", err)
+		}
+		area := make(MultiPolygon, 0, len(coords))
+		for _, c := range coords {
+			p, err := toPolygon(c)
+			if err != nil {
+				return nil, err
+			}
+			area = append(area, p)
+		}
+		return area, nil
+	case "Feature":
+		if g.Geometry == nil {
+			return nil, nil
+		}
+		return g.Geometry.polygons()
+	case "FeatureCollection":
+		var area MultiPolygon
+		for _, f := range g.Features {
+			polygons, err := f.polygons()
+			if err != nil {
+				return nil, err
+			}
+			area = append(area, polygons...)
+		}
+		return area, nil
+	default:
+		// 点・線などはエリアにならないため読み飛ばす
+		return nil, nil
+	}
+}
+
+func toPolygon(coords [][][]float64) (Polygon, error) {
+	if len(coords) == 0 {
+		return nil, fmt.Errorf("多角形の座標がありません")
+	}
+	p := make(Polygon, 0, len(coords))
+	for _, ring := range coords {
+		points := make([]Point, 0, len(ring))
+		for _, c := range ring {
+			if len(c) < 2 || c[1] < -90 || c[1] > 90 || c[0] < -180 || c[0] > 180 {
+				return nil, fmt.Errorf("多角形の座標が不正です: %v", c)
+			}
+			points = append(points, Point{Lat: c[1], Lon: c[0]})
+		}
+		// 閉じた輪（最初と最後が同じ点）も閉じていない輪も受け付ける
+		if len(points) > 1 && points[0] == points[len(points)-1] {
+			points = points[:len(points)-1]
+		}
+		if len(points) < 3 {
+			return nil, fmt.Errorf("多角形には3点以上が必要です")
+		}
+		p = append(p, points)
+	}
+	return p, nil
+}
+
+// Contains は点がエリア内（いずれかの多角形の外周の内側で、穴の外側）にあるかどうか
+// 停車地の規模（数km以内）では緯度・経度を平面とみなして判定する
+func (m MultiPolygon) Contains(lat, lon float64) bool {
+	for _, p := range m {
+		if p.Contains(lat, lon) {
+			return true
+		}
+	}
+	return false
+}
+
+// Contains は点が多角形の内側（穴を除く）にあるかどうか。外周・穴の境界線上の点は内側とする
+func (p Polygon) Contains(lat, lon float64) bool {
+	if len(p) == 0 {
+		return false
+	}
+	if onRing(p[0], lat, lon) {
+		return true
+	}
+	if !ringContains(p[0], lat, lon) {
+		return false
+	}
+	for _, hole := range p[1:] {
+		if onRing(hole, lat, lon) {
+			return true
+		}
+		if ringContains(hole, lat, lon) {
+			return false
+		}
+	}
+	return true
+}
+
+// onRingEpsilon は境界線上とみなす緯度・経度のずれ（約1cm）
+const onRingEpsilon = 1e-7
+
+// onRing は点が輪の辺（頂点を含む）の上にあるかどうか
+// 半直線の交差判定だけでは辺上の点が辺の向きによって内側にも外側にもなるため、先に確かめる
+func onRing(ring []Point, lat, lon float64) bool {
+	for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+		a, b := ring[j], ring[i]
+		if lat < math.Min(a.Lat, b.Lat)-onRingEpsilon || lat > math.Max(a.Lat, b.Lat)+onRingEpsilon ||
+			lon < math.Min(a.Lon, b.Lon)-onRingEpsilon || lon > math.Max(a.Lon, b.Lon)+onRingEpsilon {
+			continue
+		}
+		// 点から辺を含む直線までの距離（度）
+		dLat, dLon := b.Lat-a.Lat, b.Lon-a.Lon
+		length := math.Hypot(dLat, dLon)
+		if length == 0 {
+			return true
+		}
+		if math.Abs(dLon*(lat-a.Lat)-dLat*(lon-a.Lon))/length <= onRingEpsilon {
+			return true
+		}
+	}
+	return false
+}
+
+// ringContains は点から東へ伸ばした半直線が輪の辺と交わる回数で内外を判定する
+func ringContains(ring []Point, lat, lon float64) bool {
+	inside := false
+	for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+		a, b := ring[i], ring[j]
+		if (a.Lat > lat) != (b.Lat > lat) &&
+			lon < (b.Lon-a.Lon)*(lat-a.Lat)/(b.Lat-a.Lat)+a.Lon {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// DistanceM は点からエリアの境界までの最短距離（m）。エリアの内側かどうかは見ない
+// 点の周りを平面とみなして各辺までの距離を求める
+func (m MultiPolygon) DistanceM(lat, lon float64) float64 {
+	// 点を原点とした平面（m）に置き換える
+	mPerLat := earthRadiusKm * 1000 * math.Pi / 180
+	mPerLon := mPerLat * math.Cos(degreesToRadians(lat))
+	best := math.Inf(1)
+	for _, p := range m {
+		for _, ring := range p {
+			for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+				ax, ay := (ring[j].Lon-lon)*mPerLon, (ring[j].Lat-lat)*mPerLat
+				bx, by := (ring[i].Lon-lon)*mPerLon, (ring[i].Lat-lat)*mPerLat
+				best = math.Min(best, originToSegment(ax, ay, bx, by))
+			}
+		}
+	}
+	return best
+}
+
+// originToSegment は原点から線分 a-b までの距離
+func originToSegment(ax, ay, bx, by float64) float64 {
+	dx, dy := bx-ax, by-ay
+	t := 0.0
+	if l := dx*dx + dy*dy; l > 0 {
+		t = math.Max(0, math.Min(1, -(ax*dx+ay*dy)/l))
+	}
+	return math.Hypot(ax+t*dx, ay+t*dy)
+}
@@ -0,0 +1,124 @@
+package geo
+
+import (
+	"math"
+	"testing"
+)
+
+// square は (35.0, 139.0)〜(35.001, 139.001) の正方形（閉じた輪、[経度, 緯度] の順）
+const square = `{"type":"Polygon","coordinates":[[[139.0,35.0],[139.001,35.0],[139.001,35.001],[139.0,35.001],[139.0,35.0]]]}`
+
+// concave は上が開いたコの字形（凹多角形）。(35.0005, 139.0005) あたりがくぼみ
+const concave = `{"type":"Feature","properties":{},"geometry":{"type":"Polygon","coordinates":[[
+	[139.0,35.0],[139.0015,35.0],[139.0015,35.0015],[139.001,35.0015],
+	[139.001,35.0005],[139.0005,35.0005],[139.0005,35.0015],[139.0,35.0015]
+]]}}`
+
+// withHole は正方形の中央に穴のある多角形
+const withHole = `{"type":"Polygon","coordinates":[
+	[[139.0,35.0],[139.003,35.0],[139.003,35.003],[139.0,35.003],[139.0,35.0]],
+	[[139.001,35.001],[139.002,35.001],[139.002,35.002],[139.001,35.002],[139.001,35.001]]
+]}`
+
+func mustParse(t *testing.T, data string) MultiPolygon {
+	t.Helper()
+	area, err := ParseGeoJSON(data)
+	if err != nil {
+		t.Fatalf("ParseGeoJSON: %v", err)
+	}
+	return area
+}
+
+func TestContains(t *testing.T) {
+	tests := []struct {
+		name     string
+		area     string
+		lat, lon float64
+		want     bool
+	}{
+		{name: "正方形の内側", area: square, lat: 35.0005, lon: 139.0005, want: true},
+		{name: "正方形の外側", area: square, lat: 35.002, lon: 139.0005, want: false},
+		{name: "正方形の下の辺の上", area: square, lat: 35.0, lon: 139.0005, want: true},
+		{name: "正方形の上の辺の上", area: square, lat: 35.001, lon: 139.0005, want: true},
+		{name: "正方形の左の辺の上", area: square, lat: 35.0005, lon: 139.0, want: true},
+		{name: "正方形の右の辺の上", area: square, lat: 35.0005, lon: 139.001, want: true},
+		{name: "正方形の頂点", area: square, lat: 35.001, lon: 139.001, want: true},
+		{name: "辺の延長線上の外側", area: square, lat: 35.0, lon: 139.002, want: false},
+		{name: "凹多角形の左の腕の中", area: concave, lat: 35.001, lon: 139.00025, want: true},
+		{name: "凹多角形の右の腕の中", area: concave, lat: 35.001, lon: 139.00125, want: true},
+		{name: "凹多角形の底の中", area: concave, lat: 35.00025, lon: 139.00075, want: true},
+		{name: "凹多角形のくぼみは外側", area: concave, lat: 35.001, lon: 139.00075, want: false},
+		{name: "凹多角形のくぼみの底の辺の上", area: concave, lat: 35.0005, lon: 139.00075, want: true},
+		{name: "穴の外側で外周の内側", area: withHole, lat: 35.0005, lon: 139.0005, want: true},
+		{name: "穴の中は外側", area: withHole, lat: 35.0015, lon: 139.0015, want: false},
+		{name: "穴の辺の上はエリアの境界なので内側", area: withHole, lat: 35.001, lon: 139.0015, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mustParse(t, tt.area).Contains(tt.lat, tt.lon); got != tt.want {
+				t.Errorf("Contains(%v, %v) = %v, want %v", tt.lat, tt.lon, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseGeoJSON(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		polygons int
+		wantErr  bool
+	}{
+		{name: "Polygon", data: square, polygons: 1},
+		{name: "Feature", data: concave, polygons: 1},
+		{name: "閉じていない輪", data: `{"type":"Polygon","coordinates":[[[139.0,35.0],[139.001,35.0],[139.001,35.001]]]}`, polygons: 1},
+		{name: "MultiPolygon", data: `{"type":"MultiPolygon","coordinates":[[[[139.0,35.0],[139.001,35.0],[139.001,35.001]]],[[[139.1,35.1],[139.101,35.1],[139.101,35.101]]]]}`, polygons: 2},
+		{name: "FeatureCollection の点は読み飛ばす", data: `{"type":"FeatureCollection","features":[{"type":"Feature","geometry":{"type":"Point","coordinates":[139.0,35.0]}},` + concave + `]}`, polygons: 1},
+		{name: "JSONでない", data: `abc`, wantErr: true},
+		{name: "多角形がない", data: `{"type":"Point","coordinates":[139.0,35.0]}`, wantErr: true},
+		{name: "3点未満", data: `{"type":"Polygon","coordinates":[[[139.0,35.0],[139.001,35.0],[139.0,35.0]]]}`, wantErr: true},
+		{name: "緯度と経度が逆", data: `{"type":"Polygon","coordinates":[[[35.0,139.0],[35.001,139.0],[35.001,139.001]]]}`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			area, err := ParseGeoJSON(tt.data)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("ParseGeoJSON = %v, want error", area)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseGeoJSON: %v", err)
+			}
+			if len(area) != tt.polygons {
+				t.Errorf("多角形の数 = %d, want %d", len(area), tt.polygons)
+			}
+		})
+	}
+}
+
+func TestDistanceM(t *testing.T) {
+	area := mustParse(t, square)
+	// 1度あたり 緯度 約111.2km
+	mPerLat := earthRadiusKm * 1000 * math.Pi / 180
+
+	tests := []struct {
+		name     string
+		lat, lon float64
+		want     float64
+	}{
+		{name: "下の辺の10m南", lat: 35.0 - 10/mPerLat, lon: 139.0005, want: 10},
+		{name: "内側から上の辺まで", lat: 35.001 - 20/mPerLat, lon: 139.0005, want: 20},
+		{name: "辺の上", lat: 35.0, lon: 139.0005, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := area.DistanceM(tt.lat, tt.lon); math.Abs(got-tt.want) > 0.1 {
+				t.Errorf("DistanceM = %.2f, want %.2f", got, tt.want)
+			}
+		})
+	}
+}
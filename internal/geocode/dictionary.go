@@ -0,0 +1,67 @@
+package geocode
+
+import (
+	"context"
+	"strings"
+)
+
+// SourceDictionary は辞書から求めた結果の検索元
+const SourceDictionary = "dictionary"
+
+// partialMatchFactor は住所の末尾（号・番地など）を1段階削って一致したときに信頼度に掛ける係数
+const partialMatchFactor = 0.6
+
+// DictionaryEntry は住所辞書の1件
+type DictionaryEntry struct {
+	Address   string
+	Latitude  float64
+	Longitude float64
+}
+
+// DictionaryStore は正規化した住所で住所辞書を引く
+type DictionaryStore interface {
+	LookupAddress(ctx context.Context, normalized string) (DictionaryEntry, bool, error)
+}
+
+// Dictionary は住所辞書（オフライン）で座標を求める
+// 完全一致しなければ末尾の「-」区切り（号・番地・丁目）を順に削って引き、削った段階に応じて信頼度を下げる
+type Dictionary struct {
+	Store DictionaryStore
+}
+
+// Geocode は Geocoder を実装する
+func (d Dictionary) Geocode(ctx context.Context, address string) (Result, bool, error) {
+	key := Normalize(address)
+	confidence := 1.0
+	for key != "" {
+		entry, ok, err := d.Store.LookupAddress(ctx, key)
+		if err != nil {
+			return Result{}, false, err
+		}
+		if ok {
+			return Result{
+				Latitude:       entry.Latitude,
+				Longitude:      entry.Longitude,
+				Confidence:     confidence,
+				Source:         SourceDictionary,
+				MatchedAddress: entry.Address,
+			}, true, nil
+		}
+		key = trimLastBlock(key)
+		confidence *= partialMatchFactor
+	}
+	return Result{}, false, nil
+}
+
+// trimLastBlock は正規化した住所の末尾の番地を1段階削る（「丸の内1-9-1」→「丸の内1-9」→「丸の内1」→「丸の内」）
+// 削れる番地がなければ空文字を返す
+func trimLastBlock(normalized string) string {
+	if i := strings.LastIndex(normalized, "-"); i > 0 {
+		return normalized[:i]
+	}
+	trimmed := strings.TrimRight(normalized, "0123456789")
+	if trimmed == normalized {
+		return ""
+	}
+	return trimmed
+}
@@ -0,0 +1,149 @@
+// Package geocode は住所から緯度・経度を求める
+// 取り込み時に座標のない停車地に使う。辞書（オフライン）と外部のHTTP APIを差し替え・組み合わせられる
+package geocode
+
+import (
+	"context"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/width"
+)
+
+// ReviewThreshold はこの信頼度未満の結果を要確認とする
+const ReviewThreshold = 0.8
+
+// Result は住所の座標の検索結果
+type Result struct {
+	Latitude       float64
+	Longitude      float64
+	Confidence     float64 // 0〜1（住所全体が一致すれば1）
+	Source         string  // 検索元（"dictionary"、"http" など）
+	MatchedAddress string  // 一致した住所
+}
+
+// NeedsReview は信頼度が低く、人の確認が必要かどうか
+func (r Result) NeedsReview() bool {
+	return r.Confidence < ReviewThreshold
+}
+
+// Geocoder は住所から座標を求める。見つからなければ found は false
+type Geocoder interface {
+	Geocode(ctx context.Context, address string) (result Result, found bool, err error)
+}
+
+// Chain は複数の Geocoder を順に試し、最も信頼度の高い結果を返す（完全一致が見つかればそこで止める）
+type Chain []Geocoder
+
+// Geocode は Geocoder を実装する
+func (c Chain) Geocode(ctx context.Context, address string) (Result, bool, error) {
+	var best Result
+	found := false
+	for _, g := range c {
+		r, ok, err := g.Geocode(ctx, address)
+		if err != nil {
+			return best, found, err
+		}
+		if ok && (!found || r.Confidence > best.Confidence) {
+			best, found = r, true
+		}
+		if found && best.Confidence >= 1 {
+			break
+		}
+	}
+	return best, found, nil
+}
+
+// Cache は検索結果の保存先（正規化した住所をキーにする）
+type Cache interface {
+	Get(ctx context.Context, normalized string) (Result, bool, error)
+	Put(ctx context.Context, normalized string, r Result) error
+}
+
+// Cached は見つかった結果を Cache に保存し、同じ住所の再検索を省く
+type Cached struct {
+	Geocoder Geocoder
+	Cache    Cache
+}
+
+// Geocode は Geocoder を実装する
+func (c Cached) Geocode(ctx context.Context, address string) (Result, bool, error) {
+	key := Normalize(address)
+	if key == "" {
+		return Result{}, false, nil
+	}
+	if r, ok, err := c.Cache.Get(ctx, key); err != nil || ok {
+		return r, ok, err
+	}
+	r, ok, err := c.Geocoder.Geocode(ctx, address)
+	if err != nil || !ok {
+		return r, ok, err
+	}
+	if err := c.Cache.Put(ctx, key, r); err != nil {
+		return r, ok, err
+	}
+	return r, ok, nil
+}
+
+var (
+	spacePattern     = regexp.MustCompile(`\s+`)
+	chomePattern     = regexp.MustCompile(`([0-9一二三四五六七八九十]+)丁目`)
+	banchiPattern    = regexp.MustCompile(`([0-9])(番地の|番地|番の|番|の)`)
+	goPattern        = regexp.MustCompile(`([0-9])号`)
+	digitDashPattern = regexp.MustCompile(`([0-9])[-ー−‐－—―]+`)
+)
+
+// Normalize は住所の表記ゆれをそろえた比較用の文字列にする
+// 全角英数字を半角に、空白を除き、「1丁目2番3号」「一丁目2番地の3」「1−2−3」などを「1-2-3」にそろえる
+func Normalize(address string) string {
+	s := width.Fold.String(address)
+	s = spacePattern.ReplaceAllString(s, "")
+	s = chomePattern.ReplaceAllStringFunc(s, func(m string) string {
+		return kanjiToArabic(strings.TrimSuffix(m, "丁目")) + "-"
+	})
+	s = banchiPattern.ReplaceAllString(s, "$1-")
+	s = goPattern.ReplaceAllString(s, "$1")
+	s = digitDashPattern.ReplaceAllString(s, "$1-")
+	return strings.TrimRight(s, "-")
+}
+
+// kanjiToArabic は「一」〜「九十九」の漢数字を算用数字にする（算用数字はそのまま）
+func kanjiToArabic(s string) string {
+	digits := map[rune]int{'一': 1, '二': 2, '三': 3, '四': 4, '五': 5, '六': 6, '七': 7, '八': 8, '九': 9}
+	if !strings.ContainsAny(s, "一二三四五六七八九十") {
+		return s
+	}
+	n, cur := 0, 0
+	for _, r := range s {
+		switch {
+		case r == '十':
+			if cur == 0 {
+				cur = 1
+			}
+			n += cur * 10
+			cur = 0
+		case digits[r] > 0:
+			cur = digits[r]
+		}
+	}
+	n += cur
+	return strconv.Itoa(n)
+}
+
+// matchConfidence は正規化した検索住所と一致した住所の先頭からの一致率（完全一致で1）
+func matchConfidence(query, matched string) float64 {
+	if query == "" {
+		return 0
+	}
+	if query == matched {
+		return 1
+	}
+	q, m := []rune(query), []rune(matched)
+	n := 0
+	for n < len(q) && n < len(m) && q[n] == m[n] {
+		n++
+	}
+	return float64(n) / float64(utf8.RuneCountInString(query))
+}
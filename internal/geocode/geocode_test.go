@@ -0,0 +1,142 @@
+package geocode
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		address string
+		want    string
+	}{
+		{address: "東京都千代田区丸の内1丁目9番1号", want: "東京都千代田区丸の内1-9-1"},
+		{address: "東京都千代田区丸の内一丁目9番地の1", want: "東京都千代田区丸の内1-9-1"},
+		{address: "東京都千代田区丸の内１−９−１", want: "東京都千代田区丸の内1-9-1"},
+		{address: " 東京都 千代田区 丸の内 1-9-1 ", want: "東京都千代田区丸の内1-9-1"},
+		{address: "大阪市北区梅田三丁目1", want: "大阪市北区梅田3-1"},
+		{address: "札幌市中央区北二十四条西十五丁目", want: "札幌市中央区北二十四条西15"},
+		{address: "", want: ""},
+	}
+	for _, tt := range tests {
+		if got := Normalize(tt.address); got != tt.want {
+			t.Errorf("Normalize(%q) = %q, want %q", tt.address, got, tt.want)
+		}
+	}
+}
+
+func TestTrimLastBlock(t *testing.T) {
+	steps := []string{"丸の内1-9-1", "丸の内1-9", "丸の内1", "丸の内", ""}
+	for i := 0; i+1 < len(steps); i++ {
+		if got := trimLastBlock(steps[i]); got != steps[i+1] {
+			t.Errorf("trimLastBlock(%q) = %q, want %q", steps[i], got, steps[i+1])
+		}
+	}
+}
+
+// mapStore は住所辞書・キャッシュの代わりの map
+type mapStore map[string]Result
+
+func (m mapStore) LookupAddress(_ context.Context, normalized string) (DictionaryEntry, bool, error) {
+	r, ok := m[normalized]
+	return DictionaryEntry{Address: normalized, Latitude: r.Latitude, Longitude: r.Longitude}, ok, nil
+}
+
+func (m mapStore) Get(_ context.Context, normalized string) (Result, bool, error) {
+	r, ok := m[normalized]
+	return r, ok, nil
+}
+
+func (m mapStore) Put(_ context.Context, normalized string, r Result) error {
+	m[normalized] = r
+	return nil
+}
+
+func TestDictionary(t *testing.T) {
+	d := Dictionary{Store: mapStore{
+		"東京都千代田区丸の内1-9-1": {Latitude: 35.6812, Longitude: 139.7671},
+		"東京都千代田区丸の内1":     {Latitude: 35.6820, Longitude: 139.7650},
+	}}
+	tests := []struct {
+		name           string
+		address        string
+		wantFound      bool
+		wantLat        float64
+		wantConfidence float64
+	}{
+		{name: "完全一致", address: "東京都千代田区丸の内1丁目9番1号", wantFound: true, wantLat: 35.6812, wantConfidence: 1},
+		{name: "号・番地を2段階削って一致", address: "東京都千代田区丸の内1-5-3", wantFound: true, wantLat: 35.6820, wantConfidence: partialMatchFactor * partialMatchFactor},
+		{name: "見つからない", address: "大阪市北区梅田3-1", wantFound: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, found, err := d.Geocode(context.Background(), tt.address)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if found != tt.wantFound {
+				t.Fatalf("found = %v, want %v", found, tt.wantFound)
+			}
+			if found && (r.Latitude != tt.wantLat || r.Confidence != tt.wantConfidence || r.Source != SourceDictionary) {
+				t.Errorf("Geocode = %+v, want 緯度 %v 信頼度 %v", r, tt.wantLat, tt.wantConfidence)
+			}
+			if found && tt.wantConfidence < 1 && !r.NeedsReview() {
+				t.Errorf("一部一致の結果は要確認にする")
+			}
+		})
+	}
+}
+
+// fixed は常に同じ結果を返す Geocoder（呼ばれた回数を数える）
+type fixed struct {
+	result Result
+	found  bool
+	err    error
+	calls  *int
+}
+
+func (f fixed) Geocode(context.Context, string) (Result, bool, error) {
+	if f.calls != nil {
+		*f.calls++
+	}
+	return f.result, f.found, f.err
+}
+
+func TestChain(t *testing.T) {
+	var calls int
+	partial := fixed{result: Result{Confidence: 0.6, Source: "a"}, found: true}
+	better := fixed{result: Result{Confidence: 0.9, Source: "b"}, found: true}
+	exact := fixed{result: Result{Confidence: 1, Source: "c"}, found: true}
+	never := fixed{result: Result{Confidence: 1, Source: "d"}, found: true, calls: &calls}
+
+	r, found, err := Chain{partial, fixed{}, better}.Geocode(context.Background(), "住所")
+	if err != nil || !found || r.Source != "b" {
+		t.Errorf("Chain = %+v, %v, %v, want 最も信頼度の高い b", r, found, err)
+	}
+	r, _, _ = Chain{partial, exact, never}.Geocode(context.Background(), "住所")
+	if r.Source != "c" || calls != 0 {
+		t.Errorf("Chain = %+v（後続の呼び出し %d 回）, want 完全一致の c で止める", r, calls)
+	}
+	if _, _, err := (Chain{fixed{err: errors.New("失敗")}, exact}).Geocode(context.Background(), "住所"); err == nil {
+		t.Errorf("途中のエラーを返さない")
+	}
+}
+
+func TestCached(t *testing.T) {
+	var calls int
+	cache := mapStore{}
+	c := Cached{Geocoder: fixed{result: Result{Latitude: 35.68, Confidence: 1}, found: true, calls: &calls}, Cache: cache}
+	for i := 0; i < 2; i++ {
+		r, found, err := c.Geocode(context.Background(), "東京都千代田区丸の内1丁目9番1号")
+		if err != nil || !found || r.Latitude != 35.68 {
+			t.Fatalf("Cached = %+v, %v, %v", r, found, err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("検索の回数 = %d, want 1（2回目はキャッシュから）", calls)
+	}
+	if _, ok := cache["東京都千代田区丸の内1-9-1"]; !ok {
+		t.Errorf("正規化した住所で保存していない: %v", cache)
+	}
+}
@@ -0,0 +1,85 @@
+package geocode
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// SourceHTTP はHTTP APIから求めた結果の検索元
+const SourceHTTP = "http"
+
+// DefaultHTTPURL は国土地理院の住所検索API
+const DefaultHTTPURL = "https://msearch.gsi.go.jp/address-search/AddressSearch"
+
+// HTTPGeocoder は住所検索APIで座標を求める
+// 国土地理院の住所検索APIと同じ形式（?q=住所 で GeoJSON の Feature の配列を返す）に対応する
+type HTTPGeocoder struct {
+	baseURL    string
+	httpClient *http.Client
+}
+
+// NewHTTPGeocoder は住所検索APIのクライアントを作成する（baseURL が空なら国土地理院のAPI）
+func NewHTTPGeocoder(baseURL string) *HTTPGeocoder {
+	if baseURL == "" {
+		baseURL = DefaultHTTPURL
+	}
+	return &HTTPGeocoder{
+		baseURL: baseURL,
+		httpClient: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+	}
+}
+
+// addressSearchFeature は住所検索APIの結果1件
+type addressSearchFeature struct {
+	Geometry struct {
+		Coordinates []float64 `json:"coordinates"` // [経度, 緯度]
+	} `json:"geometry"`
+	Properties struct {
+		Title string `json:"title"`
+	} `json:"properties"`
+}
+
+// Geocode は Geocoder を実装する。先頭の結果を採用し、住所の一致率を信頼度にする
+func (g *HTTPGeocoder) Geocode(ctx context.Context, address string) (Result, bool, error) {
+	query := Normalize(address)
+	if query == "" {
+		return Result{}, false, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, g.baseURL+"?q="+url.QueryEscape(address), nil)
+	if err != nil {
+		return Result{}, false, fmt.Errorf("住所検索リクエスト作成失敗: %w", err)
+	}
+	resp, err := g.httpClient.Do(req)
+	if err != nil {
+		return Result{}, false, fmt.Errorf("住所検索失敗: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Result{}, false, fmt.Errorf("住所検索失敗: HTTP %d", resp.StatusCode)
+	}
+
+	var features []addressSearchFeature
+	if err := json.NewDecoder(resp.Body).Decode(&features); err != nil {
+		return Result{}, false, fmt.Errorf("住所検索結果の解析失敗: %w", err)
+	}
+	for _, f := range features {
+		if len(f.Geometry.Coordinates) < 2 {
+			continue
+		}
+		return Result{
+			Latitude:       f.Geometry.Coordinates[1],
+			Longitude:      f.Geometry.Coordinates[0],
+			Confidence:     matchConfidence(query, Normalize(f.Properties.Title)),
+			Source:         SourceHTTP,
+			MatchedAddress: f.Properties.Title,
+		}, true, nil
+	}
+	return Result{}, false, nil
+}
@@ -0,0 +1,278 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/alert"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/appconfig"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/judge"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/mailer"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/visits"
+)
+
+// alertCheckInterval はアラートのルールを確かめる間隔
+const alertCheckInterval = time.Minute
+
+// alertRecentLogs は停止のアラートを確かめるときに読む直近の位置ログの件数（10秒間隔で2時間分）
+const alertRecentLogs = 720
+
+// alertMailQueueSize は送信待ちにできる通知メールの数（SMTPが遅くてもルールの確認を止めない）
+const alertMailQueueSize = 100
+
+// AlertWorker は運行中のコースを定期的に案件のアラートのルールと照らし合わせ、新しいアラートを保存してメールで知らせる
+type AlertWorker struct {
+	h       *ProjectHandler
+	Mailer  *mailer.Mailer // nil ならメールを送らない
+	BaseURL string         // メール本文のリンク先（SERVER_ADDR）
+
+	mails chan alertMail // 送信待ちの通知メール
+}
+
+// alertMail は送信待ちのアラートの通知メール
+type alertMail struct {
+	alertID int64
+	to      []string
+	subject string
+	body    string
+}
+
+func NewAlertWorker(h *ProjectHandler, m *mailer.Mailer, baseURL string) *AlertWorker {
+	return &AlertWorker{h: h, Mailer: m, BaseURL: baseURL, mails: make(chan alertMail, alertMailQueueSize)}
+}
+
+// Run は ctx が終わるまで alertCheckInterval ごとにルールを確かめる（通知メールは別の goroutine で順に送る）
+func (w *AlertWorker) Run(ctx context.Context) {
+	if w.Mailer != nil {
+		go w.sendMails(ctx)
+	}
+
+	ticker := time.NewTicker(alertCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			w.check(ctx, time.Now())
+		}
+	}
+}
+
+// check は有効なルールのある案件をすべて確かめる
+func (w *AlertWorker) check(ctx context.Context, now time.Time) {
+	rows, err := w.h.DB.ListEnabledAlertRules(ctx)
+	if err != nil {
+		log.Printf("アラートのルールの取得に失敗: %v", err)
+		return
+	}
+	var projectIDs []int64
+	byProject := make(map[int64][]database.AlertRule)
+	for _, row := range rows {
+		if _, ok := byProject[row.ProjectID]; !ok {
+			projectIDs = append(projectIDs, row.ProjectID)
+		}
+		byProject[row.ProjectID] = append(byProject[row.ProjectID], row)
+	}
+	for _, id := range projectIDs {
+		if err := w.checkProject(ctx, id, byProject[id], now); err != nil {
+			log.Printf("案件 %d のアラートの確認に失敗: %v", id, err)
+		}
+	}
+}
+
+// checkProject は案件の運行中のコースを確かめる
+// 今日・昨日の運行日のうち、コースごとに運行中（終えていない運行がある）の運行日をすべて見る（0時をまたぐ前日の運行と当日の運行を両方見る）
+func (w *AlertWorker) checkProject(ctx context.Context, projectID int64, rows []database.AlertRule, now time.Time) error {
+	lp, err := w.h.DB.GetProject(ctx, projectID)
+	if err != nil {
+		return fmt.Errorf("案件の取得に失敗: %w", err)
+	}
+	rules := make([]alert.Rule, 0, len(rows))
+	ruleByKind := make(map[string]database.AlertRule, len(rows))
+	for _, row := range rows {
+		rules = append(rules, alert.Rule{Kind: row.Kind, ThresholdMinutes: row.ThresholdMinutes})
+		ruleByKind[row.Kind] = row
+	}
+
+	local := now.In(JST)
+	var courseNames []string
+	byCourse := make(map[string]map[string][]database.RouteStop) // コース → 運行日 → 停車地
+	for _, date := range []string{local.Format(operatingDateLayout), local.AddDate(0, 0, -1).Format(operatingDateLayout)} {
+		stops, err := w.h.DB.ListRouteStopsByProjectDate(ctx, database.ListRouteStopsByProjectDateParams{
+			ProjectID:     lp.ID,
+			OperatingDate: date,
+		})
+		if err != nil {
+			return fmt.Errorf("停車地の取得に失敗: %w", err)
+		}
+		for _, stop := range stops {
+			if _, ok := byCourse[stop.CourseName]; !ok {
+				courseNames = append(courseNames, stop.CourseName)
+				byCourse[stop.CourseName] = make(map[string][]database.RouteStop)
+			}
+			byCourse[stop.CourseName][date] = append(byCourse[stop.CourseName][date], stop)
+		}
+	}
+
+	for _, name := range courseNames {
+		targets, err := w.courseTargets(ctx, lp.ID, name, byCourse[name], now)
+		if err != nil {
+			log.Printf("コース %s の運行の取得に失敗: %v", name, err)
+			continue
+		}
+		for _, target := range targets {
+			if err := w.checkCourse(ctx, lp, target.date, name, byCourse[name][target.date], target.run, rules, ruleByKind, now); err != nil {
+				log.Printf("コース %s（運行日 %s）のアラートの確認に失敗: %v", name, target.date, err)
+			}
+		}
+	}
+	return nil
+}
+
+// alertTarget は確かめるコースの運行日と運行（運行を記録していなければ nil）
+type alertTarget struct {
+	date string
+	run  *database.CourseRun
+}
+
+// courseTargets はコースの確かめる運行日を選ぶ（stopsByDate は停車地のある運行日）
+// 運行のある運行日は最新の運行を終えていなければ選ぶ。運行のない運行日は、位置ログの受信と同じ決め方で選んだ運行日なら選ぶ（まだ走り出していないコースの途絶を知らせるため）
+func (w *AlertWorker) courseTargets(ctx context.Context, projectID int64, courseName string, stopsByDate map[string][]database.RouteStop, now time.Time) ([]alertTarget, error) {
+	resolved := resolveOperatingDate(ctx, w.h.DB, projectID, courseName, now)
+	var targets []alertTarget
+	for date := range stopsByDate {
+		runs, err := w.h.DB.ListCourseRuns(ctx, database.ListCourseRunsParams{
+			ProjectID:     projectID,
+			OperatingDate: date,
+			CourseName:    courseName,
+		})
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case len(runs) > 0 && !runs[0].EndedAt.Valid:
+			targets = append(targets, alertTarget{date: date, run: &runs[0]})
+		case len(runs) == 0 && date == resolved:
+			targets = append(targets, alertTarget{date: date})
+		}
+	}
+	sort.Slice(targets, func(i, j int) bool { return targets[i].date < targets[j].date })
+	return targets, nil
+}
+
+// checkCourse はコースの運行（run が nil なら運行日の全ログ）を確かめ、新しく見つかった状況をアラートとして保存して知らせる
+// 判定は位置ログの受信のたびに進めているため、保存済みの判定結果と直近の位置ログだけで確かめる
+func (w *AlertWorker) checkCourse(ctx context.Context, lp database.Project, date, courseName string, stops []database.RouteStop, run *database.CourseRun, rules []alert.Rule, ruleByKind map[string]database.AlertRule, now time.Time) error {
+	sc := visits.Scope{Project: lp, OperatingDate: date, CourseName: courseName, Run: run}
+	result, err := w.h.Visits.Load(ctx, sc, stops)
+	if err != nil {
+		return err
+	}
+	var logs []database.LocationLog
+	if _, ok := ruleByKind[alert.KindStationary]; ok {
+		logs, err = visits.ListRecentRunLogs(ctx, w.h.DB, lp.ID, date, courseName, run, alertRecentLogs)
+		if err != nil {
+			return fmt.Errorf("位置ログの取得に失敗: %w", err)
+		}
+	}
+	windowEnds := make(map[int64]time.Time)
+	for _, stop := range stops {
+		if _, end := desiredWindow(stop); end != nil {
+			windowEnds[stop.ID] = *end
+		}
+	}
+
+	findings := alert.Evaluate(rules, alert.Course{
+		Name:        courseName,
+		Stops:       stops,
+		Timings:     result.Timings,
+		Current:     result.Current,
+		Predictions: w.h.predictArrivals(ctx, sc, stops, result.Timings, result.Current),
+		WindowEnds:  windowEnds,
+		Logs:        logs,
+		Settings:    judge.SettingsFromProject(lp),
+	}, now)
+
+	for _, f := range findings {
+		created, err := w.h.DB.CreateAlert(ctx, database.CreateAlertParams{
+			ProjectID:     lp.ID,
+			Kind:          f.Kind,
+			OperatingDate: date,
+			CourseName:    courseName,
+			CourseRunID:   sc.RunID(),
+			RouteStopID:   toNullInt64(f.StopID),
+			DedupeKey:     fmt.Sprintf("%s:%s:%s:%d:%s", f.Kind, date, courseName, sc.RunID(), f.Key),
+			Message:       f.Message,
+		})
+		if errors.Is(err, sql.ErrNoRows) {
+			// 知らせ済みの状況
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("アラートの保存に失敗: %w", err)
+		}
+		w.notify(ctx, lp, ruleByKind[f.Kind], created)
+	}
+	return nil
+}
+
+// notify はルールの宛先へのアラートの通知メールを送信待ちにする（送信待ちがいっぱいなら送らずに記録する）
+func (w *AlertWorker) notify(ctx context.Context, lp database.Project, rule database.AlertRule, a database.Alert) {
+	if w.Mailer == nil || rule.EmailTo == "" {
+		return
+	}
+	to, err := mailer.ParseAddresses(rule.EmailTo)
+	if err != nil || len(to) == 0 {
+		return
+	}
+
+	m := alertMail{
+		alertID: a.ID,
+		to:      to,
+		subject: fmt.Sprintf("[%s] %s: %s", appconfig.AppName, lp.Name, alert.KindLabel(a.Kind)),
+		body: fmt.Sprintf("%s\n\n案件: %s\n運行日: %s\nコース: %s\n\nアラート一覧: %s/projects/%d/alerts\n",
+			a.Message, lp.Name, a.OperatingDate, a.CourseName, w.BaseURL, lp.ID),
+	}
+	select {
+	case w.mails <- m:
+	default:
+		log.Printf("アラート %d のメールを送信待ちにできません（送信待ちがいっぱい）", a.ID)
+		w.recordEmail(ctx, a.ID, errors.New("送信待ちのメールが多すぎるため送りませんでした"))
+	}
+}
+
+// sendMails は ctx が終わるまで送信待ちの通知メールを順に送り、送信の結果を記録する
+func (w *AlertWorker) sendMails(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case m := <-w.mails:
+			err := w.Mailer.Send(m.to, m.subject, m.body)
+			if err != nil {
+				log.Printf("アラート %d のメール送信に失敗: %v", m.alertID, err)
+			}
+			w.recordEmail(ctx, m.alertID, err)
+		}
+	}
+}
+
+// recordEmail はアラートのメール送信の結果（err が nil なら送信時刻）を記録する
+func (w *AlertWorker) recordEmail(ctx context.Context, alertID int64, sendErr error) {
+	params := database.UpdateAlertEmailParams{ID: alertID}
+	if sendErr != nil {
+		params.EmailError = toNullString(sendErr.Error())
+	} else {
+		params.EmailedAt = sql.NullTime{Time: time.Now(), Valid: true}
+	}
+	if err := w.h.DB.UpdateAlertEmail(ctx, params); err != nil {
+		log.Printf("アラート %d のメール送信結果の保存に失敗: %v", alertID, err)
+	}
+}
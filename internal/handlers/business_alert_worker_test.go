@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/naozine/project_crud_with_auth_tmpl/db"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
+	"github.com/pressly/goose/v3"
+	_ "modernc.org/sqlite"
+)
+
+// newTestQueries はマイグレーション済みの空のDBを作る
+func newTestQueries(t *testing.T) *database.Queries {
+	t.Helper()
+	conn, err := sql.Open("sqlite", "file:"+filepath.Join(t.TempDir(), "app.db")+"?_pragma=foreign_keys(on)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	goose.SetBaseFS(db.MigrationsFS)
+	goose.SetLogger(goose.NopLogger())
+	if err := goose.SetDialect("sqlite3"); err != nil {
+		t.Fatal(err)
+	}
+	if err := goose.Up(conn, "migrations"); err != nil {
+		t.Fatal(err)
+	}
+	return database.New(conn)
+}
+
+func TestCourseTargets(t *testing.T) {
+	ctx := context.Background()
+	q := newTestQueries(t)
+	lp, err := q.CreateProject(ctx, database.CreateProjectParams{Name: "テスト", ApiKey: "test", JudgeAccuracyMode: "discard", JudgeMinFixes: 1,
+		JudgeStayTimeMinutes: sql.NullInt64{Valid: true}, JudgeSpeedLimitKmh: sql.NullFloat64{Valid: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 毎日 22:00 発・翌 03:00 まで走る夜間のコース（12/1 と 12/2 の両方に配送ルートがある）
+	for _, date := range []string{"2025-12-01", "2025-12-02"} {
+		for _, stop := range []struct {
+			sequence string
+			planned  string
+		}{{departureSequence, date + " 22:00"}, {"1", nextDay(date) + " 03:00"}} {
+			if _, err := q.CreateRouteStop(ctx, database.CreateRouteStopParams{
+				ProjectID: lp.ID, OperatingDate: date, CourseName: "夜便", Sequence: stop.sequence, StopName: stop.sequence,
+				PlannedAt: utcAt(stop.planned), LoadDirection: "load",
+			}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	stopsByDate := map[string][]database.RouteStop{"2025-12-01": {{}}, "2025-12-02": {{}}}
+	w := &AlertWorker{h: &ProjectHandler{DB: q}}
+
+	targets := func(now string) []string {
+		t.Helper()
+		got, err := w.courseTargets(ctx, lp.ID, "夜便", stopsByDate, jstAt(now))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var dates []string
+		for _, target := range got {
+			label := target.date
+			if target.run != nil {
+				label += " 運行中"
+			}
+			dates = append(dates, label)
+		}
+		return dates
+	}
+
+	// 運行がなければ位置ログの受信と同じ決め方で選んだ運行日（0時過ぎは前日）
+	if got, want := targets("2025-12-02 01:00"), []string{"2025-12-01"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("運行なし = %v, want %v", got, want)
+	}
+
+	// 前日の運行が続いたまま当日の運行が始まれば、両方の運行日を確かめる
+	prev, err := q.CreateCourseRun(ctx, database.CreateCourseRunParams{ProjectID: lp.ID, OperatingDate: "2025-12-01", CourseName: "夜便", StartedAt: utcAt("2025-12-01 22:00").Time})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.CreateCourseRun(ctx, database.CreateCourseRunParams{ProjectID: lp.ID, OperatingDate: "2025-12-02", CourseName: "夜便", StartedAt: utcAt("2025-12-02 21:50").Time}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := targets("2025-12-02 22:00"), []string{"2025-12-01 運行中", "2025-12-02 運行中"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("両日の運行中 = %v, want %v", got, want)
+	}
+
+	// 運行を終えた運行日は確かめない
+	if err := q.EndCourseRun(ctx, database.EndCourseRunParams{ID: prev.ID, EndedAt: utcAt("2025-12-02 03:10")}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := targets("2025-12-02 22:00"), []string{"2025-12-02 運行中"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("前日の運行を終えた後 = %v, want %v", got, want)
+	}
+}
+
+// utcAt は日本時間の日時を UTC で表す（DBには UTC で保存する）
+func utcAt(s string) sql.NullTime {
+	return sql.NullTime{Time: jstAt(s).UTC(), Valid: true}
+}
+
+// nextDay は運行日の翌日
+func nextDay(date string) string {
+	t, err := parseOperatingDate(date)
+	if err != nil {
+		panic(err)
+	}
+	return t.AddDate(0, 0, 1).Format(operatingDateLayout)
+}
@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/alert"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/appcontext"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/mailer"
+	"github.com/naozine/project_crud_with_auth_tmpl/web/components"
+	"github.com/naozine/project_crud_with_auth_tmpl/web/layouts"
+)
+
+// getAlertProject は URL の案件を返す
+func (h *ProjectHandler) getAlertProject(c echo.Context) (database.Project, error) {
+	lpID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return database.Project{}, echo.NewHTTPError(http.StatusBadRequest, "無効な案件ID")
+	}
+	lp, err := h.DB.GetProject(c.Request().Context(), lpID)
+	if err != nil {
+		return database.Project{}, echo.NewHTTPError(http.StatusNotFound, "物流案件が見つかりません")
+	}
+	return lp, nil
+}
+
+// ShowAlerts はアラートのルールの設定と発生したアラートの一覧を表示する
+func (h *ProjectHandler) ShowAlerts(c echo.Context) error {
+	ctx := c.Request().Context()
+	lp, err := h.getAlertProject(c)
+	if err != nil {
+		return err
+	}
+	rows, err := h.DB.ListAlertRules(ctx, lp.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	alerts, err := h.DB.ListAlertsByProject(ctx, lp.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	// まだ保存していない種類は初期値（無効）で表示する
+	saved := make(map[string]database.AlertRule, len(rows))
+	for _, row := range rows {
+		saved[row.Kind] = row
+	}
+	rules := make([]components.AlertRuleSetting, 0, len(alert.Kinds))
+	for _, kind := range alert.Kinds {
+		setting := components.AlertRuleSetting{Kind: kind, ThresholdMinutes: alert.DefaultThresholdMinutes[kind]}
+		if row, ok := saved[kind]; ok {
+			setting.ThresholdMinutes = row.ThresholdMinutes
+			setting.Enabled = row.Enabled
+			setting.EmailTo = row.EmailTo
+		}
+		rules = append(rules, setting)
+	}
+
+	content := components.AlertsPage(lp, rules, alerts)
+	c.Response().Header().Set(echo.HeaderContentType, echo.MIMETextHTML)
+	if c.Request().Header.Get("HX-Request") == "true" {
+		return content.Render(ctx, c.Response().Writer)
+	}
+	return layouts.Base(fmt.Sprintf("アラート - %s", lp.Name), content).Render(ctx, c.Response().Writer)
+}
+
+// GetAlertList は発生したアラートの一覧のみを返す（htmx polling用）
+func (h *ProjectHandler) GetAlertList(c echo.Context) error {
+	ctx := c.Request().Context()
+	lp, err := h.getAlertProject(c)
+	if err != nil {
+		return err
+	}
+	alerts, err := h.DB.ListAlertsByProject(ctx, lp.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	c.Response().Header().Set(echo.HeaderContentType, echo.MIMETextHTML)
+	return components.AlertList(lp.ID, alerts).Render(ctx, c.Response().Writer)
+}
+
+// SaveAlertRules は種類ごとのアラートのルール（有効・しきい値・通知メールの宛先）を保存する
+func (h *ProjectHandler) SaveAlertRules(c echo.Context) error {
+	if err := h.checkPermission(c); err != nil {
+		return err
+	}
+	ctx := c.Request().Context()
+	lp, err := h.getAlertProject(c)
+	if err != nil {
+		return err
+	}
+
+	params := make([]database.UpsertAlertRuleParams, 0, len(alert.Kinds))
+	for _, kind := range alert.Kinds {
+		threshold, err := strconv.ParseInt(strings.TrimSpace(c.FormValue("threshold_"+kind)), 10, 64)
+		if err != nil || threshold < 1 {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("%s のしきい値は1分以上で入力してください", alert.KindLabel(kind)))
+		}
+		to, err := mailer.ParseAddresses(c.FormValue("email_to_" + kind))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("%s: %v", alert.KindLabel(kind), err))
+		}
+		params = append(params, database.UpsertAlertRuleParams{
+			ProjectID:        lp.ID,
+			Kind:             kind,
+			ThresholdMinutes: threshold,
+			Enabled:          c.FormValue("enabled_"+kind) == "on",
+			EmailTo:          strings.Join(to, ", "),
+		})
+	}
+
+	tx, err := h.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("トランザクション開始失敗: %v", err))
+	}
+	defer tx.Rollback()
+	qtx := h.DB.WithTx(tx)
+	for _, p := range params {
+		if err := qtx.UpsertAlertRule(ctx, p); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("アラートのルールの保存失敗: %v", err))
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("コミット失敗: %v", err))
+	}
+
+	// PRG: アラートページへリダイレクト
+	return c.Redirect(http.StatusSeeOther, fmt.Sprintf("/projects/%d/alerts", lp.ID))
+}
+
+// AcknowledgeAlert はアラートを確認済みにする
+func (h *ProjectHandler) AcknowledgeAlert(c echo.Context) error {
+	if err := h.checkPermission(c); err != nil {
+		return err
+	}
+	ctx := c.Request().Context()
+	lp, err := h.getAlertProject(c)
+	if err != nil {
+		return err
+	}
+	alertID, err := strconv.ParseInt(c.Param("alert_id"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なアラートID")
+	}
+	if err := h.DB.AcknowledgeAlert(ctx, database.AcknowledgeAlertParams{
+		AcknowledgedBy: toNullInt64(appcontext.GetUserID(ctx)),
+		ID:             alertID,
+		ProjectID:      lp.ID,
+	}); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("アラートの確認失敗: %v", err))
+	}
+	return c.Redirect(http.StatusSeeOther, fmt.Sprintf("/projects/%d/alerts", lp.ID))
+}
+
+// AcknowledgeAllAlerts は案件の未確認のアラートをすべて確認済みにする
+func (h *ProjectHandler) AcknowledgeAllAlerts(c echo.Context) error {
+	if err := h.checkPermission(c); err != nil {
+		return err
+	}
+	ctx := c.Request().Context()
+	lp, err := h.getAlertProject(c)
+	if err != nil {
+		return err
+	}
+	if err := h.DB.AcknowledgeAllAlerts(ctx, database.AcknowledgeAllAlertsParams{
+		AcknowledgedBy: toNullInt64(appcontext.GetUserID(ctx)),
+		ProjectID:      lp.ID,
+	}); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("アラートの確認失敗: %v", err))
+	}
+	return c.Redirect(http.StatusSeeOther, fmt.Sprintf("/projects/%d/alerts", lp.ID))
+}
@@ -0,0 +1,208 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/transform"
+)
+
+// courseAssignmentsByDate は運行日の車両・ドライバーの割り当てをコースIDごとに返す
+func (h *ProjectHandler) courseAssignmentsByDate(ctx context.Context, lpID int64, operatingDate string) (map[int64]database.ListCourseAssignmentsByProjectDateRow, error) {
+	rows, err := h.DB.ListCourseAssignmentsByProjectDate(ctx, database.ListCourseAssignmentsByProjectDateParams{
+		ProjectID:     lpID,
+		OperatingDate: operatingDate,
+	})
+	if err != nil {
+		return nil, err
+	}
+	assignments := make(map[int64]database.ListCourseAssignmentsByProjectDateRow, len(rows))
+	for _, row := range rows {
+		assignments[row.CourseID] = row
+	}
+	return assignments, nil
+}
+
+// parseOptionalID はフォームのID（空なら未選択）を読み取る
+func parseOptionalID(v string) (sql.NullInt64, error) {
+	if v == "" {
+		return sql.NullInt64{}, nil
+	}
+	id, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return sql.NullInt64{}, err
+	}
+	return sql.NullInt64{Int64: id, Valid: true}, nil
+}
+
+// AssignCourse は運行日のコースに車両・ドライバーを割り当てる
+// 同じ運行日に別のコースへ割り当て済みの車両・ドライバーは割り当てられない
+func (h *ProjectHandler) AssignCourse(c echo.Context) error {
+	if err := h.checkPermission(c); err != nil {
+		return err
+	}
+	ctx := c.Request().Context()
+	lp, course, err := h.getCourse(c)
+	if err != nil {
+		return err
+	}
+
+	operatingDate := c.FormValue("date")
+	if _, err := parseOperatingDate(operatingDate); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効な運行日")
+	}
+	vehicleID, err := parseOptionalID(c.FormValue("vehicle_id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効な車両ID")
+	}
+	driverID, err := parseOptionalID(c.FormValue("driver_id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なドライバーID")
+	}
+
+	redirect := fmt.Sprintf("/projects/%d/courses/%s?date=%s", lp.ID, url.PathEscape(course.Name), operatingDate)
+
+	if !vehicleID.Valid && !driverID.Valid {
+		if err := h.DB.DeleteCourseAssignment(ctx, database.DeleteCourseAssignmentParams{
+			CourseID:      course.ID,
+			OperatingDate: operatingDate,
+		}); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("割り当て解除失敗: %v", err))
+		}
+		return c.Redirect(http.StatusSeeOther, redirect)
+	}
+
+	others, err := h.DB.ListOtherCourseAssignmentsByDate(ctx, database.ListOtherCourseAssignmentsByDateParams{
+		OperatingDate: operatingDate,
+		CourseID:      course.ID,
+	})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	for _, other := range others {
+		if vehicleID.Valid && other.VehicleID == vehicleID {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("この車両は %s に同じ運行日で割り当て済みです", other.CourseName))
+		}
+		if driverID.Valid && other.DriverID == driverID {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("このドライバーは %s に同じ運行日で割り当て済みです", other.CourseName))
+		}
+	}
+
+	if err := h.DB.UpsertCourseAssignment(ctx, database.UpsertCourseAssignmentParams{
+		CourseID:      course.ID,
+		OperatingDate: operatingDate,
+		VehicleID:     vehicleID,
+		DriverID:      driverID,
+	}); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("割り当て失敗: %v", err))
+	}
+
+	// PRG: コース詳細ページへリダイレクト
+	return c.Redirect(http.StatusSeeOther, redirect)
+}
+
+// ExportCourses は運行日のコース一覧（車両・ドライバーの割り当てを含む）をCSVで出力する
+// 取り込みCSVと同じく Shift_JIS で出力する
+func (h *ProjectHandler) ExportCourses(c echo.Context) error {
+	ctx := c.Request().Context()
+	lpID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効な案件ID")
+	}
+	if _, err := h.DB.GetProject(ctx, lpID); err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "物流案件が見つかりません")
+	}
+
+	operatingDate, _, err := h.selectOperatingDate(c, lpID)
+	if err != nil {
+		return err
+	}
+
+	courses, err := h.DB.ListCoursesByProject(ctx, lpID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	stops, err := h.DB.ListRouteStopsByProjectDate(ctx, database.ListRouteStopsByProjectDateParams{
+		ProjectID:     lpID,
+		OperatingDate: operatingDate,
+	})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	assignments, err := h.courseAssignmentsByDate(ctx, lpID, operatingDate)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	stopCounts := make(map[string]int)
+	weights := make(map[string]int64)
+	for _, stop := range stops {
+		stopCounts[stop.CourseName]++
+		weights[stop.CourseName] += stop.WeightKg.Int64
+	}
+
+	c.Response().Header().Set(echo.HeaderContentType, "text/csv; charset=Shift_JIS")
+	c.Response().Header().Set(echo.HeaderContentDisposition,
+		fmt.Sprintf("attachment; filename=\"courses_%s.csv\"", operatingDate))
+	c.Response().WriteHeader(http.StatusOK)
+
+	sjis := transform.NewWriter(c.Response().Writer, encoding.ReplaceUnsupported(japanese.ShiftJIS.NewEncoder()))
+	w := csv.NewWriter(sjis)
+	w.Write([]string{
+		"運行日", "コース名", "停車地点数", "総重量(kg)",
+		"車両ナンバー", "車種", "最大積載量(kg)", "冷蔵・冷凍",
+		"ドライバー", "ドライバー電話番号", "免許有効期限",
+		"出発拠点", "出発予定時刻",
+	})
+	for _, course := range courses {
+		// 運行日の割り当てがなければコースに登録済みの車両・ドライバーを出力する
+		a := assignments[course.ID]
+		if !a.VehiclePlate.Valid {
+			a.VehiclePlate = course.VehiclePlate
+		}
+		if !a.DriverName.Valid {
+			a.DriverName = course.DriverName
+			a.DriverPhone = course.DriverPhone
+		}
+		if !a.MaxLoadKg.Valid {
+			a.MaxLoadKg = course.CapacityKg
+		}
+		refrigerated := ""
+		if a.Refrigerated.Valid && a.Refrigerated.Bool {
+			refrigerated = "○"
+		}
+		maxLoad := ""
+		if a.MaxLoadKg.Valid {
+			maxLoad = strconv.FormatInt(a.MaxLoadKg.Int64, 10)
+		}
+		w.Write([]string{
+			operatingDate,
+			course.Name,
+			strconv.Itoa(stopCounts[course.Name]),
+			strconv.FormatInt(weights[course.Name], 10),
+			a.VehiclePlate.String,
+			a.VehicleType.String,
+			maxLoad,
+			refrigerated,
+			a.DriverName.String,
+			a.DriverPhone.String,
+			a.LicenseExpiry.String,
+			course.DepotName.String,
+			course.PlannedStartTime.String,
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return sjis.Close()
+}
@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/appcontext"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/visits"
+)
+
+// StartCourseRun はコースの新しい運行を開始する
+// 運行中のものがあれば終了させ、以降の判定は新しい運行の開始時刻以降のログのみを対象にする（ログは削除しない）
+func (h *ProjectHandler) StartCourseRun(c echo.Context) error {
+	if err := h.checkPermission(c); err != nil {
+		return err
+	}
+	ctx := c.Request().Context()
+	lpID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効な案件ID")
+	}
+	courseName := c.Param("course_name")
+	operatingDate := c.FormValue("date")
+	if _, err := parseOperatingDate(operatingDate); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効な運行日")
+	}
+
+	now := time.Now()
+
+	tx, err := h.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("トランザクション開始失敗: %v", err))
+	}
+	defer tx.Rollback()
+	qtx := h.DB.WithTx(tx)
+
+	runs, err := qtx.ListCourseRuns(ctx, database.ListCourseRunsParams{
+		ProjectID:     lpID,
+		OperatingDate: operatingDate,
+		CourseName:    courseName,
+	})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	for _, run := range runs {
+		if !run.EndedAt.Valid {
+			if err := qtx.EndCourseRun(ctx, database.EndCourseRunParams{
+				EndedAt: sql.NullTime{Time: now, Valid: true},
+				ID:      run.ID,
+			}); err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("運行終了失敗: %v", err))
+			}
+		}
+	}
+
+	userID := appcontext.GetUserID(ctx)
+	run, err := qtx.CreateCourseRun(ctx, database.CreateCourseRunParams{
+		ProjectID:     lpID,
+		OperatingDate: operatingDate,
+		CourseName:    courseName,
+		StartedAt:     now,
+		StartedBy:     sql.NullInt64{Int64: userID, Valid: userID != 0},
+	})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("運行開始失敗: %v", err))
+	}
+
+	// 最初の運行なら、運行を始める前にした到着・出発の手動での指定（と変更履歴）をこの運行に移す
+	if len(runs) == 0 {
+		if err := qtx.MoveStopOverridesToRun(ctx, database.MoveStopOverridesToRunParams{
+			CourseRunID:   run.ID,
+			ProjectID:     lpID,
+			OperatingDate: operatingDate,
+			CourseName:    courseName,
+		}); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("手動での指定の引き継ぎ失敗: %v", err))
+		}
+		if err := qtx.MoveStopOverrideLogsToRun(ctx, database.MoveStopOverrideLogsToRunParams{
+			CourseRunID:   run.ID,
+			ProjectID:     lpID,
+			OperatingDate: operatingDate,
+			CourseName:    courseName,
+		}); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("手動での指定の引き継ぎ失敗: %v", err))
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("コミット失敗: %v", err))
+	}
+
+	// PRG: コース詳細ページへリダイレクト
+	return c.Redirect(http.StatusSeeOther, fmt.Sprintf("/projects/%d/courses/%s?date=%s", lpID, url.PathEscape(courseName), operatingDate))
+}
+
+// EndCourseRun は運行中の運行を終了する（終了後のログは判定対象外になる）
+func (h *ProjectHandler) EndCourseRun(c echo.Context) error {
+	if err := h.checkPermission(c); err != nil {
+		return err
+	}
+	ctx := c.Request().Context()
+	lpID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効な案件ID")
+	}
+	courseName := c.Param("course_name")
+	runID, err := strconv.ParseInt(c.Param("run_id"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効な運行ID")
+	}
+
+	run, err := h.DB.GetCourseRun(ctx, runID)
+	if err != nil || run.ProjectID != lpID || run.CourseName != courseName {
+		return echo.NewHTTPError(http.StatusNotFound, "運行が見つかりません")
+	}
+
+	if err := h.DB.EndCourseRun(ctx, database.EndCourseRunParams{
+		EndedAt: sql.NullTime{Time: time.Now(), Valid: true},
+		ID:      run.ID,
+	}); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("運行終了失敗: %v", err))
+	}
+
+	// PRG: コース詳細ページへリダイレクト
+	return c.Redirect(http.StatusSeeOther, fmt.Sprintf("/projects/%d/courses/%s?date=%s&run=%d", lpID, url.PathEscape(courseName), run.OperatingDate, run.ID))
+}
+
+// selectCourseRun は ?run= から表示する運行を決める
+// 未指定の場合は最新の運行。運行が1つもなければ nil（運行日の全ログが対象）
+// 戻り値は選択した運行と、その運行日・コースの運行一覧（新しい順）
+func (h *ProjectHandler) selectCourseRun(c echo.Context, lpID int64, operatingDate, courseName string) (*database.CourseRun, []database.CourseRun, error) {
+	runs, err := h.DB.ListCourseRuns(c.Request().Context(), database.ListCourseRunsParams{
+		ProjectID:     lpID,
+		OperatingDate: operatingDate,
+		CourseName:    courseName,
+	})
+	if err != nil {
+		return nil, nil, echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	if runParam := c.QueryParam("run"); runParam != "" {
+		runID, err := strconv.ParseInt(runParam, 10, 64)
+		if err != nil {
+			return nil, nil, echo.NewHTTPError(http.StatusBadRequest, "無効な運行ID")
+		}
+		for i := range runs {
+			if runs[i].ID == runID {
+				return &runs[i], runs, nil
+			}
+		}
+		return nil, nil, echo.NewHTTPError(http.StatusNotFound, "運行が見つかりません")
+	}
+
+	if len(runs) == 0 {
+		return nil, runs, nil
+	}
+	return &runs[0], runs, nil
+}
+
+// listCourseRunLogs は運行期間内の位置情報ログを昇順で返す
+// run が nil の場合は運行日の全ログを返す
+func (h *ProjectHandler) listCourseRunLogs(ctx context.Context, lpID int64, operatingDate, courseName string, run *database.CourseRun) ([]database.LocationLog, error) {
+	return visits.ListRunLogs(ctx, h.DB, lpID, operatingDate, courseName, run)
+}
@@ -0,0 +1,261 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
+	"github.com/naozine/project_crud_with_auth_tmpl/web/components"
+	"github.com/naozine/project_crud_with_auth_tmpl/web/layouts"
+)
+
+// ensureCourse はコース名に対応するコースを返す。なければ属性が空のコースを作成する
+// CSV取り込みや端末の割り当てなど、コース名しかわからない箇所から使う
+func ensureCourse(ctx context.Context, q *database.Queries, lpID int64, name string) (database.Course, error) {
+	course, err := q.GetCourseByName(ctx, database.GetCourseByNameParams{
+		ProjectID: lpID,
+		Name:      name,
+	})
+	if err == nil {
+		return course, nil
+	}
+	if err != sql.ErrNoRows {
+		return course, fmt.Errorf("コース取得失敗: %w", err)
+	}
+	course, err = q.CreateCourse(ctx, database.CreateCourseParams{
+		ProjectID: lpID,
+		Name:      name,
+	})
+	if err != nil {
+		return course, fmt.Errorf("コース %s の作成失敗: %w", name, err)
+	}
+	return course, nil
+}
+
+// getCourse はURLの :id と :course_name からコースを取得する
+func (h *ProjectHandler) getCourse(c echo.Context) (database.Project, database.Course, error) {
+	ctx := c.Request().Context()
+	lpID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return database.Project{}, database.Course{}, echo.NewHTTPError(http.StatusBadRequest, "無効な案件ID")
+	}
+	lp, err := h.DB.GetProject(ctx, lpID)
+	if err != nil {
+		return database.Project{}, database.Course{}, echo.NewHTTPError(http.StatusNotFound, "物流案件が見つかりません")
+	}
+	course, err := h.DB.GetCourseByName(ctx, database.GetCourseByNameParams{
+		ProjectID: lpID,
+		Name:      c.Param("course_name"),
+	})
+	if err != nil {
+		return lp, database.Course{}, echo.NewHTTPError(http.StatusNotFound, "コースが見つかりません")
+	}
+	return lp, course, nil
+}
+
+// courseAttributes はコース編集フォームの入力値
+type courseAttributes struct {
+	VehiclePlate     sql.NullString
+	DriverName       sql.NullString
+	DriverPhone      sql.NullString
+	CapacityKg       sql.NullInt64
+	DepotName        sql.NullString
+	DepotAddress     sql.NullString
+	DepotLatitude    sql.NullFloat64
+	DepotLongitude   sql.NullFloat64
+	PlannedStartTime sql.NullString
+	Note             sql.NullString
+}
+
+// parseCourseAttributes はコース編集フォームを検証して読み取る
+func parseCourseAttributes(c echo.Context) (courseAttributes, error) {
+	value := func(name string) string {
+		return strings.TrimSpace(c.FormValue(name))
+	}
+	attrs := courseAttributes{
+		VehiclePlate: toNullString(value("vehicle_plate")),
+		DriverName:   toNullString(value("driver_name")),
+		DriverPhone:  toNullString(value("driver_phone")),
+		DepotName:    toNullString(value("depot_name")),
+		DepotAddress: toNullString(value("depot_address")),
+		Note:         toNullString(value("note")),
+	}
+
+	if v := value("capacity_kg"); v != "" {
+		capacity, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || capacity < 0 {
+			return attrs, fmt.Errorf("最大積載量は0以上の整数で入力してください")
+		}
+		attrs.CapacityKg = sql.NullInt64{Int64: capacity, Valid: true}
+	}
+
+	lat, lng := value("depot_latitude"), value("depot_longitude")
+	if (lat == "") != (lng == "") {
+		return attrs, fmt.Errorf("出発拠点の緯度と経度は両方入力してください")
+	}
+	if lat != "" {
+		latF, err := strconv.ParseFloat(lat, 64)
+		if err != nil || latF < -90 || latF > 90 {
+			return attrs, fmt.Errorf("出発拠点の緯度が不正です: %s", lat)
+		}
+		lngF, err := strconv.ParseFloat(lng, 64)
+		if err != nil || lngF < -180 || lngF > 180 {
+			return attrs, fmt.Errorf("出発拠点の経度が不正です: %s", lng)
+		}
+		attrs.DepotLatitude = sql.NullFloat64{Float64: latF, Valid: true}
+		attrs.DepotLongitude = sql.NullFloat64{Float64: lngF, Valid: true}
+	}
+
+	if v := value("planned_start_time"); v != "" {
+		if _, err := parseTimeToMinutes(v); err != nil {
+			return attrs, fmt.Errorf("出発予定時刻は HH:MM 形式で入力してください")
+		}
+		attrs.PlannedStartTime = sql.NullString{String: v, Valid: true}
+	}
+
+	return attrs, nil
+}
+
+// CreateCourse はコースを作成する（停車地がまだないコースも作成できる）
+func (h *ProjectHandler) CreateCourse(c echo.Context) error {
+	if err := h.checkPermission(c); err != nil {
+		return err
+	}
+	ctx := c.Request().Context()
+	lpID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効な案件ID")
+	}
+
+	name := strings.TrimSpace(c.FormValue("name"))
+	if name == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "コース名を入力してください")
+	}
+	if _, err := h.DB.GetCourseByName(ctx, database.GetCourseByNameParams{ProjectID: lpID, Name: name}); err == nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("コース %s は既に存在します", name))
+	}
+
+	if _, err := h.DB.CreateCourse(ctx, database.CreateCourseParams{
+		ProjectID: lpID,
+		Name:      name,
+	}); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("コース作成失敗: %v", err))
+	}
+
+	// PRG: 続けて車両・ドライバーを入力できるよう編集ページへリダイレクト
+	return c.Redirect(http.StatusSeeOther, fmt.Sprintf("/projects/%d/courses/%s/edit", lpID, url.PathEscape(name)))
+}
+
+// EditCoursePage はコース編集ページを表示
+func (h *ProjectHandler) EditCoursePage(c echo.Context) error {
+	if err := h.checkPermission(c); err != nil {
+		return err
+	}
+	ctx := c.Request().Context()
+	lp, course, err := h.getCourse(c)
+	if err != nil {
+		return err
+	}
+
+	stopCount, err := h.DB.CountRouteStopsByCourseID(ctx, sql.NullInt64{Int64: course.ID, Valid: true})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	content := components.CourseEdit(lp, course, stopCount, c.QueryParam("date"))
+	c.Response().Header().Set(echo.HeaderContentType, echo.MIMETextHTML)
+	if c.Request().Header.Get("HX-Request") == "true" {
+		return content.Render(ctx, c.Response().Writer)
+	}
+	return layouts.Base("コース編集: "+course.Name, content).Render(ctx, c.Response().Writer)
+}
+
+// UpdateCourse はコースの車両・ドライバー・出発拠点を更新する
+// コース名はCSVの車両名との対応に使うため変更しない
+func (h *ProjectHandler) UpdateCourse(c echo.Context) error {
+	if err := h.checkPermission(c); err != nil {
+		return err
+	}
+	ctx := c.Request().Context()
+	lp, course, err := h.getCourse(c)
+	if err != nil {
+		return err
+	}
+
+	attrs, err := parseCourseAttributes(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	if _, err := h.DB.UpdateCourse(ctx, database.UpdateCourseParams{
+		ID:               course.ID,
+		VehiclePlate:     attrs.VehiclePlate,
+		DriverName:       attrs.DriverName,
+		DriverPhone:      attrs.DriverPhone,
+		CapacityKg:       attrs.CapacityKg,
+		DepotName:        attrs.DepotName,
+		DepotAddress:     attrs.DepotAddress,
+		DepotLatitude:    attrs.DepotLatitude,
+		DepotLongitude:   attrs.DepotLongitude,
+		PlannedStartTime: attrs.PlannedStartTime,
+		Note:             attrs.Note,
+	}); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("コース更新失敗: %v", err))
+	}
+
+	// PRG: コース詳細ページへリダイレクト
+	redirect := fmt.Sprintf("/projects/%d/courses/%s", lp.ID, url.PathEscape(course.Name))
+	if date := c.FormValue("date"); date != "" {
+		redirect += "?date=" + url.QueryEscape(date)
+	}
+	return c.Redirect(http.StatusSeeOther, redirect)
+}
+
+// DeleteCourse はコースを削除する
+// 停車地が残っているコースは削除できない。割り当て済みの端末は未割当に戻す
+func (h *ProjectHandler) DeleteCourse(c echo.Context) error {
+	if err := h.checkPermission(c); err != nil {
+		return err
+	}
+	ctx := c.Request().Context()
+	lp, course, err := h.getCourse(c)
+	if err != nil {
+		return err
+	}
+
+	tx, err := h.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("トランザクション開始失敗: %v", err))
+	}
+	defer tx.Rollback()
+	qtx := h.DB.WithTx(tx)
+
+	courseID := sql.NullInt64{Int64: course.ID, Valid: true}
+	stopCount, err := qtx.CountRouteStopsByCourseID(ctx, courseID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	if stopCount > 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("停車地が %d 件あるため削除できません", stopCount))
+	}
+
+	if err := qtx.ClearDeviceCourse(ctx, courseID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("端末の割り当て解除失敗: %v", err))
+	}
+	if err := qtx.DeleteCourse(ctx, course.ID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("コース削除失敗: %v", err))
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("コミット失敗: %v", err))
+	}
+
+	// PRG: コース一覧ページへリダイレクト
+	return c.Redirect(http.StatusSeeOther, fmt.Sprintf("/projects/%d/courses", lp.ID))
+}
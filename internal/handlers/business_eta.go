@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/eta"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/judge"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/visits"
+)
+
+// etaHistoryDays は到着予測の区間の所要時間を学ぶ過去の運行日の範囲（日）
+const etaHistoryDays = 28
+
+// predictArrivals は現在の遅れと過去の運行から学んだ区間の所要時間で、残りの停車地の到着予測を求める
+// 次の目的地までの到着予想時間（current.ToEtaMinutes）も予測に合わせる。位置ログがなければ nil
+func (h *ProjectHandler) predictArrivals(ctx context.Context, sc visits.Scope, stops []database.RouteStop, timings map[int64]*judge.StopTiming, current *judge.CurrentLocationInfo) map[int64]eta.Prediction {
+	if current == nil || len(stops) == 0 {
+		return nil
+	}
+	legs, err := h.learnLegs(ctx, sc)
+	if err != nil {
+		// 過去の運行を読めなくても到着予定の間隔・距離で予測する
+		log.Printf("到着予測の区間の所要時間の取得に失敗: %v", err)
+	}
+	predictions := eta.Predict(stops, timings, current, legs)
+	if current.ToStop != "" {
+		if p, ok := predictions[stops[current.ToStopIdx].ID]; ok {
+			current.ToEtaMinutes = max(p.ArrivalAt.Sub(current.Timestamp).Minutes(), 0)
+		}
+	}
+	return predictions
+}
+
+// learnLegs は同じコースの過去の運行（運行日の前 etaHistoryDays 日分）の到着・出発から区間ごとの所要時間を学ぶ
+// 手動での指定があればその到着・出発を使う。学んだ結果は案件・コース・運行日ごとにしばらくキャッシュする
+func (h *ProjectHandler) learnLegs(ctx context.Context, sc visits.Scope) (eta.Legs, error) {
+	if legs, ok := h.Legs.Get(sc.Project.ID, sc.CourseName, sc.OperatingDate); ok {
+		return legs, nil
+	}
+	base, err := parseOperatingDate(sc.OperatingDate)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := h.DB.ListCourseVisitHistory(ctx, database.ListCourseVisitHistoryParams{
+		ProjectID:  sc.Project.ID,
+		CourseName: sc.CourseName,
+		Since:      base.AddDate(0, 0, -etaHistoryDays).Format(operatingDateLayout),
+		Before:     sc.OperatingDate,
+	})
+	if err != nil {
+		return nil, err
+	}
+	history := make([]eta.Visit, 0, len(rows))
+	for _, row := range rows {
+		v := eta.Visit{
+			Run:       fmt.Sprintf("%s/%d", row.OperatingDate, row.CourseRunID),
+			StopKey:   eta.StopKey(row.LocationID, row.StopName, row.Address.String),
+			ArrivedAt: row.ArrivedAt.Time,
+		}
+		if row.DepartedAt.Valid {
+			departed := row.DepartedAt.Time
+			v.DepartedAt = &departed
+		}
+		history = append(history, v)
+	}
+	legs := eta.Learn(history)
+	h.Legs.Set(sc.Project.ID, sc.CourseName, sc.OperatingDate, legs)
+	return legs, nil
+}
+
+// stopPrediction は停車地の到着予測（予測がなければ nil）
+func stopPrediction(predictions map[int64]eta.Prediction, stop database.RouteStop) *eta.Prediction {
+	p, ok := predictions[stop.ID]
+	if !ok {
+		return nil
+	}
+	return &p
+}
@@ -0,0 +1,275 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/appcontext"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/geocode"
+	"github.com/naozine/project_crud_with_auth_tmpl/web/components"
+	"github.com/naozine/project_crud_with_auth_tmpl/web/layouts"
+)
+
+// 住所から推定した座標の状態（route_stops.geocode_status）
+const (
+	geocodeStatusOK     = "ok"     // 推定済み（信頼度が高い、または確認済み）
+	geocodeStatusReview = "review" // 信頼度が低く要確認
+	geocodeStatusFailed = "failed" // 住所から座標を求められなかった
+)
+
+// geocodeDictionaryStore は住所辞書テーブルを geocode.DictionaryStore として使う
+type geocodeDictionaryStore struct {
+	q *database.Queries
+}
+
+func (s geocodeDictionaryStore) LookupAddress(ctx context.Context, normalized string) (geocode.DictionaryEntry, bool, error) {
+	entry, err := s.q.GetGeocodeDictionaryEntry(ctx, normalized)
+	if errors.Is(err, sql.ErrNoRows) {
+		return geocode.DictionaryEntry{}, false, nil
+	}
+	if err != nil {
+		return geocode.DictionaryEntry{}, false, fmt.Errorf("住所辞書の検索失敗: %w", err)
+	}
+	return geocode.DictionaryEntry{Address: entry.Address, Latitude: entry.Latitude, Longitude: entry.Longitude}, true, nil
+}
+
+// geocodeCacheStore は住所検索APIの結果をキャッシュテーブルに保存する
+type geocodeCacheStore struct {
+	q *database.Queries
+}
+
+func (s geocodeCacheStore) Get(ctx context.Context, normalized string) (geocode.Result, bool, error) {
+	cached, err := s.q.GetGeocodeCache(ctx, normalized)
+	if errors.Is(err, sql.ErrNoRows) {
+		return geocode.Result{}, false, nil
+	}
+	if err != nil {
+		return geocode.Result{}, false, fmt.Errorf("住所検索キャッシュの取得失敗: %w", err)
+	}
+	return geocode.Result{
+		Latitude:       cached.Latitude,
+		Longitude:      cached.Longitude,
+		Confidence:     cached.Confidence,
+		Source:         cached.Source,
+		MatchedAddress: cached.MatchedAddress.String,
+	}, true, nil
+}
+
+func (s geocodeCacheStore) Put(ctx context.Context, normalized string, r geocode.Result) error {
+	return s.q.PutGeocodeCache(ctx, database.PutGeocodeCacheParams{
+		NormalizedAddress: normalized,
+		Latitude:          r.Latitude,
+		Longitude:         r.Longitude,
+		Confidence:        r.Confidence,
+		Source:            r.Source,
+		MatchedAddress:    toNullString(r.MatchedAddress),
+	})
+}
+
+// NewGeocoder は取り込みで使う Geocoder を作る
+// 住所辞書を先に引き、見つからない・一部しか一致しない住所は httpGeocoder（nil なら使わない）で検索して結果をキャッシュする
+func NewGeocoder(q *database.Queries, httpGeocoder geocode.Geocoder) geocode.Geocoder {
+	chain := geocode.Chain{geocode.Dictionary{Store: geocodeDictionaryStore{q: q}}}
+	if httpGeocoder != nil {
+		chain = append(chain, geocode.Cached{Geocoder: httpGeocoder, Cache: geocodeCacheStore{q: q}})
+	}
+	return chain
+}
+
+// geocodeRouteStops は住所があり座標のない停車地の座標を住所から求める
+// 求めた停車地には検索元・信頼度・状態を設定し、信頼度の低いものは警告を返す。求められなかった停車地は状態だけを設定する
+// 検索の失敗（外部APIの障害など）は取り込みを止めず、その停車地を求められなかったものとして扱う
+func geocodeRouteStops(ctx context.Context, g geocode.Geocoder, stops []RouteStop) ([]RouteStop, []components.ImportIssue) {
+	if g == nil {
+		return stops, nil
+	}
+	type lookup struct {
+		result geocode.Result
+		found  bool
+	}
+	lookups := make(map[string]lookup) // 同じ住所は1回だけ検索する
+
+	var issues []components.ImportIssue
+	for i := range stops {
+		stop := &stops[i]
+		if stop.Address == "" || (stop.Latitude != 0 && stop.Longitude != 0) {
+			continue
+		}
+		l, ok := lookups[stop.Address]
+		if !ok {
+			r, found, err := g.Geocode(ctx, stop.Address)
+			if err != nil {
+				log.Printf("geocode %q: %v", stop.Address, err)
+			}
+			l = lookup{result: r, found: found && err == nil}
+			lookups[stop.Address] = l
+		}
+		if !l.found {
+			stop.GeocodeStatus = geocodeStatusFailed
+			continue
+		}
+
+		stop.Latitude = l.result.Latitude
+		stop.Longitude = l.result.Longitude
+		stop.GeocodeSource = l.result.Source
+		stop.GeocodeConfidence = l.result.Confidence
+		stop.GeocodeStatus = geocodeStatusOK
+		if l.result.NeedsReview() {
+			stop.GeocodeStatus = geocodeStatusReview
+			issues = append(issues, components.ImportIssue{
+				Row:        stop.Row,
+				CourseName: stop.CourseName,
+				Sequence:   stop.Sequence,
+				Level:      components.ImportIssueWarning,
+				Message: fmt.Sprintf("住所から座標を推定しました（信頼度 %.0f%%、一致した住所: %s）。取り込み後に座標の要確認一覧で確認してください",
+					l.result.Confidence*100, displayOrDash(l.result.MatchedAddress)),
+			})
+		}
+	}
+	return stops, issues
+}
+
+// learnGeocodeAddress は確認済みの住所と座標を住所辞書に登録し、次回以降の取り込みで使う
+func learnGeocodeAddress(ctx context.Context, q *database.Queries, address string, lat, lon float64) error {
+	normalized := geocode.Normalize(address)
+	if normalized == "" || lat == 0 || lon == 0 {
+		return nil
+	}
+	if err := q.UpsertGeocodeDictionaryEntry(ctx, database.UpsertGeocodeDictionaryEntryParams{
+		NormalizedAddress: normalized,
+		Address:           strings.TrimSpace(address),
+		Latitude:          lat,
+		Longitude:         lon,
+		CreatedBy:         toNullInt64(appcontext.GetUserID(ctx)),
+	}); err != nil {
+		return fmt.Errorf("住所辞書の登録失敗: %w", err)
+	}
+	return nil
+}
+
+// ShowGeocodeReview は住所から推定した座標のうち要確認・推定できなかった停車地の一覧を表示する
+func (h *ProjectHandler) ShowGeocodeReview(c echo.Context) error {
+	ctx := c.Request().Context()
+	lpID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効な案件ID")
+	}
+	lp, err := h.DB.GetProject(ctx, lpID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "物流案件が見つかりません")
+	}
+	stops, err := h.DB.ListRouteStopsNeedingGeocodeReview(ctx, lpID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	content := components.GeocodeReview(lp, stops)
+	c.Response().Header().Set(echo.HeaderContentType, echo.MIMETextHTML)
+	if c.Request().Header.Get("HX-Request") == "true" {
+		return content.Render(ctx, c.Response().Writer)
+	}
+	return layouts.Base("座標の要確認一覧", content).Render(ctx, c.Response().Writer)
+}
+
+// ConfirmGeocode は推定した座標を確認済みにし、住所と座標を住所辞書に登録する
+func (h *ProjectHandler) ConfirmGeocode(c echo.Context) error {
+	if err := h.checkPermission(c); err != nil {
+		return err
+	}
+	ctx := c.Request().Context()
+	lpID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効な案件ID")
+	}
+	stopID, err := strconv.ParseInt(c.Param("stop_id"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効な地点ID")
+	}
+	stop, err := h.DB.GetRouteStopByID(ctx, stopID)
+	if err != nil || stop.ProjectID != lpID {
+		return echo.NewHTTPError(http.StatusNotFound, "停車地が見つかりません")
+	}
+	if !stop.Latitude.Valid || !stop.Longitude.Valid {
+		return echo.NewHTTPError(http.StatusBadRequest, "座標がないため確認済みにできません。停車地の編集で地図から座標を指定してください")
+	}
+
+	tx, err := h.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("トランザクション開始失敗: %v", err))
+	}
+	defer tx.Rollback()
+	qtx := h.DB.WithTx(tx)
+
+	if err := qtx.ConfirmRouteStopGeocode(ctx, stop.ID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("確認済みへの更新失敗: %v", err))
+	}
+	if err := learnGeocodeAddress(ctx, qtx, stop.Address.String, stop.Latitude.Float64, stop.Longitude.Float64); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("コミット失敗: %v", err))
+	}
+
+	// PRG: 要確認一覧へリダイレクト
+	return c.Redirect(http.StatusSeeOther, fmt.Sprintf("/projects/%d/geocode-review", lpID))
+}
+
+// ListGeocodeDictionary は住所辞書の一覧を表示
+func (h *ProjectHandler) ListGeocodeDictionary(c echo.Context) error {
+	ctx := c.Request().Context()
+	entries, err := h.DB.ListGeocodeDictionary(ctx)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	content := components.GeocodeDictionaryList(entries)
+	c.Response().Header().Set(echo.HeaderContentType, echo.MIMETextHTML)
+	if c.Request().Header.Get("HX-Request") == "true" {
+		return content.Render(ctx, c.Response().Writer)
+	}
+	return layouts.Base("住所辞書", content).Render(ctx, c.Response().Writer)
+}
+
+// CreateGeocodeDictionaryEntry は住所辞書に登録する（同じ住所があれば座標を更新）
+func (h *ProjectHandler) CreateGeocodeDictionaryEntry(c echo.Context) error {
+	if err := h.checkPermission(c); err != nil {
+		return err
+	}
+	address := strings.TrimSpace(c.FormValue("address"))
+	if geocode.Normalize(address) == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "住所を入力してください")
+	}
+	lat, errLat := strconv.ParseFloat(strings.TrimSpace(c.FormValue("latitude")), 64)
+	lon, errLon := strconv.ParseFloat(strings.TrimSpace(c.FormValue("longitude")), 64)
+	if errLat != nil || errLon != nil || !isInJapan(lat, lon) {
+		return echo.NewHTTPError(http.StatusBadRequest, "緯度・経度を日本国内の座標で入力してください")
+	}
+
+	if err := learnGeocodeAddress(c.Request().Context(), h.DB, address, lat, lon); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.Redirect(http.StatusSeeOther, "/masters/geocode")
+}
+
+// DeleteGeocodeDictionaryEntry は住所辞書から削除（登録済みの停車地の座標は変わらない）
+func (h *ProjectHandler) DeleteGeocodeDictionaryEntry(c echo.Context) error {
+	if err := h.checkPermission(c); err != nil {
+		return err
+	}
+	id, err := strconv.ParseInt(c.Param("entry_id"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効な住所辞書ID")
+	}
+	if err := h.DB.DeleteGeocodeDictionaryEntry(c.Request().Context(), id); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("住所辞書の削除に失敗しました: %v", err))
+	}
+	return c.Redirect(http.StatusSeeOther, "/masters/geocode")
+}
@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/judge"
+	"github.com/naozine/project_crud_with_auth_tmpl/web/components"
+	"github.com/naozine/project_crud_with_auth_tmpl/web/layouts"
+)
+
+// ShowJudgeReplay はコースの保存済みの位置ログを別の判定設定で判定し直し、現在の設定と並べて表示する
+// 判定設定を案件に適用する前に、どの停車地の到着・未到着や時刻が変わるかを確かめるために使う（何も保存しない）
+func (h *ProjectHandler) ShowJudgeReplay(c echo.Context) error {
+	ctx := c.Request().Context()
+	lp, course, err := h.getCourse(c)
+	if err != nil {
+		return err
+	}
+	operatingDate, operatingDates, err := h.selectOperatingDate(c, lp.ID)
+	if err != nil {
+		return err
+	}
+	stops, err := h.DB.ListRouteStopsByCourse(ctx, database.ListRouteStopsByCourseParams{
+		ProjectID:     lp.ID,
+		OperatingDate: operatingDate,
+		CourseName:    course.Name,
+	})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	run, runs, err := h.selectCourseRun(c, lp.ID, operatingDate, course.Name)
+	if err != nil {
+		return err
+	}
+	logs, err := h.listCourseRunLogs(ctx, lp.ID, operatingDate, course.Name, run)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	candidate, err := judgeCandidateProject(c, lp)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	comparison := judge.Compare(stops, logs, judge.SettingsFromProject(lp), judge.SettingsFromProject(candidate))
+
+	content := components.JudgeReplay(lp, candidate, course.Name, operatingDate, operatingDates, run, runs, len(logs), comparison)
+	c.Response().Header().Set(echo.HeaderContentType, echo.MIMETextHTML)
+	if c.Request().Header.Get("HX-Request") == "true" {
+		return content.Render(ctx, c.Response().Writer)
+	}
+	return layouts.Base("判定の再現: "+course.Name, content).Render(ctx, c.Response().Writer)
+}
+
+// judgeCandidateProject は再現に使う判定設定（フォームの値）を案件の設定に重ねて返す
+// フォームを送信していなければ案件の現在の設定のまま。読み取れない値も現在の設定を使う
+// （位置の精度などの設定は案件の編集と同じく、範囲外ならエラー）
+func judgeCandidateProject(c echo.Context, lp database.Project) (database.Project, error) {
+	if c.QueryParam("arrival_threshold") == "" {
+		return lp, nil
+	}
+	candidate := lp
+	if v, err := strconv.ParseInt(c.QueryParam("arrival_threshold"), 10, 64); err == nil && v > 0 {
+		candidate.ArrivalThresholdMeters = sql.NullInt64{Int64: v, Valid: true}
+	}
+	if v, err := strconv.ParseInt(c.QueryParam("judge_stay_time"), 10, 64); err == nil && v >= 0 {
+		candidate.JudgeStayTimeMinutes = sql.NullInt64{Int64: v, Valid: true}
+	}
+	if v, err := strconv.ParseFloat(c.QueryParam("judge_speed_limit"), 64); err == nil && v >= 0 {
+		candidate.JudgeSpeedLimitKmh = sql.NullFloat64{Float64: v, Valid: true}
+	}
+	if v, err := strconv.ParseInt(c.QueryParam("judge_sequence_window"), 10, 64); err == nil && v >= 0 {
+		candidate.JudgeSequenceWindow = v
+	}
+	fixes, err := parseJudgeFixSettings(c)
+	if err != nil {
+		return lp, err
+	}
+	candidate.JudgeMaxAccuracyM = fixes.MaxAccuracyM
+	candidate.JudgeAccuracyMode = fixes.AccuracyMode
+	candidate.JudgeMinFixes = fixes.MinFixes
+	candidate.JudgeExitMarginM = fixes.ExitMarginM
+	candidate.JudgeUseBearing = fixes.UseBearing
+	return candidate, nil
+}
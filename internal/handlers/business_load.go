@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
+	"github.com/naozine/project_crud_with_auth_tmpl/web/components"
+)
+
+// 停車地の積降区分
+const (
+	loadDirectionLoad   = "load"   // 積込（重量分だけ積載量が増える）
+	loadDirectionUnload = "unload" // 荷降ろし（出発時に積んでおき、重量分だけ積載量が減る）
+)
+
+// parseLoadDirection はCSVの積降区分の表記を正規化する（空欄は積込）
+func parseLoadDirection(s string) (string, bool) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "積", "積込", "積込み", "集荷", "load", "pickup":
+		return loadDirectionLoad, true
+	case "降", "卸", "荷降", "荷降し", "荷降ろし", "荷卸し", "納品", "配達", "unload", "delivery":
+		return loadDirectionUnload, true
+	default:
+		return loadDirectionLoad, false
+	}
+}
+
+// loadStep は積載量の計算に使う停車地1件分の積降
+type loadStep struct {
+	Direction string
+	WeightKg  int64
+}
+
+// signedWeight は積載量の増減（積込は正、荷降ろしは負）
+func (s loadStep) signedWeight() int64 {
+	if s.Direction == loadDirectionUnload {
+		return -s.WeightKg
+	}
+	return s.WeightKg
+}
+
+// plannedLoad は順番どおりに積降した場合の出発時の積載量、各地点の作業後の積載量、最大積載量を返す
+// 荷降ろしする荷物は出発時にすべて積んでいるものとする
+func plannedLoad(steps []loadStep) (startKg int64, afterKg []int64, peakKg int64) {
+	for _, s := range steps {
+		if s.Direction == loadDirectionUnload {
+			startKg += s.WeightKg
+		}
+	}
+	load := startKg
+	peakKg = startKg
+	afterKg = make([]int64, len(steps))
+	for i, s := range steps {
+		load += s.signedWeight()
+		afterKg[i] = load
+		if load > peakKg {
+			peakKg = load
+		}
+	}
+	return startKg, afterKg, peakKg
+}
+
+// routeStopLoadSteps は停車地を積載量の計算用に変換する
+func routeStopLoadSteps(stops []database.RouteStop) []loadStep {
+	steps := make([]loadStep, len(stops))
+	for i, stop := range stops {
+		steps[i] = loadStep{Direction: stop.LoadDirection, WeightKg: stop.WeightKg.Int64}
+	}
+	return steps
+}
+
+// csvLoadSteps はCSVの行を積載量の計算用に変換する
+func csvLoadSteps(stops []RouteStop) []loadStep {
+	steps := make([]loadStep, len(stops))
+	for i, stop := range stops {
+		steps[i] = loadStep{Direction: stop.LoadDirection, WeightKg: stop.WeightKg}
+	}
+	return steps
+}
+
+// currentLoadKg は到着済みの地点の積降を反映した現在の積載量を返す
+func currentLoadKg(stops []database.RouteStop, timings map[int64]*components.StopTiming) int64 {
+	startKg, _, _ := plannedLoad(routeStopLoadSteps(stops))
+	load := startKg
+	for _, stop := range stops {
+		if timing := timings[stop.ID]; timing != nil && timing.Arrived {
+			load += loadStep{Direction: stop.LoadDirection, WeightKg: stop.WeightKg.Int64}.signedWeight()
+		}
+	}
+	return load
+}
+
+// buildLoadCurve はコースの予定と実績の積載量の推移を作る
+// 実績は到着済みの地点まで予定どおりに積降したものとして計算する
+func buildLoadCurve(stops []database.RouteStop, timings map[int64]*components.StopTiming, capacityKg int64) components.LoadCurve {
+	startKg, afterKg, peakKg := plannedLoad(routeStopLoadSteps(stops))
+	curve := components.LoadCurve{
+		CapacityKg: capacityKg,
+		StartKg:    startKg,
+		PeakKg:     peakKg,
+		Points:     make([]components.LoadPoint, len(stops)),
+	}
+
+	actual := startKg
+	for i, stop := range stops {
+		point := components.LoadPoint{
+			StopID:    stop.ID,
+			Sequence:  stop.Sequence,
+			StopName:  stop.StopName,
+			Direction: stop.LoadDirection,
+			WeightKg:  stop.WeightKg.Int64,
+			PlannedKg: afterKg[i],
+		}
+		if timing := timings[stop.ID]; timing != nil && timing.Arrived {
+			actual += loadStep{Direction: stop.LoadDirection, WeightKg: stop.WeightKg.Int64}.signedWeight()
+			point.Arrived = true
+			point.ActualKg = actual
+		}
+		curve.Points[i] = point
+	}
+	return curve
+}
+
+// courseCapacityKg はコースの最大積載量を返す（0は不明）
+// 運行日に割り当てた車両の最大積載量を優先し、なければコースに登録した値を使う
+func courseCapacityKg(course database.Course, assignment *database.ListCourseAssignmentsByProjectDateRow) int64 {
+	if assignment != nil && assignment.MaxLoadKg.Valid {
+		return assignment.MaxLoadKg.Int64
+	}
+	if course.CapacityKg.Valid {
+		return course.CapacityKg.Int64
+	}
+	return 0
+}
+
+// courseCapacitiesByDate は案件の各コースの運行日時点の最大積載量をコース名ごとに返す（不明なコースは含まない）
+func (h *ProjectHandler) courseCapacitiesByDate(ctx context.Context, lpID int64, operatingDate string) (map[string]int64, error) {
+	courses, err := h.DB.ListCoursesByProject(ctx, lpID)
+	if err != nil {
+		return nil, err
+	}
+	assignments, err := h.courseAssignmentsByDate(ctx, lpID, operatingDate)
+	if err != nil {
+		return nil, err
+	}
+	capacities := make(map[string]int64, len(courses))
+	for _, course := range courses {
+		var assignment *database.ListCourseAssignmentsByProjectDateRow
+		if a, ok := assignments[course.ID]; ok {
+			assignment = &a
+		}
+		if capacity := courseCapacityKg(course, assignment); capacity > 0 {
+			capacities[course.Name] = capacity
+		}
+	}
+	return capacities, nil
+}
+
+// checkImportCapacity はコースごとの予定の最大積載量を最大積載量と比較し、超過するコースを警告にする
+func checkImportCapacity(summaries []components.ImportCourseSummary, capacities map[string]int64) []components.ImportIssue {
+	var issues []components.ImportIssue
+	for i := range summaries {
+		s := &summaries[i]
+		s.CapacityKg = capacities[s.CourseName]
+		if s.OverCapacity() {
+			issues = append(issues, components.ImportIssue{
+				CourseName: s.CourseName,
+				Level:      components.ImportIssueWarning,
+				Message:    fmt.Sprintf("予定の最大積載量 %dkg が車両の最大積載量 %dkg を超えています", s.PeakLoadKg, s.CapacityKg),
+			})
+		}
+	}
+	return issues
+}
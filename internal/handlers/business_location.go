@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"io"
@@ -13,15 +14,17 @@ import (
 
 	"github.com/labstack/echo/v4"
 	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
-	"github.com/naozine/project_crud_with_auth_tmpl/internal/geo"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/judge"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/visits"
 )
 
 type LocationHandler struct {
-	DB *database.Queries
+	DB     *database.Queries
+	Visits *visits.Store // 受信したログで到着判定を進める
 }
 
-func NewLocationHandler(db *database.Queries) *LocationHandler {
-	return &LocationHandler{DB: db}
+func NewLocationHandler(db *database.Queries, conn *sql.DB) *LocationHandler {
+	return &LocationHandler{DB: db, Visits: visits.NewStore(db, conn)}
 }
 
 // リクエスト用の構造体
@@ -37,9 +40,24 @@ type LocationData struct {
 
 type LocationRequest struct {
 	DeviceID  string         `json:"device_id"`
+	SpeedUnit string         `json:"speed_unit,omitempty"` // locations の speed の単位（省略時は m/s）
 	Locations []LocationData `json:"locations"`
 }
 
+// 受信する速度の単位（保存するときは km/h にそろえる）
+const (
+	speedUnitKmh = "kmh"
+	speedUnitMps = "mps" // 端末の測位APIの値そのまま。speed_unit を送らない古いアプリもこの単位
+)
+
+// speedKmh は申告された単位の速度を km/h に換算する（単位の申告がなければ m/s とみなす）
+func speedKmh(speed float64, unit string) float64 {
+	if unit == speedUnitKmh {
+		return speed
+	}
+	return speed * 3.6
+}
+
 // デバイス登録API用の構造体
 type DeviceRegisterRequest struct {
 	DeviceID   string `json:"device_id"`
@@ -217,6 +235,13 @@ func (h *LocationHandler) CreateLocations(c echo.Context) error {
 		})
 	}
 
+	if req.SpeedUnit != "" && req.SpeedUnit != speedUnitKmh && req.SpeedUnit != speedUnitMps {
+		return c.JSON(http.StatusBadRequest, LocationResponse{
+			Success: false,
+			Error:   "speed_unit must be \"kmh\" or \"mps\"",
+		})
+	}
+
 	// 3. device_id からコース名を取得
 	device, err := h.DB.GetDeviceByDeviceID(ctx, database.GetDeviceByDeviceIDParams{
 		ProjectID: project.ID,
@@ -252,6 +277,7 @@ func (h *LocationHandler) CreateLocations(c echo.Context) error {
 
 	// 各位置情報を保存
 	recorded := 0
+	operatingDates := make(map[string]bool) // ログを保存した運行日
 	for _, loc := range req.Locations {
 		// タイムスタンプのパース
 		timestamp, err := time.Parse(time.RFC3339, loc.Timestamp)
@@ -268,7 +294,7 @@ func (h *LocationHandler) CreateLocations(c echo.Context) error {
 			accuracy = sql.NullFloat64{Float64: *loc.Accuracy, Valid: true}
 		}
 		if loc.Speed != nil {
-			speed = sql.NullFloat64{Float64: *loc.Speed, Valid: true}
+			speed = sql.NullFloat64{Float64: speedKmh(*loc.Speed, req.SpeedUnit), Valid: true}
 		}
 		if loc.Bearing != nil {
 			bearing = sql.NullFloat64{Float64: *loc.Bearing, Valid: true}
@@ -278,17 +304,20 @@ func (h *LocationHandler) CreateLocations(c echo.Context) error {
 		}
 
 		// データベースに挿入
+		operatingDate := resolveOperatingDate(ctx, h.DB, project.ID, courseName, timestamp)
 		err = h.DB.CreateLocationLog(ctx, database.CreateLocationLogParams{
-			ProjectID:    project.ID,
-			CourseName:   courseName,
-			DeviceID:     sql.NullString{String: req.DeviceID, Valid: true},
-			Latitude:     loc.Latitude,
-			Longitude:    loc.Longitude,
-			Timestamp:    timestamp,
-			Accuracy:     accuracy,
-			Speed:        speed,
-			Bearing:      bearing,
-			BatteryLevel: batteryLevel,
+			ProjectID:     project.ID,
+			OperatingDate: operatingDate,
+			CourseID:      device.CourseID,
+			CourseName:    courseName,
+			DeviceID:      sql.NullString{String: req.DeviceID, Valid: true},
+			Latitude:      loc.Latitude,
+			Longitude:     loc.Longitude,
+			Timestamp:     timestamp,
+			Accuracy:      accuracy,
+			Speed:         speed,
+			Bearing:       bearing,
+			BatteryLevel:  batteryLevel,
 		})
 
 		if err != nil {
@@ -297,6 +326,14 @@ func (h *LocationHandler) CreateLocations(c echo.Context) error {
 		}
 
 		recorded++
+		operatingDates[operatingDate] = true
+	}
+
+	// 受信したログの分だけ到着判定を進める（判定に失敗してもログは保存済みなので受信は成功とする）
+	for operatingDate := range operatingDates {
+		if err := h.advanceVisits(ctx, project, operatingDate, courseName); err != nil {
+			log.Printf("Failed to advance stop visits: %v", err)
+		}
 	}
 
 	// レスポンス
@@ -314,6 +351,33 @@ func (h *LocationHandler) CreateLocations(c echo.Context) error {
 	})
 }
 
+// advanceVisits は運行日・コースの最新の運行（運行がなければ運行日の全ログ）の到着判定を進める
+// 過去の運行の判定は画面で表示したときに進める
+func (h *LocationHandler) advanceVisits(ctx context.Context, project database.Project, operatingDate, courseName string) error {
+	stops, err := h.DB.ListRouteStopsByCourse(ctx, database.ListRouteStopsByCourseParams{
+		ProjectID:     project.ID,
+		OperatingDate: operatingDate,
+		CourseName:    courseName,
+	})
+	if err != nil || len(stops) == 0 {
+		return err
+	}
+	runs, err := h.DB.ListCourseRuns(ctx, database.ListCourseRunsParams{
+		ProjectID:     project.ID,
+		OperatingDate: operatingDate,
+		CourseName:    courseName,
+	})
+	if err != nil {
+		return err
+	}
+	var run *database.CourseRun
+	if len(runs) > 0 {
+		run = &runs[0]
+	}
+	_, err = h.Visits.Refresh(ctx, visits.Scope{Project: project, OperatingDate: operatingDate, CourseName: courseName, Run: run}, stops)
+	return err
+}
+
 // 写真メタデータAPI用の構造体
 type PhotoMetadataRequest struct {
 	DeviceID      string  `json:"device_id"`
@@ -435,10 +499,12 @@ func (h *LocationHandler) CreatePhotoMetadata(c echo.Context) error {
 		})
 	}
 
-	// 該当コースの停車地一覧を取得
+	// 撮影時刻から運行日を判定し、その日の停車地一覧を取得
+	operatingDate := resolveOperatingDate(ctx, h.DB, project.ID, courseName, takenAt)
 	stops, err := h.DB.ListRouteStopsByCourse(ctx, database.ListRouteStopsByCourseParams{
-		ProjectID:  project.ID,
-		CourseName: courseName,
+		ProjectID:     project.ID,
+		OperatingDate: operatingDate,
+		CourseName:    courseName,
 	})
 	if err != nil {
 		log.Printf("Failed to get route stops: %v", err)
@@ -451,21 +517,17 @@ func (h *LocationHandler) CreatePhotoMetadata(c echo.Context) error {
 	// 写真の位置から最も近い停車地を探す
 	var matchedStop *RouteStopInfo
 	var matchedStopID sql.NullInt64
-	thresholdMeters := float64(project.ArrivalThresholdMeters.Int64)
+	settings := judge.SettingsFromProject(project)
 
 	for _, stop := range stops {
-		if !stop.Latitude.Valid || !stop.Longitude.Valid {
+		fence, ok := settings.FenceFor(stop)
+		if !ok {
 			continue
 		}
+		distance := fence.DistanceM(req.Latitude, req.Longitude)
 
-		distanceKm := geo.Haversine(
-			req.Latitude, req.Longitude,
-			stop.Latitude.Float64, stop.Longitude.Float64,
-		)
-		distance := distanceKm * 1000 // メートルに変換
-
-		// 閾値内で最も近い地点を探す
-		if distance <= thresholdMeters {
+		// 到着判定のエリア内（多角形がなければ範囲の円内）で最も近い地点を探す
+		if fence.Contains(req.Latitude, req.Longitude) {
 			if matchedStop == nil || distance < matchedStop.DistanceMeters {
 				addr := ""
 				if stop.Address.Valid {
@@ -488,6 +550,7 @@ func (h *LocationHandler) CreatePhotoMetadata(c echo.Context) error {
 	// 写真メタデータをDBに保存
 	photo, err := h.DB.CreatePhotoMetadata(ctx, database.CreatePhotoMetadataParams{
 		ProjectID:     project.ID,
+		OperatingDate: operatingDate,
 		CourseName:    courseName,
 		DevicePhotoID: req.DevicePhotoID,
 		Latitude:      req.Latitude,
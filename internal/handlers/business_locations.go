@@ -0,0 +1,663 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/appcontext"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/dwell"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/geo"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/geocode"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/judge"
+	"github.com/naozine/project_crud_with_auth_tmpl/web/components"
+	"github.com/naozine/project_crud_with_auth_tmpl/web/layouts"
+)
+
+// 実際の滞在位置から停車地の座標の修正案を作るときの設定
+const (
+	dwellRadiusM           = 30.0                // この範囲にとどまっていれば停まっているとみなす
+	dwellDefaultMinutes    = 2                   // 案件に判定の滞在時間がなければこの時間以上の停車を滞在とする
+	dwellSearchRadiusM     = 300.0               // 予定の座標からこの範囲で実際の停車位置を探す（到着判定の範囲の3倍の方が広ければそちら）
+	dwellClusterRadiusM    = 30.0                // 運行日ごとの停車位置がこの範囲に集まれば同じ位置とみなす
+	locationMinShiftM      = 15.0                // 予定の座標とのずれがこれ未満なら修正案にしない
+	locationSuggestMaxDays = 60                  // 分析できる期間
+	locationSuggestDefault = 30 * 24 * time.Hour // 期間を指定しない場合の分析期間
+)
+
+// 位置マスタの座標の登録元
+const (
+	locationSourceLearned = "learned" // 実際の滞在位置から学習
+	locationSourceManual  = "manual"  // 画面で入力
+)
+
+// locationResolver は取り込む停車地に位置マスタの座標を適用し、残った座標のない停車地の座標を住所から求める
+type locationResolver struct {
+	DB       *database.Queries
+	Geocoder geocode.Geocoder
+}
+
+// importResolver は取り込みで停車地の座標を決める locationResolver を返す
+func (h *ProjectHandler) importResolver() *locationResolver {
+	return &locationResolver{DB: h.DB, Geocoder: h.Geocoder}
+}
+
+// resolve は停車地の座標を位置マスタ → CSV → 住所からの推定の順に決める
+func (r *locationResolver) resolve(ctx context.Context, stops []RouteStop) ([]RouteStop, []components.ImportIssue, error) {
+	stops, err := applyLocationMaster(ctx, r.DB, stops)
+	if err != nil {
+		return nil, nil, err
+	}
+	stops, issues := geocodeRouteStops(ctx, r.Geocoder, stops)
+	return stops, issues, nil
+}
+
+// applyLocationMaster は停車地を位置マスタと照合し、マスタで上書きする設定の項目をマスタの値に置き換える
+// 顧客コードのある停車地は顧客コードで照合し、同じ顧客コードの登録がなければ住所で照合する
+func applyLocationMaster(ctx context.Context, q *database.Queries, stops []RouteStop) ([]RouteStop, error) {
+	cache := make(map[string]*database.Location)
+	for i := range stops {
+		stop := &stops[i]
+		loc, err := findLocation(ctx, q, cache, stop.CustomerCode, stop.Address)
+		if err != nil {
+			return nil, err
+		}
+		if loc == nil {
+			continue
+		}
+		stop.LocationID = loc.ID
+		stop.GeofenceRadiusM = loc.GeofenceRadiusM.Int64
+		stop.GeofencePolygon = loc.GeofencePolygon.String
+		if loc.OverrideCoordinates {
+			stop.Latitude = loc.Latitude
+			stop.Longitude = loc.Longitude
+			stop.coordinateError = false
+		}
+		if loc.OverridePhone && loc.PhoneNumber.String != "" {
+			stop.PhoneNumber = loc.PhoneNumber.String
+		}
+		if loc.OverrideStayMinutes && loc.StayMinutes.Valid {
+			stop.StayMinutes = loc.StayMinutes.Int64
+		}
+	}
+	return stops, nil
+}
+
+// findLocation は顧客コード → 住所の順に位置マスタを探す（見つからなければ nil）
+// 顧客コードのある登録は同じ住所の別の顧客と区別するため、顧客コードでのみ照合する
+func findLocation(ctx context.Context, q *database.Queries, cache map[string]*database.Location, customerCode, address string) (*database.Location, error) {
+	lookup := func(key string, get func() (database.Location, error)) (*database.Location, error) {
+		if loc, ok := cache[key]; ok {
+			return loc, nil
+		}
+		var loc *database.Location
+		found, err := get()
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+		case err != nil:
+			return nil, fmt.Errorf("位置マスタの取得失敗: %w", err)
+		default:
+			loc = &found
+		}
+		cache[key] = loc
+		return loc, nil
+	}
+
+	if customerCode != "" {
+		loc, err := lookup("code:"+customerCode, func() (database.Location, error) {
+			return q.GetLocationByCustomerCode(ctx, toNullString(customerCode))
+		})
+		if loc != nil || err != nil {
+			return loc, err
+		}
+	}
+	key := geocode.Normalize(address)
+	if key == "" {
+		return nil, nil
+	}
+	return lookup("address:"+key, func() (database.Location, error) {
+		return q.GetLocationByNormalizedAddress(ctx, key)
+	})
+}
+
+// locationKey は停車地・位置マスタを同じ配送先としてまとめるキー（顧客コードも住所もなければ空）
+func locationKey(customerCode, address string) string {
+	if customerCode != "" {
+		return "code:" + customerCode
+	}
+	if key := geocode.Normalize(address); key != "" {
+		return "address:" + key
+	}
+	return ""
+}
+
+// geofenceRadiusLabel は停車地の到着判定の範囲の表示（0は案件の設定を使う）
+func geofenceRadiusLabel(radiusM int64) string {
+	if radiusM <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("%dm", radiusM)
+}
+
+// geofencePolygonLabel は停車地の到着判定のエリアの表示（空はエリアなし）
+func geofencePolygonLabel(polygon string) string {
+	if polygon == "" {
+		return ""
+	}
+	area, err := geo.ParseGeoJSON(polygon)
+	if err != nil {
+		return "読み取れないエリア"
+	}
+	points := 0
+	for _, p := range area {
+		points += len(p[0])
+	}
+	if len(area) > 1 {
+		return fmt.Sprintf("多角形%d個（%d点）", len(area), points)
+	}
+	return fmt.Sprintf("多角形（%d点）", points)
+}
+
+// parseGeofencePolygon はフォームで入力・描画したエリア（GeoJSON）を検証する（空ならエリアなし）
+func parseGeofencePolygon(value string) (sql.NullString, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return sql.NullString{}, nil
+	}
+	if _, err := geo.ParseGeoJSON(value); err != nil {
+		return sql.NullString{}, fmt.Errorf("到着判定のエリア: %w", err)
+	}
+	return sql.NullString{String: value, Valid: true}, nil
+}
+
+// locationGroup は同じ配送先（顧客コードまたは住所）の停車地の分析途中の集計
+type locationGroup struct {
+	suggestion   components.LocationSuggestion
+	courses      map[string]bool
+	observations []dwell.Observation
+}
+
+// ShowLocationSuggestions は過去の運行で実際に停まった位置から、停車地の座標の修正案を表示する
+func (h *ProjectHandler) ShowLocationSuggestions(c echo.Context) error {
+	ctx := c.Request().Context()
+	lpID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効な案件ID")
+	}
+	lp, err := h.DB.GetProject(ctx, lpID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "物流案件が見つかりません")
+	}
+
+	latest, operatingDates, err := h.selectOperatingDate(c, lpID)
+	if err != nil {
+		return err
+	}
+	to := c.QueryParam("to")
+	if to == "" {
+		to = latest
+	}
+	toDate, err := parseOperatingDate(to)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("無効な運行日: %s", to))
+	}
+	from := c.QueryParam("from")
+	if from == "" {
+		from = toDate.Add(-locationSuggestDefault).Format(operatingDateLayout)
+	}
+	fromDate, err := parseOperatingDate(from)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("無効な運行日: %s", from))
+	}
+	if fromDate.After(toDate) {
+		return echo.NewHTTPError(http.StatusBadRequest, "開始日は終了日以前にしてください")
+	}
+	if toDate.Sub(fromDate) >= locationSuggestMaxDays*24*time.Hour {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("分析できる期間は%d日までです", locationSuggestMaxDays))
+	}
+
+	var dates []string
+	for _, d := range operatingDates {
+		if d >= from && d <= to {
+			dates = append(dates, d)
+		}
+	}
+	sort.Strings(dates)
+
+	data := components.LocationSuggestionsData{From: from, To: to}
+	groups := make(map[string]*locationGroup)
+	for _, date := range dates {
+		if err := h.collectDwellObservations(ctx, lp, date, groups, &data); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, err)
+		}
+	}
+
+	masters, err := h.DB.ListLocations(ctx)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	masterByKey := make(map[string]database.Location, len(masters))
+	for _, m := range masters {
+		masterByKey[locationKey(m.CustomerCode.String, m.Address)] = m
+	}
+
+	for key, g := range groups {
+		s, ok := dwell.Suggest(g.observations, g.suggestion.Visits, dwellClusterRadiusM)
+		if !ok {
+			continue
+		}
+		suggestion := g.suggestion
+		suggestion.SuggestedLatitude = s.Latitude
+		suggestion.SuggestedLongitude = s.Longitude
+		suggestion.Support = s.Support
+		suggestion.Confidence = s.Confidence
+		suggestion.ShiftMeters = geo.Haversine(suggestion.PlannedLatitude, suggestion.PlannedLongitude, s.Latitude, s.Longitude) * 1000
+		if suggestion.ShiftMeters < locationMinShiftM {
+			continue
+		}
+		for course := range g.courses {
+			suggestion.CourseNames = append(suggestion.CourseNames, course)
+		}
+		sort.Strings(suggestion.CourseNames)
+		if m, ok := masterByKey[key]; ok {
+			suggestion.Master = &m
+			suggestion.Accepted = geo.Haversine(m.Latitude, m.Longitude, s.Latitude, s.Longitude)*1000 < locationMinShiftM
+		}
+		data.Suggestions = append(data.Suggestions, suggestion)
+	}
+	sort.Slice(data.Suggestions, func(i, j int) bool {
+		a, b := data.Suggestions[i], data.Suggestions[j]
+		if a.Accepted != b.Accepted {
+			return !a.Accepted
+		}
+		if a.Confidence != b.Confidence {
+			return a.Confidence > b.Confidence
+		}
+		return a.ShiftMeters > b.ShiftMeters
+	})
+
+	content := components.LocationSuggestions(lp, data)
+	c.Response().Header().Set(echo.HeaderContentType, echo.MIMETextHTML)
+	if c.Request().Header.Get("HX-Request") == "true" {
+		return content.Render(ctx, c.Response().Writer)
+	}
+	return layouts.Base("停車地の座標の修正案", content).Render(ctx, c.Response().Writer)
+}
+
+// collectDwellObservations は1運行日分の各停車地の近くでの滞在を配送先（顧客コードまたは住所）ごとに集める
+// 顧客コードも住所もない停車地は位置マスタに登録できないため件数だけを数える
+func (h *ProjectHandler) collectDwellObservations(ctx context.Context, lp database.Project, operatingDate string, groups map[string]*locationGroup, data *components.LocationSuggestionsData) error {
+	stops, err := h.DB.ListRouteStopsByProjectDate(ctx, database.ListRouteStopsByProjectDateParams{
+		ProjectID:     lp.ID,
+		OperatingDate: operatingDate,
+	})
+	if err != nil {
+		return err
+	}
+	var courseNames []string
+	byCourse := make(map[string][]database.RouteStop)
+	for _, stop := range stops {
+		if _, ok := byCourse[stop.CourseName]; !ok {
+			courseNames = append(courseNames, stop.CourseName)
+		}
+		byCourse[stop.CourseName] = append(byCourse[stop.CourseName], stop)
+	}
+
+	settings := judge.SettingsFromProject(lp)
+	stayMinutes := settings.StayMinutes
+	if stayMinutes <= 0 {
+		stayMinutes = dwellDefaultMinutes
+	}
+	searchRadiusM := math.Max(dwellSearchRadiusM, float64(settings.ThresholdM)*3)
+
+	for _, courseName := range courseNames {
+		runs, err := h.DB.ListCourseRuns(ctx, database.ListCourseRunsParams{
+			ProjectID:     lp.ID,
+			OperatingDate: operatingDate,
+			CourseName:    courseName,
+		})
+		if err != nil {
+			return err
+		}
+		var run *database.CourseRun
+		if len(runs) > 0 {
+			run = &runs[0]
+		}
+		logs, err := h.listCourseRunLogs(ctx, lp.ID, operatingDate, courseName, run)
+		if err != nil {
+			return err
+		}
+		if len(logs) == 0 {
+			continue
+		}
+		points := make([]dwell.Point, len(logs))
+		for i, log := range logs {
+			points[i] = dwell.Point{Latitude: log.Latitude, Longitude: log.Longitude, Time: log.Timestamp}
+		}
+		episodes := dwell.Detect(points, dwellRadiusM, time.Duration(stayMinutes)*time.Minute)
+
+		for _, stop := range byCourse[courseName] {
+			if stop.Sequence == departureSequence || !stop.Latitude.Valid || !stop.Longitude.Valid {
+				continue
+			}
+			key := locationKey(stop.CustomerCode.String, stop.Address.String)
+			if key == "" {
+				data.NoAddressStops++
+				continue
+			}
+			g, ok := groups[key]
+			if !ok {
+				g = &locationGroup{courses: make(map[string]bool)}
+				groups[key] = g
+			}
+			// 運行日の古い順に集めるため、予定の座標・名称は最新の運行日のものになる
+			g.suggestion.Name = stop.StopName
+			g.suggestion.CustomerCode = stop.CustomerCode.String
+			g.suggestion.Address = stop.Address.String
+			g.suggestion.PlannedLatitude = stop.Latitude.Float64
+			g.suggestion.PlannedLongitude = stop.Longitude.Float64
+			g.suggestion.Visits++
+			g.courses[courseName] = true
+			if e, ok := dwell.Nearby(episodes, stop.Latitude.Float64, stop.Longitude.Float64, searchRadiusM); ok {
+				g.observations = append(g.observations, dwell.Observation{Latitude: e.Latitude, Longitude: e.Longitude, Duration: e.Duration()})
+			}
+		}
+		data.AnalyzedRuns++
+	}
+	return nil
+}
+
+// AcceptLocationSuggestion は座標の修正案を位置マスタに登録する（以降の取り込みでCSVの座標より優先される）
+func (h *ProjectHandler) AcceptLocationSuggestion(c echo.Context) error {
+	if err := h.checkPermission(c); err != nil {
+		return err
+	}
+	ctx := c.Request().Context()
+	lpID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効な案件ID")
+	}
+
+	customerCode := strings.TrimSpace(c.FormValue("customer_code"))
+	address := strings.TrimSpace(c.FormValue("address"))
+	key := geocode.Normalize(address)
+	if customerCode == "" && key == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "顧客コードも住所もない停車地は登録できません")
+	}
+	lat, errLat := strconv.ParseFloat(c.FormValue("latitude"), 64)
+	lon, errLon := strconv.ParseFloat(c.FormValue("longitude"), 64)
+	if errLat != nil || errLon != nil || !isInJapan(lat, lon) {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効な座標")
+	}
+	confidence, _ := strconv.ParseFloat(c.FormValue("confidence"), 64)
+	observations, _ := strconv.ParseInt(c.FormValue("observations"), 10, 64)
+
+	// 顧客コードのある停車地はその顧客コードの登録を、なければ住所の登録を更新する（なければ新しく登録する）
+	var existing database.Location
+	if customerCode != "" {
+		existing, err = h.DB.GetLocationByCustomerCode(ctx, toNullString(customerCode))
+	} else {
+		existing, err = h.DB.GetLocationByNormalizedAddress(ctx, key)
+	}
+	switch {
+	case err == nil:
+		err = h.DB.UpdateLocationLearnedCoordinates(ctx, database.UpdateLocationLearnedCoordinatesParams{
+			ID:           existing.ID,
+			Latitude:     lat,
+			Longitude:    lon,
+			Confidence:   toNullFloat64(confidence),
+			Observations: toNullInt64(observations),
+		})
+	case errors.Is(err, sql.ErrNoRows):
+		_, err = h.DB.CreateLocation(ctx, database.CreateLocationParams{
+			CustomerCode:        toNullString(customerCode),
+			NormalizedAddress:   key,
+			Name:                strings.TrimSpace(c.FormValue("name")),
+			Address:             address,
+			Latitude:            lat,
+			Longitude:           lon,
+			CoordinateSource:    locationSourceLearned,
+			Confidence:          toNullFloat64(confidence),
+			Observations:        toNullInt64(observations),
+			OverrideCoordinates: true,
+			CreatedBy:           toNullInt64(appcontext.GetUserID(ctx)),
+		})
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("位置マスタの登録失敗: %v", err))
+	}
+
+	// PRG: 修正案の一覧へリダイレクト
+	return c.Redirect(http.StatusSeeOther, fmt.Sprintf("/projects/%d/location-suggestions?from=%s&to=%s",
+		lpID, url.QueryEscape(c.FormValue("from")), url.QueryEscape(c.FormValue("to"))))
+}
+
+// locationInput は位置マスタフォームの入力値
+type locationInput struct {
+	CustomerCode        sql.NullString
+	Name                string
+	Address             string
+	Latitude            float64
+	Longitude           float64
+	PhoneNumber         sql.NullString
+	ContactName         sql.NullString
+	GateInstructions    sql.NullString
+	StayMinutes         sql.NullInt64
+	GeofenceRadiusM     sql.NullInt64
+	GeofencePolygon     sql.NullString
+	Note                sql.NullString
+	OverrideCoordinates bool
+	OverridePhone       bool
+	OverrideStayMinutes bool
+}
+
+// parseLocationForm は位置マスタフォームを検証して読み取る
+func parseLocationForm(c echo.Context) (locationInput, error) {
+	in := locationInput{
+		CustomerCode:        toNullString(strings.TrimSpace(c.FormValue("customer_code"))),
+		Name:                strings.TrimSpace(c.FormValue("name")),
+		Address:             strings.TrimSpace(c.FormValue("address")),
+		PhoneNumber:         toNullString(strings.TrimSpace(c.FormValue("phone_number"))),
+		ContactName:         toNullString(strings.TrimSpace(c.FormValue("contact_name"))),
+		GateInstructions:    toNullString(strings.TrimSpace(c.FormValue("gate_instructions"))),
+		Note:                toNullString(strings.TrimSpace(c.FormValue("note"))),
+		OverrideCoordinates: c.FormValue("override_coordinates") != "",
+		OverridePhone:       c.FormValue("override_phone") != "",
+		OverrideStayMinutes: c.FormValue("override_stay_minutes") != "",
+	}
+	if in.Name == "" {
+		return in, fmt.Errorf("名称を入力してください")
+	}
+	if !in.CustomerCode.Valid && geocode.Normalize(in.Address) == "" {
+		return in, fmt.Errorf("顧客コードか住所のどちらかを入力してください")
+	}
+	lat, errLat := strconv.ParseFloat(strings.TrimSpace(c.FormValue("latitude")), 64)
+	lon, errLon := strconv.ParseFloat(strings.TrimSpace(c.FormValue("longitude")), 64)
+	if errLat != nil || errLon != nil || !isInJapan(lat, lon) {
+		return in, fmt.Errorf("緯度・経度を正しく入力してください")
+	}
+	in.Latitude, in.Longitude = lat, lon
+	if v := strings.TrimSpace(c.FormValue("stay_minutes")); v != "" {
+		minutes, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || minutes < 0 {
+			return in, fmt.Errorf("滞在時間は0以上の整数で入力してください")
+		}
+		in.StayMinutes = sql.NullInt64{Int64: minutes, Valid: true}
+	}
+	if v := strings.TrimSpace(c.FormValue("geofence_radius_m")); v != "" {
+		radius, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || radius <= 0 {
+			return in, fmt.Errorf("到着判定の範囲は1以上の整数（m）で入力してください")
+		}
+		in.GeofenceRadiusM = sql.NullInt64{Int64: radius, Valid: true}
+	}
+	polygon, err := parseGeofencePolygon(c.FormValue("geofence_polygon"))
+	if err != nil {
+		return in, err
+	}
+	in.GeofencePolygon = polygon
+	return in, nil
+}
+
+// ListLocationMasters は位置マスタの一覧を表示する（?q= で名称・住所・顧客コードを検索）
+func (h *ProjectHandler) ListLocationMasters(c echo.Context) error {
+	ctx := c.Request().Context()
+	keyword := strings.TrimSpace(c.QueryParam("q"))
+	var locations []database.Location
+	var err error
+	if keyword != "" {
+		locations, err = h.DB.SearchLocations(ctx, keyword)
+	} else {
+		locations, err = h.DB.ListLocations(ctx)
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	content := components.LocationList(locations, keyword)
+	c.Response().Header().Set(echo.HeaderContentType, echo.MIMETextHTML)
+	if c.Request().Header.Get("HX-Request") == "true" {
+		return content.Render(ctx, c.Response().Writer)
+	}
+	return layouts.Base("位置マスタ", content).Render(ctx, c.Response().Writer)
+}
+
+// CreateLocationMaster は位置マスタに配送先を登録する
+func (h *ProjectHandler) CreateLocationMaster(c echo.Context) error {
+	if err := h.checkPermission(c); err != nil {
+		return err
+	}
+	ctx := c.Request().Context()
+	in, err := parseLocationForm(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	if _, err := h.DB.CreateLocation(ctx, database.CreateLocationParams{
+		CustomerCode:        in.CustomerCode,
+		NormalizedAddress:   geocode.Normalize(in.Address),
+		Name:                in.Name,
+		Address:             in.Address,
+		Latitude:            in.Latitude,
+		Longitude:           in.Longitude,
+		CoordinateSource:    locationSourceManual,
+		PhoneNumber:         in.PhoneNumber,
+		ContactName:         in.ContactName,
+		GateInstructions:    in.GateInstructions,
+		StayMinutes:         in.StayMinutes,
+		GeofenceRadiusM:     in.GeofenceRadiusM,
+		GeofencePolygon:     in.GeofencePolygon,
+		Note:                in.Note,
+		OverrideCoordinates: in.OverrideCoordinates,
+		OverridePhone:       in.OverridePhone,
+		OverrideStayMinutes: in.OverrideStayMinutes,
+		CreatedBy:           toNullInt64(appcontext.GetUserID(ctx)),
+	}); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("位置マスタの登録に失敗しました（顧客コード・住所の重複など）: %v", err))
+	}
+	return c.Redirect(http.StatusSeeOther, "/masters/locations")
+}
+
+// getLocation はURLの :location_id から位置マスタの登録を取得する
+func (h *ProjectHandler) getLocation(c echo.Context) (database.Location, error) {
+	id, err := strconv.ParseInt(c.Param("location_id"), 10, 64)
+	if err != nil {
+		return database.Location{}, echo.NewHTTPError(http.StatusBadRequest, "無効な位置マスタID")
+	}
+	loc, err := h.DB.GetLocation(c.Request().Context(), id)
+	if err != nil {
+		return database.Location{}, echo.NewHTTPError(http.StatusNotFound, "位置マスタの登録が見つかりません")
+	}
+	return loc, nil
+}
+
+// EditLocationMasterPage は位置マスタの編集ページを表示する
+func (h *ProjectHandler) EditLocationMasterPage(c echo.Context) error {
+	if err := h.checkPermission(c); err != nil {
+		return err
+	}
+	ctx := c.Request().Context()
+	loc, err := h.getLocation(c)
+	if err != nil {
+		return err
+	}
+
+	content := components.LocationEdit(loc)
+	c.Response().Header().Set(echo.HeaderContentType, echo.MIMETextHTML)
+	if c.Request().Header.Get("HX-Request") == "true" {
+		return content.Render(ctx, c.Response().Writer)
+	}
+	return layouts.Base("位置マスタ編集: "+loc.Name, content).Render(ctx, c.Response().Writer)
+}
+
+// UpdateLocationMaster は位置マスタの登録を更新する
+// 座標を書き換えた場合は手入力の座標として扱い、学習時の信頼度は消す
+func (h *ProjectHandler) UpdateLocationMaster(c echo.Context) error {
+	if err := h.checkPermission(c); err != nil {
+		return err
+	}
+	loc, err := h.getLocation(c)
+	if err != nil {
+		return err
+	}
+	in, err := parseLocationForm(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	source, confidence, observations := loc.CoordinateSource, loc.Confidence, loc.Observations
+	if geo.Haversine(loc.Latitude, loc.Longitude, in.Latitude, in.Longitude)*1000 >= 1 {
+		source, confidence, observations = locationSourceManual, sql.NullFloat64{}, sql.NullInt64{}
+	}
+
+	if err := h.DB.UpdateLocation(c.Request().Context(), database.UpdateLocationParams{
+		ID:                  loc.ID,
+		CustomerCode:        in.CustomerCode,
+		NormalizedAddress:   geocode.Normalize(in.Address),
+		Name:                in.Name,
+		Address:             in.Address,
+		Latitude:            in.Latitude,
+		Longitude:           in.Longitude,
+		CoordinateSource:    source,
+		Confidence:          confidence,
+		Observations:        observations,
+		PhoneNumber:         in.PhoneNumber,
+		ContactName:         in.ContactName,
+		GateInstructions:    in.GateInstructions,
+		StayMinutes:         in.StayMinutes,
+		GeofenceRadiusM:     in.GeofenceRadiusM,
+		GeofencePolygon:     in.GeofencePolygon,
+		Note:                in.Note,
+		OverrideCoordinates: in.OverrideCoordinates,
+		OverridePhone:       in.OverridePhone,
+		OverrideStayMinutes: in.OverrideStayMinutes,
+	}); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("位置マスタの更新に失敗しました（顧客コード・住所の重複など）: %v", err))
+	}
+	return c.Redirect(http.StatusSeeOther, "/masters/locations")
+}
+
+// DeleteLocationMaster は位置マスタの登録を削除する（取り込み済みの停車地の座標は変わらない）
+func (h *ProjectHandler) DeleteLocationMaster(c echo.Context) error {
+	if err := h.checkPermission(c); err != nil {
+		return err
+	}
+	loc, err := h.getLocation(c)
+	if err != nil {
+		return err
+	}
+	if err := h.DB.DeleteLocation(c.Request().Context(), loc.ID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("位置マスタの削除に失敗しました: %v", err))
+	}
+	return c.Redirect(http.StatusSeeOther, "/masters/locations")
+}
@@ -0,0 +1,295 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
+	"github.com/naozine/project_crud_with_auth_tmpl/web/components"
+	"github.com/naozine/project_crud_with_auth_tmpl/web/layouts"
+)
+
+// 車両・ドライバーは案件をまたいで毎日使うため、案件に属さないマスタとして管理する
+
+// vehicleInput は車両フォームの入力値
+type vehicleInput struct {
+	Plate        string
+	VehicleType  sql.NullString
+	MaxLoadKg    sql.NullInt64
+	Refrigerated bool
+	Note         sql.NullString
+}
+
+// parseVehicleForm は車両フォームを検証して読み取る
+func parseVehicleForm(c echo.Context) (vehicleInput, error) {
+	in := vehicleInput{
+		Plate:        strings.TrimSpace(c.FormValue("plate")),
+		VehicleType:  toNullString(strings.TrimSpace(c.FormValue("vehicle_type"))),
+		Refrigerated: c.FormValue("refrigerated") != "",
+		Note:         toNullString(strings.TrimSpace(c.FormValue("note"))),
+	}
+	if in.Plate == "" {
+		return in, fmt.Errorf("車両ナンバーを入力してください")
+	}
+	if v := strings.TrimSpace(c.FormValue("max_load_kg")); v != "" {
+		maxLoad, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || maxLoad < 0 {
+			return in, fmt.Errorf("最大積載量は0以上の整数で入力してください")
+		}
+		in.MaxLoadKg = sql.NullInt64{Int64: maxLoad, Valid: true}
+	}
+	return in, nil
+}
+
+// driverInput はドライバーフォームの入力値
+type driverInput struct {
+	Name          string
+	Phone         sql.NullString
+	LicenseExpiry sql.NullString
+	Note          sql.NullString
+}
+
+// parseDriverForm はドライバーフォームを検証して読み取る
+func parseDriverForm(c echo.Context) (driverInput, error) {
+	in := driverInput{
+		Name:  strings.TrimSpace(c.FormValue("name")),
+		Phone: toNullString(strings.TrimSpace(c.FormValue("phone"))),
+		Note:  toNullString(strings.TrimSpace(c.FormValue("note"))),
+	}
+	if in.Name == "" {
+		return in, fmt.Errorf("氏名を入力してください")
+	}
+	if v := strings.TrimSpace(c.FormValue("license_expiry")); v != "" {
+		if _, err := parseOperatingDate(v); err != nil {
+			return in, fmt.Errorf("免許の有効期限は YYYY-MM-DD 形式で入力してください")
+		}
+		in.LicenseExpiry = sql.NullString{String: v, Valid: true}
+	}
+	return in, nil
+}
+
+// ListVehicles は車両マスタ一覧を表示
+func (h *ProjectHandler) ListVehicles(c echo.Context) error {
+	ctx := c.Request().Context()
+	vehicles, err := h.DB.ListVehicles(ctx)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	content := components.VehicleList(vehicles)
+	c.Response().Header().Set(echo.HeaderContentType, echo.MIMETextHTML)
+	if c.Request().Header.Get("HX-Request") == "true" {
+		return content.Render(ctx, c.Response().Writer)
+	}
+	return layouts.Base("車両マスタ", content).Render(ctx, c.Response().Writer)
+}
+
+// CreateVehicle は車両を登録
+func (h *ProjectHandler) CreateVehicle(c echo.Context) error {
+	if err := h.checkPermission(c); err != nil {
+		return err
+	}
+	in, err := parseVehicleForm(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	if _, err := h.DB.CreateVehicle(c.Request().Context(), database.CreateVehicleParams{
+		Plate:        in.Plate,
+		VehicleType:  in.VehicleType,
+		MaxLoadKg:    in.MaxLoadKg,
+		Refrigerated: in.Refrigerated,
+		Note:         in.Note,
+	}); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("車両の登録に失敗しました（ナンバーの重複など）: %v", err))
+	}
+	return c.Redirect(http.StatusSeeOther, "/masters/vehicles")
+}
+
+// getVehicle はURLの :vehicle_id から車両を取得する
+func (h *ProjectHandler) getVehicle(c echo.Context) (database.Vehicle, error) {
+	id, err := strconv.ParseInt(c.Param("vehicle_id"), 10, 64)
+	if err != nil {
+		return database.Vehicle{}, echo.NewHTTPError(http.StatusBadRequest, "無効な車両ID")
+	}
+	vehicle, err := h.DB.GetVehicle(c.Request().Context(), id)
+	if err != nil {
+		return database.Vehicle{}, echo.NewHTTPError(http.StatusNotFound, "車両が見つかりません")
+	}
+	return vehicle, nil
+}
+
+// EditVehiclePage は車両編集ページを表示
+func (h *ProjectHandler) EditVehiclePage(c echo.Context) error {
+	if err := h.checkPermission(c); err != nil {
+		return err
+	}
+	ctx := c.Request().Context()
+	vehicle, err := h.getVehicle(c)
+	if err != nil {
+		return err
+	}
+
+	content := components.VehicleEdit(vehicle)
+	c.Response().Header().Set(echo.HeaderContentType, echo.MIMETextHTML)
+	if c.Request().Header.Get("HX-Request") == "true" {
+		return content.Render(ctx, c.Response().Writer)
+	}
+	return layouts.Base("車両編集: "+vehicle.Plate, content).Render(ctx, c.Response().Writer)
+}
+
+// UpdateVehicle は車両を更新
+func (h *ProjectHandler) UpdateVehicle(c echo.Context) error {
+	if err := h.checkPermission(c); err != nil {
+		return err
+	}
+	vehicle, err := h.getVehicle(c)
+	if err != nil {
+		return err
+	}
+	in, err := parseVehicleForm(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	if _, err := h.DB.UpdateVehicle(c.Request().Context(), database.UpdateVehicleParams{
+		ID:           vehicle.ID,
+		Plate:        in.Plate,
+		VehicleType:  in.VehicleType,
+		MaxLoadKg:    in.MaxLoadKg,
+		Refrigerated: in.Refrigerated,
+		Note:         in.Note,
+	}); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("車両の更新に失敗しました（ナンバーの重複など）: %v", err))
+	}
+	return c.Redirect(http.StatusSeeOther, "/masters/vehicles")
+}
+
+// DeleteVehicle は車両を削除（割り当て済みのコースは車両未割当になる）
+func (h *ProjectHandler) DeleteVehicle(c echo.Context) error {
+	if err := h.checkPermission(c); err != nil {
+		return err
+	}
+	vehicle, err := h.getVehicle(c)
+	if err != nil {
+		return err
+	}
+	if err := h.DB.DeleteVehicle(c.Request().Context(), vehicle.ID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("車両の削除に失敗しました: %v", err))
+	}
+	return c.Redirect(http.StatusSeeOther, "/masters/vehicles")
+}
+
+// ListDrivers はドライバーマスタ一覧を表示
+func (h *ProjectHandler) ListDrivers(c echo.Context) error {
+	ctx := c.Request().Context()
+	drivers, err := h.DB.ListDrivers(ctx)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	content := components.DriverList(drivers, todayOperatingDate())
+	c.Response().Header().Set(echo.HeaderContentType, echo.MIMETextHTML)
+	if c.Request().Header.Get("HX-Request") == "true" {
+		return content.Render(ctx, c.Response().Writer)
+	}
+	return layouts.Base("ドライバーマスタ", content).Render(ctx, c.Response().Writer)
+}
+
+// CreateDriver はドライバーを登録
+func (h *ProjectHandler) CreateDriver(c echo.Context) error {
+	if err := h.checkPermission(c); err != nil {
+		return err
+	}
+	in, err := parseDriverForm(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	if _, err := h.DB.CreateDriver(c.Request().Context(), database.CreateDriverParams{
+		Name:          in.Name,
+		Phone:         in.Phone,
+		LicenseExpiry: in.LicenseExpiry,
+		Note:          in.Note,
+	}); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("ドライバーの登録に失敗しました: %v", err))
+	}
+	return c.Redirect(http.StatusSeeOther, "/masters/drivers")
+}
+
+// getDriver はURLの :driver_id からドライバーを取得する
+func (h *ProjectHandler) getDriver(c echo.Context) (database.Driver, error) {
+	id, err := strconv.ParseInt(c.Param("driver_id"), 10, 64)
+	if err != nil {
+		return database.Driver{}, echo.NewHTTPError(http.StatusBadRequest, "無効なドライバーID")
+	}
+	driver, err := h.DB.GetDriver(c.Request().Context(), id)
+	if err != nil {
+		return database.Driver{}, echo.NewHTTPError(http.StatusNotFound, "ドライバーが見つかりません")
+	}
+	return driver, nil
+}
+
+// EditDriverPage はドライバー編集ページを表示
+func (h *ProjectHandler) EditDriverPage(c echo.Context) error {
+	if err := h.checkPermission(c); err != nil {
+		return err
+	}
+	ctx := c.Request().Context()
+	driver, err := h.getDriver(c)
+	if err != nil {
+		return err
+	}
+
+	content := components.DriverEdit(driver)
+	c.Response().Header().Set(echo.HeaderContentType, echo.MIMETextHTML)
+	if c.Request().Header.Get("HX-Request") == "true" {
+		return content.Render(ctx, c.Response().Writer)
+	}
+	return layouts.Base("ドライバー編集: "+driver.Name, content).Render(ctx, c.Response().Writer)
+}
+
+// UpdateDriver はドライバーを更新
+func (h *ProjectHandler) UpdateDriver(c echo.Context) error {
+	if err := h.checkPermission(c); err != nil {
+		return err
+	}
+	driver, err := h.getDriver(c)
+	if err != nil {
+		return err
+	}
+	in, err := parseDriverForm(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	if _, err := h.DB.UpdateDriver(c.Request().Context(), database.UpdateDriverParams{
+		ID:            driver.ID,
+		Name:          in.Name,
+		Phone:         in.Phone,
+		LicenseExpiry: in.LicenseExpiry,
+		Note:          in.Note,
+	}); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("ドライバーの更新に失敗しました: %v", err))
+	}
+	return c.Redirect(http.StatusSeeOther, "/masters/drivers")
+}
+
+// DeleteDriver はドライバーを削除（割り当て済みのコースはドライバー未割当になる）
+func (h *ProjectHandler) DeleteDriver(c echo.Context) error {
+	if err := h.checkPermission(c); err != nil {
+		return err
+	}
+	driver, err := h.getDriver(c)
+	if err != nil {
+		return err
+	}
+	if err := h.DB.DeleteDriver(c.Request().Context(), driver.ID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("ドライバーの削除に失敗しました: %v", err))
+	}
+	return c.Redirect(http.StatusSeeOther, "/masters/drivers")
+}
@@ -0,0 +1,279 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/judge"
+	"golang.org/x/text/width"
+)
+
+// operatingDateLayout は運行日の形式（YYYY-MM-DD）
+const operatingDateLayout = "2006-01-02"
+
+// operatingDayCarryOverMargin は日付をまたぐ運行（到着予定 "25:30" など）で、前日の最後の到着予定から
+// この時間までのログを前日の運行として扱う幅（遅れ・営業所への帰着の分）
+const operatingDayCarryOverMargin = 3 * time.Hour
+
+// operatingDayCarryOverHour は前日の配送ルートに到着予定がなく当日の配送ルートもない場合に、
+// 前日の運行として扱う時刻の上限（この時刻より前なら前日扱い）
+const operatingDayCarryOverHour = 6
+
+// todayOperatingDate は日本時間での今日の運行日を返す
+func todayOperatingDate() string {
+	return time.Now().In(JST).Format(operatingDateLayout)
+}
+
+// parseOperatingDate は運行日の文字列を検証して日本時間の0時を返す
+func parseOperatingDate(s string) (time.Time, error) {
+	return time.ParseInLocation(operatingDateLayout, s, JST)
+}
+
+// plannedTimeOnDate は到着予定 "HH:MM" を運行日を基準にした時刻に変換する
+// 24時以降（"25:30" など）は翌日の時刻として扱う
+func plannedTimeOnDate(operatingDate, hhmm string) (time.Time, error) {
+	base, err := parseOperatingDate(operatingDate)
+	if err != nil {
+		return time.Time{}, err
+	}
+	minutes, err := parseTimeToMinutes(hhmm)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return base.Add(time.Duration(minutes) * time.Minute), nil
+}
+
+// departureSequence は順番列で出発地点を表す値
+const departureSequence = judge.DepartureSequence
+
+// overnightThreshold は希望時間帯や手動で指定した到着時刻が到着予定からこの時間以上離れた場合に前日・翌日の時刻とみなす幅
+const overnightThreshold = 12 * time.Hour
+
+// overnightTolerance は到着予定が直前の地点の到着予定＋滞在予定より前に戻っても、同じ日の予定の前後とみなす幅
+const overnightTolerance = 30 * time.Minute
+
+// parseSequenceNo は順番列を並べ替え用の整数にする（「出発」は0、全角数字も可）
+func parseSequenceNo(s string) (int64, bool) {
+	s = strings.TrimSpace(s)
+	if s == departureSequence {
+		return 0, true
+	}
+	n, err := strconv.ParseInt(width.Narrow.String(s), 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return n, true
+}
+
+// plannedTimesInOrder は並び順どおりの到着予定 "HH:MM" と滞在予定（分）を運行日を基準にした日時に変換する
+// 直前の地点の到着予定＋滞在予定より前になる時刻は、その後になるまで翌日・翌々日へ送る（日付をまたぐ運行）
+// ただし overnightTolerance 以内の前後は予定の誤差として同じ日のままにする。解釈できない時刻は無効値にする
+func plannedTimesInOrder(operatingDate string, clockTimes []string, stayMinutes []int64) []sql.NullTime {
+	planned := make([]sql.NullTime, len(clockTimes))
+	var departable time.Time // 直前の地点を出発できる日時
+	for i, hhmm := range clockTimes {
+		if !isValidClockTime(hhmm) {
+			continue
+		}
+		t, err := plannedTimeOnDate(operatingDate, hhmm)
+		if err != nil {
+			continue
+		}
+		for !departable.IsZero() && departable.Sub(t) > overnightTolerance {
+			t = t.AddDate(0, 0, 1)
+		}
+		planned[i] = sql.NullTime{Time: t, Valid: true}
+		departable = t
+		if i < len(stayMinutes) && stayMinutes[i] > 0 {
+			departable = t.Add(time.Duration(stayMinutes[i]) * time.Minute)
+		}
+	}
+	return planned
+}
+
+// scheduleRouteStops はCSVの行に並び順（SequenceNo）と到着予定日時（PlannedAt）を設定したコピーを返す
+// 順番列が数値でない行はコース内の直前の行の次とし、到着予定日時はコースごとに並び順で判定する
+func scheduleRouteStops(operatingDate string, stops []RouteStop) []RouteStop {
+	scheduled := make([]RouteStop, len(stops))
+	copy(scheduled, stops)
+
+	lastSequenceNo := make(map[string]int64)
+	byCourse := make(map[string][]int)
+	for i := range scheduled {
+		stop := &scheduled[i]
+		if n, ok := parseSequenceNo(stop.Sequence); ok {
+			stop.SequenceNo = n
+		} else {
+			stop.SequenceNo = lastSequenceNo[stop.CourseName] + 1
+		}
+		lastSequenceNo[stop.CourseName] = stop.SequenceNo
+		byCourse[stop.CourseName] = append(byCourse[stop.CourseName], i)
+	}
+
+	for _, idx := range byCourse {
+		sort.SliceStable(idx, func(a, b int) bool {
+			return scheduled[idx[a]].SequenceNo < scheduled[idx[b]].SequenceNo
+		})
+		clockTimes := make([]string, len(idx))
+		stayMinutes := make([]int64, len(idx))
+		for j, i := range idx {
+			clockTimes[j] = scheduled[i].ArrivalTime
+			stayMinutes[j] = scheduled[i].StayMinutes
+		}
+		for j, plannedAt := range plannedTimesInOrder(operatingDate, clockTimes, stayMinutes) {
+			scheduled[idx[j]].PlannedAt = plannedAt
+		}
+	}
+	return scheduled
+}
+
+// resolveOperatingDate は受信した位置情報・写真の時刻がどの運行日に属するかを判定する
+// 前日の配送ルートがあり、時刻が前日の運行に属する（belongsToPreviousDay）なら前日、それ以外は当日とする
+// 毎日走る夜間のコースでは当日の配送ルートもあるため、当日の配送ルートの有無だけでは決めない
+func resolveOperatingDate(ctx context.Context, q *database.Queries, projectID int64, courseName string, ts time.Time) string {
+	local := ts.In(JST)
+	today := local.Format(operatingDateLayout)
+	yesterday := local.AddDate(0, 0, -1).Format(operatingDateLayout)
+
+	dates, err := q.ListOperatingDatesByCourse(ctx, database.ListOperatingDatesByCourseParams{
+		ProjectID:  projectID,
+		CourseName: courseName,
+		Date1:      today,
+		Date2:      yesterday,
+	})
+	if err != nil {
+		return today
+	}
+
+	var prev, cur courseDay
+	for _, d := range dates {
+		switch d {
+		case today:
+			cur.hasRoute = true
+		case yesterday:
+			prev.hasRoute = true
+		}
+	}
+	if !prev.hasRoute {
+		return today
+	}
+
+	if prev, err = loadCourseDay(ctx, q, projectID, yesterday, courseName, true); err != nil {
+		return today
+	}
+	if cur.hasRoute {
+		if cur, err = loadCourseDay(ctx, q, projectID, today, courseName, false); err != nil {
+			return today
+		}
+	}
+	if belongsToPreviousDay(local, prev, cur) {
+		return yesterday
+	}
+	return today
+}
+
+// courseDay はある運行日のコースの配送ルート・運行（ラン）の状況
+type courseDay struct {
+	hasRoute    bool
+	lastPlanned sql.NullTime // 最後の到着予定日時
+	runs        []database.CourseRun
+}
+
+// loadCourseDay は運行日のコースの運行と（withPlanned なら）最後の到着予定日時を読み込む
+func loadCourseDay(ctx context.Context, q *database.Queries, projectID int64, operatingDate, courseName string, withPlanned bool) (courseDay, error) {
+	day := courseDay{hasRoute: true}
+	runs, err := q.ListCourseRuns(ctx, database.ListCourseRunsParams{
+		ProjectID:     projectID,
+		OperatingDate: operatingDate,
+		CourseName:    courseName,
+	})
+	if err != nil {
+		return day, err
+	}
+	day.runs = runs
+	if !withPlanned {
+		return day, nil
+	}
+
+	stops, err := q.ListRouteStopsByCourse(ctx, database.ListRouteStopsByCourseParams{
+		ProjectID:     projectID,
+		OperatingDate: operatingDate,
+		CourseName:    courseName,
+	})
+	if err != nil {
+		return day, err
+	}
+	for _, stop := range stops {
+		if stop.PlannedAt.Valid && (!day.lastPlanned.Valid || stop.PlannedAt.Time.After(day.lastPlanned.Time)) {
+			day.lastPlanned = stop.PlannedAt
+		}
+	}
+	return day, nil
+}
+
+// belongsToPreviousDay は時刻 ts が前日（prev）の運行に属するかどうかを返す（cur は当日）
+// 運行（ラン）の期間内ならその運行日とし（前日を優先）、どちらの運行にも入らなければ
+// 前日の最後の到着予定＋ operatingDayCarryOverMargin 以前なら前日とする
+// 前日に到着予定がなく当日の配送ルートもなければ、早朝（operatingDayCarryOverHour より前）のみ前日とする
+func belongsToPreviousDay(ts time.Time, prev, cur courseDay) bool {
+	if withinCourseRun(ts, prev.runs) {
+		return true
+	}
+	if withinCourseRun(ts, cur.runs) {
+		return false
+	}
+	if prev.lastPlanned.Valid {
+		return !ts.After(prev.lastPlanned.Time.Add(operatingDayCarryOverMargin))
+	}
+	return !cur.hasRoute && ts.In(JST).Hour() < operatingDayCarryOverHour
+}
+
+// withinCourseRun は ts がいずれかの運行の期間内（運行中なら開始以降）かどうかを返す
+func withinCourseRun(ts time.Time, runs []database.CourseRun) bool {
+	for _, run := range runs {
+		if ts.Before(run.StartedAt) {
+			continue
+		}
+		if !run.EndedAt.Valid || !ts.After(run.EndedAt.Time) {
+			return true
+		}
+	}
+	return false
+}
+
+// selectOperatingDate は ?date= から表示する運行日を決める
+// 未指定の場合は今日、今日の配送ルートがなければ配送ルートのある直近の日を選ぶ
+// 戻り値は選択した運行日と、配送ルートのある運行日の一覧（降順）
+func (h *ProjectHandler) selectOperatingDate(c echo.Context, lpID int64) (string, []string, error) {
+	dates, err := h.DB.ListOperatingDatesByProject(c.Request().Context(), lpID)
+	if err != nil {
+		return "", nil, echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	if date := c.QueryParam("date"); date != "" {
+		if _, err := parseOperatingDate(date); err != nil {
+			return "", nil, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("無効な運行日: %s", date))
+		}
+		return date, dates, nil
+	}
+
+	today := todayOperatingDate()
+	if len(dates) == 0 {
+		return today, dates, nil
+	}
+	// dates は降順なので、今日以前で最初に見つかった日が直近の運行日
+	for _, d := range dates {
+		if d <= today {
+			return d, dates, nil
+		}
+	}
+	return dates[len(dates)-1], dates, nil
+}
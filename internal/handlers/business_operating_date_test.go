@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
+)
+
+// jstAt は日本時間の日時（"2025-12-02 01:30" の形式）
+func jstAt(s string) time.Time {
+	t, err := time.ParseInLocation("2006-01-02 15:04", s, JST)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func plannedAt(s string) sql.NullTime {
+	return sql.NullTime{Time: jstAt(s), Valid: true}
+}
+
+// courseRun は運行（ラン）。ended が空なら運行中
+func courseRun(started, ended string) database.CourseRun {
+	run := database.CourseRun{StartedAt: jstAt(started)}
+	if ended != "" {
+		run.EndedAt = plannedAt(ended)
+	}
+	return run
+}
+
+func TestBelongsToPreviousDay(t *testing.T) {
+	// 毎日 22:00 発・翌 03:00 まで走る夜間のコース（12/1 と 12/2 の両方に配送ルートがある）
+	nightPrev := courseDay{hasRoute: true, lastPlanned: plannedAt("2025-12-02 03:00")}
+	nightCur := courseDay{hasRoute: true}
+	// 毎日 08:00〜17:00 の日中のコース
+	dayPrev := courseDay{hasRoute: true, lastPlanned: plannedAt("2025-12-01 17:00")}
+	dayCur := courseDay{hasRoute: true}
+
+	tests := []struct {
+		name      string
+		ts        string
+		prev, cur courseDay
+		want      bool
+	}{
+		{name: "夜間のコースの0時過ぎは当日の配送ルートがあっても前日の運行", ts: "2025-12-02 01:30", prev: nightPrev, cur: nightCur, want: true},
+		{name: "夜間のコースの最後の到着予定を過ぎても幅の内なら前日の運行", ts: "2025-12-02 05:59", prev: nightPrev, cur: nightCur, want: true},
+		{name: "夜間のコースの最後の到着予定から幅を過ぎれば当日", ts: "2025-12-02 06:30", prev: nightPrev, cur: nightCur, want: false},
+		{name: "夜間のコースの当日の出発後は当日", ts: "2025-12-02 22:30", prev: nightPrev, cur: nightCur, want: false},
+		{name: "日中のコースの0時過ぎは当日", ts: "2025-12-02 00:30", prev: dayPrev, cur: dayCur, want: false},
+		{name: "日中のコースの朝は当日", ts: "2025-12-02 07:00", prev: dayPrev, cur: dayCur, want: false},
+		{
+			name: "前日の運行が続いていれば最後の到着予定から幅を過ぎても前日",
+			ts:   "2025-12-02 07:30",
+			prev: courseDay{hasRoute: true, lastPlanned: plannedAt("2025-12-02 03:00"), runs: []database.CourseRun{courseRun("2025-12-01 22:00", "")}},
+			cur:  nightCur,
+			want: true,
+		},
+		{
+			name: "前日の運行が終わり当日の運行が始まっていれば幅の内でも当日",
+			ts:   "2025-12-02 02:45",
+			prev: courseDay{hasRoute: true, lastPlanned: plannedAt("2025-12-02 03:00"), runs: []database.CourseRun{courseRun("2025-12-01 22:00", "2025-12-02 02:00")}},
+			cur:  courseDay{hasRoute: true, runs: []database.CourseRun{courseRun("2025-12-02 02:30", "")}},
+			want: false,
+		},
+		{name: "前日に到着予定がなく当日の配送ルートもなければ早朝は前日", ts: "2025-12-02 05:00", prev: courseDay{hasRoute: true}, want: true},
+		{name: "前日に到着予定がなく当日の配送ルートがあれば当日", ts: "2025-12-02 05:00", prev: courseDay{hasRoute: true}, cur: dayCur, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := belongsToPreviousDay(jstAt(tt.ts), tt.prev, tt.cur); got != tt.want {
+				t.Errorf("belongsToPreviousDay(%s) = %v, want %v", tt.ts, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPlannedTimesInOrder(t *testing.T) {
+	tests := []struct {
+		name        string
+		clockTimes  []string
+		stayMinutes []int64
+		want        []string // 空は無効値
+	}{
+		{
+			name:       "同じ日の予定",
+			clockTimes: []string{"08:30", "09:00", "14:00"},
+			want:       []string{"2025-12-01 08:30", "2025-12-01 09:00", "2025-12-01 14:00"},
+		},
+		{
+			name:       "0時をまたぐ夜間の運行",
+			clockTimes: []string{"22:00", "23:50", "00:10", "03:00"},
+			want:       []string{"2025-12-01 22:00", "2025-12-01 23:50", "2025-12-02 00:10", "2025-12-02 03:00"},
+		},
+		{
+			name:       "並び替えで12時間未満だけ前に戻る時刻も翌日にする",
+			clockTimes: []string{"08:30", "14:00", "01:00", "15:00"},
+			want:       []string{"2025-12-01 08:30", "2025-12-01 14:00", "2025-12-02 01:00", "2025-12-02 15:00"},
+		},
+		{
+			name:        "直前の地点の滞在予定が終わる前の時刻は翌日にする",
+			clockTimes:  []string{"10:00", "12:00"},
+			stayMinutes: []int64{180, 0},
+			want:        []string{"2025-12-01 10:00", "2025-12-02 12:00"},
+		},
+		{
+			name:        "滞在予定の終わりより少し前なら予定の誤差として同じ日",
+			clockTimes:  []string{"10:00", "10:20"},
+			stayMinutes: []int64{30, 0},
+			want:        []string{"2025-12-01 10:00", "2025-12-01 10:20"},
+		},
+		{
+			name:       "24時以降の表記はそのまま翌日",
+			clockTimes: []string{"22:00", "25:30", "26:00"},
+			want:       []string{"2025-12-01 22:00", "2025-12-02 01:30", "2025-12-02 02:00"},
+		},
+		{
+			name:       "解釈できない時刻は無効値にして前後の判定に使わない",
+			clockTimes: []string{"22:00", "", "10:75", "23:00"},
+			want:       []string{"2025-12-01 22:00", "", "", "2025-12-01 23:00"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := plannedTimesInOrder("2025-12-01", tt.clockTimes, tt.stayMinutes)
+			if len(got) != len(tt.want) {
+				t.Fatalf("plannedTimesInOrder = %d件, want %d件", len(got), len(tt.want))
+			}
+			for i, w := range tt.want {
+				g := ""
+				if got[i].Valid {
+					g = got[i].Time.In(JST).Format("2006-01-02 15:04")
+				}
+				if g != w {
+					t.Errorf("[%d] %s = %q, want %q", i, tt.clockTimes[i], g, w)
+				}
+			}
+		})
+	}
+}
@@ -4,11 +4,9 @@ import (
 	"context"
 	"crypto/rand"
 	"database/sql"
-	"encoding/csv"
 	"encoding/hex"
 	"fmt"
-	"io"
-	"mime/multipart"
+	"log"
 	"net/http"
 	"strconv"
 	"strings"
@@ -17,22 +15,27 @@ import (
 	"github.com/labstack/echo/v4"
 	"github.com/naozine/project_crud_with_auth_tmpl/internal/appcontext"
 	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
-	"github.com/naozine/project_crud_with_auth_tmpl/internal/geo"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/eta"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/geocode"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/judge"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/visits"
 	"github.com/naozine/project_crud_with_auth_tmpl/web/components"
 	"github.com/naozine/project_crud_with_auth_tmpl/web/layouts"
-	"golang.org/x/text/encoding/japanese"
-	"golang.org/x/text/transform"
 )
 
 // JST は日本標準時 (componentsからも参照されるためここにも定義)
 var JST = time.FixedZone("Asia/Tokyo", 9*60*60)
 
 type ProjectHandler struct {
-	DB *database.Queries
+	DB       *database.Queries
+	Conn     *sql.DB          // トランザクション用
+	Geocoder geocode.Geocoder // 取り込み時に住所から座標を求める（nil なら求めない）
+	Visits   *visits.Store    // 保存済みの到着判定
+	Legs     *eta.LegCache    // 到着予測に使う過去の運行の区間の所要時間
 }
 
-func NewProjectHandler(db *database.Queries) *ProjectHandler {
-	return &ProjectHandler{DB: db}
+func NewProjectHandler(db *database.Queries, conn *sql.DB, geocoder geocode.Geocoder) *ProjectHandler {
+	return &ProjectHandler{DB: db, Conn: conn, Geocoder: geocoder, Visits: visits.NewStore(db, conn), Legs: eta.NewLegCache(0)}
 }
 
 // checkPermission は現在のユーザーが書き込み権限を持っているかチェック
@@ -102,6 +105,17 @@ func (h *ProjectHandler) CreateProject(c echo.Context) error {
 		}
 	}
 
+	judgeSequenceWindow := int64(0)
+	if v := c.FormValue("judge_sequence_window"); v != "" {
+		if parsed, err := strconv.ParseInt(v, 10, 64); err == nil && parsed > 0 {
+			judgeSequenceWindow = parsed
+		}
+	}
+	fixes, err := parseJudgeFixSettings(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
 	// プロジェクト作成時にAPIキーを生成
 	newKey, err := generateAPIKey()
 	if err != nil {
@@ -114,6 +128,12 @@ func (h *ProjectHandler) CreateProject(c echo.Context) error {
 		ArrivalThresholdMeters: sql.NullInt64{Int64: arrivalThreshold, Valid: true},
 		JudgeStayTimeMinutes:   sql.NullInt64{Int64: judgeStayTime, Valid: true},
 		JudgeSpeedLimitKmh:     sql.NullFloat64{Float64: judgeSpeedLimit, Valid: true},
+		JudgeSequenceWindow:    judgeSequenceWindow,
+		JudgeMaxAccuracyM:      fixes.MaxAccuracyM,
+		JudgeAccuracyMode:      fixes.AccuracyMode,
+		JudgeMinFixes:          fixes.MinFixes,
+		JudgeExitMarginM:       fixes.ExitMarginM,
+		JudgeUseBearing:        fixes.UseBearing,
 	})
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, err)
@@ -143,10 +163,16 @@ func (h *ProjectHandler) ShowProject(c echo.Context) error {
 	// コース一覧を取得（デバイス割り当て用）
 	courses, err := h.DB.ListCoursesByProject(ctx, lpID)
 	if err != nil {
-		courses = []string{}
+		courses = []database.Course{}
 	}
 
-	content := components.ProjectDetail(lp, devices, courses)
+	// 取り込み履歴を取得
+	history, err := h.DB.ListRouteImportHistory(ctx, lpID)
+	if err != nil {
+		history = []database.ListRouteImportHistoryRow{}
+	}
+
+	content := components.ProjectDetail(lp, devices, courses, history)
 	c.Response().Header().Set(echo.HeaderContentType, echo.MIMETextHTML)
 	if c.Request().Header.Get("HX-Request") == "true" {
 		return content.Render(ctx, c.Response().Writer)
@@ -211,12 +237,29 @@ func (h *ProjectHandler) UpdateProject(c echo.Context) error {
 		}
 	}
 
+	judgeSequenceWindow := int64(0)
+	if v := c.FormValue("judge_sequence_window"); v != "" {
+		if parsed, err := strconv.ParseInt(v, 10, 64); err == nil && parsed > 0 {
+			judgeSequenceWindow = parsed
+		}
+	}
+	fixes, err := parseJudgeFixSettings(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
 	_, err = h.DB.UpdateProject(ctx, database.UpdateProjectParams{
 		ID:                     lpID,
 		Name:                   name,
 		ArrivalThresholdMeters: sql.NullInt64{Int64: arrivalThreshold, Valid: true},
 		JudgeStayTimeMinutes:   sql.NullInt64{Int64: judgeStayTime, Valid: true},
 		JudgeSpeedLimitKmh:     sql.NullFloat64{Float64: judgeSpeedLimit, Valid: true},
+		JudgeSequenceWindow:    judgeSequenceWindow,
+		JudgeMaxAccuracyM:      fixes.MaxAccuracyM,
+		JudgeAccuracyMode:      fixes.AccuracyMode,
+		JudgeMinFixes:          fixes.MinFixes,
+		JudgeExitMarginM:       fixes.ExitMarginM,
+		JudgeUseBearing:        fixes.UseBearing,
 	})
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, err)
@@ -225,6 +268,41 @@ func (h *ProjectHandler) UpdateProject(c echo.Context) error {
 	return c.Redirect(http.StatusSeeOther, fmt.Sprintf("/projects/%d", lpID))
 }
 
+// parseJudgeFixSettings は案件フォームの測位のぶれへの対策の設定を読み取る（空欄は既定値、不正な値・範囲外はエラー）
+func parseJudgeFixSettings(c echo.Context) (judge.Settings, error) {
+	s := judge.Settings{AccuracyMode: judge.AccuracyDiscard, MinFixes: 1}
+	if v := c.FormValue("judge_max_accuracy"); v != "" {
+		parsed, err := strconv.ParseFloat(v, 64)
+		if err != nil || parsed < 0 || parsed > 1000 {
+			return s, fmt.Errorf("測位精度の上限は0〜1000（m）で入力してください")
+		}
+		s.MaxAccuracyM = parsed
+	}
+	switch mode := c.FormValue("judge_accuracy_mode"); mode {
+	case "", judge.AccuracyDiscard:
+	case judge.AccuracyWeight:
+		s.AccuracyMode = judge.AccuracyWeight
+	default:
+		return s, fmt.Errorf("精度の悪い測位の扱いが不正です: %s", mode)
+	}
+	if v := c.FormValue("judge_min_fixes"); v != "" {
+		parsed, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || parsed < 1 || parsed > 30 {
+			return s, fmt.Errorf("出入りを確定する連続の測位数は1〜30で入力してください")
+		}
+		s.MinFixes = parsed
+	}
+	if v := c.FormValue("judge_exit_margin"); v != "" {
+		parsed, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || parsed < 0 || parsed > 500 {
+			return s, fmt.Errorf("出発とみなすまでの余裕は0〜500（m）で入力してください")
+		}
+		s.ExitMarginM = parsed
+	}
+	s.UseBearing = c.FormValue("judge_use_bearing") == "1"
+	return s, nil
+}
+
 // DeleteProject は物流案件を削除
 func (h *ProjectHandler) DeleteProject(c echo.Context) error {
 	if err := h.checkPermission(c); err != nil {
@@ -301,7 +379,20 @@ func (h *ProjectHandler) AssignDeviceCourse(c echo.Context) error {
 	courseName := c.FormValue("course_name")
 	courseNameNull := sql.NullString{String: courseName, Valid: courseName != ""}
 
+	var courseID sql.NullInt64
+	if courseName != "" {
+		course, err := h.DB.GetCourseByName(ctx, database.GetCourseByNameParams{
+			ProjectID: lpID,
+			Name:      courseName,
+		})
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "コースが見つかりません")
+		}
+		courseID = sql.NullInt64{Int64: course.ID, Valid: true}
+	}
+
 	_, err = h.DB.UpdateDeviceCourseName(ctx, database.UpdateDeviceCourseNameParams{
+		CourseID:   courseID,
 		CourseName: courseNameNull,
 		ProjectID:  lpID,
 		DeviceID:   deviceID,
@@ -341,11 +432,8 @@ func (h *ProjectHandler) DeleteDevice(c echo.Context) error {
 	return c.Redirect(http.StatusSeeOther, fmt.Sprintf("/projects/%d", lpID))
 }
 
-// UploadRoutesPage はCSVアップロードページを表示
-func (h *ProjectHandler) UploadRoutesPage(c echo.Context) error {
-	if err := h.checkPermission(c); err != nil {
-		return err
-	}
+// ListCourses はコース一覧ページを表示
+func (h *ProjectHandler) ListCourses(c echo.Context) error {
 	ctx := c.Request().Context()
 	lpID, err := strconv.ParseInt(c.Param("id"), 10, 64)
 	if err != nil {
@@ -357,161 +445,53 @@ func (h *ProjectHandler) UploadRoutesPage(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusNotFound, "物流案件が見つかりません")
 	}
 
-	content := components.RouteUploadForm(lpID, lp.Name)
-	c.Response().Header().Set(echo.HeaderContentType, echo.MIMETextHTML)
-	if c.Request().Header.Get("HX-Request") == "true" {
-		return content.Render(ctx, c.Response().Writer)
-	}
-	return layouts.Base("CSVアップロード", content).Render(ctx, c.Response().Writer)
-}
-
-// UploadRoutes はCSVファイルをアップロードして既存データを上書き
-func (h *ProjectHandler) UploadRoutes(c echo.Context) error {
-	if err := h.checkPermission(c); err != nil {
-		return err
-	}
-	ctx := c.Request().Context()
-	lpID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	operatingDate, operatingDates, err := h.selectOperatingDate(c, lpID)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, "無効な案件ID")
+		return err
 	}
 
-	// 物流案件の存在確認
-	_, err = h.DB.GetProject(ctx, lpID)
+	// コース一覧取得（コース名の昇順でソート済み）
+	courses, err := h.DB.ListCoursesByProject(ctx, lpID)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusNotFound, "物流案件が見つかりません")
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
 	}
 
-	// ファイルアップロード
-	fileHeader, err := c.FormFile("csv_file")
+	// 選択中の運行日の各コースの停車地点数を取得
+	counts, err := h.DB.CountRouteStopsByProjectDate(ctx, database.CountRouteStopsByProjectDateParams{
+		ProjectID:     lpID,
+		OperatingDate: operatingDate,
+	})
 	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, "CSVファイルが指定されていません")
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
 	}
-
-	file, err := fileHeader.Open()
-	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "ファイルを開けませんでした")
+	stopCounts := make(map[string]int, len(counts))
+	for _, row := range counts {
+		stopCounts[row.CourseName] = int(row.StopCount)
 	}
-	defer file.Close()
 
-	// CSVパース
-	stops, err := parseCP932CSV(file, c.FormValue("has_header") == "true")
+	assignments, err := h.courseAssignmentsByDate(ctx, lpID, operatingDate)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("CSVパースエラー: %v", err))
-	}
-
-	if len(stops) == 0 {
-		return echo.NewHTTPError(http.StatusBadRequest, "CSVファイルにデータがありません")
-	}
-
-	// オプション処理: 「出発」行をスキップ
-	if c.FormValue("skip_departure") == "true" {
-		stops = filterDepartureRows(stops)
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
 	}
 
-	// オプション処理: 出発時間を調整
-	if c.FormValue("adjust_time") == "true" {
-		startTime := c.FormValue("start_time")
-		if startTime != "" {
-			stops = adjustArrivalTimes(stops, startTime)
+	courseInfos := make([]components.CourseInfo, 0, len(courses))
+	for _, course := range courses {
+		info := components.CourseInfo{
+			Course:    course,
+			StopCount: stopCounts[course.Name],
 		}
-	}
-
-	if len(stops) == 0 {
-		return echo.NewHTTPError(http.StatusBadRequest, "フィルタ後のデータがありません")
-	}
-
-	// 既存データを削除
-	err = h.DB.DeleteRouteStopsByProject(ctx, lpID)
-	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("既存データ削除失敗: %v", err))
-	}
-
-	// 物流案件のCSV情報を更新
-	_, err = h.DB.UpdateProjectCSV(ctx, database.UpdateProjectCSVParams{
-		ID: lpID,
-		CsvFilename: sql.NullString{
-			String: fileHeader.Filename,
-			Valid:  true,
-		},
-		CsvImportedAt: sql.NullTime{
-			Time:  time.Now(),
-			Valid: true,
-		},
-		CsvRowCount: sql.NullInt64{
-			Int64: int64(len(stops)),
-			Valid: true,
-		},
-	})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("物流案件CSV情報更新失敗: %v", err))
-	}
-
-	// 停車地データ一括挿入
-	for _, stop := range stops {
-		err = h.DB.CreateRouteStop(ctx, database.CreateRouteStopParams{
-			ProjectID:        lpID,
-			CourseName:       stop.CourseName,
-			Sequence:         stop.Sequence,
-			ArrivalTime:      toNullString(stop.ArrivalTime),
-			StopName:         stop.StopName,
-			Address:          toNullString(stop.Address),
-			Latitude:         toNullFloat64(stop.Latitude),
-			Longitude:        toNullFloat64(stop.Longitude),
-			StayMinutes:      toNullInt64(stop.StayMinutes),
-			WeightKg:         toNullInt64(stop.WeightKg),
-			PhoneNumber:      toNullString(stop.PhoneNumber),
-			Note1:            toNullString(stop.Note1),
-			Note2:            toNullString(stop.Note2),
-			Note3:            toNullString(stop.Note3),
-			DesiredTimeStart: toNullString(stop.DesiredTimeStart),
-			DesiredTimeEnd:   toNullString(stop.DesiredTimeEnd),
-		})
-		if err != nil {
-			return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("停車地データ挿入失敗: %v", err))
+		if a, ok := assignments[course.ID]; ok {
+			info.Assignment = &a
 		}
+		courseInfos = append(courseInfos, info)
 	}
 
-	// PRG: 物流案件詳細ページへリダイレクト
-	return c.Redirect(http.StatusSeeOther, fmt.Sprintf("/projects/%d", lpID))
-}
-
-// ListCourses はコース一覧ページを表示
-func (h *ProjectHandler) ListCourses(c echo.Context) error {
-	ctx := c.Request().Context()
-	lpID, err := strconv.ParseInt(c.Param("id"), 10, 64)
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, "無効な案件ID")
-	}
-
-	lp, err := h.DB.GetProject(ctx, lpID)
-	if err != nil {
-		return echo.NewHTTPError(http.StatusNotFound, "物流案件が見つかりません")
-	}
-
-	// コース一覧取得（車両名の昇順でソート済み）
-	courses, err := h.DB.ListCoursesByProject(ctx, lpID)
+	unacknowledgedAlerts, err := h.DB.CountUnacknowledgedAlerts(ctx, lpID)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, err)
 	}
 
-	// 各コースの停車地点数を取得
-	courseInfos := make([]components.CourseInfo, 0, len(courses))
-	for _, courseName := range courses {
-		stops, err := h.DB.ListRouteStopsByCourse(ctx, database.ListRouteStopsByCourseParams{
-			ProjectID:  lpID,
-			CourseName: courseName,
-		})
-		if err != nil {
-			continue
-		}
-		courseInfos = append(courseInfos, components.CourseInfo{
-			CourseName: courseName,
-			StopCount:  len(stops),
-		})
-	}
-
-	content := components.CourseList(lp, courseInfos)
+	content := components.CourseList(lp, operatingDate, operatingDates, courseInfos, unacknowledgedAlerts)
 	c.Response().Header().Set(echo.HeaderContentType, echo.MIMETextHTML)
 	if c.Request().Header.Get("HX-Request") == "true" {
 		return content.Render(ctx, c.Response().Writer)
@@ -522,67 +502,72 @@ func (h *ProjectHandler) ListCourses(c echo.Context) error {
 // ShowCourse はコース詳細ページを表示
 func (h *ProjectHandler) ShowCourse(c echo.Context) error {
 	ctx := c.Request().Context()
-	lpID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	lp, course, err := h.getCourse(c)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, "無効な案件ID")
+		return err
 	}
-	courseName := c.Param("course_name")
+	lpID := lp.ID
+	courseName := course.Name
 
-	lp, err := h.DB.GetProject(ctx, lpID)
+	operatingDate, operatingDates, err := h.selectOperatingDate(c, lpID)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusNotFound, "物流案件が見つかりません")
+		return err
 	}
 
 	// コースの停車地取得（順番でソート済み）
 	stops, err := h.DB.ListRouteStopsByCourse(ctx, database.ListRouteStopsByCourseParams{
-		ProjectID:  lpID,
-		CourseName: courseName,
+		ProjectID:     lpID,
+		OperatingDate: operatingDate,
+		CourseName:    courseName,
 	})
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, err)
 	}
 
-	if len(stops) == 0 {
-		return echo.NewHTTPError(http.StatusNotFound, "コースが見つかりません")
+	// 表示する運行（未指定なら最新の運行）
+	run, runs, err := h.selectCourseRun(c, lpID, operatingDate, courseName)
+	if err != nil {
+		return err
 	}
 
-	// 到着判定の閾値を取得（デフォルト100m）
-	arrivalThresholdM := int64(100)
-	if lp.ArrivalThresholdMeters.Valid {
-		arrivalThresholdM = lp.ArrivalThresholdMeters.Int64
-	}
+	// 保存済みの到着判定（前回以降に届いたログの分だけ判定を進める）
+	currentLocation, timings, predictions := h.courseVisits(ctx, visits.Scope{Project: lp, OperatingDate: operatingDate, CourseName: courseName, Run: run}, stops)
 
-	// 判定設定を取得
-	stayMinutes := int64(0)
-	if lp.JudgeStayTimeMinutes.Valid {
-		stayMinutes = lp.JudgeStayTimeMinutes.Int64
+	// 運行日の車両・ドライバーの割り当てと、割り当て候補のマスタ
+	var assignment *database.ListCourseAssignmentsByProjectDateRow
+	assignments, err := h.courseAssignmentsByDate(ctx, lpID, operatingDate)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	if a, ok := assignments[course.ID]; ok {
+		assignment = &a
+	}
+	vehicles, err := h.DB.ListVehicles(ctx)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
 	}
-	speedLimitKmh := 0.0
-	if lp.JudgeSpeedLimitKmh.Valid {
-		speedLimitKmh = lp.JudgeSpeedLimitKmh.Float64
+	drivers, err := h.DB.ListDrivers(ctx)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
 	}
 
-	// 現在位置情報を取得（降順: 最新が先頭）
-	var currentLocation *components.CurrentLocationInfo
-	var timings map[int64]*components.StopTiming
-	logsDesc, err := h.DB.ListLocationLogsByCourseDesc(ctx, database.ListLocationLogsByCourseDescParams{
-		ProjectID:  lpID,
-		CourseName: courseName,
-	})
-	if err == nil && len(logsDesc) > 0 {
-		// 昇順でログを取得して動的計算
-		logsAsc, err := h.DB.ListLocationLogsByCourse(ctx, database.ListLocationLogsByCourseParams{
-			ProjectID:  lpID,
-			CourseName: courseName,
-		})
-		if err == nil {
-			timings = h.calculateStopTimings(logsAsc, stops, arrivalThresholdM, stayMinutes, speedLimitKmh)
-		}
+	loadCurve := buildLoadCurve(stops, timings, courseCapacityKg(course, assignment))
 
-		currentLocation = h.calculateCurrentSection(logsDesc, stops, arrivalThresholdM, timings)
+	// 画面からの停車地の修正履歴
+	edits, err := h.DB.ListRouteStopEditsByCourse(ctx, database.ListRouteStopEditsByCourseParams{
+		ProjectID:     lpID,
+		OperatingDate: operatingDate,
+		CourseName:    courseName,
+	})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
 	}
 
-	content := components.CourseDetail(lp, courseName, stops, currentLocation, timings)
+	content := components.CourseDetail(lp, course, operatingDate, operatingDates, stops, currentLocation, timings, predictions, evaluateTimeWindows(stops, timings), run, runs, components.CourseAssignmentInfo{
+		Assignment: assignment,
+		Vehicles:   vehicles,
+		Drivers:    drivers,
+	}, loadCurve, edits)
 	c.Response().Header().Set(echo.HeaderContentType, echo.MIMETextHTML)
 	if c.Request().Header.Get("HX-Request") == "true" {
 		return content.Render(ctx, c.Response().Writer)
@@ -605,56 +590,33 @@ func (h *ProjectHandler) GetCurrentLocation(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusNotFound, "物流案件が見つかりません")
 	}
 
-	// 到着判定の閾値を取得（デフォルト100m）
-	arrivalThresholdM := int64(100)
-	if lp.ArrivalThresholdMeters.Valid {
-		arrivalThresholdM = lp.ArrivalThresholdMeters.Int64
-	}
-
-	// 判定設定を取得
-	stayMinutes := int64(0)
-	if lp.JudgeStayTimeMinutes.Valid {
-		stayMinutes = lp.JudgeStayTimeMinutes.Int64
-	}
-	speedLimitKmh := 0.0
-	if lp.JudgeSpeedLimitKmh.Valid {
-		speedLimitKmh = lp.JudgeSpeedLimitKmh.Float64
+	operatingDate, _, err := h.selectOperatingDate(c, lpID)
+	if err != nil {
+		return err
 	}
 
 	// コースの停車地取得
 	stops, err := h.DB.ListRouteStopsByCourse(ctx, database.ListRouteStopsByCourseParams{
-		ProjectID:  lpID,
-		CourseName: courseName,
+		ProjectID:     lpID,
+		OperatingDate: operatingDate,
+		CourseName:    courseName,
 	})
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, err)
 	}
 
-	// 過去ログ取得（降順: 最新が先頭）
-	logsDesc, err := h.DB.ListLocationLogsByCourseDesc(ctx, database.ListLocationLogsByCourseDescParams{
-		ProjectID:  lpID,
-		CourseName: courseName,
-	})
-
-	var currentLocation *components.CurrentLocationInfo
-	var timings map[int64]*components.StopTiming
-
-	if err == nil && len(logsDesc) > 0 {
-		// 昇順でログを取得して動的計算
-		logsAsc, err := h.DB.ListLocationLogsByCourse(ctx, database.ListLocationLogsByCourseParams{
-			ProjectID:  lpID,
-			CourseName: courseName,
-		})
-		if err == nil {
-			timings = h.calculateStopTimings(logsAsc, stops, arrivalThresholdM, stayMinutes, speedLimitKmh)
-		}
-
-		currentLocation = h.calculateCurrentSection(logsDesc, stops, arrivalThresholdM, timings)
+	// 表示中の運行（未指定なら最新の運行）
+	run, _, err := h.selectCourseRun(c, lpID, operatingDate, courseName)
+	if err != nil {
+		return err
 	}
 
+	// 保存済みの到着判定（前回以降に届いたログの分だけ判定を進める）
+	currentLocation, timings, predictions := h.courseVisits(ctx, visits.Scope{Project: lp, OperatingDate: operatingDate, CourseName: courseName, Run: run}, stops)
+
 	// 部分レンダリング（現在位置セクション＋テーブル）
 	c.Response().Header().Set(echo.HeaderContentType, echo.MIMETextHTML)
-	return components.CourseLocationStatus(lpID, courseName, stops, currentLocation, timings).Render(ctx, c.Response().Writer)
+	return components.CourseLocationStatus(lpID, courseName, stops, currentLocation, timings, predictions, evaluateTimeWindows(stops, timings)).Render(ctx, c.Response().Writer)
 }
 
 // ShowStop は地点詳細ページを表示
@@ -676,22 +638,6 @@ func (h *ProjectHandler) ShowStop(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusNotFound, "物流案件が見つかりません")
 	}
 
-	// 到着判定の閾値を取得（デフォルト100m）
-	arrivalThresholdM := int64(100)
-	if lp.ArrivalThresholdMeters.Valid {
-		arrivalThresholdM = lp.ArrivalThresholdMeters.Int64
-	}
-
-	// 判定設定を取得
-	stayMinutes := int64(0)
-	if lp.JudgeStayTimeMinutes.Valid {
-		stayMinutes = lp.JudgeStayTimeMinutes.Int64
-	}
-	speedLimitKmh := 0.0
-	if lp.JudgeSpeedLimitKmh.Valid {
-		speedLimitKmh = lp.JudgeSpeedLimitKmh.Float64
-	}
-
 	stop, err := h.DB.GetRouteStopByID(ctx, stopID)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusNotFound, "地点が見つかりません")
@@ -702,26 +648,38 @@ func (h *ProjectHandler) ShowStop(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusNotFound, "地点が見つかりません")
 	}
 
-	// コースの全停車地を取得
+	// コースの全停車地を取得（地点と同じ運行日のもの）
 	stops, _ := h.DB.ListRouteStopsByCourse(ctx, database.ListRouteStopsByCourseParams{
-		ProjectID:  lpID,
-		CourseName: courseName,
+		ProjectID:     lpID,
+		OperatingDate: stop.OperatingDate,
+		CourseName:    courseName,
 	})
 
-	// 動的計算でtimingsを取得
-	var timings map[int64]*components.StopTiming
-	logsAsc, err := h.DB.ListLocationLogsByCourse(ctx, database.ListLocationLogsByCourseParams{
-		ProjectID:  lpID,
-		CourseName: courseName,
-	})
-	if err == nil && len(logsAsc) > 0 {
-		timings = h.calculateStopTimings(logsAsc, stops, arrivalThresholdM, stayMinutes, speedLimitKmh)
+	// 最新の運行（?run= 指定があればその運行）の判定
+	run, _, err := h.selectCourseRun(c, lpID, stop.OperatingDate, courseName)
+	if err != nil {
+		return err
+	}
+	sc := visits.Scope{Project: lp, OperatingDate: stop.OperatingDate, CourseName: courseName, Run: run}
+	result := h.stopVisits(ctx, sc, stops)
+	timings := result.Timings
+
+	// トラック状況とこの地点の到着予測を計算
+	truckStatus := result.TruckStatus(stop)
+	prediction := stopPrediction(h.predictArrivals(ctx, sc, stops, timings, result.Current), stop)
+
+	// 位置マスタの進入口・担当者などは取り込み後に更新されることがあるため、表示のたびに取得する
+	var location *database.Location
+	if stop.LocationID.Valid {
+		if loc, err := h.DB.GetLocation(ctx, stop.LocationID.Int64); err == nil {
+			location = &loc
+		}
 	}
 
-	// トラック状況を計算
-	truckStatus := h.calculateTruckStatus(ctx, lpID, courseName, stop, arrivalThresholdM, timings)
+	// 到着・出発の手動での指定と変更履歴（表示中の運行の分）
+	override := h.loadStopOverride(ctx, stop, run)
 
-	content := components.StopDetail(lpID, courseName, stopID, stop, truckStatus, timings)
+	content := components.StopDetail(lpID, courseName, stopID, stop, location, truckStatus, prediction, timings, override)
 	c.Response().Header().Set(echo.HeaderContentType, echo.MIMETextHTML)
 	if c.Request().Header.Get("HX-Request") == "true" {
 		return content.Render(ctx, c.Response().Writer)
@@ -748,22 +706,6 @@ func (h *ProjectHandler) GetStopTruckStatus(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusNotFound, "物流案件が見つかりません")
 	}
 
-	// 到着判定の閾値を取得（デフォルト100m）
-	arrivalThresholdM := int64(100)
-	if lp.ArrivalThresholdMeters.Valid {
-		arrivalThresholdM = lp.ArrivalThresholdMeters.Int64
-	}
-
-	// 判定設定を取得
-	stayMinutes := int64(0)
-	if lp.JudgeStayTimeMinutes.Valid {
-		stayMinutes = lp.JudgeStayTimeMinutes.Int64
-	}
-	speedLimitKmh := 0.0
-	if lp.JudgeSpeedLimitKmh.Valid {
-		speedLimitKmh = lp.JudgeSpeedLimitKmh.Float64
-	}
-
 	stop, err := h.DB.GetRouteStopByID(ctx, stopID)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusNotFound, "地点が見つかりません")
@@ -774,466 +716,57 @@ func (h *ProjectHandler) GetStopTruckStatus(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusNotFound, "地点が見つかりません")
 	}
 
-	// コースの全停車地を取得
+	// コースの全停車地を取得（地点と同じ運行日のもの）
 	stops, _ := h.DB.ListRouteStopsByCourse(ctx, database.ListRouteStopsByCourseParams{
-		ProjectID:  lpID,
-		CourseName: courseName,
+		ProjectID:     lpID,
+		OperatingDate: stop.OperatingDate,
+		CourseName:    courseName,
 	})
 
-	// 動的計算でtimingsを取得
-	var timings map[int64]*components.StopTiming
-	logsAsc, err := h.DB.ListLocationLogsByCourse(ctx, database.ListLocationLogsByCourseParams{
-		ProjectID:  lpID,
-		CourseName: courseName,
-	})
-	if err == nil && len(logsAsc) > 0 {
-		timings = h.calculateStopTimings(logsAsc, stops, arrivalThresholdM, stayMinutes, speedLimitKmh)
+	// 最新の運行（?run= 指定があればその運行）の判定
+	run, _, err := h.selectCourseRun(c, lpID, stop.OperatingDate, courseName)
+	if err != nil {
+		return err
 	}
-
-	// トラック状況を計算
-	truckStatus := h.calculateTruckStatus(ctx, lpID, courseName, stop, arrivalThresholdM, timings)
+	sc := visits.Scope{Project: lp, OperatingDate: stop.OperatingDate, CourseName: courseName, Run: run}
+	result := h.stopVisits(ctx, sc, stops)
+	truckStatus := result.TruckStatus(stop)
+	prediction := stopPrediction(h.predictArrivals(ctx, sc, stops, result.Timings, result.Current), stop)
 
 	// 部分レンダリング（トラック状況セクションのみ）
 	c.Response().Header().Set(echo.HeaderContentType, echo.MIMETextHTML)
-	return components.StopTruckStatus(truckStatus).Render(ctx, c.Response().Writer)
+	return components.StopTruckStatus(stop, truckStatus, prediction).Render(ctx, c.Response().Writer)
 }
 
-// calculateTruckStatus はトラックの状況を計算する
-func (h *ProjectHandler) calculateTruckStatus(ctx context.Context, lpID int64, courseName string, targetStop database.RouteStop, arrivalThresholdM int64, timings map[int64]*components.StopTiming) *components.TruckStatusInfo {
-	truckStatus := &components.TruckStatusInfo{
-		HasLocation: false,
-	}
-
-	// 位置情報を取得
-	latestLoc, err := h.DB.GetLatestLocationByCourse(ctx, database.GetLatestLocationByCourseParams{
-		ProjectID:  lpID,
-		CourseName: courseName,
-	})
-	if err != nil {
-		return truckStatus
-	}
-	truckStatus.HasLocation = true
-
-	// この地点までの距離を計算
-	if targetStop.Latitude.Valid && targetStop.Longitude.Valid {
-		truckStatus.DistanceKm = geo.Haversine(
-			latestLoc.Latitude, latestLoc.Longitude,
-			targetStop.Latitude.Float64, targetStop.Longitude.Float64,
-		)
-		// 範囲内判定
-		if truckStatus.DistanceKm*1000 < float64(arrivalThresholdM) {
-			truckStatus.IsWithinRange = true
-		}
+// courseVisits はコースの保存済みの到着判定から現在位置・停車地ごとの到着・出発・残りの停車地の到着予測を返す
+// 判定を読めない場合はすべて nil、位置ログがまだない場合は現在位置と到着予測が nil（位置情報なしとして表示する）
+func (h *ProjectHandler) courseVisits(ctx context.Context, sc visits.Scope, stops []database.RouteStop) (*components.CurrentLocationInfo, map[int64]*components.StopTiming, map[int64]components.ArrivalPrediction) {
+	if len(stops) == 0 {
+		return nil, nil, nil
 	}
-
-	// コースの全地点を取得
-	stops, err := h.DB.ListRouteStopsByCourse(ctx, database.ListRouteStopsByCourseParams{
-		ProjectID:  lpID,
-		CourseName: courseName,
-	})
+	result, err := h.Visits.Refresh(ctx, sc, stops)
 	if err != nil {
-		return truckStatus
-	}
-
-	// 対象地点のインデックスを探す
-	targetIdx := -1
-	for i, s := range stops {
-		if s.ID == targetStop.ID {
-			targetIdx = i
-			break
-		}
-	}
-	if targetIdx == -1 {
-		return truckStatus
-	}
-
-	// 最後に到着した地点を探す（動的計算結果を使用）
-	lastArrivedIdx := -1
-	for i, s := range stops {
-		timing := timings[s.ID]
-		if timing != nil && timing.Arrived {
-			lastArrivedIdx = i
-		}
-	}
-
-	// 何地点前にいるかを計算
-	if lastArrivedIdx == -1 {
-		// まだどこにも到着していない → 全地点分前にいる
-		truckStatus.StopsAway = targetIdx + 1
-		truckStatus.CurrentStopName = "出発前"
-	} else {
-		// lastArrivedIdx が targetIdx より前なら、その差が「何地点前」
-		// lastArrivedIdx が targetIdx と同じなら、この地点に到着済み
-		// lastArrivedIdx が targetIdx より後なら、この地点は通過済み
-		truckStatus.StopsAway = targetIdx - lastArrivedIdx
-		truckStatus.CurrentStopName = stops[lastArrivedIdx].StopName
-		truckStatus.LastArrivedStop = stops[lastArrivedIdx].StopName
-		truckStatus.ScheduledTime = stops[lastArrivedIdx].ArrivalTime.String
-
-		// 実績時刻を設定（動的計算結果を使用）
-		lastTiming := timings[stops[lastArrivedIdx].ID]
-		if lastTiming != nil {
-			truckStatus.LastArrivedTime = lastTiming.ArrivalTimeStr()
-			truckStatus.LastDepartedTime = lastTiming.DepartureTimeStr()
-
-			// 遅延計算: 予定時刻と実績到着時刻の差
-			if stops[lastArrivedIdx].ArrivalTime.Valid && lastTiming.ArrivalTime != nil {
-				scheduledMinutes := parseTimeToMinutesOrZero(stops[lastArrivedIdx].ArrivalTime.String)
-				actualMinutes := parseTimeToMinutesOrZero(lastTiming.ArrivalTimeStr())
-				truckStatus.DelayMinutes = actualMinutes - scheduledMinutes
-			}
-		}
+		log.Printf("到着判定の更新に失敗: %v", err)
+		return nil, nil, nil
 	}
-
-	return truckStatus
-}
-
-// parseTimeToMinutesOrZero は "HH:MM" 形式の時間を分数に変換（エラー時は0）
-func parseTimeToMinutesOrZero(timeStr string) int {
-	minutes, err := parseTimeToMinutes(timeStr)
-	if err != nil {
-		return 0
+	if result.Current == nil {
+		// 位置ログがなくても手動で指定した到着・出発は表示する
+		return nil, result.Timings, nil
 	}
-	return minutes
+	result.Current.CurrentLoadKg = currentLoadKg(stops, result.Timings)
+	return result.Current, result.Timings, h.predictArrivals(ctx, sc, stops, result.Timings, result.Current)
 }
 
-// ResetCourseStatus はコースの位置情報ログを削除する
-func (h *ProjectHandler) ResetCourseStatus(c echo.Context) error {
-	if err := h.checkPermission(c); err != nil {
-		return err
-	}
-
-	ctx := c.Request().Context()
-	lpID, err := strconv.ParseInt(c.Param("id"), 10, 64)
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, "無効な案件ID")
-	}
-	courseName := c.Param("course_name")
-
-	// ログを削除
-	err = h.DB.DeleteLocationLogsByCourse(ctx, database.DeleteLocationLogsByCourseParams{
-		ProjectID:  lpID,
-		CourseName: courseName,
-	})
+// stopVisits は地点詳細用の保存済みの到着判定を返す（読めなければ位置情報なしの結果）
+func (h *ProjectHandler) stopVisits(ctx context.Context, sc visits.Scope, stops []database.RouteStop) *judge.Result {
+	result, err := h.Visits.Refresh(ctx, sc, stops)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("ログ削除失敗: %v", err))
-	}
-
-	// PRG: コース詳細ページへリダイレクト
-	return c.Redirect(http.StatusSeeOther, fmt.Sprintf("/projects/%d/courses/%s", lpID, courseName))
-}
-
-// calculateSpeedBetweenLogs は2つのログ間の移動速度を計算する（km/h）
-func (h *ProjectHandler) calculateSpeedBetweenLogs(older, newer database.LocationLog) float64 {
-	// 時間差（秒）
-	timeDiff := newer.Timestamp.Sub(older.Timestamp).Seconds()
-	if timeDiff <= 0 {
-		return 0
-	}
-
-	// 距離（km）
-	distKm := geo.Haversine(older.Latitude, older.Longitude, newer.Latitude, newer.Longitude)
-
-	// 速度（km/h）
-	return (distKm / timeDiff) * 3600
-}
-
-// calculateCurrentSection は現在位置から走行中の区間を計算する
-func (h *ProjectHandler) calculateCurrentSection(logs []database.LocationLog, stops []database.RouteStop, thresholdM int64, timings map[int64]*components.StopTiming) *components.CurrentLocationInfo {
-	if len(logs) == 0 {
-		return nil
-	}
-	loc := logs[0]
-
-	info := &components.CurrentLocationInfo{
-		Latitude:   loc.Latitude,
-		Longitude:  loc.Longitude,
-		Timestamp:  loc.Timestamp,
-		ThresholdM: thresholdM,
-	}
-
-	// 積載重量を計算（動的計算結果を使用）
-	var currentLoad int64
-	for _, stop := range stops {
-		timing := timings[stop.ID]
-		if timing != nil && timing.Arrived && stop.WeightKg.Valid {
-			currentLoad += stop.WeightKg.Int64
-		}
-	}
-	info.CurrentLoadKg = currentLoad
-
-	// 速度を設定（m/s）
-	if loc.Speed.Valid {
-		info.SpeedMps = loc.Speed.Float64
-	}
-
-	// 到着済みの最後の地点を探す（出発地点）- 動的計算結果を使用
-	lastArrivedIdx := -1
-	for i, stop := range stops {
-		timing := timings[stop.ID]
-		if timing != nil && timing.Arrived {
-			lastArrivedIdx = i
-		}
-	}
-
-	// 出発地点を設定
-	if lastArrivedIdx >= 0 {
-		info.FromStop = stops[lastArrivedIdx].StopName
-		info.FromStopIdx = lastArrivedIdx
-	}
-
-	// 次の未到着地点を探す（目的地点）- 動的計算結果を使用
-	nextStopIdx := -1
-	for i, stop := range stops {
-		timing := timings[stop.ID]
-		if timing == nil || !timing.Arrived {
-			nextStopIdx = i
-			break
-		}
-	}
-
-	// 目的地点を設定
-	if nextStopIdx >= 0 {
-		nextStop := stops[nextStopIdx]
-		info.ToStop = nextStop.StopName
-		info.ToStopIdx = nextStopIdx
-
-		// 目的地までの距離を計算
-		if nextStop.Latitude.Valid && nextStop.Longitude.Valid {
-			info.ToDistanceKm = geo.Haversine(
-				loc.Latitude, loc.Longitude,
-				nextStop.Latitude.Float64, nextStop.Longitude.Float64,
-			)
-
-			// 範囲内判定
-			if info.ToDistanceKm*1000 < float64(thresholdM) {
-				info.IsWithinRange = true
-
-				// 滞在時間の計算
-				startTime := loc.Timestamp
-				thresholdKm := float64(thresholdM) / 1000.0
-				destLat := nextStop.Latitude.Float64
-				destLon := nextStop.Longitude.Float64
-
-				for _, log := range logs {
-					dist := geo.Haversine(log.Latitude, log.Longitude, destLat, destLon)
-					if dist < thresholdKm {
-						startTime = log.Timestamp // 範囲内なら開始時間を更新（より過去へ）
-					} else {
-						break // 範囲外に出たら終了
-					}
-				}
-				info.CurrentStayDuration = loc.Timestamp.Sub(startTime)
-			}
-
-			// ETA計算（速度が有効な場合）
-			if info.SpeedMps > 0 {
-				distanceM := info.ToDistanceKm * 1000
-				etaSeconds := distanceM / info.SpeedMps
-				info.ToEtaMinutes = etaSeconds / 60
-			}
-		}
-	}
-
-	// 後方互換性のため、最寄り地点情報も設定
-	info.NearestStop = info.ToStop
-	info.NearestStopIdx = info.ToStopIdx
-	info.DistanceKm = info.ToDistanceKm
-	info.NextStop = ""
-	info.NextStopIdx = -1
-	info.NextDistanceKm = 0
-
-	return info
-}
-
-// calculateStopTimings はGPSログから全停車地の到着・出発時刻を動的に計算する
-// logs: 時系列昇順（古い順）にソートされたログ
-func (h *ProjectHandler) calculateStopTimings(logs []database.LocationLog, stops []database.RouteStop, thresholdM int64, stayMinutes int64, speedLimitKmh float64) map[int64]*components.StopTiming {
-	result := make(map[int64]*components.StopTiming)
-
-	if len(logs) == 0 {
-		return result
-	}
-
-	thresholdKm := float64(thresholdM) / 1000.0
-	requiredStay := time.Duration(stayMinutes) * time.Minute
-
-	for _, stop := range stops {
-		if !stop.Latitude.Valid || !stop.Longitude.Valid {
-			continue
-		}
-
-		timing := &components.StopTiming{
-			StopID:  stop.ID,
-			Arrived: false,
-		}
-
-		stopLat := stop.Latitude.Float64
-		stopLon := stop.Longitude.Float64
-
-		var enteredAt *time.Time        // エリアに入った時刻
-		var stayStartAt *time.Time      // 滞在条件を満たし始めた時刻（低速開始）
-		var confirmedArrival *time.Time // 到着確定時刻
-		var departedAt *time.Time       // 出発時刻
-
-		wasInRange := false
-
-		for i, log := range logs {
-			dist := geo.Haversine(log.Latitude, log.Longitude, stopLat, stopLon)
-			isInRange := dist < thresholdKm
-
-			// 速度チェック（設定されている場合）
-			isLowSpeed := true
-			if speedLimitKmh > 0 && i > 0 {
-				speed := h.calculateSpeedBetweenLogs(logs[i-1], log)
-				isLowSpeed = speed <= speedLimitKmh
-			}
-
-			if isInRange {
-				if enteredAt == nil {
-					// 初めてエリアに入った
-					ts := log.Timestamp
-					enteredAt = &ts
-				}
-
-				if stayMinutes > 0 {
-					// 滞在時間判定が有効な場合
-					if isLowSpeed {
-						if stayStartAt == nil {
-							ts := log.Timestamp
-							stayStartAt = &ts
-						}
-						// 滞在時間が要件を満たしたか
-						if confirmedArrival == nil && stayStartAt != nil {
-							stayDuration := log.Timestamp.Sub(*stayStartAt)
-							if stayDuration >= requiredStay {
-								// 到着確定: エリアに入った時刻を到着時刻とする
-								confirmedArrival = enteredAt
-							}
-						}
-					} else {
-						// 速度超過 → 滞在カウントリセット
-						stayStartAt = nil
-					}
-				} else {
-					// 滞在時間判定なし → 即時到着
-					if confirmedArrival == nil {
-						confirmedArrival = enteredAt
-					}
-				}
-
-				// 出発後に戻ってきた場合は出発をキャンセル
-				if departedAt != nil {
-					departedAt = nil
-				}
-
-				wasInRange = true
-			} else {
-				// エリア外
-				if wasInRange && confirmedArrival != nil && departedAt == nil {
-					// 到着済みでエリアを出た → 出発
-					ts := log.Timestamp
-					departedAt = &ts
-				}
-				// エリア外に出たら滞在カウントリセット
-				stayStartAt = nil
-				wasInRange = false
-			}
-		}
-
-		// 結果を設定
-		if confirmedArrival != nil {
-			timing.Arrived = true
-			timing.ArrivalTime = confirmedArrival
-			timing.DepartureTime = departedAt
-		}
-
-		result[stop.ID] = timing
+		log.Printf("到着判定の更新に失敗: %v", err)
+		return judge.Evaluate(stops, nil, judge.SettingsFromProject(sc.Project))
 	}
-
 	return result
 }
 
-// RouteStop はCSVの1行分のデータ
-type RouteStop struct {
-	CourseName       string
-	Sequence         string
-	ArrivalTime      string
-	StopName         string
-	Address          string
-	Latitude         float64
-	Longitude        float64
-	StayMinutes      int64
-	WeightKg         int64
-	PhoneNumber      string
-	Note1            string
-	Note2            string
-	Note3            string
-	DesiredTimeStart string
-	DesiredTimeEnd   string
-}
-
-// parseCP932CSV はCP932エンコードされたCSVファイルをパース
-func parseCP932CSV(file multipart.File, hasHeader bool) ([]RouteStop, error) {
-	// CP932 -> UTF-8変換
-	reader := transform.NewReader(file, japanese.ShiftJIS.NewDecoder())
-	csvReader := csv.NewReader(reader)
-
-	// フィールド数のチェックを無効化（行末のカンマ対応）
-	csvReader.FieldsPerRecord = -1
-	csvReader.TrimLeadingSpace = true
-
-	// ヘッダースキップ
-	if hasHeader {
-		_, err := csvReader.Read()
-		if err != nil {
-			return nil, fmt.Errorf("ヘッダー読み込みエラー: %w", err)
-		}
-	}
-
-	var stops []RouteStop
-	for {
-		record, err := csvReader.Read()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return nil, fmt.Errorf("CSV読み込みエラー: %w", err)
-		}
-
-		// 17カラム必要（CSVの構造に基づく）
-		if len(record) < 17 {
-			continue // スキップ
-		}
-
-		latitude, _ := strconv.ParseFloat(record[5], 64)
-		longitude, _ := strconv.ParseFloat(record[6], 64)
-		stayMinutes, _ := strconv.ParseInt(record[7], 10, 64)
-		weightKg, _ := strconv.ParseInt(record[8], 10, 64)
-
-		stop := RouteStop{
-			CourseName:       record[0],
-			Sequence:         record[1],
-			ArrivalTime:      record[2],
-			StopName:         record[3],
-			Address:          record[4],
-			Latitude:         latitude,
-			Longitude:        longitude,
-			StayMinutes:      stayMinutes,
-			WeightKg:         weightKg,
-			PhoneNumber:      record[11],
-			Note1:            record[12],
-			Note2:            record[13],
-			Note3:            record[14],
-			DesiredTimeStart: record[15],
-			DesiredTimeEnd:   record[16],
-		}
-		stops = append(stops, stop)
-	}
-
-	return stops, nil
-}
-
 // ヘルパー関数: stringをsql.NullStringに変換
 func toNullString(s string) sql.NullString {
 	return sql.NullString{String: s, Valid: s != ""}
@@ -1268,70 +801,3 @@ func parseTimeToMinutes(timeStr string) (int, error) {
 	}
 	return hours*60 + minutes, nil
 }
-
-// minutesToTime は分数を "HH:MM" 形式に変換する
-func minutesToTime(minutes int) string {
-	// 負の値や24時間超えに対応
-	for minutes < 0 {
-		minutes += 24 * 60
-	}
-	minutes = minutes % (24 * 60)
-	return fmt.Sprintf("%02d:%02d", minutes/60, minutes%60)
-}
-
-// filterDepartureRows は「出発」行を除外する
-func filterDepartureRows(stops []RouteStop) []RouteStop {
-	var filtered []RouteStop
-	for _, stop := range stops {
-		if stop.Sequence != "出発" {
-			filtered = append(filtered, stop)
-		}
-	}
-	return filtered
-}
-
-// adjustArrivalTimes は各コースの到着時間を調整する
-func adjustArrivalTimes(stops []RouteStop, newStartTime string) []RouteStop {
-	if len(stops) == 0 {
-		return stops
-	}
-
-	newStartMinutes, err := parseTimeToMinutes(newStartTime)
-	if err != nil {
-		return stops // パースエラーの場合は変更しない
-	}
-
-	// コースごとにグループ化
-	courseStops := make(map[string][]int) // コース名 -> stopsのインデックス
-	for i, stop := range stops {
-		courseStops[stop.CourseName] = append(courseStops[stop.CourseName], i)
-	}
-
-	// 各コースの時間を調整
-	for _, indices := range courseStops {
-		if len(indices) == 0 {
-			continue
-		}
-
-		// 先頭地点の元の到着時間を取得
-		firstIdx := indices[0]
-		originalStartMinutes, err := parseTimeToMinutes(stops[firstIdx].ArrivalTime)
-		if err != nil {
-			continue // パースエラーの場合はこのコースをスキップ
-		}
-
-		// 差分を計算
-		diff := newStartMinutes - originalStartMinutes
-
-		// 全地点の到着時間をシフト
-		for _, idx := range indices {
-			originalMinutes, err := parseTimeToMinutes(stops[idx].ArrivalTime)
-			if err != nil {
-				continue
-			}
-			stops[idx].ArrivalTime = minutesToTime(originalMinutes + diff)
-		}
-	}
-
-	return stops
-}
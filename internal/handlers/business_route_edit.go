@@ -0,0 +1,718 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/appcontext"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
+	"github.com/naozine/project_crud_with_auth_tmpl/web/components"
+	"github.com/naozine/project_crud_with_auth_tmpl/web/layouts"
+)
+
+// 画面からの停車地の修正の種類（route_stop_edits.action）
+const (
+	stopEditCreate   = "create"
+	stopEditUpdate   = "update"
+	stopEditDelete   = "delete"
+	stopEditReorder  = "reorder"
+	stopEditMove     = "move"
+	stopEditOptimize = "optimize"
+)
+
+// manualEditGuard はCSV取り込みで画面からの修正を上書きしないための判定
+// Keep が false の場合も、プレビューで上書きされる修正を警告するために使う
+type manualEditGuard struct {
+	Keep    bool
+	deleted map[string]bool   // 画面から削除した停車地（stableStopKey）
+	edited  map[string]string // 画面から修正した停車地（コースを除いた stableStopKey → コース名）
+}
+
+// loadManualEditGuard は運行日の画面からの修正（修正済みの停車地と削除履歴）を読み込む
+func loadManualEditGuard(ctx context.Context, q *database.Queries, lpID int64, operatingDate string, current []database.RouteStop, keep bool) (manualEditGuard, error) {
+	guard := manualEditGuard{
+		Keep:    keep,
+		deleted: make(map[string]bool),
+		edited:  make(map[string]string),
+	}
+	deletions, err := q.ListActiveRouteStopDeletions(ctx, database.ListActiveRouteStopDeletionsParams{
+		ProjectID:     lpID,
+		OperatingDate: operatingDate,
+	})
+	if err != nil {
+		return guard, fmt.Errorf("修正履歴取得失敗: %w", err)
+	}
+	for _, d := range deletions {
+		guard.deleted[stableStopKey(d.CourseName, d.StopName, d.Address.String)] = true
+	}
+	for _, stop := range current {
+		if stop.ManuallyEditedAt.Valid {
+			guard.edited[stableStopKey("", stop.StopName, stop.Address.String)] = stop.CourseName
+		}
+	}
+	return guard, nil
+}
+
+// keepsExisting は既存の停車地を取り込みで変更・削除しないかどうか
+func (g manualEditGuard) keepsExisting(stop database.RouteStop) bool {
+	return g.Keep && stop.ManuallyEditedAt.Valid
+}
+
+// conflict はCSVの行が画面で削除・移動した停車地にあたる場合にその内容を返す（該当しなければ空）
+func (g manualEditGuard) conflict(stop RouteStop) string {
+	if g.deleted[stableStopKey(stop.CourseName, stop.StopName, stop.Address)] {
+		return "画面で削除した地点"
+	}
+	if course, ok := g.edited[stableStopKey("", stop.StopName, stop.Address)]; ok && course != stop.CourseName {
+		return fmt.Sprintf("画面でコース %s へ移動した地点", course)
+	}
+	return ""
+}
+
+// filterIncoming は修正を保持する場合に、画面で削除・移動した停車地にあたるCSVの行を除く
+func (g manualEditGuard) filterIncoming(stops []RouteStop) []RouteStop {
+	if !g.Keep {
+		return stops
+	}
+	filtered := make([]RouteStop, 0, len(stops))
+	for _, stop := range stops {
+		if g.conflict(stop) == "" {
+			filtered = append(filtered, stop)
+		}
+	}
+	return filtered
+}
+
+// importIssues は取り込みで影響を受ける画面からの修正をプレビュー用の警告にする
+func (g manualEditGuard) importIssues(operatingDate string, current []database.RouteStop, incoming []RouteStop) []components.ImportIssue {
+	var issues []components.ImportIssue
+	warn := func(row int, courseName, sequence, msg string) {
+		issues = append(issues, components.ImportIssue{
+			Row:        row,
+			CourseName: courseName,
+			Sequence:   sequence,
+			Level:      components.ImportIssueWarning,
+			Message:    msg,
+		})
+	}
+
+	for _, stop := range incoming {
+		reason := g.conflict(stop)
+		if reason == "" {
+			continue
+		}
+		if g.Keep {
+			warn(stop.Row, stop.CourseName, stop.Sequence, reason+"のため取り込みません")
+		} else {
+			warn(stop.Row, stop.CourseName, stop.Sequence, reason+"が再び追加されます（画面での修正は上書きされます）")
+		}
+	}
+
+	match := matchRouteStops(current, scheduleRouteStops(operatingDate, g.filterIncoming(incoming)))
+	for _, m := range match.Matched {
+		if !m.Existing.ManuallyEditedAt.Valid || (len(diffRouteStop(m.Existing, m.Incoming)) == 0 && sameSchedule(m.Existing, m.Incoming)) {
+			continue
+		}
+		if g.Keep {
+			warn(m.Incoming.Row, m.Existing.CourseName, m.Existing.Sequence, fmt.Sprintf("%s は画面で修正した地点のため、CSVの変更を反映しません", m.Existing.StopName))
+		} else {
+			warn(m.Incoming.Row, m.Existing.CourseName, m.Existing.Sequence, fmt.Sprintf("%s の画面での修正がCSVの内容で上書きされます", m.Existing.StopName))
+		}
+	}
+	for _, stop := range match.Removed {
+		if !stop.ManuallyEditedAt.Valid {
+			continue
+		}
+		if g.Keep {
+			warn(0, stop.CourseName, stop.Sequence, fmt.Sprintf("%s は画面で修正・追加した地点のため、CSVにありませんが削除しません", stop.StopName))
+		} else {
+			warn(0, stop.CourseName, stop.Sequence, fmt.Sprintf("%s は画面で修正・追加した地点ですが、CSVにないため削除されます", stop.StopName))
+		}
+	}
+	return issues
+}
+
+// parseStopForm は停車地フォームを検証してCSVの1行分と同じ形で読み取る
+// 順番はフォームでは変更しない（並べ替えは ReorderStops で行う）
+func parseStopForm(c echo.Context) (RouteStop, error) {
+	value := func(name string) string {
+		return strings.TrimSpace(c.FormValue(name))
+	}
+	stop := RouteStop{
+		CourseName:       value("course_name"),
+		ArrivalTime:      value("arrival_time"),
+		StopName:         value("stop_name"),
+		Address:          value("address"),
+		PhoneNumber:      value("phone_number"),
+		Note1:            value("note1"),
+		Note2:            value("note2"),
+		Note3:            value("note3"),
+		DesiredTimeStart: value("desired_time_start"),
+		DesiredTimeEnd:   value("desired_time_end"),
+	}
+	if stop.StopName == "" {
+		return stop, fmt.Errorf("名称を入力してください")
+	}
+	for label, v := range map[string]string{
+		"到着予定":      stop.ArrivalTime,
+		"希望時間帯（開始）": stop.DesiredTimeStart,
+		"希望時間帯（終了）": stop.DesiredTimeEnd,
+	} {
+		if v != "" && !isValidClockTime(v) {
+			return stop, fmt.Errorf("%sは HH:MM 形式で入力してください", label)
+		}
+	}
+
+	lat, lng := value("latitude"), value("longitude")
+	if (lat == "") != (lng == "") {
+		return stop, fmt.Errorf("緯度と経度は両方入力してください")
+	}
+	if lat != "" {
+		latF, err := strconv.ParseFloat(lat, 64)
+		if err != nil || latF < -90 || latF > 90 {
+			return stop, fmt.Errorf("緯度が不正です: %s", lat)
+		}
+		lngF, err := strconv.ParseFloat(lng, 64)
+		if err != nil || lngF < -180 || lngF > 180 {
+			return stop, fmt.Errorf("経度が不正です: %s", lng)
+		}
+		stop.Latitude, stop.Longitude = latF, lngF
+	}
+
+	for label, dst := range map[string]*int64{
+		"stay_minutes": &stop.StayMinutes,
+		"weight_kg":    &stop.WeightKg,
+	} {
+		v := value(label)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			return stop, fmt.Errorf("滞在時間・重量は0以上の整数で入力してください")
+		}
+		*dst = n
+	}
+
+	// 到着判定の範囲・エリア（空欄なら案件の設定の範囲で判定する）
+	if v := value("geofence_radius_m"); v != "" {
+		radius, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || radius <= 0 {
+			return stop, fmt.Errorf("到着判定の範囲は1以上の整数（m）で入力してください")
+		}
+		stop.GeofenceRadiusM = radius
+	}
+	polygon, err := parseGeofencePolygon(value("geofence_polygon"))
+	if err != nil {
+		return stop, err
+	}
+	stop.GeofencePolygon = polygon.String
+
+	direction, ok := parseLoadDirection(value("load_direction"))
+	if !ok {
+		return stop, fmt.Errorf("積降区分が不正です")
+	}
+	stop.LoadDirection = direction
+	return stop, nil
+}
+
+// getRouteStop はURLの :id、:course_name、:stop_id から停車地を取得する
+func (h *ProjectHandler) getRouteStop(c echo.Context) (database.Project, database.Course, database.RouteStop, error) {
+	lp, course, err := h.getCourse(c)
+	if err != nil {
+		return lp, course, database.RouteStop{}, err
+	}
+	stopID, err := strconv.ParseInt(c.Param("stop_id"), 10, 64)
+	if err != nil {
+		return lp, course, database.RouteStop{}, echo.NewHTTPError(http.StatusBadRequest, "無効な地点ID")
+	}
+	stop, err := h.DB.GetRouteStopByID(c.Request().Context(), stopID)
+	if err != nil || stop.ProjectID != lp.ID || stop.CourseName != course.Name {
+		return lp, course, database.RouteStop{}, echo.NewHTTPError(http.StatusNotFound, "地点が見つかりません")
+	}
+	return lp, course, stop, nil
+}
+
+// resequenceCourse はコースの停車地を order の順（order にない地点は現在の順で末尾）に並べ直し、
+// 順番を1から振り直して到着予定日時を再計算する。「出発」行は常に先頭にする
+// markIDs に含まれる地点で順番が変わったものは画面で修正した地点として記録する。呼び出し側でトランザクションを張ること
+func resequenceCourse(ctx context.Context, q *database.Queries, lpID int64, operatingDate string, course database.Course, order []int64, markIDs map[int64]bool) ([]database.RouteStop, error) {
+	stops, err := q.ListRouteStopsByCourse(ctx, database.ListRouteStopsByCourseParams{
+		ProjectID:     lpID,
+		OperatingDate: operatingDate,
+		CourseName:    course.Name,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("停車地取得失敗: %w", err)
+	}
+
+	byID := make(map[int64]database.RouteStop, len(stops))
+	for _, stop := range stops {
+		byID[stop.ID] = stop
+	}
+	ordered := make([]database.RouteStop, 0, len(stops))
+	placed := make(map[int64]bool, len(stops))
+	place := func(stop database.RouteStop) {
+		if !placed[stop.ID] {
+			ordered = append(ordered, stop)
+			placed[stop.ID] = true
+		}
+	}
+	for _, stop := range stops {
+		if stop.Sequence == departureSequence {
+			place(stop)
+		}
+	}
+	for _, id := range order {
+		if stop, ok := byID[id]; ok {
+			place(stop)
+		}
+	}
+	for _, stop := range stops {
+		place(stop)
+	}
+
+	clockTimes := make([]string, len(ordered))
+	stayMinutes := make([]int64, len(ordered))
+	for i, stop := range ordered {
+		clockTimes[i] = stop.ArrivalTime.String
+		stayMinutes[i] = stop.StayMinutes.Int64
+	}
+	plannedAt := plannedTimesInOrder(operatingDate, clockTimes, stayMinutes)
+
+	now := sql.NullTime{Time: time.Now(), Valid: true}
+	no := int64(0)
+	for i, stop := range ordered {
+		sequence := departureSequence
+		if stop.Sequence != departureSequence {
+			no++
+			sequence = strconv.FormatInt(no, 10)
+		}
+		sequenceNo := no // 「出発」行は先頭にあるため0になる
+		moved := stop.Sequence != sequence || stop.CourseID.Int64 != course.ID
+		if !moved && stop.SequenceNo == sequenceNo && sameNullTime(stop.PlannedAt, plannedAt[i]) {
+			continue
+		}
+		var edited sql.NullTime
+		if moved && markIDs[stop.ID] {
+			edited = now
+		}
+		if err := q.UpdateRouteStopSchedule(ctx, database.UpdateRouteStopScheduleParams{
+			CourseID:         sql.NullInt64{Int64: course.ID, Valid: true},
+			CourseName:       course.Name,
+			Sequence:         sequence,
+			SequenceNo:       sequenceNo,
+			PlannedAt:        plannedAt[i],
+			ManuallyEditedAt: edited,
+			ID:               stop.ID,
+		}); err != nil {
+			return nil, fmt.Errorf("停車地 %s の並び替え失敗: %w", stop.StopName, err)
+		}
+		ordered[i].Sequence = sequence
+	}
+	return ordered, nil
+}
+
+// sameNullTime は2つの日時（未設定を含む）が同じかどうか
+func sameNullTime(a, b sql.NullTime) bool {
+	if a.Valid != b.Valid {
+		return false
+	}
+	return !a.Valid || a.Time.Equal(b.Time)
+}
+
+// recordStopEdit は画面からの停車地の修正を履歴に記録する
+func recordStopEdit(ctx context.Context, q *database.Queries, stop database.RouteStop, action, detail string) error {
+	userID := appcontext.GetUserID(ctx)
+	err := q.CreateRouteStopEdit(ctx, database.CreateRouteStopEditParams{
+		ProjectID:     stop.ProjectID,
+		OperatingDate: stop.OperatingDate,
+		RouteStopID:   sql.NullInt64{Int64: stop.ID, Valid: stop.ID != 0},
+		CourseName:    stop.CourseName,
+		StopName:      stop.StopName,
+		Address:       stop.Address,
+		Action:        action,
+		Detail:        toNullString(detail),
+		EditedBy:      sql.NullInt64{Int64: userID, Valid: userID != 0},
+	})
+	if err != nil {
+		return fmt.Errorf("修正履歴の記録失敗: %w", err)
+	}
+	return nil
+}
+
+// courseStopsRedirect はコース詳細ページ（停車地の編集セクション）のURL
+func courseStopsRedirect(lpID int64, courseName, operatingDate string) string {
+	return fmt.Sprintf("/projects/%d/courses/%s?date=%s#stop-editor", lpID, url.PathEscape(courseName), url.QueryEscape(operatingDate))
+}
+
+// NewStopPage は停車地の追加ページを表示
+func (h *ProjectHandler) NewStopPage(c echo.Context) error {
+	if err := h.checkPermission(c); err != nil {
+		return err
+	}
+	ctx := c.Request().Context()
+	lp, course, err := h.getCourse(c)
+	if err != nil {
+		return err
+	}
+	operatingDate := c.QueryParam("date")
+	if _, err := parseOperatingDate(operatingDate); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効な運行日")
+	}
+	courses, err := h.DB.ListCoursesByProject(ctx, lp.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	stop := database.RouteStop{
+		ProjectID:     lp.ID,
+		OperatingDate: operatingDate,
+		CourseName:    course.Name,
+		LoadDirection: loadDirectionLoad,
+	}
+	content := components.StopEdit(lp, course, stop, courses, false)
+	c.Response().Header().Set(echo.HeaderContentType, echo.MIMETextHTML)
+	if c.Request().Header.Get("HX-Request") == "true" {
+		return content.Render(ctx, c.Response().Writer)
+	}
+	return layouts.Base("停車地の追加: "+course.Name, content).Render(ctx, c.Response().Writer)
+}
+
+// CreateStop は停車地をコースの末尾に追加する
+func (h *ProjectHandler) CreateStop(c echo.Context) error {
+	if err := h.checkPermission(c); err != nil {
+		return err
+	}
+	ctx := c.Request().Context()
+	lp, course, err := h.getCourse(c)
+	if err != nil {
+		return err
+	}
+	operatingDate := c.FormValue("date")
+	if _, err := parseOperatingDate(operatingDate); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効な運行日")
+	}
+	in, err := parseStopForm(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	tx, err := h.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("トランザクション開始失敗: %v", err))
+	}
+	defer tx.Rollback()
+	qtx := h.DB.WithTx(tx)
+
+	// 順番は resequenceCourse で末尾の番号に振り直す
+	id, err := qtx.CreateRouteStop(ctx, database.CreateRouteStopParams{
+		ProjectID:        lp.ID,
+		OperatingDate:    operatingDate,
+		CourseID:         sql.NullInt64{Int64: course.ID, Valid: true},
+		CourseName:       course.Name,
+		Sequence:         "",
+		SequenceNo:       math.MaxInt32,
+		ArrivalTime:      toNullString(in.ArrivalTime),
+		StopName:         in.StopName,
+		Address:          toNullString(in.Address),
+		Latitude:         toNullFloat64(in.Latitude),
+		Longitude:        toNullFloat64(in.Longitude),
+		StayMinutes:      toNullInt64(in.StayMinutes),
+		WeightKg:         toNullInt64(in.WeightKg),
+		LoadDirection:    in.LoadDirection,
+		PhoneNumber:      toNullString(in.PhoneNumber),
+		Note1:            toNullString(in.Note1),
+		Note2:            toNullString(in.Note2),
+		Note3:            toNullString(in.Note3),
+		DesiredTimeStart: toNullString(in.DesiredTimeStart),
+		DesiredTimeEnd:   toNullString(in.DesiredTimeEnd),
+		ManuallyEditedAt: sql.NullTime{Time: time.Now(), Valid: true},
+		GeofenceRadiusM:  toNullInt64(in.GeofenceRadiusM),
+		GeofencePolygon:  toNullString(in.GeofencePolygon),
+	})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("停車地の追加失敗: %v", err))
+	}
+	if _, err := resequenceCourse(ctx, qtx, lp.ID, operatingDate, course, nil, nil); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	created, err := qtx.GetRouteStopByID(ctx, id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	if err := recordStopEdit(ctx, qtx, created, stopEditCreate, fmt.Sprintf("順番 %s に追加", created.Sequence)); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("コミット失敗: %v", err))
+	}
+
+	// PRG: コース詳細ページへリダイレクト
+	return c.Redirect(http.StatusSeeOther, courseStopsRedirect(lp.ID, course.Name, operatingDate))
+}
+
+// EditStopPage は停車地の編集ページを表示
+func (h *ProjectHandler) EditStopPage(c echo.Context) error {
+	if err := h.checkPermission(c); err != nil {
+		return err
+	}
+	ctx := c.Request().Context()
+	lp, course, stop, err := h.getRouteStop(c)
+	if err != nil {
+		return err
+	}
+	courses, err := h.DB.ListCoursesByProject(ctx, lp.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	content := components.StopEdit(lp, course, stop, courses, true)
+	c.Response().Header().Set(echo.HeaderContentType, echo.MIMETextHTML)
+	if c.Request().Header.Get("HX-Request") == "true" {
+		return content.Render(ctx, c.Response().Writer)
+	}
+	return layouts.Base("停車地の編集: "+stop.StopName, content).Render(ctx, c.Response().Writer)
+}
+
+// UpdateStop は停車地を更新する。別のコースを指定した場合はそのコースの末尾へ移動する
+func (h *ProjectHandler) UpdateStop(c echo.Context) error {
+	if err := h.checkPermission(c); err != nil {
+		return err
+	}
+	ctx := c.Request().Context()
+	lp, course, stop, err := h.getRouteStop(c)
+	if err != nil {
+		return err
+	}
+	in, err := parseStopForm(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	target := course
+	if in.CourseName != "" && in.CourseName != course.Name {
+		target, err = h.DB.GetCourseByName(ctx, database.GetCourseByNameParams{ProjectID: lp.ID, Name: in.CourseName})
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("コース %s が見つかりません", in.CourseName))
+		}
+	}
+	in.CourseName = course.Name
+	in.Sequence = stop.Sequence
+	in.CustomerCode = stop.CustomerCode.String // 顧客コードは画面では変えない
+	changes := diffRouteStop(stop, in)
+	if len(changes) == 0 && target.ID == course.ID {
+		return c.Redirect(http.StatusSeeOther, courseStopsRedirect(lp.ID, course.Name, stop.OperatingDate))
+	}
+
+	tx, err := h.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("トランザクション開始失敗: %v", err))
+	}
+	defer tx.Rollback()
+	qtx := h.DB.WithTx(tx)
+
+	hasCoordinates := in.Latitude != 0 && in.Longitude != 0
+	geocodeSource, geocodeConfidence, geocodeStatus := stop.GeocodeSource, stop.GeocodeConfidence, stop.GeocodeStatus
+	if hasCoordinates {
+		geocodeSource, geocodeConfidence, geocodeStatus = sql.NullString{}, sql.NullFloat64{}, sql.NullString{}
+	}
+
+	now := sql.NullTime{Time: time.Now(), Valid: true}
+	if err := qtx.UpdateRouteStop(ctx, database.UpdateRouteStopParams{
+		ID:               stop.ID,
+		CourseID:         stop.CourseID,
+		CourseName:       stop.CourseName,
+		Sequence:         stop.Sequence,
+		SequenceNo:       stop.SequenceNo,
+		ArrivalTime:      toNullString(in.ArrivalTime),
+		PlannedAt:        stop.PlannedAt,
+		StopName:         in.StopName,
+		Address:          toNullString(in.Address),
+		Latitude:         toNullFloat64(in.Latitude),
+		Longitude:        toNullFloat64(in.Longitude),
+		StayMinutes:      toNullInt64(in.StayMinutes),
+		WeightKg:         toNullInt64(in.WeightKg),
+		LoadDirection:    in.LoadDirection,
+		PhoneNumber:      toNullString(in.PhoneNumber),
+		Note1:            toNullString(in.Note1),
+		Note2:            toNullString(in.Note2),
+		Note3:            toNullString(in.Note3),
+		DesiredTimeStart: toNullString(in.DesiredTimeStart),
+		DesiredTimeEnd:   toNullString(in.DesiredTimeEnd),
+		ManuallyEditedAt: now,
+		// 座標を指定した停車地は推定ではなくなる。座標がないままなら推定できなかった状態を残す
+		GeocodeSource:     geocodeSource,
+		GeocodeConfidence: geocodeConfidence,
+		GeocodeStatus:     geocodeStatus,
+		CustomerCode:      toNullString(in.CustomerCode),
+		LocationID:        stop.LocationID,
+		// 到着判定の範囲・エリアは画面で停車地ごとに変えられる（位置マスタから適用した値の上書きを含む）
+		GeofenceRadiusM: toNullInt64(in.GeofenceRadiusM),
+		GeofencePolygon: toNullString(in.GeofencePolygon),
+	}); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("停車地の更新失敗: %v", err))
+	}
+	// 住所から推定した座標を画面で確かめた・直した場合は、住所辞書に登録して次回の取り込みで使う
+	if stop.GeocodeStatus.Valid && hasCoordinates {
+		if err := learnGeocodeAddress(ctx, qtx, in.Address, in.Latitude, in.Longitude); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+		}
+	}
+	updated := stop
+	updated.StopName = in.StopName
+	updated.Address = toNullString(in.Address)
+	if len(changes) > 0 {
+		if err := recordStopEdit(ctx, qtx, updated, stopEditUpdate, strings.Join(changes, "、")); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+		}
+	}
+
+	if target.ID != course.ID {
+		// 移動先のコースの末尾に並べる（順番が最後になるよう一時的に大きな値にする）
+		if err := qtx.UpdateRouteStopSchedule(ctx, database.UpdateRouteStopScheduleParams{
+			CourseID:         sql.NullInt64{Int64: target.ID, Valid: true},
+			CourseName:       target.Name,
+			Sequence:         "",
+			SequenceNo:       math.MaxInt32,
+			PlannedAt:        stop.PlannedAt,
+			ManuallyEditedAt: now,
+			ID:               stop.ID,
+		}); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("コースの移動失敗: %v", err))
+		}
+		if err := recordStopEdit(ctx, qtx, updated, stopEditMove, fmt.Sprintf("%s → %s", course.Name, target.Name)); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+		}
+		if _, err := resequenceCourse(ctx, qtx, lp.ID, stop.OperatingDate, target, nil, nil); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+		}
+	}
+	// 到着予定の変更や移動に合わせて元のコースの順番・到着予定日時を振り直す
+	if _, err := resequenceCourse(ctx, qtx, lp.ID, stop.OperatingDate, course, nil, nil); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("コミット失敗: %v", err))
+	}
+
+	// PRG: 移動先のコース詳細ページへリダイレクト
+	return c.Redirect(http.StatusSeeOther, courseStopsRedirect(lp.ID, target.Name, stop.OperatingDate))
+}
+
+// DeleteStop は停車地を削除する。次回のCSV取り込みで修正を保持する場合、同じ地点は再び追加されない
+func (h *ProjectHandler) DeleteStop(c echo.Context) error {
+	if err := h.checkPermission(c); err != nil {
+		return err
+	}
+	ctx := c.Request().Context()
+	lp, course, stop, err := h.getRouteStop(c)
+	if err != nil {
+		return err
+	}
+
+	tx, err := h.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("トランザクション開始失敗: %v", err))
+	}
+	defer tx.Rollback()
+	qtx := h.DB.WithTx(tx)
+
+	if err := qtx.DeleteRouteStop(ctx, stop.ID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("停車地の削除失敗: %v", err))
+	}
+	deleted := stop
+	deleted.ID = 0
+	if err := recordStopEdit(ctx, qtx, deleted, stopEditDelete, fmt.Sprintf("順番 %s を削除", stop.Sequence)); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	if _, err := resequenceCourse(ctx, qtx, lp.ID, stop.OperatingDate, course, nil, nil); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("コミット失敗: %v", err))
+	}
+
+	// PRG: コース詳細ページへリダイレクト
+	return c.Redirect(http.StatusSeeOther, courseStopsRedirect(lp.ID, course.Name, stop.OperatingDate))
+}
+
+// ReorderStops はドラッグで並べ替えた順（フォームの stop_id の順）にコースの停車地を並べ直す
+func (h *ProjectHandler) ReorderStops(c echo.Context) error {
+	if err := h.checkPermission(c); err != nil {
+		return err
+	}
+	ctx := c.Request().Context()
+	lp, course, err := h.getCourse(c)
+	if err != nil {
+		return err
+	}
+	operatingDate := c.FormValue("date")
+	if _, err := parseOperatingDate(operatingDate); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効な運行日")
+	}
+	form, err := c.FormParams()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なフォーム")
+	}
+	order := make([]int64, 0, len(form["stop_id"]))
+	markIDs := make(map[int64]bool, len(form["stop_id"]))
+	for _, v := range form["stop_id"] {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "無効な地点ID")
+		}
+		order = append(order, id)
+		markIDs[id] = true
+	}
+
+	tx, err := h.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("トランザクション開始失敗: %v", err))
+	}
+	defer tx.Rollback()
+	qtx := h.DB.WithTx(tx)
+
+	before, err := qtx.ListRouteStopsByCourse(ctx, database.ListRouteStopsByCourseParams{
+		ProjectID:     lp.ID,
+		OperatingDate: operatingDate,
+		CourseName:    course.Name,
+	})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	previous := make(map[int64]string, len(before))
+	for _, stop := range before {
+		previous[stop.ID] = stop.Sequence
+	}
+
+	after, err := resequenceCourse(ctx, qtx, lp.ID, operatingDate, course, order, markIDs)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	for _, stop := range after {
+		if prev := previous[stop.ID]; prev != stop.Sequence {
+			if err := recordStopEdit(ctx, qtx, stop, stopEditReorder, fmt.Sprintf("順番 %s → %s", prev, stop.Sequence)); err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("コミット失敗: %v", err))
+	}
+
+	// PRG: コース詳細ページへリダイレクト
+	return c.Redirect(http.StatusSeeOther, courseStopsRedirect(lp.ID, course.Name, operatingDate))
+}
@@ -0,0 +1,266 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
+	"github.com/naozine/project_crud_with_auth_tmpl/web/components"
+	"github.com/naozine/project_crud_with_auth_tmpl/web/layouts"
+)
+
+// DownloadRouteImport は取り込み時の元CSVファイルをダウンロードする
+func (h *ProjectHandler) DownloadRouteImport(c echo.Context) error {
+	_, imp, err := h.getRouteImport(c)
+	if err != nil {
+		return err
+	}
+
+	// 日本語ファイル名に対応するため RFC 5987 形式でも指定する
+	c.Response().Header().Set(echo.HeaderContentDisposition,
+		fmt.Sprintf("attachment; filename=\"route.csv\"; filename*=UTF-8''%s", url.PathEscape(imp.Filename)))
+	return c.Blob(http.StatusOK, "text/csv", imp.CsvData)
+}
+
+// DiffRouteImports は2つの版の停車地を比較して表示する
+func (h *ProjectHandler) DiffRouteImports(c echo.Context) error {
+	ctx := c.Request().Context()
+	lpID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効な案件ID")
+	}
+
+	lp, err := h.DB.GetProject(ctx, lpID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "物流案件が見つかりません")
+	}
+
+	history, err := h.DB.ListRouteImportHistory(ctx, lpID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	if len(history) == 0 {
+		return echo.NewHTTPError(http.StatusNotFound, "取り込み履歴がありません")
+	}
+
+	// 未指定の場合は最新版とその1つ前を比較する
+	toVersion := history[0].Version.Int64
+	fromVersion := toVersion - 1
+	if v, err := strconv.ParseInt(c.QueryParam("to"), 10, 64); err == nil {
+		toVersion = v
+	}
+	if v, err := strconv.ParseInt(c.QueryParam("from"), 10, 64); err == nil {
+		fromVersion = v
+	}
+
+	var fromStops []database.RouteStop
+	if fromVersion > 0 {
+		fromImp, err := h.DB.GetRouteImportByVersion(ctx, database.GetRouteImportByVersionParams{
+			ProjectID: lpID,
+			Version:   toNullInt64(fromVersion),
+		})
+		if err != nil {
+			return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("版 %d が見つかりません", fromVersion))
+		}
+		stops, err := h.routeImportStops(ctx, fromImp)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+		}
+		// 比較元は既存の停車地として扱う（IDは対応付けの識別にのみ使う）
+		for i, stop := range stops {
+			fromStops = append(fromStops, snapshotToDBRouteStop(int64(i+1), lpID, stop))
+		}
+	}
+
+	toImp, err := h.DB.GetRouteImportByVersion(ctx, database.GetRouteImportByVersionParams{
+		ProjectID: lpID,
+		Version:   toNullInt64(toVersion),
+	})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("版 %d が見つかりません", toVersion))
+	}
+	toStops, err := h.routeImportStops(ctx, toImp)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	courses := buildImportCourseSummaries(fromStops, toStops, manualEditGuard{})
+
+	content := components.RouteImportDiff(lp, history, fromVersion, toVersion, courses)
+	c.Response().Header().Set(echo.HeaderContentType, echo.MIMETextHTML)
+	if c.Request().Header.Get("HX-Request") == "true" {
+		return content.Render(ctx, c.Response().Writer)
+	}
+	return layouts.Base("取り込み履歴の比較", content).Render(ctx, c.Response().Writer)
+}
+
+// RollbackRouteImport は指定した版の停車地に戻す取り込みを作成し、プレビューへ移る
+// 過去の版を書き換えるのではなく、その版の内容で新しい版を作成する
+// CSVの取り込みと同じくプレビューで差分と画面での修正の扱いを確認してから確定する
+func (h *ProjectHandler) RollbackRouteImport(c echo.Context) error {
+	if err := h.checkPermission(c); err != nil {
+		return err
+	}
+	ctx := c.Request().Context()
+	lpID, target, err := h.getRouteImport(c)
+	if err != nil {
+		return err
+	}
+	if target.Status != "committed" {
+		return echo.NewHTTPError(http.StatusBadRequest, "確定済みの取り込みのみロールバックできます")
+	}
+
+	stops, err := h.routeImportStops(ctx, target)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	if len(stops) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "この版には停車地データがありません")
+	}
+
+	// 放置されたプレビューを掃除
+	_ = h.DB.DeleteStalePendingRouteImports(ctx, lpID)
+
+	imp, err := h.DB.CreateRollbackRouteImport(ctx, database.CreateRollbackRouteImportParams{
+		ProjectID:     lpID,
+		OperatingDate: target.OperatingDate,
+		Filename:      target.Filename,
+		CsvData:       target.CsvData,
+		HasHeader:     target.HasHeader,
+		SkipDeparture: target.SkipDeparture,
+		AdjustTime:    target.AdjustTime,
+		StartTime:     target.StartTime,
+		// 画面から修正した停車地を上書きしない
+		KeepManualEdits:   c.FormValue("keep_manual_edits") == "true",
+		RollbackOfVersion: target.Version,
+	})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("取り込みデータ保存失敗: %v", err))
+	}
+
+	// PRG: プレビューページへリダイレクト
+	return c.Redirect(http.StatusSeeOther, fmt.Sprintf("/projects/%d/imports/%d", lpID, imp.ID))
+}
+
+// pendingRouteImportStops は未確定の取り込みで反映する停車地と行単位の警告を返す
+// ロールバックは戻す版の停車地を、それ以外は保存済みのCSVを使う
+func (h *ProjectHandler) pendingRouteImportStops(ctx context.Context, imp database.RouteImport) ([]RouteStop, []components.ImportIssue, error) {
+	if !imp.RollbackOfVersion.Valid {
+		stops, issues, err := loadRouteImportStops(ctx, imp, h.importResolver())
+		if err != nil {
+			return nil, nil, fmt.Errorf("CSVパースエラー: %w", err)
+		}
+		return stops, issues, nil
+	}
+
+	target, err := h.DB.GetRouteImportByVersion(ctx, database.GetRouteImportByVersionParams{
+		ProjectID: imp.ProjectID,
+		Version:   imp.RollbackOfVersion,
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("戻す版 v%d が見つかりません", imp.RollbackOfVersion.Int64)
+	}
+	stops, err := h.routeImportStops(ctx, target)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// 位置マスタと紐付いていない停車地（紐付けを保存する前の版を含む）は現在の位置マスタで照合し直す
+	var unmatched []int
+	var rematch []RouteStop
+	for i, stop := range stops {
+		if stop.LocationID == 0 {
+			unmatched = append(unmatched, i)
+			rematch = append(rematch, stop)
+		}
+	}
+	rematch, err = applyLocationMaster(ctx, h.DB, rematch)
+	if err != nil {
+		return nil, nil, err
+	}
+	for j, i := range unmatched {
+		stops[i] = rematch[j]
+	}
+	return stops, validateRouteStops(stops), nil
+}
+
+// routeImportStops は確定済み取り込みの停車地を返す
+// スナップショットがない取り込み（履歴機能の追加前に確定したもの）は保存済みのCSVから再構成する
+func (h *ProjectHandler) routeImportStops(ctx context.Context, imp database.RouteImport) ([]RouteStop, error) {
+	snapshot, err := h.DB.ListRouteImportStops(ctx, imp.ID)
+	if err != nil {
+		return nil, fmt.Errorf("スナップショット取得失敗: %w", err)
+	}
+	if len(snapshot) == 0 {
+		stops, _, err := loadRouteImportStops(ctx, imp, h.importResolver())
+		if err != nil {
+			return nil, fmt.Errorf("CSVパースエラー: %w", err)
+		}
+		return stops, nil
+	}
+
+	stops := make([]RouteStop, 0, len(snapshot))
+	for i, s := range snapshot {
+		stops = append(stops, RouteStop{
+			Row:              i + 1,
+			CourseName:       s.CourseName,
+			Sequence:         s.Sequence,
+			ArrivalTime:      s.ArrivalTime.String,
+			StopName:         s.StopName,
+			Address:          s.Address.String,
+			Latitude:         s.Latitude.Float64,
+			Longitude:        s.Longitude.Float64,
+			StayMinutes:      s.StayMinutes.Int64,
+			WeightKg:         s.WeightKg.Int64,
+			LoadDirection:    s.LoadDirection,
+			PhoneNumber:      s.PhoneNumber.String,
+			Note1:            s.Note1.String,
+			Note2:            s.Note2.String,
+			Note3:            s.Note3.String,
+			DesiredTimeStart: s.DesiredTimeStart.String,
+			DesiredTimeEnd:   s.DesiredTimeEnd.String,
+			CustomerCode:     s.CustomerCode.String,
+
+			LocationID:      s.LocationID.Int64,
+			GeofenceRadiusM: s.GeofenceRadiusM.Int64,
+			GeofencePolygon: s.GeofencePolygon.String,
+
+			GeocodeSource:     s.GeocodeSource.String,
+			GeocodeConfidence: s.GeocodeConfidence.Float64,
+			GeocodeStatus:     s.GeocodeStatus.String,
+		})
+	}
+	return stops, nil
+}
+
+// snapshotToDBRouteStop は版の比較用に取り込み行を停車地レコードの形に変換する
+func snapshotToDBRouteStop(id, lpID int64, stop RouteStop) database.RouteStop {
+	return database.RouteStop{
+		ID:               id,
+		ProjectID:        lpID,
+		CourseName:       stop.CourseName,
+		Sequence:         stop.Sequence,
+		ArrivalTime:      toNullString(stop.ArrivalTime),
+		StopName:         stop.StopName,
+		Address:          toNullString(stop.Address),
+		Latitude:         toNullFloat64(stop.Latitude),
+		Longitude:        toNullFloat64(stop.Longitude),
+		StayMinutes:      toNullInt64(stop.StayMinutes),
+		WeightKg:         toNullInt64(stop.WeightKg),
+		LoadDirection:    stop.LoadDirection,
+		PhoneNumber:      toNullString(stop.PhoneNumber),
+		Note1:            toNullString(stop.Note1),
+		Note2:            toNullString(stop.Note2),
+		Note3:            toNullString(stop.Note3),
+		DesiredTimeStart: toNullString(stop.DesiredTimeStart),
+		DesiredTimeEnd:   toNullString(stop.DesiredTimeEnd),
+		CustomerCode:     toNullString(stop.CustomerCode),
+		LocationID:       toNullInt64(stop.LocationID),
+		GeofenceRadiusM:  toNullInt64(stop.GeofenceRadiusM),
+		GeofencePolygon:  toNullString(stop.GeofencePolygon),
+	}
+}
@@ -0,0 +1,1185 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/appcontext"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
+	"github.com/naozine/project_crud_with_auth_tmpl/web/components"
+	"github.com/naozine/project_crud_with_auth_tmpl/web/layouts"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/transform"
+)
+
+// 日本国内とみなす座標範囲（沖ノ鳥島〜択捉島、与那国島〜南鳥島をおおまかに含む）
+const (
+	japanMinLatitude  = 20.0
+	japanMaxLatitude  = 46.0
+	japanMinLongitude = 122.0
+	japanMaxLongitude = 154.0
+)
+
+// UploadRoutesPage はCSVアップロードページを表示
+func (h *ProjectHandler) UploadRoutesPage(c echo.Context) error {
+	if err := h.checkPermission(c); err != nil {
+		return err
+	}
+	ctx := c.Request().Context()
+	lpID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効な案件ID")
+	}
+
+	lp, err := h.DB.GetProject(ctx, lpID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "物流案件が見つかりません")
+	}
+
+	// 運行日の初期値（?date= 指定があればその日）
+	operatingDate := c.QueryParam("date")
+	if _, err := parseOperatingDate(operatingDate); err != nil {
+		operatingDate = todayOperatingDate()
+	}
+
+	content := components.RouteUploadForm(lpID, lp.Name, operatingDate)
+	c.Response().Header().Set(echo.HeaderContentType, echo.MIMETextHTML)
+	if c.Request().Header.Get("HX-Request") == "true" {
+		return content.Render(ctx, c.Response().Writer)
+	}
+	return layouts.Base("CSVアップロード", content).Render(ctx, c.Response().Writer)
+}
+
+// UploadRoutes はCSVファイルを受け取り、取り込み前のプレビューとして保存する
+// 既存データはまだ変更せず、プレビュー画面で確認後に CommitRouteImport で反映する
+func (h *ProjectHandler) UploadRoutes(c echo.Context) error {
+	if err := h.checkPermission(c); err != nil {
+		return err
+	}
+	ctx := c.Request().Context()
+	lpID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効な案件ID")
+	}
+
+	// 物流案件の存在確認
+	_, err = h.DB.GetProject(ctx, lpID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "物流案件が見つかりません")
+	}
+
+	// ファイルアップロード
+	fileHeader, err := c.FormFile("csv_file")
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "CSVファイルが指定されていません")
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "ファイルを開けませんでした")
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "ファイルを読み込めませんでした")
+	}
+
+	// 取り込み先の運行日（未指定なら今日）
+	operatingDate := c.FormValue("operating_date")
+	if operatingDate == "" {
+		operatingDate = todayOperatingDate()
+	}
+	if _, err := parseOperatingDate(operatingDate); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "運行日の形式が不正です")
+	}
+
+	startTime := ""
+	if c.FormValue("adjust_time") == "true" {
+		startTime = c.FormValue("start_time")
+	}
+
+	imp := database.RouteImport{
+		ProjectID:     lpID,
+		OperatingDate: operatingDate,
+		Filename:      fileHeader.Filename,
+		CsvData:       data,
+		HasHeader:     c.FormValue("has_header") == "true",
+		SkipDeparture: c.FormValue("skip_departure") == "true",
+		AdjustTime:    c.FormValue("adjust_time") == "true",
+		StartTime:     toNullString(startTime),
+		// 画面から修正した停車地を上書きしない
+		KeepManualEdits: c.FormValue("keep_manual_edits") == "true",
+	}
+
+	// この時点でパースできないファイルは保存しない
+	stops, _, err := loadRouteImportStops(ctx, imp, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("CSVパースエラー: %v", err))
+	}
+	if len(stops) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "取り込み可能なデータがありません")
+	}
+
+	// 放置されたプレビューを掃除
+	_ = h.DB.DeleteStalePendingRouteImports(ctx, lpID)
+
+	created, err := h.DB.CreateRouteImport(ctx, database.CreateRouteImportParams{
+		ProjectID:       imp.ProjectID,
+		OperatingDate:   imp.OperatingDate,
+		Filename:        imp.Filename,
+		CsvData:         imp.CsvData,
+		HasHeader:       imp.HasHeader,
+		SkipDeparture:   imp.SkipDeparture,
+		AdjustTime:      imp.AdjustTime,
+		StartTime:       imp.StartTime,
+		KeepManualEdits: imp.KeepManualEdits,
+	})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("取り込みデータ保存失敗: %v", err))
+	}
+
+	// PRG: プレビューページへリダイレクト
+	return c.Redirect(http.StatusSeeOther, fmt.Sprintf("/projects/%d/imports/%d", lpID, created.ID))
+}
+
+// PreviewRouteImport は取り込み前のプレビュー（警告・差分）を表示
+func (h *ProjectHandler) PreviewRouteImport(c echo.Context) error {
+	if err := h.checkPermission(c); err != nil {
+		return err
+	}
+	ctx := c.Request().Context()
+	lpID, imp, err := h.getPendingRouteImport(c)
+	if err != nil {
+		return err
+	}
+
+	lp, err := h.DB.GetProject(ctx, lpID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "物流案件が見つかりません")
+	}
+
+	stops, issues, err := h.pendingRouteImportStops(ctx, imp)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	current, err := h.DB.ListRouteStopsByProjectDate(ctx, database.ListRouteStopsByProjectDateParams{
+		ProjectID:     lpID,
+		OperatingDate: imp.OperatingDate,
+	})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	// 画面から修正した停車地が取り込みでどうなるかを警告する
+	guard, err := loadManualEditGuard(ctx, h.DB, lpID, imp.OperatingDate, current, imp.KeepManualEdits)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	issues = append(issues, guard.importIssues(imp.OperatingDate, current, stops)...)
+
+	// 取り込み後の予定積載量が車両の最大積載量を超えるコースを警告する
+	courses := buildImportCourseSummaries(current, stops, guard)
+	capacities, err := h.courseCapacitiesByDate(ctx, lpID, imp.OperatingDate)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	issues = append(issues, checkImportCapacity(courses, capacities)...)
+
+	locationCount := 0
+	for _, stop := range stops {
+		if stop.LocationID != 0 {
+			locationCount++
+		}
+	}
+
+	preview := components.RouteImportPreviewData{
+		ImportID:          imp.ID,
+		RollbackOfVersion: imp.RollbackOfVersion.Int64,
+		OperatingDate:     imp.OperatingDate,
+		Filename:          imp.Filename,
+		RowCount:          len(stops),
+		SkipDeparture:     imp.SkipDeparture,
+		StartTime:         imp.StartTime.String,
+		KeepManualEdits:   imp.KeepManualEdits,
+		LocationCount:     locationCount,
+		Issues:            issues,
+		Courses:           courses,
+	}
+
+	content := components.RouteImportPreview(lp, preview)
+	c.Response().Header().Set(echo.HeaderContentType, echo.MIMETextHTML)
+	if c.Request().Header.Get("HX-Request") == "true" {
+		return content.Render(ctx, c.Response().Writer)
+	}
+	return layouts.Base("取り込みプレビュー", content).Render(ctx, c.Response().Writer)
+}
+
+// CommitRouteImport はプレビュー済みのCSVを既存データに差分反映して確定する
+func (h *ProjectHandler) CommitRouteImport(c echo.Context) error {
+	if err := h.checkPermission(c); err != nil {
+		return err
+	}
+	ctx := c.Request().Context()
+	lpID, imp, err := h.getPendingRouteImport(c)
+	if err != nil {
+		return err
+	}
+
+	stops, _, err := h.pendingRouteImportStops(ctx, imp)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if len(stops) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "フィルタ後のデータがありません")
+	}
+
+	// 停車地の差分反映・履歴の記録・案件のCSV情報の更新をまとめて1トランザクションで行う
+	tx, err := h.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("トランザクション開始失敗: %v", err))
+	}
+	defer tx.Rollback()
+
+	switch err := finalizeRouteImport(ctx, h.DB.WithTx(tx), imp, stops, appcontext.GetUserID(ctx)); {
+	case errors.Is(err, errRouteImportNotPending):
+		// 二重送信や別のタブで先に確定された
+		return echo.NewHTTPError(http.StatusConflict, "この取り込みは既に確定済みです")
+	case err != nil:
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("コミット失敗: %v", err))
+	}
+
+	// PRG: 物流案件詳細ページへリダイレクト
+	return c.Redirect(http.StatusSeeOther, fmt.Sprintf("/projects/%d", lpID))
+}
+
+// DiscardRouteImport はプレビュー中の取り込みを破棄する
+func (h *ProjectHandler) DiscardRouteImport(c echo.Context) error {
+	if err := h.checkPermission(c); err != nil {
+		return err
+	}
+	ctx := c.Request().Context()
+	lpID, imp, err := h.getPendingRouteImport(c)
+	if err != nil {
+		return err
+	}
+
+	if err := h.DB.DeleteRouteImport(ctx, imp.ID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("取り込みデータ削除失敗: %v", err))
+	}
+
+	// ロールバックは取り込み履歴のある物流案件詳細ページへ戻す
+	if imp.RollbackOfVersion.Valid {
+		return c.Redirect(http.StatusSeeOther, fmt.Sprintf("/projects/%d", lpID))
+	}
+	return c.Redirect(http.StatusSeeOther, fmt.Sprintf("/projects/%d/courses/upload", lpID))
+}
+
+// getRouteImport はURLパラメータから取り込みデータを取得する
+func (h *ProjectHandler) getRouteImport(c echo.Context) (int64, database.RouteImport, error) {
+	lpID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return 0, database.RouteImport{}, echo.NewHTTPError(http.StatusBadRequest, "無効な案件ID")
+	}
+	importID, err := strconv.ParseInt(c.Param("import_id"), 10, 64)
+	if err != nil {
+		return 0, database.RouteImport{}, echo.NewHTTPError(http.StatusBadRequest, "無効な取り込みID")
+	}
+
+	imp, err := h.DB.GetRouteImport(c.Request().Context(), importID)
+	if err != nil || imp.ProjectID != lpID {
+		return 0, database.RouteImport{}, echo.NewHTTPError(http.StatusNotFound, "取り込みデータが見つかりません")
+	}
+	return lpID, imp, nil
+}
+
+// getPendingRouteImport はURLパラメータから未確定の取り込みデータを取得する
+func (h *ProjectHandler) getPendingRouteImport(c echo.Context) (int64, database.RouteImport, error) {
+	lpID, imp, err := h.getRouteImport(c)
+	if err != nil {
+		return 0, database.RouteImport{}, err
+	}
+	if imp.Status != "pending" {
+		return 0, database.RouteImport{}, echo.NewHTTPError(http.StatusConflict, "この取り込みは既に確定済みです")
+	}
+	return lpID, imp, nil
+}
+
+// errRouteImportNotPending は確定しようとした取り込みが既に確定済み・破棄済みであることを表す
+var errRouteImportNotPending = errors.New("この取り込みは既に確定済みです")
+
+// finalizeRouteImport は停車地を差分反映し、取り込みを新しい版として確定する
+// 確定時点の停車地をスナップショットとして保存し、案件のCSV情報も更新する
+// 呼び出し側でトランザクションを張ること
+// 画面からの修正を保持しない取り込みでは、それまでの修正履歴を上書き済みにする
+// 同じ取り込みを二重に確定しないよう、トランザクション内で未確定であることを確かめる（確定済みなら errRouteImportNotPending）
+func finalizeRouteImport(ctx context.Context, q *database.Queries, imp database.RouteImport, stops []RouteStop, userID int64) error {
+	imp, err := q.GetRouteImport(ctx, imp.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return errRouteImportNotPending
+	}
+	if err != nil {
+		return fmt.Errorf("取り込みデータ取得失敗: %w", err)
+	}
+	if imp.Status != "pending" {
+		return errRouteImportNotPending
+	}
+
+	current, err := q.ListRouteStopsByProjectDate(ctx, database.ListRouteStopsByProjectDateParams{
+		ProjectID:     imp.ProjectID,
+		OperatingDate: imp.OperatingDate,
+	})
+	if err != nil {
+		return fmt.Errorf("既存データ取得失敗: %w", err)
+	}
+	guard, err := loadManualEditGuard(ctx, q, imp.ProjectID, imp.OperatingDate, current, imp.KeepManualEdits)
+	if err != nil {
+		return err
+	}
+	if _, err := applyRouteStops(ctx, q, imp.ProjectID, imp.OperatingDate, stops, guard); err != nil {
+		return fmt.Errorf("停車地データ反映失敗: %w", err)
+	}
+
+	// 修正を保持した場合はCSVと実際の停車地が異なるため、反映後の停車地をスナップショットにする
+	snapshot := stops
+	if imp.KeepManualEdits {
+		applied, err := q.ListRouteStopsByProjectDate(ctx, database.ListRouteStopsByProjectDateParams{
+			ProjectID:     imp.ProjectID,
+			OperatingDate: imp.OperatingDate,
+		})
+		if err != nil {
+			return fmt.Errorf("反映後の停車地取得失敗: %w", err)
+		}
+		snapshot = make([]RouteStop, len(applied))
+		for i, stop := range applied {
+			snapshot[i] = routeStopRow(i+1, stop)
+		}
+	} else {
+		if err := q.SupersedeRouteStopEdits(ctx, database.SupersedeRouteStopEditsParams{
+			SupersededByImportID: sql.NullInt64{Int64: imp.ID, Valid: true},
+			ProjectID:            imp.ProjectID,
+			OperatingDate:        imp.OperatingDate,
+		}); err != nil {
+			return fmt.Errorf("修正履歴の更新失敗: %w", err)
+		}
+	}
+
+	for _, stop := range snapshot {
+		err := q.CreateRouteImportStop(ctx, database.CreateRouteImportStopParams{
+			ImportID:         imp.ID,
+			CourseName:       stop.CourseName,
+			Sequence:         stop.Sequence,
+			ArrivalTime:      toNullString(stop.ArrivalTime),
+			StopName:         stop.StopName,
+			Address:          toNullString(stop.Address),
+			Latitude:         toNullFloat64(stop.Latitude),
+			Longitude:        toNullFloat64(stop.Longitude),
+			StayMinutes:      toNullInt64(stop.StayMinutes),
+			WeightKg:         toNullInt64(stop.WeightKg),
+			LoadDirection:    stop.LoadDirection,
+			PhoneNumber:      toNullString(stop.PhoneNumber),
+			Note1:            toNullString(stop.Note1),
+			Note2:            toNullString(stop.Note2),
+			Note3:            toNullString(stop.Note3),
+			DesiredTimeStart: toNullString(stop.DesiredTimeStart),
+			DesiredTimeEnd:   toNullString(stop.DesiredTimeEnd),
+			CustomerCode:     toNullString(stop.CustomerCode),
+
+			LocationID:        toNullInt64(stop.LocationID),
+			GeofenceRadiusM:   toNullInt64(stop.GeofenceRadiusM),
+			GeofencePolygon:   toNullString(stop.GeofencePolygon),
+			GeocodeSource:     toNullString(stop.GeocodeSource),
+			GeocodeConfidence: toNullFloat64(stop.GeocodeConfidence),
+			GeocodeStatus:     toNullString(stop.GeocodeStatus),
+		})
+		if err != nil {
+			return fmt.Errorf("スナップショット保存失敗: %w", err)
+		}
+	}
+
+	// 物流案件のCSV情報を更新
+	_, err = q.UpdateProjectCSV(ctx, database.UpdateProjectCSVParams{
+		ID: imp.ProjectID,
+		CsvFilename: sql.NullString{
+			String: imp.Filename,
+			Valid:  true,
+		},
+		CsvImportedAt: sql.NullTime{
+			Time:  time.Now(),
+			Valid: true,
+		},
+		CsvRowCount: sql.NullInt64{
+			Int64: int64(len(stops)),
+			Valid: true,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("物流案件CSV情報更新失敗: %w", err)
+	}
+
+	version, err := q.GetNextRouteImportVersion(ctx, imp.ProjectID)
+	if err != nil {
+		return fmt.Errorf("版番号取得失敗: %w", err)
+	}
+	importedBy := sql.NullInt64{Int64: userID, Valid: userID != 0}
+	committed, err := q.CommitRouteImport(ctx, database.CommitRouteImportParams{
+		RowCount:   int64(len(stops)),
+		Version:    sql.NullInt64{Int64: version, Valid: true},
+		ImportedBy: importedBy,
+		ID:         imp.ID,
+	})
+	if err != nil {
+		return fmt.Errorf("取り込み状態更新失敗: %w", err)
+	}
+	if committed == 0 {
+		return errRouteImportNotPending
+	}
+	return nil
+}
+
+// RouteStop はCSVの1行分のデータ
+type RouteStop struct {
+	Row              int // CSV上の行番号（1始まり）
+	CourseName       string
+	Sequence         string
+	ArrivalTime      string
+	StopName         string
+	Address          string
+	Latitude         float64
+	Longitude        float64
+	StayMinutes      int64
+	WeightKg         int64
+	LoadDirection    string       // 積降区分（load / unload）
+	SequenceNo       int64        // 並び順（取り込み時に scheduleRouteStops で設定）
+	PlannedAt        sql.NullTime // 運行日を基準にした到着予定日時（同上）
+	PhoneNumber      string
+	Note1            string
+	Note2            string
+	Note3            string
+	DesiredTimeStart string
+	DesiredTimeEnd   string
+	CustomerCode     string // 顧客コード（19列目、任意）。位置マスタの照合に使う
+
+	// 照合した位置マスタと、マスタから適用した到着判定の範囲・エリア（applyLocationMaster で設定。照合しなければ0・空）
+	// 画面で停車地ごとに指定することもできる
+	LocationID      int64
+	GeofenceRadiusM int64
+	GeofencePolygon string // GeoJSON
+
+	// 住所から座標を推定した場合の検索元・信頼度・状態（geocodeRouteStops で設定）
+	GeocodeSource     string
+	GeocodeConfidence float64
+	GeocodeStatus     string
+
+	coordinateError bool // 緯度・経度が数値に変換できなかった
+}
+
+// routeStopRow は登録済みの停車地をCSVの1行分のデータに変換する
+func routeStopRow(row int, stop database.RouteStop) RouteStop {
+	return RouteStop{
+		Row:              row,
+		CourseName:       stop.CourseName,
+		Sequence:         stop.Sequence,
+		ArrivalTime:      stop.ArrivalTime.String,
+		StopName:         stop.StopName,
+		Address:          stop.Address.String,
+		Latitude:         stop.Latitude.Float64,
+		Longitude:        stop.Longitude.Float64,
+		StayMinutes:      stop.StayMinutes.Int64,
+		WeightKg:         stop.WeightKg.Int64,
+		LoadDirection:    stop.LoadDirection,
+		PhoneNumber:      stop.PhoneNumber.String,
+		Note1:            stop.Note1.String,
+		Note2:            stop.Note2.String,
+		Note3:            stop.Note3.String,
+		DesiredTimeStart: stop.DesiredTimeStart.String,
+		DesiredTimeEnd:   stop.DesiredTimeEnd.String,
+		CustomerCode:     stop.CustomerCode.String,
+		SequenceNo:       stop.SequenceNo,
+		PlannedAt:        stop.PlannedAt,
+
+		LocationID:      stop.LocationID.Int64,
+		GeofenceRadiusM: stop.GeofenceRadiusM.Int64,
+		GeofencePolygon: stop.GeofencePolygon.String,
+
+		GeocodeSource:     stop.GeocodeSource.String,
+		GeocodeConfidence: stop.GeocodeConfidence.Float64,
+		GeocodeStatus:     stop.GeocodeStatus.String,
+	}
+}
+
+// loadRouteImportStops は保存済みのCSVを取り込みオプション付きでパースし、行単位の警告を返す
+// r を指定した場合は位置マスタの座標を適用し、座標のない停車地の座標を住所から求める（nil なら行わない）
+func loadRouteImportStops(ctx context.Context, imp database.RouteImport, r *locationResolver) ([]RouteStop, []components.ImportIssue, error) {
+	stops, issues, err := parseCP932CSV(bytes.NewReader(imp.CsvData), imp.HasHeader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// オプション処理: 「出発」行をスキップ
+	if imp.SkipDeparture {
+		stops = filterDepartureRows(stops)
+	}
+
+	// オプション処理: 出発時間を調整
+	if imp.AdjustTime && imp.StartTime.String != "" {
+		stops = adjustArrivalTimes(stops, imp.StartTime.String)
+	}
+
+	if r != nil {
+		resolved, resolveIssues, err := r.resolve(ctx, stops)
+		if err != nil {
+			return nil, nil, err
+		}
+		stops = resolved
+		issues = append(issues, resolveIssues...)
+	}
+	issues = append(issues, validateRouteStops(stops)...)
+	sort.SliceStable(issues, func(i, j int) bool {
+		return issues[i].Row < issues[j].Row
+	})
+	return stops, issues, nil
+}
+
+// parseCP932CSV はCP932エンコードされたCSVファイルをパース
+// 列数不足の行はスキップし、数値に変換できない値は0として扱った上で issues に記録する
+func parseCP932CSV(r io.Reader, hasHeader bool) ([]RouteStop, []components.ImportIssue, error) {
+	// CP932 -> UTF-8変換
+	reader := transform.NewReader(r, japanese.ShiftJIS.NewDecoder())
+	csvReader := csv.NewReader(reader)
+
+	// フィールド数のチェックを無効化（行末のカンマ対応）
+	csvReader.FieldsPerRecord = -1
+	csvReader.TrimLeadingSpace = true
+
+	// ヘッダースキップ
+	if hasHeader {
+		_, err := csvReader.Read()
+		if err != nil {
+			return nil, nil, fmt.Errorf("ヘッダー読み込みエラー: %w", err)
+		}
+	}
+
+	var stops []RouteStop
+	var issues []components.ImportIssue
+	for {
+		record, err := csvReader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("CSV読み込みエラー: %w", err)
+		}
+		row, _ := csvReader.FieldPos(0)
+
+		// 17カラム必要（CSVの構造に基づく）
+		if len(record) < 17 {
+			issues = append(issues, components.ImportIssue{
+				Row:     row,
+				Level:   components.ImportIssueError,
+				Message: fmt.Sprintf("列数が不足しています（%d列 / 17列必要）。この行は取り込まれません", len(record)),
+			})
+			continue
+		}
+		for i := range record {
+			record[i] = strings.TrimSpace(record[i])
+		}
+
+		stop := RouteStop{
+			Row:              row,
+			CourseName:       record[0],
+			Sequence:         record[1],
+			ArrivalTime:      record[2],
+			StopName:         record[3],
+			Address:          record[4],
+			PhoneNumber:      record[11],
+			Note1:            record[12],
+			Note2:            record[13],
+			Note3:            record[14],
+			DesiredTimeStart: record[15],
+			DesiredTimeEnd:   record[16],
+		}
+
+		if stop.CourseName == "" {
+			issues = append(issues, components.ImportIssue{
+				Row:      row,
+				Sequence: stop.Sequence,
+				Level:    components.ImportIssueError,
+				Message:  "コース名が空です。この行は取り込まれません",
+			})
+			continue
+		}
+
+		warn := func(msg string) {
+			issues = append(issues, components.ImportIssue{
+				Row:        row,
+				CourseName: stop.CourseName,
+				Sequence:   stop.Sequence,
+				Level:      components.ImportIssueWarning,
+				Message:    msg,
+			})
+		}
+
+		// 18列目（任意）は積降区分。空欄・列なしは積込として扱う
+		stop.LoadDirection = loadDirectionLoad
+		if len(record) > 17 {
+			direction, ok := parseLoadDirection(record[17])
+			if !ok {
+				warn(fmt.Sprintf("積降区分 %q を解釈できません（積込として取り込みます）", record[17]))
+			}
+			stop.LoadDirection = direction
+		}
+
+		// 19列目（任意）は顧客コード。位置マスタに同じ顧客コードがあれば住所より優先して照合する
+		if len(record) > 18 {
+			stop.CustomerCode = strings.TrimSpace(record[18])
+		}
+
+		var ok bool
+		if stop.Latitude, ok = parseCSVFloat(record[5]); !ok {
+			warn(fmt.Sprintf("緯度 %q を数値に変換できません", record[5]))
+			stop.coordinateError = true
+		}
+		if stop.Longitude, ok = parseCSVFloat(record[6]); !ok {
+			warn(fmt.Sprintf("経度 %q を数値に変換できません", record[6]))
+			stop.coordinateError = true
+		}
+		if stop.StayMinutes, ok = parseCSVInt(record[7]); !ok {
+			warn(fmt.Sprintf("滞在時間 %q を数値に変換できません（0分として取り込みます）", record[7]))
+		}
+		if stop.WeightKg, ok = parseCSVInt(record[8]); !ok {
+			warn(fmt.Sprintf("重量 %q を数値に変換できません（0kgとして取り込みます）", record[8]))
+		}
+
+		stops = append(stops, stop)
+	}
+
+	return stops, issues, nil
+}
+
+// parseCSVFloat は数値列をパースする（空欄は0として正常扱い）
+func parseCSVFloat(s string) (float64, bool) {
+	if s == "" {
+		return 0, true
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, false
+	}
+	return f, true
+}
+
+// parseCSVInt は整数列をパースする（空欄は0として正常扱い）
+func parseCSVInt(s string) (int64, bool) {
+	if s == "" {
+		return 0, true
+	}
+	i, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return i, true
+}
+
+// validateRouteStops はパース済みの停車地を検証し、行単位の警告を返す
+func validateRouteStops(stops []RouteStop) []components.ImportIssue {
+	var issues []components.ImportIssue
+	seenSequences := make(map[string]map[string]int) // コース名 -> 順番 -> 最初に出現した行
+
+	for _, stop := range stops {
+		warn := func(msg string) {
+			issues = append(issues, components.ImportIssue{
+				Row:        stop.Row,
+				CourseName: stop.CourseName,
+				Sequence:   stop.Sequence,
+				Level:      components.ImportIssueWarning,
+				Message:    msg,
+			})
+		}
+
+		if stop.StopName == "" {
+			warn("名称が空です")
+		}
+
+		// 座標
+		if !stop.coordinateError {
+			if stop.GeocodeStatus == geocodeStatusFailed {
+				warn(fmt.Sprintf("座標がなく、住所 %q からも求められませんでした（到着判定・写真照合の対象外になります）", stop.Address))
+			} else if stop.Latitude == 0 || stop.Longitude == 0 {
+				warn("座標がありません（到着判定・写真照合の対象外になります）")
+			} else if !isInJapan(stop.Latitude, stop.Longitude) {
+				warn(fmt.Sprintf("座標 (%.6f, %.6f) が日本国外です（緯度・経度の入れ違いの可能性があります）", stop.Latitude, stop.Longitude))
+			}
+		}
+
+		// 時刻
+		if stop.ArrivalTime == "" {
+			warn("到着予定時刻が空です")
+		} else if !isValidClockTime(stop.ArrivalTime) {
+			warn(fmt.Sprintf("到着予定時刻 %q を解釈できません（HH:MM形式）", stop.ArrivalTime))
+		}
+		if stop.DesiredTimeStart != "" && !isValidClockTime(stop.DesiredTimeStart) {
+			warn(fmt.Sprintf("希望時間帯（開始） %q を解釈できません（HH:MM形式）", stop.DesiredTimeStart))
+		}
+		if stop.DesiredTimeEnd != "" && !isValidClockTime(stop.DesiredTimeEnd) {
+			warn(fmt.Sprintf("希望時間帯（終了） %q を解釈できません（HH:MM形式）", stop.DesiredTimeEnd))
+		}
+
+		// 順番
+		if _, ok := parseSequenceNo(stop.Sequence); !ok {
+			warn(fmt.Sprintf("順番 %q が数値ではありません（直前の行の次として並べます）", stop.Sequence))
+		}
+		if seenSequences[stop.CourseName] == nil {
+			seenSequences[stop.CourseName] = make(map[string]int)
+		}
+		if firstRow, exists := seenSequences[stop.CourseName][stop.Sequence]; exists {
+			warn(fmt.Sprintf("順番 %q が重複しています（%d行目と同じ）", stop.Sequence, firstRow))
+		} else {
+			seenSequences[stop.CourseName][stop.Sequence] = stop.Row
+		}
+	}
+
+	return issues
+}
+
+// isInJapan は座標が日本国内の範囲にあるかを判定する
+func isInJapan(lat, lon float64) bool {
+	return lat >= japanMinLatitude && lat <= japanMaxLatitude &&
+		lon >= japanMinLongitude && lon <= japanMaxLongitude
+}
+
+// isValidClockTime は "HH:MM" 形式の時刻として解釈できるかを判定する（深夜便の 24:00 以降も許容）
+func isValidClockTime(s string) bool {
+	parts := strings.Split(s, ":")
+	if len(parts) != 2 {
+		return false
+	}
+	hours, err := strconv.Atoi(parts[0])
+	if err != nil || hours < 0 || hours >= 48 {
+		return false
+	}
+	minutes, err := strconv.Atoi(parts[1])
+	if err != nil || minutes < 0 || minutes >= 60 {
+		return false
+	}
+	return true
+}
+
+// routeStopMatch は既存の停車地とCSVの行の対応付け結果
+type routeStopMatch struct {
+	Matched []matchedRouteStop   // 既存の停車地に対応する行（IDを引き継いで更新）
+	Added   []RouteStop          // 新規に追加する行
+	Removed []database.RouteStop // CSVから消えた既存の停車地
+}
+
+type matchedRouteStop struct {
+	Existing database.RouteStop
+	Incoming RouteStop
+}
+
+// matchRouteStops は既存の停車地とCSVの行を対応付ける
+// 1. コース内で「名称＋住所」が一意に一致するものを同一地点とみなす（順番が入れ替わっても地点IDを維持）
+// 2. 残りをコース＋順番で対応付ける
+func matchRouteStops(current []database.RouteStop, incoming []RouteStop) routeStopMatch {
+	var result routeStopMatch
+	matchedExisting := make(map[int64]bool)
+	matchedIncoming := make(map[int]bool)
+
+	match := func(existingIdx []int, incomingIdx []int) {
+		result.Matched = append(result.Matched, matchedRouteStop{
+			Existing: current[existingIdx[0]],
+			Incoming: incoming[incomingIdx[0]],
+		})
+		matchedExisting[current[existingIdx[0]].ID] = true
+		matchedIncoming[incomingIdx[0]] = true
+	}
+
+	// 1. 名称＋住所（コース内で一意な場合のみ）
+	existingByStable := make(map[string][]int)
+	for i, stop := range current {
+		k := stableStopKey(stop.CourseName, stop.StopName, stop.Address.String)
+		existingByStable[k] = append(existingByStable[k], i)
+	}
+	incomingByStable := make(map[string][]int)
+	for i, stop := range incoming {
+		k := stableStopKey(stop.CourseName, stop.StopName, stop.Address)
+		incomingByStable[k] = append(incomingByStable[k], i)
+	}
+	for k, incomingIdx := range incomingByStable {
+		existingIdx := existingByStable[k]
+		if len(existingIdx) == 1 && len(incomingIdx) == 1 {
+			match(existingIdx, incomingIdx)
+		}
+	}
+
+	// 2. コース＋順番
+	existingBySequence := make(map[string][]int)
+	for i, stop := range current {
+		if matchedExisting[stop.ID] {
+			continue
+		}
+		k := stop.CourseName + "\x00" + stop.Sequence
+		existingBySequence[k] = append(existingBySequence[k], i)
+	}
+	for i, stop := range incoming {
+		if matchedIncoming[i] {
+			continue
+		}
+		k := stop.CourseName + "\x00" + stop.Sequence
+		if existingIdx := existingBySequence[k]; len(existingIdx) > 0 {
+			match(existingIdx, []int{i})
+			existingBySequence[k] = existingIdx[1:]
+		}
+	}
+
+	// CSVの行順を保つ
+	sort.SliceStable(result.Matched, func(i, j int) bool {
+		return result.Matched[i].Incoming.Row < result.Matched[j].Incoming.Row
+	})
+	for i, stop := range incoming {
+		if !matchedIncoming[i] {
+			result.Added = append(result.Added, stop)
+		}
+	}
+	for _, stop := range current {
+		if !matchedExisting[stop.ID] {
+			result.Removed = append(result.Removed, stop)
+		}
+	}
+	return result
+}
+
+// stableStopKey は順番に依存しない地点の識別キー（コース＋名称＋住所、空白は無視）
+func stableStopKey(courseName, stopName, address string) string {
+	normalize := func(s string) string {
+		return strings.Join(strings.Fields(s), "")
+	}
+	return courseName + "\x00" + normalize(stopName) + "\x00" + normalize(address)
+}
+
+// routeImportResult は停車地の差分反映結果
+type routeImportResult struct {
+	Updated   int
+	Unchanged int
+	Kept      int // 画面からの修正を保持したため変更しなかった停車地
+	Added     int
+	Removed   int
+}
+
+// applyRouteStops はCSVの行を指定した運行日の既存の停車地に差分反映する（対応する地点は更新、新規は追加、消えた地点のみ削除）
+// 他の運行日の停車地には影響しない。未登録のコースは作成する。呼び出し側でトランザクションを張ること
+// guard で保持を指定した場合、画面から修正した停車地は変更・削除せず、画面から削除した停車地は追加しない
+func applyRouteStops(ctx context.Context, q *database.Queries, lpID int64, operatingDate string, stops []RouteStop, guard manualEditGuard) (routeImportResult, error) {
+	var result routeImportResult
+	stops = scheduleRouteStops(operatingDate, guard.filterIncoming(stops))
+
+	courseIDs := make(map[string]sql.NullInt64)
+	for _, stop := range stops {
+		if _, ok := courseIDs[stop.CourseName]; ok {
+			continue
+		}
+		course, err := ensureCourse(ctx, q, lpID, stop.CourseName)
+		if err != nil {
+			return result, err
+		}
+		courseIDs[stop.CourseName] = sql.NullInt64{Int64: course.ID, Valid: true}
+	}
+
+	current, err := q.ListRouteStopsByProjectDate(ctx, database.ListRouteStopsByProjectDateParams{
+		ProjectID:     lpID,
+		OperatingDate: operatingDate,
+	})
+	if err != nil {
+		return result, fmt.Errorf("既存データ取得失敗: %w", err)
+	}
+	match := matchRouteStops(current, stops)
+
+	for _, m := range match.Matched {
+		if guard.keepsExisting(m.Existing) {
+			result.Kept++
+			continue
+		}
+		// 他の地点の時刻の変更で日付をまたぐかどうかが変わることがあるため、並び順・到着予定日時も比較する
+		if len(diffRouteStop(m.Existing, m.Incoming)) == 0 && sameSchedule(m.Existing, m.Incoming) {
+			result.Unchanged++
+			continue
+		}
+		stop := m.Incoming
+		err := q.UpdateRouteStop(ctx, database.UpdateRouteStopParams{
+			ID:                m.Existing.ID,
+			CourseID:          courseIDs[stop.CourseName],
+			CourseName:        stop.CourseName,
+			Sequence:          stop.Sequence,
+			SequenceNo:        stop.SequenceNo,
+			ArrivalTime:       toNullString(stop.ArrivalTime),
+			PlannedAt:         stop.PlannedAt,
+			StopName:          stop.StopName,
+			Address:           toNullString(stop.Address),
+			Latitude:          toNullFloat64(stop.Latitude),
+			Longitude:         toNullFloat64(stop.Longitude),
+			StayMinutes:       toNullInt64(stop.StayMinutes),
+			WeightKg:          toNullInt64(stop.WeightKg),
+			LoadDirection:     stop.LoadDirection,
+			PhoneNumber:       toNullString(stop.PhoneNumber),
+			Note1:             toNullString(stop.Note1),
+			Note2:             toNullString(stop.Note2),
+			Note3:             toNullString(stop.Note3),
+			DesiredTimeStart:  toNullString(stop.DesiredTimeStart),
+			DesiredTimeEnd:    toNullString(stop.DesiredTimeEnd),
+			GeocodeSource:     toNullString(stop.GeocodeSource),
+			GeocodeConfidence: toNullFloat64(stop.GeocodeConfidence),
+			GeocodeStatus:     toNullString(stop.GeocodeStatus),
+			CustomerCode:      toNullString(stop.CustomerCode),
+			LocationID:        toNullInt64(stop.LocationID),
+			GeofenceRadiusM:   toNullInt64(stop.GeofenceRadiusM),
+			GeofencePolygon:   toNullString(stop.GeofencePolygon),
+		})
+		if err != nil {
+			return result, fmt.Errorf("%d行目の更新失敗: %w", stop.Row, err)
+		}
+		result.Updated++
+	}
+
+	for _, stop := range match.Added {
+		_, err := q.CreateRouteStop(ctx, database.CreateRouteStopParams{
+			ProjectID:         lpID,
+			OperatingDate:     operatingDate,
+			CourseID:          courseIDs[stop.CourseName],
+			CourseName:        stop.CourseName,
+			Sequence:          stop.Sequence,
+			SequenceNo:        stop.SequenceNo,
+			ArrivalTime:       toNullString(stop.ArrivalTime),
+			PlannedAt:         stop.PlannedAt,
+			StopName:          stop.StopName,
+			Address:           toNullString(stop.Address),
+			Latitude:          toNullFloat64(stop.Latitude),
+			Longitude:         toNullFloat64(stop.Longitude),
+			StayMinutes:       toNullInt64(stop.StayMinutes),
+			WeightKg:          toNullInt64(stop.WeightKg),
+			LoadDirection:     stop.LoadDirection,
+			PhoneNumber:       toNullString(stop.PhoneNumber),
+			Note1:             toNullString(stop.Note1),
+			Note2:             toNullString(stop.Note2),
+			Note3:             toNullString(stop.Note3),
+			DesiredTimeStart:  toNullString(stop.DesiredTimeStart),
+			DesiredTimeEnd:    toNullString(stop.DesiredTimeEnd),
+			GeocodeSource:     toNullString(stop.GeocodeSource),
+			GeocodeConfidence: toNullFloat64(stop.GeocodeConfidence),
+			GeocodeStatus:     toNullString(stop.GeocodeStatus),
+			CustomerCode:      toNullString(stop.CustomerCode),
+			LocationID:        toNullInt64(stop.LocationID),
+			GeofenceRadiusM:   toNullInt64(stop.GeofenceRadiusM),
+			GeofencePolygon:   toNullString(stop.GeofencePolygon),
+		})
+		if err != nil {
+			return result, fmt.Errorf("%d行目の挿入失敗: %w", stop.Row, err)
+		}
+		result.Added++
+	}
+
+	for _, stop := range match.Removed {
+		if guard.keepsExisting(stop) {
+			result.Kept++
+			continue
+		}
+		if err := q.DeleteRouteStop(ctx, stop.ID); err != nil {
+			return result, fmt.Errorf("停車地 %s の削除失敗: %w", stop.StopName, err)
+		}
+		result.Removed++
+	}
+
+	return result, nil
+}
+
+// buildImportCourseSummaries は現在の停車地と取り込み予定の停車地の差分をコース単位でまとめる
+// 対応付けは実際の取り込みと同じ matchRouteStops を使い、guard で保持する停車地は差分に含めない
+func buildImportCourseSummaries(current []database.RouteStop, incoming []RouteStop, guard manualEditGuard) []components.ImportCourseSummary {
+	incoming = guard.filterIncoming(incoming)
+	summaries := make(map[string]*components.ImportCourseSummary)
+	summaryFor := func(course string) *components.ImportCourseSummary {
+		s, ok := summaries[course]
+		if !ok {
+			s = &components.ImportCourseSummary{CourseName: course}
+			summaries[course] = s
+		}
+		return s
+	}
+
+	for _, stop := range current {
+		summaryFor(stop.CourseName).CurrentCount++
+	}
+	incomingByCourse := make(map[string][]RouteStop)
+	for _, stop := range incoming {
+		summaryFor(stop.CourseName).NewCount++
+		incomingByCourse[stop.CourseName] = append(incomingByCourse[stop.CourseName], stop)
+	}
+	for course, stops := range incomingByCourse {
+		_, _, peakKg := plannedLoad(csvLoadSteps(stops))
+		summaryFor(course).PeakLoadKg = peakKg
+	}
+
+	match := matchRouteStops(current, incoming)
+	for _, m := range match.Matched {
+		if guard.keepsExisting(m.Existing) {
+			continue
+		}
+		if changes := diffRouteStop(m.Existing, m.Incoming); len(changes) > 0 {
+			s := summaryFor(m.Incoming.CourseName)
+			s.Changed = append(s.Changed, components.ImportDiffEntry{Sequence: m.Incoming.Sequence, StopName: m.Incoming.StopName, Changes: changes})
+		}
+	}
+	for _, stop := range match.Added {
+		s := summaryFor(stop.CourseName)
+		s.Added = append(s.Added, components.ImportDiffEntry{Sequence: stop.Sequence, StopName: stop.StopName})
+	}
+	for _, stop := range match.Removed {
+		if guard.keepsExisting(stop) {
+			continue
+		}
+		s := summaryFor(stop.CourseName)
+		s.Removed = append(s.Removed, components.ImportDiffEntry{Sequence: stop.Sequence, StopName: stop.StopName})
+	}
+
+	result := make([]components.ImportCourseSummary, 0, len(summaries))
+	for _, s := range summaries {
+		result = append(result, *s)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].CourseName < result[j].CourseName
+	})
+	return result
+}
+
+// sameSchedule は既存の停車地とCSVの行で並び順・到着予定日時が同じかどうか
+func sameSchedule(existing database.RouteStop, incoming RouteStop) bool {
+	if existing.SequenceNo != incoming.SequenceNo || existing.PlannedAt.Valid != incoming.PlannedAt.Valid {
+		return false
+	}
+	return !existing.PlannedAt.Valid || existing.PlannedAt.Time.Equal(incoming.PlannedAt.Time)
+}
+
+// diffRouteStop は既存の停車地とCSVの行で変わる項目を列挙する
+func diffRouteStop(existing database.RouteStop, incoming RouteStop) []string {
+	var changes []string
+	compare := func(label, before, after string) {
+		if before != after {
+			changes = append(changes, fmt.Sprintf("%s: %s → %s", label, displayOrDash(before), displayOrDash(after)))
+		}
+	}
+
+	compare("順番", existing.Sequence, incoming.Sequence)
+	compare("名称", existing.StopName, incoming.StopName)
+	compare("到着予定", existing.ArrivalTime.String, incoming.ArrivalTime)
+	compare("住所", existing.Address.String, incoming.Address)
+	if math.Abs(existing.Latitude.Float64-incoming.Latitude) > 1e-7 || math.Abs(existing.Longitude.Float64-incoming.Longitude) > 1e-7 {
+		changes = append(changes, fmt.Sprintf("座標: %.6f,%.6f → %.6f,%.6f",
+			existing.Latitude.Float64, existing.Longitude.Float64, incoming.Latitude, incoming.Longitude))
+	}
+	compare("滞在予定", fmt.Sprintf("%d分", existing.StayMinutes.Int64), fmt.Sprintf("%d分", incoming.StayMinutes))
+	compare("重量", fmt.Sprintf("%dkg", existing.WeightKg.Int64), fmt.Sprintf("%dkg", incoming.WeightKg))
+	compare("積降区分", components.LoadDirectionLabel(existing.LoadDirection), components.LoadDirectionLabel(incoming.LoadDirection))
+	compare("電話番号", existing.PhoneNumber.String, incoming.PhoneNumber)
+	compare("顧客コード", existing.CustomerCode.String, incoming.CustomerCode)
+	compare("到着判定の範囲", geofenceRadiusLabel(existing.GeofenceRadiusM.Int64), geofenceRadiusLabel(incoming.GeofenceRadiusM))
+	if existing.GeofencePolygon.String != incoming.GeofencePolygon {
+		changes = append(changes, fmt.Sprintf("到着判定のエリア: %s → %s",
+			displayOrDash(geofencePolygonLabel(existing.GeofencePolygon.String)), displayOrDash(geofencePolygonLabel(incoming.GeofencePolygon))))
+	}
+	compare("希望時間帯", existing.DesiredTimeStart.String+"〜"+existing.DesiredTimeEnd.String, incoming.DesiredTimeStart+"〜"+incoming.DesiredTimeEnd)
+	compare("備考1", existing.Note1.String, incoming.Note1)
+	compare("備考2", existing.Note2.String, incoming.Note2)
+	compare("備考3", existing.Note3.String, incoming.Note3)
+	return changes
+}
+
+// displayOrDash は空文字を "-" に置き換える
+func displayOrDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// minutesToTime は分数を "HH:MM" 形式に変換する
+func minutesToTime(minutes int) string {
+	// 負の値や24時間超えに対応
+	for minutes < 0 {
+		minutes += 24 * 60
+	}
+	minutes = minutes % (24 * 60)
+	return fmt.Sprintf("%02d:%02d", minutes/60, minutes%60)
+}
+
+// filterDepartureRows は「出発」行を除外する
+func filterDepartureRows(stops []RouteStop) []RouteStop {
+	var filtered []RouteStop
+	for _, stop := range stops {
+		if stop.Sequence != departureSequence {
+			filtered = append(filtered, stop)
+		}
+	}
+	return filtered
+}
+
+// adjustArrivalTimes は各コースの到着時間を調整する
+func adjustArrivalTimes(stops []RouteStop, newStartTime string) []RouteStop {
+	if len(stops) == 0 {
+		return stops
+	}
+
+	newStartMinutes, err := parseTimeToMinutes(newStartTime)
+	if err != nil {
+		return stops // パースエラーの場合は変更しない
+	}
+
+	// コースごとにグループ化
+	courseStops := make(map[string][]int) // コース名 -> stopsのインデックス
+	for i, stop := range stops {
+		courseStops[stop.CourseName] = append(courseStops[stop.CourseName], i)
+	}
+
+	// 各コースの時間を調整
+	for _, indices := range courseStops {
+		if len(indices) == 0 {
+			continue
+		}
+
+		// 先頭地点の元の到着時間を取得
+		firstIdx := indices[0]
+		originalStartMinutes, err := parseTimeToMinutes(stops[firstIdx].ArrivalTime)
+		if err != nil {
+			continue // パースエラーの場合はこのコースをスキップ
+		}
+
+		// 差分を計算
+		diff := newStartMinutes - originalStartMinutes
+
+		// 全地点の到着時間をシフト
+		for _, idx := range indices {
+			originalMinutes, err := parseTimeToMinutes(stops[idx].ArrivalTime)
+			if err != nil {
+				continue
+			}
+			stops[idx].ArrivalTime = minutesToTime(originalMinutes + diff)
+		}
+	}
+
+	return stops
+}
@@ -0,0 +1,286 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/optimizer"
+	"github.com/naozine/project_crud_with_auth_tmpl/web/components"
+	"github.com/naozine/project_crud_with_auth_tmpl/web/layouts"
+)
+
+// optimizeMatrix はルート最適化で使う移動モデル（道路の所要時間の表を使う場合はここを差し替える）
+var optimizeMatrix optimizer.Matrix = optimizer.HaversineMatrix{
+	SpeedKmh:     optimizer.DefaultSpeedKmh,
+	DetourFactor: optimizer.DefaultDetourFactor,
+}
+
+// parseObjective は ?objective= を最適化の目的にする（未指定・不明な値は距離優先）
+func parseObjective(s string) optimizer.Objective {
+	if s == string(optimizer.ObjectiveLateness) {
+		return optimizer.ObjectiveLateness
+	}
+	return optimizer.ObjectiveDistance
+}
+
+// buildOptimizeProblem はコースの停車地から最適化の入力を作る
+// 「出発」行（なければコースの出発拠点）を出発地点とし、出発時刻は「出発」行の到着予定 → コースの出発予定時刻 → 最初の地点の到着予定の順に決める。
+// 戻り値の stops は「出発」行を除いた現在の順番の停車地（Problem.Stops と同じ並び）、missing は座標のない停車地の名称
+func buildOptimizeProblem(course database.Course, operatingDate string, all []database.RouteStop, objective optimizer.Objective) (optimizer.Problem, []database.RouteStop, []string, error) {
+	problem := optimizer.Problem{Objective: objective, Matrix: optimizeMatrix}
+
+	var stops []database.RouteStop
+	var missing []string
+	var departure *database.RouteStop
+	for i, stop := range all {
+		if stop.Sequence == departureSequence {
+			departure = &all[i]
+			continue
+		}
+		stops = append(stops, stop)
+		if !stop.Latitude.Valid || !stop.Longitude.Valid {
+			missing = append(missing, stop.StopName)
+			continue
+		}
+		s := optimizer.Stop{
+			ID:        stop.ID,
+			Latitude:  stop.Latitude.Float64,
+			Longitude: stop.Longitude.Float64,
+			Stay:      time.Duration(stop.StayMinutes.Int64) * time.Minute,
+		}
+		start, end := desiredWindow(stop)
+		if start != nil {
+			s.WindowStart = *start
+		}
+		if end != nil {
+			s.WindowEnd = *end
+		}
+		problem.Stops = append(problem.Stops, s)
+	}
+
+	switch {
+	case departure != nil && departure.Latitude.Valid && departure.Longitude.Valid:
+		problem.Depot = &optimizer.Stop{Latitude: departure.Latitude.Float64, Longitude: departure.Longitude.Float64}
+	case course.DepotLatitude.Valid && course.DepotLongitude.Valid:
+		problem.Depot = &optimizer.Stop{Latitude: course.DepotLatitude.Float64, Longitude: course.DepotLongitude.Float64}
+	}
+
+	switch {
+	case departure != nil && departure.PlannedAt.Valid:
+		problem.StartTime = departure.PlannedAt.Time
+	case course.PlannedStartTime.Valid && isValidClockTime(course.PlannedStartTime.String):
+		t, err := plannedTimeOnDate(operatingDate, course.PlannedStartTime.String)
+		if err != nil {
+			return problem, stops, missing, err
+		}
+		problem.StartTime = t
+	case len(stops) > 0 && stops[0].PlannedAt.Valid:
+		problem.StartTime = stops[0].PlannedAt.Time
+	default:
+		return problem, stops, missing, errors.New("出発時刻が分からないため最適化できません（「出発」行の到着予定かコースの出発予定時刻を設定してください）")
+	}
+	return problem, stops, missing, nil
+}
+
+// optimizeSummary は予定の評価を表示用にまとめる
+func optimizeSummary(plan optimizer.Plan) components.OptimizeSummary {
+	summary := components.OptimizeSummary{
+		DistanceKm:  plan.DistanceKm,
+		LateStops:   plan.LateStops,
+		LateMinutes: int(plan.Lateness.Minutes()),
+	}
+	if len(plan.Visits) > 0 {
+		summary.Finish = plan.Finish.In(JST).Format("15:04")
+	}
+	return summary
+}
+
+// ShowOptimizeRoute はコースの停車地の訪問順の最適化案を現在の順番と比較して表示する
+func (h *ProjectHandler) ShowOptimizeRoute(c echo.Context) error {
+	ctx := c.Request().Context()
+	lp, course, err := h.getCourse(c)
+	if err != nil {
+		return err
+	}
+	operatingDate, _, err := h.selectOperatingDate(c, lp.ID)
+	if err != nil {
+		return err
+	}
+	objective := parseObjective(c.QueryParam("objective"))
+
+	all, err := h.DB.ListRouteStopsByCourse(ctx, database.ListRouteStopsByCourseParams{
+		ProjectID:     lp.ID,
+		OperatingDate: operatingDate,
+		CourseName:    course.Name,
+	})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	data := components.CourseOptimizeData{
+		OperatingDate: operatingDate,
+		Objective:     string(objective),
+	}
+	problem, stops, missing, err := buildOptimizeProblem(course, operatingDate, all, objective)
+	switch {
+	case err != nil:
+		data.Error = err.Error()
+	case len(missing) > 0:
+		data.Error = "座標のない停車地があるため最適化できません: " + strings.Join(missing, "、")
+	case len(stops) < 2:
+		data.Error = "停車地が2件以上ないため最適化できません"
+	default:
+		current := make([]int, len(problem.Stops))
+		for i := range current {
+			current[i] = i
+		}
+		before := optimizer.Evaluate(problem, current)
+		after := optimizer.Optimize(problem)
+
+		data.StartTime = problem.StartTime.In(JST).Format("15:04")
+		data.HasDepot = problem.Depot != nil
+		data.Before = optimizeSummary(before)
+		data.After = optimizeSummary(after)
+		for k, i := range after.Order {
+			stop := stops[i]
+			visit := after.Visits[k]
+			data.Rows = append(data.Rows, components.OptimizeRow{
+				Stop:            stop,
+				NewSequence:     k + 1,
+				ProposedArrival: visit.Arrival.In(JST).Format("15:04"),
+				WaitMinutes:     int(visit.Wait.Minutes()),
+				LateMinutes:     int(visit.Late.Minutes()),
+			})
+		}
+	}
+
+	content := components.CourseOptimize(lp, course, data)
+	c.Response().Header().Set(echo.HeaderContentType, echo.MIMETextHTML)
+	if c.Request().Header.Get("HX-Request") == "true" {
+		return content.Render(ctx, c.Response().Writer)
+	}
+	return layouts.Base(course.Name+" のルート最適化", content).Render(ctx, c.Response().Writer)
+}
+
+// ApplyOptimizedRoute は最適化案の順番と到着予定をコースに反映する
+// フォームの stop_id と arrival_time は最適化案の順に並んだ組。表示後に停車地が変わっていれば反映しない
+func (h *ProjectHandler) ApplyOptimizedRoute(c echo.Context) error {
+	if err := h.checkPermission(c); err != nil {
+		return err
+	}
+	ctx := c.Request().Context()
+	lp, course, err := h.getCourse(c)
+	if err != nil {
+		return err
+	}
+	operatingDate := c.FormValue("date")
+	if _, err := parseOperatingDate(operatingDate); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効な運行日")
+	}
+	form, err := c.FormParams()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なフォーム")
+	}
+	ids, arrivals := form["stop_id"], form["arrival_time"]
+	if len(ids) == 0 || len(ids) != len(arrivals) {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効な最適化案")
+	}
+	order := make([]int64, len(ids))
+	proposed := make(map[int64]string, len(ids))
+	markIDs := make(map[int64]bool, len(ids))
+	for i, v := range ids {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "無効な地点ID")
+		}
+		if !isValidClockTime(arrivals[i]) {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("無効な到着予定: %s", arrivals[i]))
+		}
+		order[i] = id
+		proposed[id] = arrivals[i]
+		markIDs[id] = true
+	}
+
+	tx, err := h.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("トランザクション開始失敗: %v", err))
+	}
+	defer tx.Rollback()
+	qtx := h.DB.WithTx(tx)
+
+	before, err := qtx.ListRouteStopsByCourse(ctx, database.ListRouteStopsByCourseParams{
+		ProjectID:     lp.ID,
+		OperatingDate: operatingDate,
+		CourseName:    course.Name,
+	})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	previous := make(map[int64]database.RouteStop, len(before))
+	for _, stop := range before {
+		if stop.Sequence == departureSequence {
+			continue
+		}
+		if _, ok := proposed[stop.ID]; !ok {
+			return echo.NewHTTPError(http.StatusConflict, "最適化案の表示後に停車地が変更されました。もう一度最適化してください")
+		}
+		previous[stop.ID] = stop
+	}
+	if len(previous) != len(order) {
+		return echo.NewHTTPError(http.StatusConflict, "最適化案の表示後に停車地が変更されました。もう一度最適化してください")
+	}
+
+	now := sql.NullTime{Time: time.Now(), Valid: true}
+	for _, id := range order {
+		if previous[id].ArrivalTime.String == proposed[id] {
+			continue
+		}
+		if err := qtx.UpdateRouteStopArrivalTime(ctx, database.UpdateRouteStopArrivalTimeParams{
+			ArrivalTime:      toNullString(proposed[id]),
+			ManuallyEditedAt: now,
+			ID:               id,
+		}); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("到着予定の更新失敗: %v", err))
+		}
+	}
+
+	after, err := resequenceCourse(ctx, qtx, lp.ID, operatingDate, course, order, markIDs)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	objective := parseObjective(c.FormValue("objective"))
+	for _, stop := range after {
+		prev, ok := previous[stop.ID]
+		if !ok {
+			continue
+		}
+		var changes []string
+		if prev.Sequence != stop.Sequence {
+			changes = append(changes, fmt.Sprintf("順番 %s → %s", prev.Sequence, stop.Sequence))
+		}
+		if prev.ArrivalTime.String != proposed[stop.ID] {
+			changes = append(changes, fmt.Sprintf("到着予定 %s → %s", prev.ArrivalTime.String, proposed[stop.ID]))
+		}
+		if len(changes) == 0 {
+			continue
+		}
+		detail := components.OptimizeObjectiveLabel(string(objective)) + ": " + strings.Join(changes, "、")
+		if err := recordStopEdit(ctx, qtx, stop, stopEditOptimize, detail); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("コミット失敗: %v", err))
+	}
+
+	// PRG: コース詳細ページへリダイレクト
+	return c.Redirect(http.StatusSeeOther, courseStopsRedirect(lp.ID, course.Name, operatingDate))
+}
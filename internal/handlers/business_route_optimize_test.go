@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/optimizer"
+)
+
+func TestBuildOptimizeProblem(t *testing.T) {
+	stop := func(id int64, sequence, planned string, lat, lon float64) database.RouteStop {
+		s := database.RouteStop{
+			ID:        id,
+			Sequence:  sequence,
+			StopName:  sequence,
+			Latitude:  sql.NullFloat64{Float64: lat, Valid: true},
+			Longitude: sql.NullFloat64{Float64: lon, Valid: true},
+		}
+		if planned != "" {
+			s.PlannedAt = plannedAt("2025-12-01 " + planned)
+		}
+		return s
+	}
+	departure := stop(1, departureSequence, "08:30", 35.68, 139.76)
+	a := stop(2, "1", "09:00", 35.69, 139.77)
+	b := stop(3, "2", "09:30", 35.70, 139.78)
+	course := database.Course{Name: "1便"}
+
+	// 「出発」行が途中にあっても出発地点として扱い、訪問する地点には含めない
+	problem, stops, missing, err := buildOptimizeProblem(course, "2025-12-01", []database.RouteStop{a, departure, b}, optimizer.ObjectiveDistance)
+	if err != nil {
+		t.Fatalf("buildOptimizeProblem: %v", err)
+	}
+	if len(missing) != 0 {
+		t.Errorf("missing = %v, want なし", missing)
+	}
+	if problem.Depot == nil || problem.Depot.Latitude != 35.68 || problem.Depot.Longitude != 139.76 {
+		t.Fatalf("Depot = %+v, want 「出発」行の座標", problem.Depot)
+	}
+	if !problem.StartTime.Equal(jstAt("2025-12-01 08:30")) {
+		t.Errorf("StartTime = %v, want 「出発」行の到着予定", problem.StartTime)
+	}
+	if len(stops) != 2 || stops[0].ID != a.ID || stops[1].ID != b.ID {
+		t.Errorf("stops = %v, want 「出発」行を除いた A, B", stops)
+	}
+	if len(problem.Stops) != 2 || problem.Stops[0].ID != a.ID || problem.Stops[1].ID != b.ID {
+		t.Errorf("Problem.Stops = %+v, want A, B", problem.Stops)
+	}
+	// 最適化の結果は「出発」行を除いた地点の順番になり、出発地点は常に先頭（Depot）に残る
+	for _, i := range optimizer.Optimize(problem).Order {
+		if stops[i].Sequence == departureSequence {
+			t.Errorf("最適化の訪問順に「出発」行が含まれている")
+		}
+	}
+
+	// 「出発」行がなければコースの出発拠点・出発予定時刻を使う
+	course.DepotLatitude = sql.NullFloat64{Float64: 35.6, Valid: true}
+	course.DepotLongitude = sql.NullFloat64{Float64: 139.6, Valid: true}
+	course.PlannedStartTime = sql.NullString{String: "08:00", Valid: true}
+	problem, _, _, err = buildOptimizeProblem(course, "2025-12-01", []database.RouteStop{a, b}, optimizer.ObjectiveDistance)
+	if err != nil {
+		t.Fatalf("buildOptimizeProblem: %v", err)
+	}
+	if problem.Depot == nil || problem.Depot.Latitude != 35.6 || !problem.StartTime.Equal(jstAt("2025-12-01 08:00")) {
+		t.Errorf("Depot = %+v, StartTime = %v, want コースの出発拠点と 08:00", problem.Depot, problem.StartTime)
+	}
+
+	// 出発時刻が分からなければ最適化しない
+	noTime := database.Course{Name: "2便"}
+	if _, _, _, err := buildOptimizeProblem(noTime, "2025-12-01", []database.RouteStop{stop(4, "1", "", 35.69, 139.77)}, optimizer.ObjectiveDistance); err == nil {
+		t.Errorf("出発時刻がない場合に error を返さない")
+	}
+}
@@ -0,0 +1,256 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/appcontext"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/visits"
+	"github.com/naozine/project_crud_with_auth_tmpl/web/components"
+)
+
+// 手動での指定の変更履歴の操作（stop_override_logs.action）
+const (
+	stopOverrideSet   = "set"
+	stopOverrideClear = "clear"
+)
+
+// stopOverrideForm は到着・出発の手動での指定のフォーム
+type stopOverrideForm struct {
+	Status     string
+	ArrivedAt  sql.NullTime
+	DepartedAt sql.NullTime
+	Reason     string
+}
+
+// parseStopOverrideForm はフォームの状態・到着／出発時刻（"HH:MM"）・理由を検証する
+// 到着時刻は停車地の到着予定に最も近い日の時刻とし（0時をまたぐ運行で前日・翌日にずれないように）、出発時刻が到着時刻より前なら翌日の時刻とする
+func parseStopOverrideForm(c echo.Context, stop database.RouteStop) (stopOverrideForm, error) {
+	in := stopOverrideForm{
+		Status: c.FormValue("status"),
+		Reason: strings.TrimSpace(c.FormValue("reason")),
+	}
+	if in.Reason == "" {
+		return in, fmt.Errorf("理由を入力してください")
+	}
+	switch in.Status {
+	case visits.StatusNotArrived:
+		// 未到着の指定では時刻を持たない
+		return in, nil
+	case visits.StatusArrived:
+	default:
+		return in, fmt.Errorf("無効な状態です")
+	}
+
+	arrival := strings.TrimSpace(c.FormValue("arrival_time"))
+	if arrival == "" {
+		return in, fmt.Errorf("到着済みにする場合は到着時刻を入力してください")
+	}
+	if !isValidClockTime(arrival) {
+		return in, fmt.Errorf("到着時刻は HH:MM 形式で入力してください")
+	}
+	arrivedAt, err := plannedTimeOnDate(stop.OperatingDate, arrival)
+	if err != nil {
+		return in, fmt.Errorf("到着時刻は HH:MM 形式で入力してください")
+	}
+	// 24時以降の表記は運行日の翌日の時刻としてそのまま使う
+	if minutes, _ := parseTimeToMinutes(arrival); stop.PlannedAt.Valid && minutes < 24*60 {
+		arrivedAt = nearestDay(arrivedAt, stop.PlannedAt.Time)
+	}
+	in.ArrivedAt = sql.NullTime{Time: arrivedAt, Valid: true}
+
+	if departure := strings.TrimSpace(c.FormValue("departure_time")); departure != "" {
+		if !isValidClockTime(departure) {
+			return in, fmt.Errorf("出発時刻は HH:MM 形式で入力してください")
+		}
+		departedAt, err := plannedTimeOnDate(arrivedAt.In(JST).Format(operatingDateLayout), departure)
+		if err != nil {
+			return in, fmt.Errorf("出発時刻は HH:MM 形式で入力してください")
+		}
+		if departedAt.Before(arrivedAt) {
+			departedAt = departedAt.AddDate(0, 0, 1)
+		}
+		// 翌日に回しても到着から半日以上あとになるのは入力の誤りとみなす
+		if departedAt.Sub(arrivedAt) >= overnightThreshold {
+			return in, fmt.Errorf("出発時刻は到着時刻より後にしてください")
+		}
+		in.DepartedAt = sql.NullTime{Time: departedAt, Valid: true}
+	}
+	return in, nil
+}
+
+// nearestDay は t と同じ時刻のうち ref に最も近い日の時刻を返す
+func nearestDay(t, ref time.Time) time.Time {
+	for t.Sub(ref) > overnightThreshold {
+		t = t.AddDate(0, 0, -1)
+	}
+	for ref.Sub(t) > overnightThreshold {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t
+}
+
+// stopOverrideRedirect は地点詳細ページ（表示していた運行）のURL
+func stopOverrideRedirect(lpID int64, courseName string, stopID int64, run *database.CourseRun) string {
+	url := fmt.Sprintf("/projects/%d/courses/%s/stops/%d", lpID, courseName, stopID)
+	if run != nil {
+		url += fmt.Sprintf("?run=%d", run.ID)
+	}
+	return url
+}
+
+// SaveStopOverride は停車地の到着・出発を手動で指定する（位置情報の判定より優先する）
+func (h *ProjectHandler) SaveStopOverride(c echo.Context) error {
+	if err := h.checkPermission(c); err != nil {
+		return err
+	}
+	ctx := c.Request().Context()
+	lp, course, stop, err := h.getRouteStop(c)
+	if err != nil {
+		return err
+	}
+	run, _, err := h.selectCourseRun(c, lp.ID, stop.OperatingDate, course.Name)
+	if err != nil {
+		return err
+	}
+	in, err := parseStopOverrideForm(c, stop)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	sc := visits.Scope{Project: lp, OperatingDate: stop.OperatingDate, CourseName: course.Name, Run: run}
+
+	tx, err := h.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("トランザクション開始失敗: %v", err))
+	}
+	defer tx.Rollback()
+	qtx := h.DB.WithTx(tx)
+
+	userID := appcontext.GetUserID(ctx)
+	if err := qtx.UpsertStopOverride(ctx, database.UpsertStopOverrideParams{
+		ProjectID:     lp.ID,
+		OperatingDate: stop.OperatingDate,
+		CourseName:    course.Name,
+		CourseRunID:   sc.RunID(),
+		RouteStopID:   stop.ID,
+		Status:        in.Status,
+		ArrivedAt:     in.ArrivedAt,
+		DepartedAt:    in.DepartedAt,
+		Reason:        in.Reason,
+		UpdatedBy:     sql.NullInt64{Int64: userID, Valid: userID != 0},
+	}); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("手動での指定の保存失敗: %v", err))
+	}
+	if err := recordStopOverride(ctx, qtx, sc, stop, stopOverrideSet, in); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("コミット失敗: %v", err))
+	}
+
+	// PRG: 地点詳細ページへリダイレクト
+	return c.Redirect(http.StatusSeeOther, stopOverrideRedirect(lp.ID, course.Name, stop.ID, run))
+}
+
+// ClearStopOverride は手動での指定を取り消して位置情報の判定に戻す
+func (h *ProjectHandler) ClearStopOverride(c echo.Context) error {
+	if err := h.checkPermission(c); err != nil {
+		return err
+	}
+	ctx := c.Request().Context()
+	lp, course, stop, err := h.getRouteStop(c)
+	if err != nil {
+		return err
+	}
+	run, _, err := h.selectCourseRun(c, lp.ID, stop.OperatingDate, course.Name)
+	if err != nil {
+		return err
+	}
+	reason := strings.TrimSpace(c.FormValue("reason"))
+	if reason == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "理由を入力してください")
+	}
+	sc := visits.Scope{Project: lp, OperatingDate: stop.OperatingDate, CourseName: course.Name, Run: run}
+
+	tx, err := h.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("トランザクション開始失敗: %v", err))
+	}
+	defer tx.Rollback()
+	qtx := h.DB.WithTx(tx)
+
+	// 指定がなければ取り消すものがない（変更履歴も残さない）
+	_, err = qtx.GetStopOverride(ctx, database.GetStopOverrideParams{
+		RouteStopID: stop.ID,
+		CourseRunID: sc.RunID(),
+	})
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return echo.NewHTTPError(http.StatusNotFound, "手動での指定がありません")
+	case err != nil:
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("手動での指定の取得失敗: %v", err))
+	}
+	if err := qtx.DeleteStopOverride(ctx, database.DeleteStopOverrideParams{
+		RouteStopID: stop.ID,
+		CourseRunID: sc.RunID(),
+	}); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("手動での指定の取り消し失敗: %v", err))
+	}
+	if err := recordStopOverride(ctx, qtx, sc, stop, stopOverrideClear, stopOverrideForm{Reason: reason}); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("コミット失敗: %v", err))
+	}
+
+	// PRG: 地点詳細ページへリダイレクト
+	return c.Redirect(http.StatusSeeOther, stopOverrideRedirect(lp.ID, course.Name, stop.ID, run))
+}
+
+// recordStopOverride は手動での指定・取り消しを変更履歴に残す
+func recordStopOverride(ctx context.Context, q *database.Queries, sc visits.Scope, stop database.RouteStop, action string, in stopOverrideForm) error {
+	userID := appcontext.GetUserID(ctx)
+	err := q.CreateStopOverrideLog(ctx, database.CreateStopOverrideLogParams{
+		ProjectID:     sc.Project.ID,
+		OperatingDate: sc.OperatingDate,
+		CourseName:    sc.CourseName,
+		CourseRunID:   sc.RunID(),
+		RouteStopID:   sql.NullInt64{Int64: stop.ID, Valid: true},
+		StopName:      stop.StopName,
+		Action:        action,
+		Status:        toNullString(in.Status),
+		ArrivedAt:     in.ArrivedAt,
+		DepartedAt:    in.DepartedAt,
+		Reason:        in.Reason,
+		EditedBy:      sql.NullInt64{Int64: userID, Valid: userID != 0},
+	})
+	if err != nil {
+		return fmt.Errorf("修正履歴の記録失敗: %w", err)
+	}
+	return nil
+}
+
+// loadStopOverride は地点詳細に表示する運行の手動での指定と変更履歴を読み込む（読めなければ表示しない）
+func (h *ProjectHandler) loadStopOverride(ctx context.Context, stop database.RouteStop, run *database.CourseRun) components.StopOverrideInfo {
+	info := components.StopOverrideInfo{}
+	if run != nil {
+		info.RunID = run.ID
+	}
+	if o, err := h.DB.GetStopOverride(ctx, database.GetStopOverrideParams{RouteStopID: stop.ID, CourseRunID: info.RunID}); err == nil {
+		info.Override = &o
+	}
+	info.Logs, _ = h.DB.ListStopOverrideLogsByStop(ctx, database.ListStopOverrideLogsByStopParams{
+		RouteStopID: sql.NullInt64{Int64: stop.ID, Valid: true},
+		CourseRunID: info.RunID,
+	})
+	return info
+}
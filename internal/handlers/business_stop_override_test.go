@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
+)
+
+func TestParseStopOverrideForm(t *testing.T) {
+	// 運行日 12/1 の 22:00 発の夜間のコースで、翌 01:30 に到着予定の停車地
+	night := database.RouteStop{OperatingDate: "2025-12-01", PlannedAt: plannedAt("2025-12-02 01:30")}
+	day := database.RouteStop{OperatingDate: "2025-12-01", PlannedAt: plannedAt("2025-12-01 10:00")}
+	noPlan := database.RouteStop{OperatingDate: "2025-12-01"}
+
+	tests := []struct {
+		name               string
+		stop               database.RouteStop
+		arrival, departure string
+		wantArrival        string
+		wantDeparture      string
+		wantErr            bool
+	}{
+		{name: "日中の到着・出発", stop: day, arrival: "10:05", departure: "10:20", wantArrival: "2025-12-01 10:05", wantDeparture: "2025-12-01 10:20"},
+		{name: "出発は空欄なら滞在中", stop: day, arrival: "10:05", wantArrival: "2025-12-01 10:05"},
+		{name: "0時過ぎの到着予定の停車地は翌日の到着", stop: night, arrival: "01:40", wantArrival: "2025-12-02 01:40"},
+		{name: "0時過ぎの到着予定の停車地に0時前に着いた", stop: night, arrival: "23:50", departure: "00:10", wantArrival: "2025-12-01 23:50", wantDeparture: "2025-12-02 00:10"},
+		{name: "24時以降の表記はそのまま翌日", stop: day, arrival: "25:30", wantArrival: "2025-12-02 01:30"},
+		{name: "到着予定がなければ運行日の時刻", stop: noPlan, arrival: "01:30", wantArrival: "2025-12-01 01:30"},
+		{name: "存在しない分", stop: day, arrival: "10:75", wantErr: true},
+		{name: "存在しない時", stop: day, arrival: "99:00", wantErr: true},
+		{name: "出発が存在しない時刻", stop: day, arrival: "10:05", departure: "10:60", wantErr: true},
+		{name: "出発が到着より半日以上前", stop: day, arrival: "10:05", departure: "09:00", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{"status": {"arrived"}, "reason": {"電話で確認"}, "arrival_time": {tt.arrival}, "departure_time": {tt.departure}}
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(form.Encode()))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+			c := echo.New().NewContext(req, httptest.NewRecorder())
+
+			in, err := parseStopOverrideForm(c, tt.stop)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("parseStopOverrideForm = %+v, want error", in)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseStopOverrideForm: %v", err)
+			}
+			if got := in.ArrivedAt.Time.In(JST).Format("2006-01-02 15:04"); !in.ArrivedAt.Valid || got != tt.wantArrival {
+				t.Errorf("到着 = %q, want %q", got, tt.wantArrival)
+			}
+			gotDeparture := ""
+			if in.DepartedAt.Valid {
+				gotDeparture = in.DepartedAt.Time.In(JST).Format("2006-01-02 15:04")
+			}
+			if gotDeparture != tt.wantDeparture {
+				t.Errorf("出発 = %q, want %q", gotDeparture, tt.wantDeparture)
+			}
+		})
+	}
+}
@@ -0,0 +1,242 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/visits"
+	"github.com/naozine/project_crud_with_auth_tmpl/web/components"
+	"github.com/naozine/project_crud_with_auth_tmpl/web/layouts"
+)
+
+// timeWindowReportMaxDays はコンプライアンスレポートで一度に集計できる運行日数の上限
+const timeWindowReportMaxDays = 31
+
+// desiredWindow は停車地の希望時間帯（"HH:MM"）を日時にする
+// 到着予定日時の日付を基準とし、終了が開始以前なら翌日にまたぐ時間帯として扱う。
+// 基準日の時間帯が到着予定から overnightThreshold 以上離れる場合は前日・翌日の時間帯とみなす
+func desiredWindow(stop database.RouteStop) (start, end *time.Time) {
+	baseDate := stop.OperatingDate
+	if stop.PlannedAt.Valid {
+		baseDate = stop.PlannedAt.Time.In(JST).Format(operatingDateLayout)
+	}
+	if stop.DesiredTimeStart.Valid && isValidClockTime(stop.DesiredTimeStart.String) {
+		if t, err := plannedTimeOnDate(baseDate, stop.DesiredTimeStart.String); err == nil {
+			start = &t
+		}
+	}
+	if stop.DesiredTimeEnd.Valid && isValidClockTime(stop.DesiredTimeEnd.String) {
+		if t, err := plannedTimeOnDate(baseDate, stop.DesiredTimeEnd.String); err == nil {
+			end = &t
+		}
+	}
+	if start != nil && end != nil && !end.After(*start) {
+		t := end.AddDate(0, 0, 1)
+		end = &t
+	}
+
+	if stop.PlannedAt.Valid {
+		shift := 0
+		if start != nil && start.Sub(stop.PlannedAt.Time) >= overnightThreshold {
+			shift = -1
+		} else if end != nil && stop.PlannedAt.Time.Sub(*end) >= overnightThreshold {
+			shift = 1
+		}
+		if shift != 0 {
+			if start != nil {
+				t := start.AddDate(0, 0, shift)
+				start = &t
+			}
+			if end != nil {
+				t := end.AddDate(0, 0, shift)
+				end = &t
+			}
+		}
+	}
+	return start, end
+}
+
+// evaluateTimeWindow は到着実績を希望時間帯と比べて早着・時間内・遅延を判定する
+// 希望時間帯のない地点は TimeWindowNone、まだ到着していない地点は TimeWindowPending
+func evaluateTimeWindow(stop database.RouteStop, timing *components.StopTiming) components.TimeWindowResult {
+	start, end := desiredWindow(stop)
+	if start == nil && end == nil {
+		return components.TimeWindowResult{Status: components.TimeWindowNone}
+	}
+	if timing == nil || !timing.Arrived || timing.ArrivalTime == nil {
+		return components.TimeWindowResult{Status: components.TimeWindowPending}
+	}
+
+	arrival := *timing.ArrivalTime
+	switch {
+	case start != nil && arrival.Before(*start):
+		return components.TimeWindowResult{
+			Status:           components.TimeWindowEarly,
+			DeviationMinutes: int(start.Sub(arrival).Minutes()),
+		}
+	case end != nil && arrival.After(*end):
+		return components.TimeWindowResult{
+			Status:           components.TimeWindowLate,
+			DeviationMinutes: int(arrival.Sub(*end).Minutes()),
+		}
+	default:
+		return components.TimeWindowResult{Status: components.TimeWindowOnTime}
+	}
+}
+
+// evaluateTimeWindows はコースの全停車地の希望時間帯の判定結果を地点IDごとに返す
+func evaluateTimeWindows(stops []database.RouteStop, timings map[int64]*components.StopTiming) map[int64]components.TimeWindowResult {
+	results := make(map[int64]components.TimeWindowResult, len(stops))
+	for _, stop := range stops {
+		results[stop.ID] = evaluateTimeWindow(stop, timings[stop.ID])
+	}
+	return results
+}
+
+// ShowTimeWindowReport はコース別の希望時間帯の遵守率レポートを表示する
+// ?from= ?to= で運行日の範囲を指定（未指定なら直近の運行日までの7日間）。各コースの最新の運行で判定する
+func (h *ProjectHandler) ShowTimeWindowReport(c echo.Context) error {
+	ctx := c.Request().Context()
+	lpID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効な案件ID")
+	}
+	lp, err := h.DB.GetProject(ctx, lpID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "物流案件が見つかりません")
+	}
+
+	latest, operatingDates, err := h.selectOperatingDate(c, lpID)
+	if err != nil {
+		return err
+	}
+	to := c.QueryParam("to")
+	if to == "" {
+		to = latest
+	}
+	toDate, err := parseOperatingDate(to)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("無効な運行日: %s", to))
+	}
+	from := c.QueryParam("from")
+	if from == "" {
+		from = toDate.AddDate(0, 0, -6).Format(operatingDateLayout)
+	}
+	fromDate, err := parseOperatingDate(from)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("無効な運行日: %s", from))
+	}
+	if fromDate.After(toDate) {
+		return echo.NewHTTPError(http.StatusBadRequest, "開始日は終了日以前にしてください")
+	}
+	if toDate.Sub(fromDate) >= timeWindowReportMaxDays*24*time.Hour {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("集計できる期間は%d日までです", timeWindowReportMaxDays))
+	}
+
+	// 範囲内の運行日（古い順）
+	var dates []string
+	for _, d := range operatingDates {
+		if d >= from && d <= to {
+			dates = append(dates, d)
+		}
+	}
+	sort.Strings(dates)
+
+	report := components.TimeWindowReportData{From: from, To: to}
+	stats := make(map[string]*components.TimeWindowCourseStat)
+	for _, date := range dates {
+		if err := h.collectTimeWindowResults(ctx, lp, date, stats, &report); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, err)
+		}
+	}
+
+	for _, stat := range stats {
+		report.Courses = append(report.Courses, *stat)
+		report.Total.Add(*stat)
+	}
+	sort.Slice(report.Courses, func(i, j int) bool {
+		return report.Courses[i].CourseName < report.Courses[j].CourseName
+	})
+
+	content := components.TimeWindowReport(lp, report)
+	c.Response().Header().Set(echo.HeaderContentType, echo.MIMETextHTML)
+	if c.Request().Header.Get("HX-Request") == "true" {
+		return content.Render(ctx, c.Response().Writer)
+	}
+	return layouts.Base("希望時間帯の遵守率", content).Render(ctx, c.Response().Writer)
+}
+
+// collectTimeWindowResults は運行日の各コースの希望時間帯の判定結果をコース別の集計と違反一覧に加える
+func (h *ProjectHandler) collectTimeWindowResults(ctx context.Context, lp database.Project, operatingDate string, stats map[string]*components.TimeWindowCourseStat, report *components.TimeWindowReportData) error {
+	stops, err := h.DB.ListRouteStopsByProjectDate(ctx, database.ListRouteStopsByProjectDateParams{
+		ProjectID:     lp.ID,
+		OperatingDate: operatingDate,
+	})
+	if err != nil {
+		return err
+	}
+
+	// コースごとに分ける（並び順はクエリで保証済み）、希望時間帯のないコースは判定しない
+	var courseNames []string
+	byCourse := make(map[string][]database.RouteStop)
+	hasWindow := make(map[string]bool)
+	for _, stop := range stops {
+		if _, ok := byCourse[stop.CourseName]; !ok {
+			courseNames = append(courseNames, stop.CourseName)
+		}
+		byCourse[stop.CourseName] = append(byCourse[stop.CourseName], stop)
+		if stop.DesiredTimeStart.Valid || stop.DesiredTimeEnd.Valid {
+			hasWindow[stop.CourseName] = true
+		}
+	}
+
+	for _, courseName := range courseNames {
+		if !hasWindow[courseName] {
+			continue
+		}
+		courseStops := byCourse[courseName]
+
+		runs, err := h.DB.ListCourseRuns(ctx, database.ListCourseRunsParams{
+			ProjectID:     lp.ID,
+			OperatingDate: operatingDate,
+			CourseName:    courseName,
+		})
+		if err != nil {
+			return err
+		}
+		var run *database.CourseRun
+		if len(runs) > 0 {
+			run = &runs[0]
+		}
+		result, err := h.Visits.Refresh(ctx, visits.Scope{Project: lp, OperatingDate: operatingDate, CourseName: courseName, Run: run}, courseStops)
+		if err != nil {
+			return err
+		}
+		timings := result.Timings
+
+		stat, ok := stats[courseName]
+		if !ok {
+			stat = &components.TimeWindowCourseStat{CourseName: courseName}
+			stats[courseName] = stat
+		}
+		for _, stop := range courseStops {
+			result := evaluateTimeWindow(stop, timings[stop.ID])
+			stat.Count(result)
+			if result.IsViolation() {
+				report.Violations = append(report.Violations, components.TimeWindowViolation{
+					OperatingDate: operatingDate,
+					Stop:          stop,
+					ArrivalTime:   *timings[stop.ID].ArrivalTime,
+					Result:        result,
+				})
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,873 @@
+// Package judge は位置ログから停車地への到着・出発を判定し、走行中の区間やトラックの状況を求める
+// 画面・API から切り離し、停車地・位置ログ・判定設定だけを入力とする
+package judge
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/geo"
+)
+
+// DefaultThresholdM は案件に到着判定の範囲が設定されていない場合の範囲（m）
+const DefaultThresholdM = 100
+
+// DepartureSequence は順番列で出発地点を表す値
+const DepartureSequence = "出発"
+
+// 精度の悪い測位の扱い（Settings.AccuracyMode）
+const (
+	AccuracyDiscard = "discard" // 捨てる
+	AccuracyWeight  = "weight"  // 精度に応じて軽く数える（精度が MaxAccuracyM の2倍なら0.5件）
+)
+
+// 進行方向で出発を早めに判定する条件（この速度以上で、停車地から見た方向とのずれがこの角度以内なら遠ざかっている）
+const (
+	movingAwayMinSpeedKmh = 5.4 // 1.5 m/s
+	movingAwayMaxAngle    = 45.0
+)
+
+// jst は到着・出発時刻の表示に使う日本標準時
+var jst = time.FixedZone("Asia/Tokyo", 9*60*60)
+
+// Settings は到着判定の設定
+type Settings struct {
+	ThresholdM    int64   // 停車地からこの距離（m）未満に入れば範囲内
+	StayMinutes   int64   // 範囲内にこの時間（分）以上とどまれば到着（0なら範囲に入った時点で到着）
+	SpeedLimitKmh float64 // この速度（km/h）を超えている間は滞在とみなさない（0なら速度を見ない）
+
+	// SequenceWindow は順番を考慮した判定で到着の候補にする地点数（0なら停車地ごとに独立して判定する）
+	// 次に向かう予定の地点から前後この地点数までの未到着の停車地だけを候補にし、1回の滞在は1つの停車地に割り当てる
+	SequenceWindow int64
+
+	// 測位のぶれへの対策（既定値ならすべての測位をそのまま使う）
+	MaxAccuracyM float64 // この精度（m）より悪い測位は捨てるか軽く数える（0なら精度を見ない）
+	AccuracyMode string  // 精度の悪い測位の扱い（AccuracyDiscard・AccuracyWeight。空なら捨てる）
+	MinFixes     int64   // 範囲の出入りを確定する連続の測位数（0・1なら1件で確定する）
+	ExitMarginM  int64   // 範囲内の停車地は、範囲からこの距離（m）離れるまで出発とみなさない
+	UseBearing   bool    // 停車地から遠ざかる向きに走っていれば、ExitMarginM の内側でも範囲を出たとみなす
+}
+
+// SettingsFromProject は案件の判定設定を既定値を補って返す
+func SettingsFromProject(lp database.Project) Settings {
+	s := Settings{ThresholdM: DefaultThresholdM}
+	if lp.ArrivalThresholdMeters.Valid {
+		s.ThresholdM = lp.ArrivalThresholdMeters.Int64
+	}
+	if lp.JudgeStayTimeMinutes.Valid {
+		s.StayMinutes = lp.JudgeStayTimeMinutes.Int64
+	}
+	if lp.JudgeSpeedLimitKmh.Valid {
+		s.SpeedLimitKmh = lp.JudgeSpeedLimitKmh.Float64
+	}
+	s.SequenceWindow = lp.JudgeSequenceWindow
+	s.MaxAccuracyM = lp.JudgeMaxAccuracyM
+	s.AccuracyMode = lp.JudgeAccuracyMode
+	s.MinFixes = lp.JudgeMinFixes
+	s.ExitMarginM = lp.JudgeExitMarginM
+	s.UseBearing = lp.JudgeUseBearing
+	return s
+}
+
+// weight は測位1件の重み（捨てる測位は0）
+func (s Settings) weight(log database.LocationLog) float64 {
+	if s.MaxAccuracyM <= 0 || !log.Accuracy.Valid || log.Accuracy.Float64 <= s.MaxAccuracyM {
+		return 1
+	}
+	if s.AccuracyMode == AccuracyWeight {
+		return s.MaxAccuracyM / log.Accuracy.Float64
+	}
+	return 0
+}
+
+// minFixes は範囲の出入りを確定する測位の重みの合計
+func (s Settings) minFixes() float64 {
+	return float64(max(s.MinFixes, 1))
+}
+
+// RadiusM は停車地の到着判定の範囲（m）。停車地ごとに範囲を決めている場合はその範囲を使う
+func (s Settings) RadiusM(stop database.RouteStop) float64 {
+	if stop.GeofenceRadiusM.Valid && stop.GeofenceRadiusM.Int64 > 0 {
+		return float64(stop.GeofenceRadiusM.Int64)
+	}
+	return float64(s.ThresholdM)
+}
+
+// Fence は停車地の到着判定のエリア
+// 停車地にエリア（多角形）があればその内側、なければ停車地の座標から RadiusM の円の内側を範囲内とする
+type Fence struct {
+	lat, lon float64
+	radiusM  float64
+	area     geo.MultiPolygon // nil なら円
+}
+
+// FenceFor は停車地の到着判定のエリアを返す（座標のない停車地は false）
+// 保存済みのエリアが読み取れない場合は円で判定する
+func (s Settings) FenceFor(stop database.RouteStop) (Fence, bool) {
+	if !stop.Latitude.Valid || !stop.Longitude.Valid {
+		return Fence{}, false
+	}
+	f := Fence{lat: stop.Latitude.Float64, lon: stop.Longitude.Float64, radiusM: s.RadiusM(stop)}
+	if stop.GeofencePolygon.Valid && stop.GeofencePolygon.String != "" {
+		if area, err := geo.ParseGeoJSON(stop.GeofencePolygon.String); err == nil {
+			f.area = area
+		}
+	}
+	return f, true
+}
+
+// Contains は位置がエリア内かどうか
+func (f Fence) Contains(lat, lon float64) bool {
+	if f.area != nil {
+		return f.area.Contains(lat, lon)
+	}
+	return f.DistanceM(lat, lon) < f.radiusM
+}
+
+// ContainsWithin は位置がエリアから marginM（m）以内かどうか
+func (f Fence) ContainsWithin(lat, lon float64, marginM float64) bool {
+	if marginM <= 0 {
+		return f.Contains(lat, lon)
+	}
+	if f.area != nil {
+		return f.area.Contains(lat, lon) || f.area.DistanceM(lat, lon) < marginM
+	}
+	return f.DistanceM(lat, lon) < f.radiusM+marginM
+}
+
+// movingAway は位置が停車地の座標から遠ざかる向きに走っているかどうか（向き・速度のないログは false）
+func (f Fence) movingAway(log database.LocationLog) bool {
+	if !log.Bearing.Valid || !log.Speed.Valid || log.Speed.Float64 < movingAwayMinSpeedKmh {
+		return false
+	}
+	away := geo.Bearing(f.lat, f.lon, log.Latitude, log.Longitude)
+	return geo.BearingDiff(log.Bearing.Float64, away) <= movingAwayMaxAngle
+}
+
+// DistanceM は停車地の座標までの距離（m）
+func (f Fence) DistanceM(lat, lon float64) float64 {
+	return geo.Haversine(lat, lon, f.lat, f.lon) * 1000
+}
+
+// IsPolygon はエリアが多角形かどうか
+func (f Fence) IsPolygon() bool {
+	return f.area != nil
+}
+
+// StopTiming は停車地の到着・出発時刻（判定結果）
+type StopTiming struct {
+	StopID        int64
+	Arrived       bool       // 到着したか
+	ArrivalTime   *time.Time // エリアに入った時刻
+	DepartureTime *time.Time // エリアを出た時刻（nilなら滞在中）
+	OutOfSequence bool       // 予定の順番と異なる順で到着した（順番を考慮した判定の場合のみ）
+	Manual        bool       // 手動で指定した到着・出発（判定より優先する）
+}
+
+// ArrivalTimeStr は到着時刻を "HH:MM" 形式で返す
+func (t *StopTiming) ArrivalTimeStr() string {
+	if t.ArrivalTime == nil {
+		return ""
+	}
+	return t.ArrivalTime.In(jst).Format("15:04")
+}
+
+// DepartureTimeStr は出発時刻を "HH:MM" 形式で返す
+func (t *StopTiming) DepartureTimeStr() string {
+	if t.DepartureTime == nil {
+		return ""
+	}
+	return t.DepartureTime.In(jst).Format("15:04")
+}
+
+// StayDurationStr は滞在時間を "X分" 形式で返す
+// 出発済みなら確定値、滞在中なら現在時刻までの暫定値を表示
+func (t *StopTiming) StayDurationStr() string {
+	if t.ArrivalTime == nil {
+		return "-"
+	}
+	var endTime time.Time
+	if t.DepartureTime != nil {
+		endTime = *t.DepartureTime
+	} else {
+		// 滞在中: 現在時刻までの滞在時間を表示
+		endTime = time.Now()
+	}
+	duration := endTime.Sub(*t.ArrivalTime)
+	return fmt.Sprintf("%d分", int(duration.Minutes()))
+}
+
+// IsStaying は現在滞在中かどうかを返す
+func (t *StopTiming) IsStaying() bool {
+	return t.Arrived && t.DepartureTime == nil
+}
+
+// CurrentLocationInfo は現在位置と走行区間情報
+type CurrentLocationInfo struct {
+	Latitude      float64
+	Longitude     float64
+	Timestamp     time.Time
+	SpeedKmh      float64 // 端末が測った速度（km/h）
+	CurrentLoadKg int64   // 現在の積載重量（kg）。積降の計算は呼び出し側で行う
+
+	// 出発地点（最後に到着した地点）
+	FromStop    string
+	FromStopIdx int
+
+	// 目的地点（次の未到着地点）
+	ToStop              string
+	ToStopIdx           int
+	ToDistanceKm        float64
+	ToEtaMinutes        float64       // 到着予想時間（分）。過去の運行から予測するため呼び出し側で設定する
+	IsWithinRange       bool          // 判定範囲内かどうか
+	ThresholdM          int64         // 判定閾値（メートル）
+	CurrentStayDuration time.Duration // 現在のエリア内滞在時間
+
+	// 後方互換性のため残す
+	NearestStop    string
+	NearestStopIdx int
+	DistanceKm     float64
+	NextStop       string
+	NextStopIdx    int
+	NextDistanceKm float64
+}
+
+// TruckStatusInfo はトラックの現在状況（ある地点から見た）
+type TruckStatusInfo struct {
+	HasLocation      bool    // 位置情報があるか
+	StopsAway        int     // この地点まであと何地点か（負の場合は通過済み）
+	CurrentStopName  string  // トラックが現在いる地点（または直前に通過した地点）
+	DelayMinutes     int     // 予定からの遅れ（正: 遅れ、負: 早い）
+	LastArrivedStop  string  // 最後に到着した地点名
+	LastArrivedTime  string  // 最後に到着した時刻（実績）
+	LastDepartedTime string  // 最後に到着した地点の出発時刻（実績）
+	ScheduledTime    string  // 最後に到着した地点の予定時刻
+	DistanceKm       float64 // この地点までの距離（km）
+	IsWithinRange    bool    // 判定範囲内かどうか
+}
+
+// Result はコースの1運行分の判定結果
+type Result struct {
+	Timings map[int64]*StopTiming // 停車地IDごとの到着・出発（座標のない停車地は手動で指定した場合のみ含む）
+	Current *CurrentLocationInfo  // 最新の位置と走行中の区間（位置ログがなければnil）
+
+	stops    []database.RouteStop
+	latest   *database.LocationLog
+	settings Settings
+}
+
+// Evaluate はコースの停車地（並び順）と運行期間内の位置ログ（時刻の昇順）から到着・出発を判定する
+func Evaluate(stops []database.RouteStop, logs []database.LocationLog, s Settings) *Result {
+	t := NewTracker(stops, s, nil, nil)
+	for _, log := range logs {
+		t.Add(log)
+	}
+	return t.Result()
+}
+
+// Visit は停車地ごとの判定の途中経過
+// 永続化しておけば、次に届いたログから判定を続けられる
+type Visit struct {
+	StopID       int64
+	EnteredAt    *time.Time // 初めて範囲に入った時刻
+	StayStartAt  *time.Time // 滞在条件を満たし始めた時刻（低速開始）
+	ArrivedAt    *time.Time // 到着確定時の到着時刻（範囲に入った時刻）
+	DepartedAt   *time.Time // 出発時刻（範囲に戻ればnil）
+	InRangeSince *time.Time // 範囲内に入り続けている開始時刻（範囲外ならnil）
+
+	// 範囲の出入りが確定する前の、今と異なる側（範囲外なら範囲内、範囲内なら範囲外）の測位
+	PendingSince  *time.Time // 続き始めた時刻（なければnil）
+	PendingWeight float64    // 重みの合計（Settings.MinFixes に達したら確定）
+}
+
+// Tracker は位置ログを時刻順に1件ずつ受け取り、停車地ごとの判定を進める
+type Tracker struct {
+	stops    []database.RouteStop
+	settings Settings
+	fences   map[int64]Fence
+	visits   map[int64]*Visit
+	judged   []int // 判定する（座標のある）停車地の stops 内の位置（並び順）
+	last     *database.LocationLog
+
+	overrides map[int64]Override // 手動で指定した停車地（判定せず、順番の判定では指定どおりに扱う）
+}
+
+// NewTracker は判定の途中経過（visits）と最後に判定したログ（last）から判定を再開する
+// 最初から判定する場合はどちらも nil を渡す。座標のない停車地は判定しない
+func NewTracker(stops []database.RouteStop, s Settings, visits []Visit, last *database.LocationLog) *Tracker {
+	t := &Tracker{
+		stops:    stops,
+		settings: s,
+		fences:   make(map[int64]Fence, len(stops)),
+		visits:   make(map[int64]*Visit),
+		last:     last,
+	}
+	for i := range visits {
+		v := visits[i]
+		t.visits[v.StopID] = &v
+	}
+	for i, stop := range stops {
+		fence, ok := s.FenceFor(stop)
+		if !ok {
+			delete(t.visits, stop.ID)
+			continue
+		}
+		t.judged = append(t.judged, i)
+		t.fences[stop.ID] = fence
+		if t.visits[stop.ID] == nil {
+			t.visits[stop.ID] = &Visit{StopID: stop.ID}
+		}
+	}
+	return t
+}
+
+// SetOverrides は手動での指定を設定する（ログを判定する前に呼ぶ）
+// 指定した停車地は位置ログで判定せず、順番を考慮した判定では指定どおりに到着済み・未到着として次の停車地を決める
+func (t *Tracker) SetOverrides(overrides []Override) {
+	t.overrides = make(map[int64]Override, len(overrides))
+	for _, o := range overrides {
+		t.overrides[o.StopID] = o
+	}
+}
+
+// overridden は停車地が手動で指定されているか
+func (t *Tracker) overridden(stopID int64) bool {
+	_, ok := t.overrides[stopID]
+	return ok
+}
+
+// arrivedAt は停車地の到着時刻（手動での指定を優先。未到着ならnil）
+func (t *Tracker) arrivedAt(stopID int64) *time.Time {
+	if o, ok := t.overrides[stopID]; ok {
+		if !o.Arrived {
+			return nil
+		}
+		return o.ArrivalTime
+	}
+	return t.visits[stopID].ArrivedAt
+}
+
+// Last は最後に判定したログ（まだなければnil）
+func (t *Tracker) Last() *database.LocationLog {
+	return t.last
+}
+
+// Visits は停車地ごとの判定の途中経過を停車地の並び順で返す
+func (t *Tracker) Visits() []Visit {
+	visits := make([]Visit, 0, len(t.visits))
+	for _, stop := range t.stops {
+		if v := t.visits[stop.ID]; v != nil {
+			visits = append(visits, *v)
+		}
+	}
+	return visits
+}
+
+// Add は次のログ（直前に判定したログより後の時刻）で判定を進める
+// 範囲に入った時刻を到着とし、滞在時間の判定が有効なら低速のまま範囲内にとどまった時点で到着を確定する
+// 範囲の出入りは、今と異なる側の測位が MinFixes 件続いた時点で、続き始めた時刻にさかのぼって確定する
+func (t *Tracker) Add(log database.LocationLog) {
+	s := t.settings
+	w := s.weight(log)
+	if w == 0 {
+		// 精度の悪い測位は判定にも現在位置にも使わない
+		return
+	}
+
+	// 速度チェック（設定されている場合）
+	isLowSpeed := true
+	if s.SpeedLimitKmh > 0 && t.last != nil {
+		isLowSpeed = SpeedKmh(*t.last, log) <= s.SpeedLimitKmh
+	}
+	ts := log.Timestamp
+
+	if s.SequenceWindow > 0 {
+		t.addSequenced(log, w, isLowSpeed)
+	} else {
+		for _, stop := range t.stops {
+			v := t.visits[stop.ID]
+			if v == nil || t.overridden(stop.ID) {
+				continue
+			}
+			inRange := v.InRangeSince != nil
+			changed := t.observe(stop, log, inRange) != inRange
+			if !t.count(v, changed, ts, w) {
+				if !changed && inRange {
+					t.stay(v, ts, ts, isLowSpeed)
+				}
+				continue
+			}
+			since := *v.PendingSince
+			resetPending(v)
+			if inRange {
+				t.leave(v, since)
+			} else {
+				t.stay(v, since, ts, isLowSpeed)
+			}
+		}
+	}
+	t.last = &log
+}
+
+// observe はログが停車地の範囲内かどうかを、今の状態（inRange）をふまえて判定する
+// 範囲内の停車地は、範囲から ExitMarginM 離れるか、遠ざかる向きに走り出すまで範囲内とみなす
+func (t *Tracker) observe(stop database.RouteStop, log database.LocationLog, inRange bool) bool {
+	f := t.fences[stop.ID]
+	if f.Contains(log.Latitude, log.Longitude) {
+		return true
+	}
+	if !inRange {
+		return false
+	}
+	if t.settings.UseBearing && f.movingAway(log) {
+		return false
+	}
+	return f.ContainsWithin(log.Latitude, log.Longitude, float64(t.settings.ExitMarginM))
+}
+
+// count は今と異なる側の測位（changed）の重みを足し、出入りを確定できるかどうかを返す
+// 今と同じ側の測位が来たら数え直す
+func (t *Tracker) count(v *Visit, changed bool, ts time.Time, w float64) bool {
+	if !changed {
+		resetPending(v)
+		return false
+	}
+	if v.PendingSince == nil {
+		v.PendingSince = &ts
+	}
+	v.PendingWeight += w
+	return ready(v, t.settings)
+}
+
+// ready は範囲の出入りを確定できるだけの測位が続いたかどうか
+func ready(v *Visit, s Settings) bool {
+	// 重みの合計は浮動小数点の誤差を見込んで比べる
+	return v.PendingSince != nil && v.PendingWeight >= s.minFixes()-1e-9
+}
+
+func resetPending(v *Visit) {
+	v.PendingSince = nil
+	v.PendingWeight = 0
+}
+
+// stay は範囲内のログで停車地の滞在を進める（since は範囲に入った時刻、ts はログの時刻）
+func (t *Tracker) stay(v *Visit, since, ts time.Time, isLowSpeed bool) {
+	s := t.settings
+	if v.EnteredAt == nil {
+		// 初めてエリアに入った
+		v.EnteredAt = &since
+	}
+	if v.InRangeSince == nil {
+		v.InRangeSince = &since
+	}
+
+	if s.StayMinutes > 0 {
+		// 滞在時間判定が有効な場合
+		if isLowSpeed {
+			if v.StayStartAt == nil {
+				v.StayStartAt = &since
+			}
+			// 滞在時間が要件を満たしたか
+			if v.ArrivedAt == nil && ts.Sub(*v.StayStartAt) >= time.Duration(s.StayMinutes)*time.Minute {
+				// 到着確定: エリアに入った時刻を到着時刻とする
+				v.ArrivedAt = v.EnteredAt
+			}
+		} else {
+			// 速度超過 → 滞在カウントリセット
+			v.StayStartAt = nil
+		}
+	} else if v.ArrivedAt == nil {
+		// 滞在時間判定なし → 即時到着
+		v.ArrivedAt = v.EnteredAt
+	}
+
+	// 出発後に戻ってきた場合は出発をキャンセル
+	v.DepartedAt = nil
+}
+
+// leave は範囲を出たとき（または次の停車地への引き継ぎ）に停車地の滞在を終える
+func (t *Tracker) leave(v *Visit, ts time.Time) {
+	if v.InRangeSince != nil && v.ArrivedAt != nil && v.DepartedAt == nil {
+		// 到着済みでエリアを出た → 出発
+		v.DepartedAt = &ts
+	}
+	// エリア外に出たら滞在カウントリセット
+	v.StayStartAt = nil
+	v.InRangeSince = nil
+}
+
+// addSequenced は順番を考慮してログを1つの停車地に割り当てる
+// 滞在中の停車地があればその停車地だけを判定し、エリアを出たら次の候補を探す
+// 同じ建物などエリアが重なる停車地は、滞在予定の時間が過ぎたら次の停車地に引き継ぐ
+func (t *Tracker) addSequenced(log database.LocationLog, w float64, isLowSpeed bool) {
+	ts := log.Timestamp
+	active := t.activePos()
+
+	// 滞在中でない停車地は、範囲内の測位が続いているかを数えておく（手動で指定した停車地は数えない）
+	for pos, i := range t.judged {
+		if stop := t.stops[i]; pos != active && !t.overridden(stop.ID) {
+			t.count(t.visits[stop.ID], t.observe(stop, log, false), ts, w)
+		}
+	}
+
+	if active >= 0 {
+		stop := t.stops[t.judged[active]]
+		v := t.visits[stop.ID]
+		if t.observe(stop, log, true) {
+			resetPending(v)
+			plannedStay := time.Duration(stop.StayMinutes.Int64) * time.Minute
+			if v.ArrivedAt != nil && ts.Sub(*v.ArrivedAt) >= plannedStay {
+				if next := t.candidatePos(); next >= 0 {
+					t.leave(v, ts)
+					t.claim(next, ts, ts, isLowSpeed)
+					return
+				}
+			}
+			t.stay(v, ts, ts, isLowSpeed)
+			return
+		}
+		if !t.count(v, true, ts, w) {
+			// 範囲を出たことがまだ確定しない
+			return
+		}
+		since := *v.PendingSince
+		resetPending(v)
+		t.leave(v, since)
+		if v.ArrivedAt == nil {
+			// 到着に至らずに通り過ぎた停車地は、次に来たときの滞在から判定し直す
+			v.EnteredAt = nil
+		}
+	}
+
+	// 直前に到着した停車地に戻った場合（GPSの揺れなど）はその停車地の出発を取り消す
+	if pos := t.recentPos(); pos >= 0 && !t.overridden(t.stops[t.judged[pos]].ID) && ready(t.visits[t.stops[t.judged[pos]].ID], t.settings) {
+		t.claim(pos, *t.visits[t.stops[t.judged[pos]].ID].PendingSince, ts, isLowSpeed)
+		return
+	}
+	if pos := t.candidatePos(); pos >= 0 {
+		t.claim(pos, *t.visits[t.stops[t.judged[pos]].ID].PendingSince, ts, isLowSpeed)
+	}
+}
+
+// claim は停車地への滞在を始める（ほかの停車地の数えかけの測位は数え直す）
+func (t *Tracker) claim(pos int, since, ts time.Time, isLowSpeed bool) {
+	for _, i := range t.judged {
+		resetPending(t.visits[t.stops[i].ID])
+	}
+	t.stay(t.visits[t.stops[t.judged[pos]].ID], since, ts, isLowSpeed)
+}
+
+// activePos は範囲内に入り続けている停車地の位置（なければ-1）
+// 順番を考慮した判定では同時に1つの停車地にしか滞在しない
+func (t *Tracker) activePos() int {
+	for pos, i := range t.judged {
+		if id := t.stops[i].ID; !t.overridden(id) && t.visits[id].InRangeSince != nil {
+			return pos
+		}
+	}
+	return -1
+}
+
+// recentPos は最後に到着した停車地の位置（なければ-1）
+func (t *Tracker) recentPos() int {
+	recent := -1
+	var recentAt time.Time
+	for pos, i := range t.judged {
+		if at := t.arrivedAt(t.stops[i].ID); at != nil && (recent < 0 || !at.Before(recentAt)) {
+			recent, recentAt = pos, *at
+		}
+	}
+	return recent
+}
+
+// expectedPos は次に向かう予定の停車地の位置（最後に到着した停車地より後の最初の未到着の停車地。なければ-1）
+func (t *Tracker) expectedPos() int {
+	recent := t.recentPos()
+	first := -1
+	for pos, i := range t.judged {
+		if t.arrivedAt(t.stops[i].ID) != nil {
+			continue
+		}
+		if pos > recent {
+			return pos
+		}
+		if first < 0 {
+			first = pos
+		}
+	}
+	return first
+}
+
+// candidatePos は範囲内の測位が続いている未到着の停車地のうち、次に向かう予定の地点から
+// 前後 SequenceWindow 地点以内で最も近いもの（同じ近さなら先の地点）の位置を返す（なければ-1）
+func (t *Tracker) candidatePos() int {
+	expected := t.expectedPos()
+	if expected < 0 {
+		return -1
+	}
+	best, bestGap := -1, 0
+	for pos, i := range t.judged {
+		stop := t.stops[i]
+		if t.overridden(stop.ID) || t.visits[stop.ID].ArrivedAt != nil {
+			continue
+		}
+		gap := pos - expected
+		if gap < 0 {
+			gap = -gap
+		}
+		if int64(gap) > t.settings.SequenceWindow || !ready(t.visits[stop.ID], t.settings) {
+			continue
+		}
+		if best < 0 || gap < bestGap || (gap == bestGap && pos > expected) {
+			best, bestGap = pos, gap
+		}
+	}
+	return best
+}
+
+// Result は現在までの判定結果（手動での指定を反映したもの）を返す
+// 座標のない停車地や位置ログがない場合も、手動で指定した到着・出発は結果に含める
+func (t *Tracker) Result() *Result {
+	r := &Result{
+		Timings:  make(map[int64]*StopTiming, len(t.visits)),
+		stops:    t.stops,
+		latest:   t.last,
+		settings: t.settings,
+	}
+	if t.last != nil {
+		for id, v := range t.visits {
+			timing := &StopTiming{StopID: id}
+			if v.ArrivedAt != nil {
+				timing.Arrived = true
+				timing.ArrivalTime = v.ArrivedAt
+				timing.DepartureTime = v.DepartedAt
+			}
+			r.Timings[id] = timing
+		}
+	}
+	for _, o := range t.overrides {
+		timing := &StopTiming{StopID: o.StopID, Manual: true}
+		if o.Arrived {
+			timing.Arrived = true
+			timing.ArrivalTime = o.ArrivalTime
+			timing.DepartureTime = o.DepartureTime
+		}
+		r.Timings[o.StopID] = timing
+	}
+	if t.settings.SequenceWindow > 0 {
+		markOutOfSequence(t.stops, r.Timings)
+	}
+	if t.last != nil && len(t.stops) > 0 {
+		r.Current = currentSection(t.stops, *t.last, t.settings, r.Timings, t.fences, t.visits)
+	}
+	return r
+}
+
+// Override は停車地の到着・出発の手動での指定
+// 地下の荷受け場など測位できない場所の到着・出発を、配車担当が判定の代わりに指定する
+type Override struct {
+	StopID        int64
+	Arrived       bool       // false なら判定にかかわらず未到着とする
+	ArrivalTime   *time.Time // 到着時刻（Arrived のとき）
+	DepartureTime *time.Time // 出発時刻（nilなら滞在中）
+}
+
+// SpeedKmh は2つのログ間の移動速度（km/h）
+func SpeedKmh(older, newer database.LocationLog) float64 {
+	timeDiff := newer.Timestamp.Sub(older.Timestamp).Seconds()
+	if timeDiff <= 0 {
+		return 0
+	}
+	distKm := geo.Haversine(older.Latitude, older.Longitude, newer.Latitude, newer.Longitude)
+	return (distKm / timeDiff) * 3600
+}
+
+// lastArrivedIndex は到着済みの最後の停車地の位置を返す（なければ-1）
+// 順番を考慮した判定では、並び順ではなく最後に到着した停車地を返す
+func lastArrivedIndex(stops []database.RouteStop, timings map[int64]*StopTiming, s Settings) int {
+	last := -1
+	var lastAt time.Time
+	for i, stop := range stops {
+		timing := timings[stop.ID]
+		if timing == nil || !timing.Arrived {
+			continue
+		}
+		if s.SequenceWindow == 0 || last < 0 || !timing.ArrivalTime.Before(lastAt) {
+			last, lastAt = i, *timing.ArrivalTime
+		}
+	}
+	return last
+}
+
+// nextStopIndex は次に向かう停車地の位置を返す（なければ-1）
+// 順番を考慮した判定では、最後に到着した停車地より後の最初の未到着の停車地（なければ飛ばした停車地）を返す
+func nextStopIndex(stops []database.RouteStop, timings map[int64]*StopTiming, s Settings) int {
+	from := -1
+	if s.SequenceWindow > 0 {
+		from = lastArrivedIndex(stops, timings, s)
+	}
+	first := -1
+	for i, stop := range stops {
+		if timing := timings[stop.ID]; timing != nil && timing.Arrived {
+			continue
+		}
+		if i > from {
+			return i
+		}
+		if first < 0 {
+			first = i
+		}
+	}
+	return first
+}
+
+// markOutOfSequence は予定の順番と異なる順で到着した停車地に印を付ける
+// 到着時刻が並び順どおりに増えていく最長の停車地の列を順番どおりとみなし、それ以外を順番外とする
+func markOutOfSequence(stops []database.RouteStop, timings map[int64]*StopTiming) {
+	var arrived []*StopTiming
+	for _, stop := range stops {
+		if timing := timings[stop.ID]; timing != nil && timing.Arrived {
+			arrived = append(arrived, timing)
+		}
+	}
+	// length[i]: arrived[i] で終わる順番どおりの列の長さ、prev[i]: その列の直前の要素
+	length := make([]int, len(arrived))
+	prev := make([]int, len(arrived))
+	best := -1
+	for i, timing := range arrived {
+		length[i], prev[i] = 1, -1
+		for j := 0; j < i; j++ {
+			if !arrived[j].ArrivalTime.After(*timing.ArrivalTime) && length[j]+1 > length[i] {
+				length[i], prev[i] = length[j]+1, j
+			}
+		}
+		if best < 0 || length[i] >= length[best] {
+			best = i
+		}
+	}
+	inOrder := make([]bool, len(arrived))
+	for i := best; i >= 0; i = prev[i] {
+		inOrder[i] = true
+	}
+	for i, timing := range arrived {
+		timing.OutOfSequence = !inOrder[i]
+	}
+}
+
+// currentSection は最新の位置から走行中の区間（最後に到着した地点 → 次の未到着地点）を求める
+func currentSection(stops []database.RouteStop, loc database.LocationLog, s Settings, timings map[int64]*StopTiming, fences map[int64]Fence, visits map[int64]*Visit) *CurrentLocationInfo {
+	info := &CurrentLocationInfo{
+		Latitude:   loc.Latitude,
+		Longitude:  loc.Longitude,
+		Timestamp:  loc.Timestamp,
+		ThresholdM: s.ThresholdM,
+	}
+	if loc.Speed.Valid {
+		info.SpeedKmh = loc.Speed.Float64
+	}
+
+	// 出発地点（到着済みの最後の地点）
+	if lastArrivedIdx := lastArrivedIndex(stops, timings, s); lastArrivedIdx >= 0 {
+		info.FromStop = stops[lastArrivedIdx].StopName
+		info.FromStopIdx = lastArrivedIdx
+	}
+
+	// 目的地点（次の未到着地点）
+	if nextStopIdx := nextStopIndex(stops, timings, s); nextStopIdx >= 0 {
+		nextStop := stops[nextStopIdx]
+		info.ToStop = nextStop.StopName
+		info.ToStopIdx = nextStopIdx
+
+		if fence, ok := fences[nextStop.ID]; ok {
+			info.ThresholdM = int64(s.RadiusM(nextStop))
+			info.ToDistanceKm = fence.DistanceM(loc.Latitude, loc.Longitude) / 1000
+
+			if fence.Contains(loc.Latitude, loc.Longitude) {
+				info.IsWithinRange = true
+
+				// 範囲内に入り続けている間の滞在時間
+				if v := visits[nextStop.ID]; v != nil && v.InRangeSince != nil {
+					info.CurrentStayDuration = loc.Timestamp.Sub(*v.InRangeSince)
+				}
+			}
+		}
+	}
+
+	// 後方互換性のため、最寄り地点情報も設定
+	info.NearestStop = info.ToStop
+	info.NearestStopIdx = info.ToStopIdx
+	info.DistanceKm = info.ToDistanceKm
+	info.NextStopIdx = -1
+
+	return info
+}
+
+// TruckStatus は target の地点から見たトラックの状況（何地点前にいるか・遅れなど）を返す
+func (r *Result) TruckStatus(target database.RouteStop) *TruckStatusInfo {
+	status := &TruckStatusInfo{}
+	if r.latest == nil {
+		return status
+	}
+	latest := r.latest
+	status.HasLocation = true
+
+	// この地点までの距離
+	if fence, ok := r.settings.FenceFor(target); ok {
+		status.DistanceKm = fence.DistanceM(latest.Latitude, latest.Longitude) / 1000
+		status.IsWithinRange = fence.Contains(latest.Latitude, latest.Longitude)
+	}
+
+	targetIdx := -1
+	for i, s := range r.stops {
+		if s.ID == target.ID {
+			targetIdx = i
+			break
+		}
+	}
+	if targetIdx == -1 {
+		return status
+	}
+
+	// 何地点前にいるか（停車地は並び順どおり。「出発」行は地点として数えない）
+	lastArrivedIdx := lastArrivedIndex(r.stops, r.Timings, r.settings)
+	if lastArrivedIdx == -1 {
+		// まだどこにも到着していない → 対象地点までの全地点分前にいる
+		status.StopsAway = countDeliveryStops(r.stops[:targetIdx+1])
+		status.CurrentStopName = "出発前"
+		return status
+	}
+
+	// 最後に到着した地点が対象より前ならその間の地点数、同じなら到着済み（0）、後なら通過済み（負の値）
+	if lastArrivedIdx <= targetIdx {
+		status.StopsAway = countDeliveryStops(r.stops[lastArrivedIdx+1 : targetIdx+1])
+	} else {
+		status.StopsAway = -countDeliveryStops(r.stops[targetIdx+1 : lastArrivedIdx+1])
+	}
+	lastStop := r.stops[lastArrivedIdx]
+	status.CurrentStopName = lastStop.StopName
+	status.LastArrivedStop = lastStop.StopName
+	status.ScheduledTime = lastStop.ArrivalTime.String
+
+	if lastTiming := r.Timings[lastStop.ID]; lastTiming != nil {
+		status.LastArrivedTime = lastTiming.ArrivalTimeStr()
+		status.LastDepartedTime = lastTiming.DepartureTimeStr()
+
+		// 遅延: 到着予定日時と実績到着時刻の差（日付をまたぐ運行にも対応）
+		if lastStop.PlannedAt.Valid && lastTiming.ArrivalTime != nil {
+			status.DelayMinutes = int(lastTiming.ArrivalTime.Sub(lastStop.PlannedAt.Time).Minutes())
+		}
+	}
+	return status
+}
+
+// countDeliveryStops は「出発」行を除いた地点数を返す
+func countDeliveryStops(stops []database.RouteStop) int {
+	n := 0
+	for _, s := range stops {
+		if s.Sequence != DepartureSequence {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,892 @@
+package judge
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
+)
+
+// 走行ログのフィクスチャ（testdata/*.csv。端末の送信ログと同じ10秒間隔・測位誤差つきで作ったもの）
+// 実機で記録した走行に置き換えるときは cmd/export-track で匿名化して書き出し、停車地の座標と期待する時刻を合わせる
+//
+//	delivery_round.csv        08:00 営業所 → A に8分滞在 → B の前を40km/hで通過（60m手前） → C に5分滞在（ログ終了）
+//	parked_with_multipath.csv 13:00 から10分停車。13:04:00 に1点だけ250m先へ飛び、13:10 過ぎに走り去る
+const (
+	fixtureDelivery = "delivery_round.csv"
+	fixtureParked   = "parked_with_multipath.csv"
+)
+
+// loadTrack はフィクスチャの走行ログを読み込む（時刻の昇順。速度は受信時と同じく km/h に換算し、空欄はNULLにする）
+func loadTrack(t *testing.T, name string) []database.LocationLog {
+	t.Helper()
+	f, err := os.Open(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	logs := make([]database.LocationLog, 0, len(records)-1)
+	for i, r := range records[1:] {
+		ts, err := time.Parse(time.RFC3339, r[0])
+		if err != nil {
+			t.Fatalf("%s:%d: %v", name, i+2, err)
+		}
+		lat, _ := strconv.ParseFloat(r[1], 64)
+		lon, _ := strconv.ParseFloat(r[2], 64)
+		speed, speedErr := strconv.ParseFloat(r[3], 64) // speed_mps
+		accuracy, accuracyErr := strconv.ParseFloat(r[4], 64)
+		logs = append(logs, database.LocationLog{
+			ID:        int64(i + 1),
+			Latitude:  lat,
+			Longitude: lon,
+			Timestamp: ts,
+			Speed:     sql.NullFloat64{Float64: speed * 3.6, Valid: speedErr == nil},
+			Accuracy:  sql.NullFloat64{Float64: accuracy, Valid: accuracyErr == nil},
+		})
+	}
+	return logs
+}
+
+// logsUntil は clock（"15:04:05"）以前のログを返す
+func logsUntil(logs []database.LocationLog, clock string) []database.LocationLog {
+	for i, log := range logs {
+		if log.Timestamp.In(jst).Format("15:04:05") > clock {
+			return logs[:i]
+		}
+	}
+	return logs
+}
+
+func stopAt(id int64, sequence, name string, lat, lon float64) database.RouteStop {
+	return database.RouteStop{
+		ID:        id,
+		Sequence:  sequence,
+		StopName:  name,
+		Latitude:  sql.NullFloat64{Float64: lat, Valid: true},
+		Longitude: sql.NullFloat64{Float64: lon, Valid: true},
+	}
+}
+
+// deliveryStops は delivery_round.csv のコース（D はログの範囲では未到着）
+func deliveryStops() []database.RouteStop {
+	return []database.RouteStop{
+		stopAt(1, DepartureSequence, "営業所", 35.6812000, 139.7671000),
+		stopAt(2, "1", "A", 35.6812000, 139.7836888),
+		stopAt(3, "2", "B", 35.6817390, 139.8002776),
+		stopAt(4, "3", "C", 35.6883865, 139.8168664),
+		stopAt(5, "4", "D", 35.6883865, 139.8334552),
+	}
+}
+
+// clock は時刻を "15:04:05"（JST）で返す（nilは空）
+func clock(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.In(jst).Format("15:04:05")
+}
+
+// want は停車地ごとの期待する到着・出発時刻（到着しない停車地は空）
+type want struct {
+	arrival   string
+	departure string
+}
+
+func TestStopTimings(t *testing.T) {
+	tests := []struct {
+		name     string
+		fixture  string
+		stops    func() []database.RouteStop
+		settings Settings
+		want     map[int64]want
+	}{
+		{
+			name:     "範囲に入れば到着（通過しただけの B も到着になる）",
+			fixture:  fixtureDelivery,
+			stops:    deliveryStops,
+			settings: Settings{ThresholdM: 100},
+			want: map[int64]want{
+				1: {"08:00:00", "08:02:20"},
+				2: {"08:04:50", "08:13:10"},
+				3: {"08:15:10", "08:15:30"},
+				4: {"08:19:30", ""},
+				5: {"", ""},
+			},
+		},
+		{
+			name:     "滞在3分・10km/h以下で通過の B は到着にならない",
+			fixture:  fixtureDelivery,
+			stops:    deliveryStops,
+			settings: Settings{ThresholdM: 100, StayMinutes: 3, SpeedLimitKmh: 10},
+			want: map[int64]want{
+				1: {"", ""}, // 営業所はログの開始から2分しかいない
+				2: {"08:04:50", "08:13:10"},
+				3: {"", ""},
+				4: {"08:19:30", ""},
+				5: {"", ""},
+			},
+		},
+		{
+			name:     "滞在10分はどの停車地も満たさない",
+			fixture:  fixtureDelivery,
+			stops:    deliveryStops,
+			settings: Settings{ThresholdM: 100, StayMinutes: 10},
+			want: map[int64]want{
+				1: {"", ""},
+				2: {"", ""},
+				3: {"", ""},
+				4: {"", ""},
+				5: {"", ""},
+			},
+		},
+		{
+			name:    "停車地ごとの判定範囲（B は20mなので通過しても入らない）",
+			fixture: fixtureDelivery,
+			stops: func() []database.RouteStop {
+				stops := deliveryStops()
+				stops[2].GeofenceRadiusM = sql.NullInt64{Int64: 20, Valid: true}
+				return stops
+			},
+			settings: Settings{ThresholdM: 100},
+			want: map[int64]want{
+				1: {"08:00:00", "08:02:20"},
+				2: {"08:04:50", "08:13:10"},
+				3: {"", ""},
+				4: {"08:19:30", ""},
+				5: {"", ""},
+			},
+		},
+		{
+			name:    "測位の飛びで一度範囲を出ても戻れば出発にしない",
+			fixture: fixtureParked,
+			stops: func() []database.RouteStop {
+				return []database.RouteStop{stopAt(1, "1", "倉庫", 35.6812000, 139.7671000)}
+			},
+			settings: Settings{ThresholdM: 100},
+			want: map[int64]want{
+				1: {"13:00:00", "13:10:10"},
+			},
+		},
+		{
+			name:    "到着の確定後の飛びは滞在時間の判定に影響しない",
+			fixture: fixtureParked,
+			stops: func() []database.RouteStop {
+				return []database.RouteStop{stopAt(1, "1", "倉庫", 35.6812000, 139.7671000)}
+			},
+			settings: Settings{ThresholdM: 100, StayMinutes: 3, SpeedLimitKmh: 10},
+			want: map[int64]want{
+				1: {"13:00:00", "13:10:10"},
+			},
+		},
+		{
+			name:    "座標のない停車地は判定しない",
+			fixture: fixtureParked,
+			stops: func() []database.RouteStop {
+				return []database.RouteStop{{ID: 1, Sequence: "1", StopName: "座標なし"}}
+			},
+			settings: Settings{ThresholdM: 100},
+			want:     map[int64]want{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := Evaluate(tt.stops(), loadTrack(t, tt.fixture), tt.settings)
+			if len(result.Timings) != len(tt.want) {
+				t.Errorf("判定した停車地 = %d件, want %d件", len(result.Timings), len(tt.want))
+			}
+			for id, w := range tt.want {
+				timing := result.Timings[id]
+				if timing == nil {
+					t.Errorf("停車地 %d の判定結果がない", id)
+					continue
+				}
+				if timing.Arrived != (w.arrival != "") {
+					t.Errorf("停車地 %d: Arrived = %v, want %v", id, timing.Arrived, w.arrival != "")
+				}
+				if got := clock(timing.ArrivalTime); got != w.arrival {
+					t.Errorf("停車地 %d: 到着 = %q, want %q", id, got, w.arrival)
+				}
+				if got := clock(timing.DepartureTime); got != w.departure {
+					t.Errorf("停車地 %d: 出発 = %q, want %q", id, got, w.departure)
+				}
+			}
+		})
+	}
+}
+
+// degrade はフィクスチャの走行ログを実機の送信に近づける。送信時刻を ±jitter の範囲でずらし、gaps（"15:04:05" の組）の間のログを抜く
+func degrade(logs []database.LocationLog, jitter time.Duration, gaps ...[2]string) []database.LocationLog {
+	rng := rand.New(rand.NewSource(1))
+	var out []database.LocationLog
+	for _, log := range logs {
+		c := log.Timestamp.In(jst).Format("15:04:05")
+		dropped := false
+		for _, gap := range gaps {
+			if c >= gap[0] && c <= gap[1] {
+				dropped = true
+			}
+		}
+		if dropped {
+			continue
+		}
+		log.Timestamp = log.Timestamp.Add(time.Duration(rng.Int63n(int64(2*jitter)+1)) - jitter)
+		out = append(out, log)
+	}
+	return out
+}
+
+func TestStopTimingsWithGapsAndJitter(t *testing.T) {
+	// 送信間隔のゆらぎ（±4秒）と、A の滞在中・B の通過中のトンネルでの欠落があっても、判定は元のログと同じになる
+	logs := degrade(loadTrack(t, fixtureDelivery), 4*time.Second,
+		[2]string{"08:07:00", "08:09:00"},
+		[2]string{"08:14:50", "08:15:40"},
+	)
+	result := Evaluate(deliveryStops(), logs, Settings{ThresholdM: 100, StayMinutes: 3, SpeedLimitKmh: 10})
+
+	near := func(got *time.Time, want string) bool {
+		if got == nil || want == "" {
+			return got == nil && want == ""
+		}
+		w, err := time.ParseInLocation("2006-01-02 15:04:05", got.In(jst).Format("2006-01-02 ")+want, jst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d := got.Sub(w)
+		return d >= -5*time.Second && d <= 5*time.Second
+	}
+	for id, w := range map[int64]want{
+		1: {"", ""},
+		2: {"08:04:50", "08:13:10"},
+		3: {"", ""},
+		4: {"08:19:30", ""},
+		5: {"", ""},
+	} {
+		timing := result.Timings[id]
+		if timing.Arrived != (w.arrival != "") {
+			t.Errorf("停車地 %d: Arrived = %v, want %v", id, timing.Arrived, w.arrival != "")
+		}
+		if !near(timing.ArrivalTime, w.arrival) {
+			t.Errorf("停車地 %d: 到着 = %q, want %q ±5秒", id, clock(timing.ArrivalTime), w.arrival)
+		}
+		if !near(timing.DepartureTime, w.departure) {
+			t.Errorf("停車地 %d: 出発 = %q, want %q ±5秒", id, clock(timing.DepartureTime), w.departure)
+		}
+	}
+}
+
+func TestSequenceMatching(t *testing.T) {
+	tests := []struct {
+		name          string
+		stops         []database.RouteStop
+		settings      Settings
+		want          map[int64]want
+		outOfSequence []int64
+	}{
+		{
+			name: "同じ建物の2地点は滞在予定の時間で引き継ぐ",
+			stops: func() []database.RouteStop {
+				stops := deliveryStops()
+				stops[1].StayMinutes = sql.NullInt64{Int64: 3, Valid: true}
+				a2 := stopAt(6, "1-2", "A2", 35.6812000, 139.7836888)
+				return append(stops[:2], append([]database.RouteStop{a2}, stops[2:]...)...)
+			}(),
+			settings: Settings{ThresholdM: 100, SequenceWindow: 1},
+			want: map[int64]want{
+				1: {"08:00:00", "08:02:20"},
+				2: {"08:04:50", "08:07:50"},
+				6: {"08:07:50", "08:13:10"},
+				3: {"08:15:10", "08:15:30"},
+				4: {"08:19:30", ""},
+				5: {"", ""},
+			},
+		},
+		{
+			name: "予定より先の地点の前を通っても到着にならない",
+			stops: func() []database.RouteStop {
+				stops := deliveryStops()
+				// B を最後に回す（C・D の後）
+				return []database.RouteStop{stops[0], stops[1], stops[3], stops[4], stops[2]}
+			}(),
+			settings: Settings{ThresholdM: 100, SequenceWindow: 1},
+			want: map[int64]want{
+				1: {"08:00:00", "08:02:20"},
+				2: {"08:04:50", "08:13:10"},
+				4: {"08:19:30", ""},
+				5: {"", ""},
+				3: {"", ""},
+			},
+		},
+		{
+			name: "許容の範囲内なら飛ばした地点の先に到着できる",
+			stops: func() []database.RouteStop {
+				stops := deliveryStops()
+				far := stopAt(6, "1", "Z", 35.7000000, 139.7000000)
+				return []database.RouteStop{stops[0], far, stops[1], stops[2], stops[3]}
+			}(),
+			settings: Settings{ThresholdM: 100, SequenceWindow: 1},
+			want: map[int64]want{
+				1: {"08:00:00", "08:02:20"},
+				6: {"", ""},
+				2: {"08:04:50", "08:13:10"},
+				3: {"08:15:10", "08:15:30"},
+				4: {"08:19:30", ""},
+			},
+		},
+		{
+			name: "予定と逆の順に到着した地点を順番外とする",
+			stops: func() []database.RouteStop {
+				stops := deliveryStops()
+				// A と B を入れ替える
+				return []database.RouteStop{stops[0], stops[2], stops[1], stops[3]}
+			}(),
+			settings: Settings{ThresholdM: 100, SequenceWindow: 2},
+			want: map[int64]want{
+				1: {"08:00:00", "08:02:20"},
+				3: {"08:15:10", "08:15:30"},
+				2: {"08:04:50", "08:13:10"},
+				4: {"08:19:30", ""},
+			},
+			outOfSequence: []int64{2},
+		},
+	}
+
+	logs := loadTrack(t, fixtureDelivery)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := Evaluate(tt.stops, logs, tt.settings)
+			for id, w := range tt.want {
+				timing := result.Timings[id]
+				if got := clock(timing.ArrivalTime); got != w.arrival {
+					t.Errorf("停車地 %d の到着 = %q, want %q", id, got, w.arrival)
+				}
+				if got := clock(timing.DepartureTime); got != w.departure {
+					t.Errorf("停車地 %d の出発 = %q, want %q", id, got, w.departure)
+				}
+			}
+			outOfSequence := map[int64]bool{}
+			for _, id := range tt.outOfSequence {
+				outOfSequence[id] = true
+			}
+			for id, timing := range result.Timings {
+				if timing.OutOfSequence != outOfSequence[id] {
+					t.Errorf("停車地 %d の順番外 = %v, want %v", id, timing.OutOfSequence, outOfSequence[id])
+				}
+			}
+		})
+	}
+}
+
+func TestFixFiltering(t *testing.T) {
+	// parked_with_multipath.csv の 13:04:00 の測位は 240m 東へ飛んでいる（精度 65m）
+	tests := []struct {
+		name     string
+		settings Settings
+		until    string
+		bearing  bool // 13:10 以降の走り去るログに西向き（270度）の進行方向を付ける
+		want     want
+	}{
+		{name: "対策なしでは飛んだ時点で出発になる", settings: Settings{ThresholdM: 100}, until: "13:04:05", want: want{"13:00:00", "13:04:00"}},
+		{name: "精度の悪い測位は使わない", settings: Settings{ThresholdM: 100, MaxAccuracyM: 30}, until: "13:04:05", want: want{"13:00:00", ""}},
+		{name: "精度の悪い測位は軽く数える", settings: Settings{ThresholdM: 100, MaxAccuracyM: 30, AccuracyMode: AccuracyWeight}, until: "13:04:05", want: want{"13:00:00", ""}},
+		{name: "2件続かなければ出発にしない", settings: Settings{ThresholdM: 100, MinFixes: 2}, until: "13:04:05", want: want{"13:00:00", ""}},
+		{name: "余裕の内側なら出発にしない", settings: Settings{ThresholdM: 100, ExitMarginM: 300}, until: "13:04:05", want: want{"13:00:00", ""}},
+		{name: "2件続いた場合は続き始めた時刻で出発", settings: Settings{ThresholdM: 100, MinFixes: 2}, until: "23:59:59", want: want{"13:00:00", "13:10:10"}},
+		{name: "余裕を出た時刻で出発", settings: Settings{ThresholdM: 100, ExitMarginM: 100}, until: "23:59:59", want: want{"13:00:00", "13:10:20"}},
+		{name: "遠ざかる向きに走り出せば余裕の内側でも出発", settings: Settings{ThresholdM: 100, ExitMarginM: 100, UseBearing: true}, until: "23:59:59", bearing: true, want: want{"13:00:00", "13:10:10"}},
+		{name: "進行方向のないログでは余裕を出るまで待つ", settings: Settings{ThresholdM: 100, ExitMarginM: 100, UseBearing: true}, until: "23:59:59", want: want{"13:00:00", "13:10:20"}},
+	}
+
+	stops := []database.RouteStop{stopAt(1, "1", "倉庫", 35.6812000, 139.7671000)}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := logsUntil(loadTrack(t, fixtureParked), tt.until)
+			if tt.bearing {
+				for i := range logs {
+					if clock(&logs[i].Timestamp) >= "13:10:00" {
+						logs[i].Bearing = sql.NullFloat64{Float64: 270, Valid: true}
+					}
+				}
+			}
+			timing := Evaluate(stops, logs, tt.settings).Timings[1]
+			if got := clock(timing.ArrivalTime); got != tt.want.arrival {
+				t.Errorf("到着 = %q, want %q", got, tt.want.arrival)
+			}
+			if got := clock(timing.DepartureTime); got != tt.want.departure {
+				t.Errorf("出発 = %q, want %q", got, tt.want.departure)
+			}
+		})
+	}
+}
+
+func TestCurrentSection(t *testing.T) {
+	withoutDepot := func() []database.RouteStop { return deliveryStops()[1:] }
+	tests := []struct {
+		name       string
+		stops      []database.RouteStop
+		until      string
+		settings   Settings
+		wantFrom   string
+		wantTo     string
+		wantToIdx  int
+		wantWithin bool
+		wantStay   time.Duration
+	}{
+		{
+			name:      "C に到着済みなら D へ向かっている",
+			stops:     deliveryStops(),
+			until:     "23:59:59",
+			settings:  Settings{ThresholdM: 100},
+			wantFrom:  "C",
+			wantTo:    "D",
+			wantToIdx: 4,
+		},
+		{
+			name:       "A で滞在中（到着の確定前）は A の範囲内で滞在時間を数える",
+			stops:      withoutDepot(),
+			until:      "08:10:00",
+			settings:   Settings{ThresholdM: 100, StayMinutes: 10},
+			wantTo:     "A",
+			wantToIdx:  0,
+			wantWithin: true,
+			wantStay:   5*time.Minute + 10*time.Second,
+		},
+		{
+			name:      "B を通過した直後は B に到着済みで C へ向かっている",
+			stops:     withoutDepot(),
+			until:     "08:16:00",
+			settings:  Settings{ThresholdM: 100},
+			wantFrom:  "B",
+			wantTo:    "C",
+			wantToIdx: 2,
+		},
+	}
+
+	track := loadTrack(t, fixtureDelivery)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := logsUntil(track, tt.until)
+			current := Evaluate(tt.stops, logs, tt.settings).Current
+			if current == nil {
+				t.Fatal("Current = nil")
+			}
+			if !current.Timestamp.Equal(logs[len(logs)-1].Timestamp) {
+				t.Errorf("Timestamp = %v, want 最新のログ %v", current.Timestamp, logs[len(logs)-1].Timestamp)
+			}
+			if current.FromStop != tt.wantFrom || current.ToStop != tt.wantTo || current.ToStopIdx != tt.wantToIdx {
+				t.Errorf("区間 = %q → %q (%d), want %q → %q (%d)",
+					current.FromStop, current.ToStop, current.ToStopIdx, tt.wantFrom, tt.wantTo, tt.wantToIdx)
+			}
+			if current.IsWithinRange != tt.wantWithin {
+				t.Errorf("IsWithinRange = %v, want %v", current.IsWithinRange, tt.wantWithin)
+			}
+			if current.CurrentStayDuration != tt.wantStay {
+				t.Errorf("CurrentStayDuration = %v, want %v", current.CurrentStayDuration, tt.wantStay)
+			}
+		})
+	}
+
+	if current := Evaluate(deliveryStops(), nil, Settings{ThresholdM: 100}).Current; current != nil {
+		t.Errorf("ログがない場合の Current = %+v, want nil", current)
+	}
+}
+
+func TestOverrides(t *testing.T) {
+	at := func(clock string) *time.Time {
+		ts, _ := time.ParseInLocation("2006-01-02 15:04:05", "2025-12-01 "+clock, jst)
+		return &ts
+	}
+	tests := []struct {
+		name      string
+		logs      []database.LocationLog
+		stops     []database.RouteStop // nil なら deliveryStops
+		settings  Settings
+		overrides []Override
+		want      map[int64]want
+		wantTo    string // 走行中の区間の行き先（位置ログがなければ空）
+	}{
+		{
+			name:      "判定で到着した C を未到着にすると C へ向かっている",
+			logs:      loadTrack(t, fixtureDelivery),
+			overrides: []Override{{StopID: 4}},
+			want:      map[int64]want{2: {"08:04:50", "08:13:10"}, 3: {"08:15:10", "08:15:30"}, 4: {}},
+			wantTo:    "C",
+		},
+		{
+			name:      "測位できない D の到着・出発を指定する",
+			logs:      loadTrack(t, fixtureDelivery),
+			overrides: []Override{{StopID: 5, Arrived: true, ArrivalTime: at("09:00:00"), DepartureTime: at("09:20:00")}},
+			want:      map[int64]want{5: {"09:00:00", "09:20:00"}},
+		},
+		{
+			name:      "位置ログがなくても指定した到着は結果に含める",
+			overrides: []Override{{StopID: 3, Arrived: true, ArrivalTime: at("10:00:00")}},
+			want:      map[int64]want{3: {"10:00:00", ""}},
+		},
+		{
+			name: "順番を考慮した判定は手動で到着にした地点の次から続ける",
+			logs: loadTrack(t, fixtureDelivery),
+			stops: func() []database.RouteStop {
+				stops := deliveryStops()
+				// 測位できない Y・Z を A の前に入れる（指定がなければ A は許容の範囲外）
+				y := stopAt(6, "1", "Y", 35.7000000, 139.7000000)
+				z := stopAt(7, "2", "Z", 35.7100000, 139.7000000)
+				return []database.RouteStop{stops[0], y, z, stops[1], stops[2], stops[3]}
+			}(),
+			settings: Settings{ThresholdM: 100, SequenceWindow: 1},
+			overrides: []Override{
+				{StopID: 6, Arrived: true, ArrivalTime: at("08:03:00"), DepartureTime: at("08:03:30")},
+				{StopID: 7, Arrived: true, ArrivalTime: at("08:04:00"), DepartureTime: at("08:04:30")},
+			},
+			want: map[int64]want{2: {"08:04:50", "08:13:10"}, 3: {"08:15:10", "08:15:30"}, 4: {"08:19:30", ""}},
+		},
+		{
+			name:      "順番を考慮した判定は手動で未到着にした地点を飛ばして続ける",
+			logs:      loadTrack(t, fixtureDelivery),
+			settings:  Settings{ThresholdM: 100, SequenceWindow: 1},
+			overrides: []Override{{StopID: 2}},
+			want:      map[int64]want{2: {}, 3: {"08:15:10", "08:15:30"}, 4: {"08:19:30", ""}},
+			wantTo:    "D",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stops, settings := tt.stops, tt.settings
+			if stops == nil {
+				stops = deliveryStops()
+			}
+			if settings.ThresholdM == 0 {
+				settings.ThresholdM = 100
+			}
+			tracker := NewTracker(stops, settings, nil, nil)
+			tracker.SetOverrides(tt.overrides)
+			for _, log := range tt.logs {
+				tracker.Add(log)
+			}
+			result := tracker.Result()
+			for id, w := range tt.want {
+				g := result.Timings[id]
+				if g == nil {
+					t.Fatalf("停車地 %d の判定がない", id)
+				}
+				if clock(g.ArrivalTime) != w.arrival || clock(g.DepartureTime) != w.departure || g.Arrived != (w.arrival != "") {
+					t.Errorf("停車地 %d = %v %q %q, want %q %q", id, g.Arrived, clock(g.ArrivalTime), clock(g.DepartureTime), w.arrival, w.departure)
+				}
+			}
+			for _, o := range tt.overrides {
+				if !result.Timings[o.StopID].Manual {
+					t.Errorf("停車地 %d の Manual = false, want true", o.StopID)
+				}
+			}
+			if tt.logs == nil {
+				if result.Current != nil {
+					t.Errorf("Current = %+v, want nil", result.Current)
+				}
+			} else if tt.wantTo != "" && result.Current.ToStop != tt.wantTo {
+				t.Errorf("ToStop = %q, want %q", result.Current.ToStop, tt.wantTo)
+			}
+		})
+	}
+}
+
+func TestTruckStatus(t *testing.T) {
+	plannedC := time.Date(2025, 12, 1, 8, 15, 0, 0, jst)
+	stops := deliveryStops()
+	stops[3].ArrivalTime = sql.NullString{String: "08:15", Valid: true}
+	stops[3].PlannedAt = sql.NullTime{Time: plannedC, Valid: true}
+
+	tests := []struct {
+		name          string
+		target        int // stops の添字
+		settings      Settings
+		logs          bool
+		wantAway      int
+		wantCurrent   string
+		wantDelay     int
+		wantHasLoc    bool
+		wantInRange   bool
+		wantScheduled string
+	}{
+		{name: "次の地点", target: 4, settings: Settings{ThresholdM: 100}, logs: true,
+			wantAway: 1, wantCurrent: "C", wantDelay: 4, wantHasLoc: true, wantScheduled: "08:15"},
+		{name: "到着済みの地点", target: 3, settings: Settings{ThresholdM: 100}, logs: true,
+			wantAway: 0, wantCurrent: "C", wantDelay: 4, wantHasLoc: true, wantInRange: true, wantScheduled: "08:15"},
+		{name: "通過済みの地点", target: 1, settings: Settings{ThresholdM: 100}, logs: true,
+			wantAway: -2, wantCurrent: "C", wantDelay: 4, wantHasLoc: true, wantScheduled: "08:15"},
+		{name: "どこにも到着していなければ出発行を除いて数える", target: 3, settings: Settings{ThresholdM: 100, StayMinutes: 10}, logs: true,
+			wantAway: 3, wantCurrent: "出発前", wantHasLoc: true, wantInRange: true},
+		{name: "位置ログがない", target: 3, settings: Settings{ThresholdM: 100}, logs: false},
+	}
+
+	track := loadTrack(t, fixtureDelivery)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logs []database.LocationLog
+			if tt.logs {
+				logs = track
+			}
+			status := Evaluate(stops, logs, tt.settings).TruckStatus(stops[tt.target])
+			if status.HasLocation != tt.wantHasLoc {
+				t.Errorf("HasLocation = %v, want %v", status.HasLocation, tt.wantHasLoc)
+			}
+			if status.StopsAway != tt.wantAway {
+				t.Errorf("StopsAway = %d, want %d", status.StopsAway, tt.wantAway)
+			}
+			if status.CurrentStopName != tt.wantCurrent {
+				t.Errorf("CurrentStopName = %q, want %q", status.CurrentStopName, tt.wantCurrent)
+			}
+			if status.DelayMinutes != tt.wantDelay {
+				t.Errorf("DelayMinutes = %d, want %d", status.DelayMinutes, tt.wantDelay)
+			}
+			if status.IsWithinRange != tt.wantInRange {
+				t.Errorf("IsWithinRange = %v, want %v", status.IsWithinRange, tt.wantInRange)
+			}
+			if status.ScheduledTime != tt.wantScheduled {
+				t.Errorf("ScheduledTime = %q, want %q", status.ScheduledTime, tt.wantScheduled)
+			}
+		})
+	}
+}
+
+func TestSettingsFromProject(t *testing.T) {
+	tests := []struct {
+		name    string
+		project database.Project
+		want    Settings
+	}{
+		{
+			name:    "未設定なら既定の範囲で滞在・速度は見ない",
+			project: database.Project{},
+			want:    Settings{ThresholdM: DefaultThresholdM},
+		},
+		{
+			name: "案件の設定を使う",
+			project: database.Project{
+				ArrivalThresholdMeters: sql.NullInt64{Int64: 50, Valid: true},
+				JudgeStayTimeMinutes:   sql.NullInt64{Int64: 3, Valid: true},
+				JudgeSpeedLimitKmh:     sql.NullFloat64{Float64: 10, Valid: true},
+			},
+			want: Settings{ThresholdM: 50, StayMinutes: 3, SpeedLimitKmh: 10},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SettingsFromProject(tt.project); got != tt.want {
+				t.Errorf("SettingsFromProject() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFenceFor(t *testing.T) {
+	s := Settings{ThresholdM: 100}
+	at := func(lat, lon float64) database.RouteStop {
+		return database.RouteStop{
+			Latitude:  sql.NullFloat64{Float64: lat, Valid: true},
+			Longitude: sql.NullFloat64{Float64: lon, Valid: true},
+		}
+	}
+	// 停車地の北東側にだけ広がる長方形（約180m × 90m）
+	square := `{"type":"Polygon","coordinates":[[[139.7670,35.6810],[139.7690,35.6810],[139.7690,35.6818],[139.7670,35.6818],[139.7670,35.6810]]]}`
+
+	tests := []struct {
+		name     string
+		stop     database.RouteStop
+		lat, lon float64
+		want     bool
+	}{
+		{name: "案件の範囲の内側", stop: at(35.681, 139.767), lat: 35.6808, lon: 139.767, want: true},
+		{name: "案件の範囲の外側", stop: at(35.681, 139.767), lat: 35.682, lon: 139.767, want: false},
+		{
+			name: "停車地ごとの範囲を優先する",
+			stop: func() database.RouteStop {
+				stop := at(35.681, 139.767)
+				stop.GeofenceRadiusM = sql.NullInt64{Int64: 150, Valid: true}
+				return stop
+			}(),
+			lat: 35.682, lon: 139.767, want: true,
+		},
+		{
+			name: "エリアの内側なら円の外でも範囲内",
+			stop: func() database.RouteStop {
+				stop := at(35.681, 139.767)
+				stop.GeofencePolygon = sql.NullString{String: square, Valid: true}
+				return stop
+			}(),
+			lat: 35.6815, lon: 139.7685, want: true,
+		},
+		{
+			name: "エリアの外側なら円の内でも範囲外",
+			stop: func() database.RouteStop {
+				stop := at(35.681, 139.767)
+				stop.GeofencePolygon = sql.NullString{String: square, Valid: true}
+				return stop
+			}(),
+			lat: 35.6808, lon: 139.767, want: false,
+		},
+		{
+			name: "読み取れないエリアは円で判定する",
+			stop: func() database.RouteStop {
+				stop := at(35.681, 139.767)
+				stop.GeofencePolygon = sql.NullString{String: "{", Valid: true}
+				return stop
+			}(),
+			lat: 35.6808, lon: 139.767, want: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fence, ok := s.FenceFor(tt.stop)
+			if !ok {
+				t.Fatal("FenceFor() = false, want true")
+			}
+			if got := fence.Contains(tt.lat, tt.lon); got != tt.want {
+				t.Errorf("Contains(%v, %v) = %v, want %v", tt.lat, tt.lon, got, tt.want)
+			}
+		})
+	}
+
+	if _, ok := s.FenceFor(database.RouteStop{}); ok {
+		t.Error("座標のない停車地で FenceFor() = true, want false")
+	}
+}
+
+func TestSpeedKmh(t *testing.T) {
+	base := time.Date(2025, 12, 1, 8, 0, 0, 0, jst)
+	tests := []struct {
+		name         string
+		older, newer database.LocationLog
+		want         float64
+	}{
+		{
+			name:  "10秒で約111m（緯度0.001度）",
+			older: database.LocationLog{Latitude: 35.681, Longitude: 139.767, Timestamp: base},
+			newer: database.LocationLog{Latitude: 35.682, Longitude: 139.767, Timestamp: base.Add(10 * time.Second)},
+			want:  40.0,
+		},
+		{
+			name:  "同時刻のログは0",
+			older: database.LocationLog{Latitude: 35.681, Longitude: 139.767, Timestamp: base},
+			newer: database.LocationLog{Latitude: 35.682, Longitude: 139.767, Timestamp: base},
+			want:  0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SpeedKmh(tt.older, tt.newer); got < tt.want-0.5 || got > tt.want+0.5 {
+				t.Errorf("SpeedKmh() = %.2f, want %.1f±0.5", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTrackerResume(t *testing.T) {
+	tests := []struct {
+		name     string
+		fixture  string
+		settings Settings
+	}{
+		{name: "範囲に入れば到着", fixture: fixtureDelivery, settings: Settings{ThresholdM: 100}},
+		{name: "滞在3分・10km/h以下", fixture: fixtureDelivery, settings: Settings{ThresholdM: 100, StayMinutes: 3, SpeedLimitKmh: 10}},
+		{name: "測位の飛び", fixture: fixtureParked, settings: Settings{ThresholdM: 100, StayMinutes: 3, SpeedLimitKmh: 10}},
+		{name: "順番を考慮", fixture: fixtureDelivery, settings: Settings{ThresholdM: 100, StayMinutes: 3, SpeedLimitKmh: 10, SequenceWindow: 1}},
+		{name: "連続の測位数・余裕", fixture: fixtureParked, settings: Settings{ThresholdM: 100, MinFixes: 3, ExitMarginM: 50, MaxAccuracyM: 30, AccuracyMode: AccuracyWeight}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := loadTrack(t, tt.fixture)
+			stops := deliveryStops()
+			want := Evaluate(stops, logs, tt.settings)
+
+			// 途中経過を取り出して（保存・読み込みを想定）別の Tracker で判定を続けても結果は変わらない
+			for _, split := range []int{1, len(logs) / 3, len(logs) / 2, len(logs) - 1} {
+				first := NewTracker(stops, tt.settings, nil, nil)
+				for _, log := range logs[:split] {
+					first.Add(log)
+				}
+				resumed := NewTracker(stops, tt.settings, first.Visits(), first.Last())
+				for _, log := range logs[split:] {
+					resumed.Add(log)
+				}
+				got := resumed.Result()
+
+				for _, stop := range stops {
+					g, w := got.Timings[stop.ID], want.Timings[stop.ID]
+					if g.Arrived != w.Arrived || clock(g.ArrivalTime) != clock(w.ArrivalTime) || clock(g.DepartureTime) != clock(w.DepartureTime) {
+						t.Errorf("%d件目で再開: 停車地 %s = %v %q %q, want %v %q %q", split, stop.StopName,
+							g.Arrived, clock(g.ArrivalTime), clock(g.DepartureTime), w.Arrived, clock(w.ArrivalTime), clock(w.DepartureTime))
+					}
+				}
+				if *got.Current != *want.Current {
+					t.Errorf("%d件目で再開: Current = %+v, want %+v", split, *got.Current, *want.Current)
+				}
+			}
+		})
+	}
+}
+
+func TestCompare(t *testing.T) {
+	tests := []struct {
+		name          string
+		candidate     Settings
+		wantArrived   int
+		wantFlipped   []int64
+		wantUnchanged []int64 // 到着・出発が変わらない停車地
+	}{
+		{
+			name:          "同じ設定ならどの停車地も変わらない",
+			candidate:     Settings{ThresholdM: 100},
+			wantArrived:   4,
+			wantUnchanged: []int64{1, 2, 3, 4, 5},
+		},
+		{
+			name:          "滞在3分・10km/h以下にすると営業所と通過の B が未到着になる",
+			candidate:     Settings{ThresholdM: 100, StayMinutes: 3, SpeedLimitKmh: 10},
+			wantArrived:   2,
+			wantFlipped:   []int64{1, 3},
+			wantUnchanged: []int64{2, 4, 5},
+		},
+	}
+
+	logs := loadTrack(t, fixtureDelivery)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Compare(deliveryStops(), logs, Settings{ThresholdM: 100}, tt.candidate)
+			if got.CurrentArrived != 4 || got.CandidateArrived != tt.wantArrived {
+				t.Errorf("到着済み = %d → %d, want 4 → %d", got.CurrentArrived, got.CandidateArrived, tt.wantArrived)
+			}
+			if got.Flipped != len(tt.wantFlipped) {
+				t.Errorf("Flipped = %d, want %d", got.Flipped, len(tt.wantFlipped))
+			}
+			byID := make(map[int64]StopDiff, len(got.Stops))
+			for _, d := range got.Stops {
+				byID[d.Stop.ID] = d
+			}
+			for _, id := range tt.wantFlipped {
+				if d := byID[id]; !d.Flipped() || !d.Changed() {
+					t.Errorf("停車地 %d: Flipped = %v, Changed = %v, want true", id, d.Flipped(), d.Changed())
+				}
+			}
+			for _, id := range tt.wantUnchanged {
+				if d := byID[id]; d.Changed() {
+					t.Errorf("停車地 %d: Changed = true, want false", id)
+				}
+			}
+			if shift, ok := byID[2].ArrivalShift(); !ok || shift != 0 {
+				t.Errorf("A の ArrivalShift = %v, %v, want 0, true", shift, ok)
+			}
+		})
+	}
+}
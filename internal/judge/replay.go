@@ -0,0 +1,92 @@
+package judge
+
+import (
+	"time"
+
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
+)
+
+// StopDiff は同じ位置ログを現在の設定と別の設定で判定したときの停車地の到着・出発
+type StopDiff struct {
+	Stop      database.RouteStop
+	Current   *StopTiming // 現在の設定での判定（座標のない停車地は nil）
+	Candidate *StopTiming // 別の設定での判定
+}
+
+// Flipped は設定によって到着済み・未到着が変わるかどうか
+func (d StopDiff) Flipped() bool {
+	return timingArrived(d.Current) != timingArrived(d.Candidate)
+}
+
+// ArrivalShift は到着時刻のずれ（別の設定 − 現在の設定）。どちらかで未到着なら false
+func (d StopDiff) ArrivalShift() (time.Duration, bool) {
+	if !timingArrived(d.Current) || !timingArrived(d.Candidate) {
+		return 0, false
+	}
+	return d.Candidate.ArrivalTime.Sub(*d.Current.ArrivalTime), true
+}
+
+// DepartureShift は出発時刻のずれ（別の設定 − 現在の設定）。どちらかで出発していなければ false
+func (d StopDiff) DepartureShift() (time.Duration, bool) {
+	if !timingArrived(d.Current) || !timingArrived(d.Candidate) || d.Current.DepartureTime == nil || d.Candidate.DepartureTime == nil {
+		return 0, false
+	}
+	return d.Candidate.DepartureTime.Sub(*d.Current.DepartureTime), true
+}
+
+// Changed は到着済み・未到着か到着・出発時刻のどれかが設定によって変わるかどうか
+func (d StopDiff) Changed() bool {
+	if d.Flipped() {
+		return true
+	}
+	if !timingArrived(d.Current) {
+		return false
+	}
+	if shift, _ := d.ArrivalShift(); shift != 0 {
+		return true
+	}
+	if (d.Current.DepartureTime == nil) != (d.Candidate.DepartureTime == nil) {
+		return true
+	}
+	shift, _ := d.DepartureShift()
+	return shift != 0
+}
+
+func timingArrived(t *StopTiming) bool {
+	return t != nil && t.Arrived && t.ArrivalTime != nil
+}
+
+// Comparison は同じ停車地・位置ログを2つの設定で判定し直した結果（手動での指定は含めない）
+type Comparison struct {
+	Stops            []StopDiff // コースの並び順
+	CurrentArrived   int        // 現在の設定で到着済みの停車地数
+	CandidateArrived int        // 別の設定で到着済みの停車地数
+	Flipped          int        // 到着済み・未到着が変わる停車地数
+	Changed          int        // 到着・出発の判定が変わる停車地数（Flipped を含む）
+}
+
+// Compare はコースの停車地（並び順）と運行期間内の位置ログ（時刻の昇順）を
+// 現在の設定と別の設定で判定し、停車地ごとの違いを返す
+func Compare(stops []database.RouteStop, logs []database.LocationLog, current, candidate Settings) Comparison {
+	cur := Evaluate(stops, logs, current)
+	cand := Evaluate(stops, logs, candidate)
+
+	c := Comparison{Stops: make([]StopDiff, 0, len(stops))}
+	for _, stop := range stops {
+		d := StopDiff{Stop: stop, Current: cur.Timings[stop.ID], Candidate: cand.Timings[stop.ID]}
+		if timingArrived(d.Current) {
+			c.CurrentArrived++
+		}
+		if timingArrived(d.Candidate) {
+			c.CandidateArrived++
+		}
+		if d.Flipped() {
+			c.Flipped++
+		}
+		if d.Changed() {
+			c.Changed++
+		}
+		c.Stops = append(c.Stops, d)
+	}
+	return c
+}
@@ -0,0 +1,84 @@
+// Package mailer はログイン用メールと同じSMTPの設定で通知メールを送る
+package mailer
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config はSMTPの設定（SMTP_HOST などの環境変数から読んだもの）
+type Config struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+	FromName string
+}
+
+// Mailer は通知メールの送信
+type Mailer struct {
+	cfg Config
+}
+
+// New は送信元を返す。SMTPが設定されていなければ nil（メールを送らない）
+func New(cfg Config) *Mailer {
+	if cfg.Host == "" || cfg.From == "" {
+		return nil
+	}
+	return &Mailer{cfg: cfg}
+}
+
+// Send はテキストのメールを送る（STARTTLS に対応したサーバーなら暗号化して送る）
+func (m *Mailer) Send(to []string, subject, body string) error {
+	if len(to) == 0 {
+		return nil
+	}
+	from := mail.Address{Name: m.cfg.FromName, Address: m.cfg.From}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", from.String())
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.BEncoding.Encode("UTF-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	msg.WriteString("Content-Transfer-Encoding: base64\r\n\r\n")
+	encoded := base64.StdEncoding.EncodeToString([]byte(body))
+	for len(encoded) > 76 {
+		msg.WriteString(encoded[:76] + "\r\n")
+		encoded = encoded[76:]
+	}
+	msg.WriteString(encoded + "\r\n")
+
+	var auth smtp.Auth
+	if m.cfg.Username != "" {
+		auth = smtp.PlainAuth("", m.cfg.Username, m.cfg.Password, m.cfg.Host)
+	}
+	addr := net.JoinHostPort(m.cfg.Host, strconv.Itoa(m.cfg.Port))
+	if err := smtp.SendMail(addr, auth, m.cfg.From, to, msg.Bytes()); err != nil {
+		return fmt.Errorf("メールの送信に失敗: %w", err)
+	}
+	return nil
+}
+
+// ParseAddresses はカンマ・空白区切りの宛先を検証して返す
+func ParseAddresses(s string) ([]string, error) {
+	var addrs []string
+	for _, field := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ' ' || r == '\n' || r == '\r' || r == '\t' }) {
+		addr, err := mail.ParseAddress(field)
+		if err != nil {
+			return nil, fmt.Errorf("メールアドレスが正しくありません: %s", field)
+		}
+		addrs = append(addrs, addr.Address)
+	}
+	return addrs, nil
+}
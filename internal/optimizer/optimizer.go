@@ -0,0 +1,319 @@
+// Package optimizer はコースの停車地の訪問順をヒューリスティックで最適化する
+// 最近傍法で初期解を作り、2-opt と or-opt で改善する。希望時間帯を外れる到着はペナルティとして評価する
+package optimizer
+
+import (
+	"time"
+
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/geo"
+)
+
+// 既定の移動モデル（直線距離 × 迂回係数 ÷ 平均速度）
+const (
+	DefaultSpeedKmh     = 25.0
+	DefaultDetourFactor = 1.3
+)
+
+// 評価の重み
+const (
+	latePenaltyKmPerMinute = 0.1  // 距離優先: 遅延10分を1km相当として加える
+	lateWeightPerMinute    = 1000 // 遅延優先: 遅延1分の重み（距離1kmが1）
+	maxPasses              = 20   // 改善を繰り返す上限
+	maxSegmentLength       = 3    // or-opt で移動する区間の最大長
+	costEpsilon            = 1e-9
+)
+
+// Objective は最適化の目的
+type Objective string
+
+const (
+	ObjectiveDistance Objective = "distance" // 総距離を最小化（遅延はペナルティ）
+	ObjectiveLateness Objective = "lateness" // 希望時間帯からの遅延を最小化（同じなら距離の短い方）
+)
+
+// Stop は訪問する地点
+type Stop struct {
+	ID          int64
+	Latitude    float64
+	Longitude   float64
+	Stay        time.Duration // 滞在予定
+	WindowStart time.Time     // 希望時間帯の開始（ゼロ値は指定なし）
+	WindowEnd   time.Time     // 希望時間帯の終了（ゼロ値は指定なし）
+}
+
+// Matrix は2地点間の距離と移動時間を返す
+// 道路距離・所要時間の表など外部のデータを使う場合はこれを実装して差し替える
+type Matrix interface {
+	Distance(from, to Stop) float64 // km
+	TravelTime(from, to Stop) time.Duration
+}
+
+// HaversineMatrix は直線距離に迂回係数を掛け、一定の平均速度で移動時間を近似する
+type HaversineMatrix struct {
+	SpeedKmh     float64
+	DetourFactor float64
+}
+
+// Distance は直線距離 × 迂回係数（km）
+func (m HaversineMatrix) Distance(from, to Stop) float64 {
+	detour := m.DetourFactor
+	if detour <= 0 {
+		detour = 1
+	}
+	return geo.Haversine(from.Latitude, from.Longitude, to.Latitude, to.Longitude) * detour
+}
+
+// TravelTime は Distance を平均速度で割った移動時間
+func (m HaversineMatrix) TravelTime(from, to Stop) time.Duration {
+	speed := m.SpeedKmh
+	if speed <= 0 {
+		speed = DefaultSpeedKmh
+	}
+	return time.Duration(m.Distance(from, to) / speed * float64(time.Hour))
+}
+
+// Problem は1コース分の最適化の入力
+type Problem struct {
+	Depot     *Stop // 出発地点（nil なら最初に訪問する地点から出発）。戻りは考慮しない
+	Stops     []Stop
+	StartTime time.Time // 出発時刻（Depot がなければ最初の地点への到着時刻）
+	Objective Objective
+	Matrix    Matrix // nil なら既定の HaversineMatrix
+}
+
+// Visit は訪問順の1地点分の予定
+type Visit struct {
+	Stop    Stop
+	Arrival time.Time     // 作業開始の予定（希望時間帯より早く着く場合は開始時刻まで待つ）
+	Wait    time.Duration // 希望時間帯の開始までの待ち時間
+	Late    time.Duration // 希望時間帯の終了からの遅れ
+}
+
+// Plan は訪問順とその評価
+type Plan struct {
+	Order      []int // Problem.Stops の添字の訪問順
+	Visits     []Visit
+	DistanceKm float64
+	Lateness   time.Duration // 遅れの合計
+	LateStops  int
+	Finish     time.Time // 最後の地点の出発予定
+}
+
+// solver は移動の距離・時間を事前に計算した最適化の作業領域
+type solver struct {
+	p           Problem
+	dist        [][]float64
+	travel      [][]time.Duration
+	depotDist   []float64
+	depotTravel []time.Duration
+	buf         []int
+}
+
+func newSolver(p Problem) *solver {
+	if p.Matrix == nil {
+		p.Matrix = HaversineMatrix{SpeedKmh: DefaultSpeedKmh, DetourFactor: DefaultDetourFactor}
+	}
+	n := len(p.Stops)
+	s := &solver{
+		p:           p,
+		dist:        make([][]float64, n),
+		travel:      make([][]time.Duration, n),
+		depotDist:   make([]float64, n),
+		depotTravel: make([]time.Duration, n),
+		buf:         make([]int, n),
+	}
+	for i := range p.Stops {
+		s.dist[i] = make([]float64, n)
+		s.travel[i] = make([]time.Duration, n)
+		for j := range p.Stops {
+			if i != j {
+				s.dist[i][j] = p.Matrix.Distance(p.Stops[i], p.Stops[j])
+				s.travel[i][j] = p.Matrix.TravelTime(p.Stops[i], p.Stops[j])
+			}
+		}
+		if p.Depot != nil {
+			s.depotDist[i] = p.Matrix.Distance(*p.Depot, p.Stops[i])
+			s.depotTravel[i] = p.Matrix.TravelTime(*p.Depot, p.Stops[i])
+		}
+	}
+	return s
+}
+
+// simulate は訪問順をたどって予定を計算する。visits が nil でなければ各地点の予定を書き込む
+func (s *solver) simulate(order []int, visits []Visit) (km float64, lateness time.Duration, lateStops int, finish time.Time) {
+	t := s.p.StartTime
+	for k, i := range order {
+		stop := s.p.Stops[i]
+		switch {
+		case k > 0:
+			prev := order[k-1]
+			km += s.dist[prev][i]
+			t = t.Add(s.travel[prev][i])
+		case s.p.Depot != nil:
+			km += s.depotDist[i]
+			t = t.Add(s.depotTravel[i])
+		}
+
+		var wait, late time.Duration
+		if !stop.WindowStart.IsZero() && t.Before(stop.WindowStart) {
+			wait = stop.WindowStart.Sub(t)
+			t = stop.WindowStart
+		}
+		if !stop.WindowEnd.IsZero() && t.After(stop.WindowEnd) {
+			late = t.Sub(stop.WindowEnd)
+			lateness += late
+			lateStops++
+		}
+		if visits != nil {
+			visits[k] = Visit{Stop: stop, Arrival: t, Wait: wait, Late: late}
+		}
+		t = t.Add(stop.Stay)
+	}
+	return km, lateness, lateStops, t
+}
+
+// cost は目的に応じた訪問順の評価値（小さいほど良い）
+func (s *solver) cost(order []int) float64 {
+	km, lateness, _, _ := s.simulate(order, nil)
+	if s.p.Objective == ObjectiveLateness {
+		return lateness.Minutes()*lateWeightPerMinute + km
+	}
+	return km + lateness.Minutes()*latePenaltyKmPerMinute
+}
+
+// Evaluate は指定した訪問順の予定と評価を返す（現在の順番との比較用）
+func Evaluate(p Problem, order []int) Plan {
+	return newSolver(p).plan(order)
+}
+
+func (s *solver) plan(order []int) Plan {
+	visits := make([]Visit, len(order))
+	km, lateness, lateStops, finish := s.simulate(order, visits)
+	return Plan{
+		Order:      append([]int(nil), order...),
+		Visits:     visits,
+		DistanceKm: km,
+		Lateness:   lateness,
+		LateStops:  lateStops,
+		Finish:     finish,
+	}
+}
+
+// Optimize は訪問順を最適化した予定を返す
+// 現在の順番（Stops の並び）と最近傍法の解をそれぞれ改善し、良い方を採用するため現在の順番より悪くはならない
+func Optimize(p Problem) Plan {
+	s := newSolver(p)
+	n := len(p.Stops)
+	if n == 0 {
+		return s.plan(nil)
+	}
+
+	current := make([]int, n)
+	for i := range current {
+		current[i] = i
+	}
+	best := s.improve(current)
+	bestCost := s.cost(best)
+	if candidate := s.improve(s.nearestNeighbour()); s.cost(candidate) < bestCost-costEpsilon {
+		best = candidate
+	}
+	return s.plan(best)
+}
+
+// nearestNeighbour は出発地点（なければ現在の最初の地点）から最も近い未訪問の地点を順にたどる
+func (s *solver) nearestNeighbour() []int {
+	n := len(s.p.Stops)
+	order := make([]int, 0, n)
+	visited := make([]bool, n)
+	prev := -1
+	if s.p.Depot == nil {
+		prev = 0
+		order = append(order, 0)
+		visited[0] = true
+	}
+	for len(order) < n {
+		next := -1
+		for j := 0; j < n; j++ {
+			if visited[j] {
+				continue
+			}
+			d := s.depotDist[j]
+			if prev >= 0 {
+				d = s.dist[prev][j]
+			}
+			if next < 0 || d < s.distFrom(prev, next) {
+				next = j
+			}
+		}
+		order = append(order, next)
+		visited[next] = true
+		prev = next
+	}
+	return order
+}
+
+// distFrom は地点 from（-1 は出発地点）から to までの距離
+func (s *solver) distFrom(from, to int) float64 {
+	if from < 0 {
+		return s.depotDist[to]
+	}
+	return s.dist[from][to]
+}
+
+// improve は 2-opt（区間の反転）と or-opt（短い区間の移動）で改善がなくなるまで訪問順を組み替える
+func (s *solver) improve(initial []int) []int {
+	order := append([]int(nil), initial...)
+	n := len(order)
+	best := s.cost(order)
+
+	for pass := 0; pass < maxPasses; pass++ {
+		improved := false
+
+		// 2-opt: order[i..j] を反転
+		for i := 0; i < n-1; i++ {
+			for j := i + 1; j < n; j++ {
+				copy(s.buf, order)
+				for a, b := i, j; a < b; a, b = a+1, b-1 {
+					s.buf[a], s.buf[b] = s.buf[b], s.buf[a]
+				}
+				if c := s.cost(s.buf); c < best-costEpsilon {
+					copy(order, s.buf)
+					best = c
+					improved = true
+				}
+			}
+		}
+
+		// or-opt: 長さ l の区間 order[i:i+l] を別の位置 k へ移動
+		for l := 1; l <= maxSegmentLength && l < n; l++ {
+			for i := 0; i+l <= n; i++ {
+				for k := 0; k <= n-l; k++ {
+					if k == i {
+						continue
+					}
+					moveSegment(s.buf, order, i, l, k)
+					if c := s.cost(s.buf); c < best-costEpsilon {
+						copy(order, s.buf)
+						best = c
+						improved = true
+					}
+				}
+			}
+		}
+
+		if !improved {
+			break
+		}
+	}
+	return order
+}
+
+// moveSegment は src の区間 [i, i+l) を取り除いた列の位置 k に挿入した結果を dst に書き込む
+func moveSegment(dst, src []int, i, l, k int) {
+	rest := make([]int, 0, len(src)-l)
+	rest = append(rest, src[:i]...)
+	rest = append(rest, src[i+l:]...)
+	n := copy(dst, rest[:k])
+	n += copy(dst[n:], src[i:i+l])
+	copy(dst[n:], rest[k:])
+}
@@ -0,0 +1,228 @@
+package optimizer
+
+import (
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// lineMatrix は経度を直線上の位置（km）とみなし、1kmを1分で走る移動モデル
+type lineMatrix struct{}
+
+func (lineMatrix) Distance(from, to Stop) float64 {
+	return math.Abs(to.Longitude - from.Longitude)
+}
+
+func (m lineMatrix) TravelTime(from, to Stop) time.Duration {
+	return time.Duration(m.Distance(from, to) * float64(time.Minute))
+}
+
+var start = time.Date(2025, 12, 1, 9, 0, 0, 0, time.UTC)
+
+// at は出発から minutes 分後
+func at(minutes int) time.Time {
+	return start.Add(time.Duration(minutes) * time.Minute)
+}
+
+// line は直線上の位置 x（km）の地点
+func line(id int64, x float64) Stop {
+	return Stop{ID: id, Longitude: x}
+}
+
+func TestOptimizeStartsFromDepot(t *testing.T) {
+	// 出発地点 0 から 5・1・3 の順に並んだ地点は、近い順（1 → 3 → 5）に回る
+	p := Problem{
+		Depot:     &Stop{Longitude: 0},
+		Stops:     []Stop{line(1, 5), line(2, 1), line(3, 3)},
+		StartTime: start,
+		Matrix:    lineMatrix{},
+	}
+	plan := Optimize(p)
+	if want := []int{1, 2, 0}; !reflect.DeepEqual(plan.Order, want) {
+		t.Fatalf("Order = %v, want %v", plan.Order, want)
+	}
+	if plan.DistanceKm != 5 {
+		t.Errorf("DistanceKm = %v, want 5（出発地点からの距離を含む）", plan.DistanceKm)
+	}
+	if got := plan.Visits[0].Arrival; !got.Equal(at(1)) {
+		t.Errorf("最初の地点の到着 = %v, want 出発地点から1分後", got)
+	}
+	if len(plan.Order) != len(p.Stops) {
+		t.Errorf("出発地点は訪問順に含めない: Order = %v", plan.Order)
+	}
+}
+
+func TestNearestNeighbour(t *testing.T) {
+	stops := []Stop{line(1, 2), line(2, 10), line(3, 3), line(4, -1)}
+	tests := []struct {
+		name  string
+		depot *Stop
+		want  []int
+	}{
+		// 出発地点がなければ現在の最初の地点から始める
+		{name: "出発地点なし", want: []int{0, 2, 3, 1}},
+		{name: "出発地点あり", depot: &Stop{Longitude: 9}, want: []int{1, 2, 0, 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newSolver(Problem{Depot: tt.depot, Stops: stops, StartTime: start, Matrix: lineMatrix{}})
+			if got := s.nearestNeighbour(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("nearestNeighbour = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTimeWindowPenalty(t *testing.T) {
+	// 出発地点 0、A は東 5km（10:05 までに着きたい）、B は西 1km（指定なし）
+	// B → A は距離が短い（7km）が A に2分遅れる。A → B は11kmだが遅れない
+	depot := &Stop{Longitude: 0}
+	a := Stop{ID: 1, Longitude: 5, WindowEnd: at(5)}
+	b := line(2, -1)
+	problem := func(objective Objective) Problem {
+		return Problem{Depot: depot, Stops: []Stop{a, b}, StartTime: start, Objective: objective, Matrix: lineMatrix{}}
+	}
+
+	late := Evaluate(problem(ObjectiveDistance), []int{1, 0})
+	if late.LateStops != 1 || late.Lateness != 2*time.Minute {
+		t.Fatalf("B → A の遅れ = %d件 %v, want 1件 2m0s", late.LateStops, late.Lateness)
+	}
+	if late.Visits[1].Late != 2*time.Minute {
+		t.Errorf("A の遅れ = %v, want 2m0s", late.Visits[1].Late)
+	}
+
+	// 遅れは距離に加えたペナルティになる
+	s := newSolver(problem(ObjectiveDistance))
+	if got, want := s.cost([]int{1, 0}), 7+2*latePenaltyKmPerMinute; math.Abs(got-want) > 1e-9 {
+		t.Errorf("距離優先の B → A の評価 = %v, want %v", got, want)
+	}
+
+	tests := []struct {
+		objective Objective
+		want      []int
+	}{
+		// 距離優先ではペナルティを加えても B → A の方が良い
+		{objective: ObjectiveDistance, want: []int{1, 0}},
+		// 遅延優先では距離が長くても遅れない A → B を選ぶ
+		{objective: ObjectiveLateness, want: []int{0, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.objective), func(t *testing.T) {
+			if got := Optimize(problem(tt.objective)).Order; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Order = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// 希望時間帯より早く着けば開始まで待ち、遅れにはしない
+	early := Stop{ID: 3, Longitude: 1, WindowStart: at(10), WindowEnd: at(20)}
+	plan := Evaluate(Problem{Depot: depot, Stops: []Stop{early}, StartTime: start, Matrix: lineMatrix{}}, []int{0})
+	if v := plan.Visits[0]; v.Wait != 9*time.Minute || !v.Arrival.Equal(at(10)) || v.Late != 0 {
+		t.Errorf("早着 = 待ち %v 開始 %v 遅れ %v, want 待ち 9m0s 開始 10分後 遅れ 0", v.Wait, v.Arrival, v.Late)
+	}
+}
+
+func TestImproveUncrossesRoute(t *testing.T) {
+	// 正方形の角を対角線で交差する順（左下 → 右上 → 右下 → 左上）に回ると、2-opt で交差がなくなる
+	stops := []Stop{
+		{ID: 1, Latitude: 35.00, Longitude: 139.00},
+		{ID: 2, Latitude: 35.01, Longitude: 139.01},
+		{ID: 3, Latitude: 35.00, Longitude: 139.01},
+		{ID: 4, Latitude: 35.01, Longitude: 139.00},
+	}
+	p := Problem{Stops: stops, StartTime: start}
+	s := newSolver(p)
+	crossed := []int{0, 1, 2, 3}
+	improved := s.improve(crossed)
+	if s.cost(improved) >= s.cost(crossed) {
+		t.Fatalf("improve(%v) = %v、距離が短くならない", crossed, improved)
+	}
+	// 先頭を固定した場合の最短は 左下 → 右下 → 右上 → 左上（またはその逆回り）
+	if want := Evaluate(p, []int{0, 2, 1, 3}).DistanceKm; math.Abs(Evaluate(p, improved).DistanceKm-want) > 1e-9 {
+		t.Errorf("改善後の距離 = %v, want %v", Evaluate(p, improved).DistanceKm, want)
+	}
+}
+
+func TestImproveNeverLonger(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for trial := 0; trial < 50; trial++ {
+		n := 2 + rng.Intn(10)
+		stops := make([]Stop, n)
+		for i := range stops {
+			stops[i] = Stop{ID: int64(i + 1), Latitude: 35.6 + rng.Float64()*0.1, Longitude: 139.6 + rng.Float64()*0.1}
+		}
+		p := Problem{Depot: &Stop{Latitude: 35.65, Longitude: 139.65}, Stops: stops, StartTime: start}
+		if trial%2 == 1 {
+			p.Depot = nil
+		}
+		current := make([]int, n)
+		for i := range current {
+			current[i] = i
+		}
+
+		s := newSolver(p)
+		if got, input := s.cost(s.improve(current)), s.cost(current); got > input+costEpsilon {
+			t.Errorf("試行 %d: improve の距離 %v が入力 %v より長い", trial, got, input)
+		}
+		if got, input := Optimize(p).DistanceKm, Evaluate(p, current).DistanceKm; got > input+costEpsilon {
+			t.Errorf("試行 %d: Optimize の距離 %v が現在の順番 %v より長い", trial, got, input)
+		}
+		if plan := Optimize(p); !isPermutation(plan.Order, n) {
+			t.Errorf("試行 %d: Order = %v、すべての地点を1回ずつ訪問していない", trial, plan.Order)
+		}
+	}
+}
+
+func TestMoveSegment(t *testing.T) {
+	src := []int{0, 1, 2, 3, 4}
+	tests := []struct {
+		name    string
+		i, l, k int
+		want    []int
+	}{
+		{name: "区間を先頭へ", i: 1, l: 2, k: 0, want: []int{1, 2, 0, 3, 4}},
+		{name: "区間を末尾へ", i: 1, l: 2, k: 3, want: []int{0, 3, 4, 1, 2}},
+		{name: "1地点を後ろへ", i: 0, l: 1, k: 2, want: []int{1, 2, 0, 3, 4}},
+		{name: "末尾の区間を途中へ", i: 3, l: 2, k: 1, want: []int{0, 3, 4, 1, 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dst := make([]int, len(src))
+			moveSegment(dst, src, tt.i, tt.l, tt.k)
+			if !reflect.DeepEqual(dst, tt.want) {
+				t.Errorf("moveSegment(i=%d, l=%d, k=%d) = %v, want %v", tt.i, tt.l, tt.k, dst, tt.want)
+			}
+		})
+	}
+}
+
+func TestImproveMovesStrayStop(t *testing.T) {
+	// 直線上で1地点だけ離れた位置に並んでいる（0, 1, 9, 2, 3, ...）と、or-opt でその地点を正しい位置へ移す
+	xs := []float64{0, 1, 9, 2, 3, 4, 5, 6, 7, 8}
+	stops := make([]Stop, len(xs))
+	current := make([]int, len(xs))
+	for i, x := range xs {
+		stops[i] = line(int64(i+1), x)
+		current[i] = i
+	}
+	s := newSolver(Problem{Stops: stops, StartTime: start, Matrix: lineMatrix{}})
+	if got := Evaluate(Problem{Stops: stops, StartTime: start, Matrix: lineMatrix{}}, s.improve(current)).DistanceKm; got != 9 {
+		t.Errorf("改善後の距離 = %v, want 9", got)
+	}
+}
+
+func isPermutation(order []int, n int) bool {
+	if len(order) != n {
+		return false
+	}
+	seen := make([]bool, n)
+	for _, i := range order {
+		if i < 0 || i >= n || seen[i] {
+			return false
+		}
+		seen[i] = true
+	}
+	return true
+}
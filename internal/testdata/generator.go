@@ -33,7 +33,7 @@ type LocationLog struct {
 	Longitude    float64
 	Timestamp    time.Time
 	Accuracy     float64
-	Speed        float64
+	Speed        float64 // km/h（位置情報APIで保存する単位と同じ）
 	Bearing      float64
 	BatteryLevel int
 }
@@ -49,10 +49,12 @@ type GenerateResult struct {
 func (g *Generator) Generate(ctx context.Context, projectID int64, courseName string, baseDate time.Time) (*GenerateResult, error) {
 	result := &GenerateResult{}
 
-	// 1. 停車地一覧を取得
+	// 1. 基準日を運行日とする停車地一覧を取得
+	operatingDate := baseDate.Format("2006-01-02")
 	stops, err := g.DB.ListRouteStopsByCourse(ctx, database.ListRouteStopsByCourseParams{
-		ProjectID:  projectID,
-		CourseName: courseName,
+		ProjectID:     projectID,
+		OperatingDate: operatingDate,
+		CourseName:    courseName,
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to get route stops: %w", err)
@@ -62,10 +64,11 @@ func (g *Generator) Generate(ctx context.Context, projectID int64, courseName st
 		return nil, fmt.Errorf("at least 2 stops required, got %d", len(stops))
 	}
 
-	// 2. 既存ログを削除
+	// 2. 同じ運行日の既存ログを削除
 	if err := g.DB.DeleteLocationLogsByCourse(ctx, database.DeleteLocationLogsByCourseParams{
-		ProjectID:  projectID,
-		CourseName: courseName,
+		ProjectID:     projectID,
+		OperatingDate: operatingDate,
+		CourseName:    courseName,
 	}); err != nil {
 		return nil, fmt.Errorf("failed to delete existing logs: %w", err)
 	}
@@ -99,11 +102,11 @@ func (g *Generator) Generate(ctx context.Context, projectID int64, courseName st
 		result.RouteSegments++
 
 		// 出発時刻と到着時刻を計算
-		departureTime := parseArrivalTime(stopA.ArrivalTime.String, baseDate)
+		departureTime := plannedArrival(stopA, baseDate)
 		if stopA.StayMinutes.Valid {
 			departureTime = departureTime.Add(time.Duration(stopA.StayMinutes.Int64) * time.Minute)
 		}
-		arrivalTime := parseArrivalTime(stopB.ArrivalTime.String, baseDate)
+		arrivalTime := plannedArrival(stopB, baseDate)
 
 		// 滞在ログを生成（最初の停車地のみ、最初のループで）
 		if i == 0 {
@@ -123,16 +126,18 @@ func (g *Generator) Generate(ctx context.Context, projectID int64, courseName st
 	// 4. DBに挿入（testdataではdevice_idはnull）
 	for _, log := range allLogs {
 		err := g.DB.CreateLocationLog(ctx, database.CreateLocationLogParams{
-			ProjectID:    projectID,
-			CourseName:   courseName,
-			DeviceID:     sql.NullString{Valid: false},
-			Latitude:     log.Latitude,
-			Longitude:    log.Longitude,
-			Timestamp:    log.Timestamp,
-			Accuracy:     sql.NullFloat64{Float64: log.Accuracy, Valid: true},
-			Speed:        sql.NullFloat64{Float64: log.Speed, Valid: true},
-			Bearing:      sql.NullFloat64{Float64: log.Bearing, Valid: log.Bearing >= 0},
-			BatteryLevel: sql.NullInt64{Int64: int64(log.BatteryLevel), Valid: true},
+			ProjectID:     projectID,
+			OperatingDate: operatingDate,
+			CourseID:      stops[0].CourseID,
+			CourseName:    courseName,
+			DeviceID:      sql.NullString{Valid: false},
+			Latitude:      log.Latitude,
+			Longitude:     log.Longitude,
+			Timestamp:     log.Timestamp,
+			Accuracy:      sql.NullFloat64{Float64: log.Accuracy, Valid: true},
+			Speed:         sql.NullFloat64{Float64: log.Speed, Valid: true},
+			Bearing:       sql.NullFloat64{Float64: log.Bearing, Valid: log.Bearing >= 0},
+			BatteryLevel:  sql.NullInt64{Int64: int64(log.BatteryLevel), Valid: true},
 		})
 		if err != nil {
 			return nil, fmt.Errorf("failed to insert log: %w", err)
@@ -151,7 +156,7 @@ func (g *Generator) generateStayLogs(stop database.RouteStop, baseDate time.Time
 		return logs
 	}
 
-	arrivalTime := parseArrivalTime(stop.ArrivalTime.String, baseDate)
+	arrivalTime := plannedArrival(stop, baseDate)
 	stayMinutes := int64(0)
 	if stop.StayMinutes.Valid {
 		stayMinutes = stop.StayMinutes.Int64
@@ -264,6 +269,15 @@ func (g *Generator) generateMoveLogs(points []LatLng, departureTime, arrivalTime
 	return logs
 }
 
+// plannedArrival は停車地の到着予定日時を返す（日付をまたぐ運行にも対応）
+// 到着予定日時がない停車地は到着予定 "HH:MM" を基準日の時刻として扱う
+func plannedArrival(stop database.RouteStop, baseDate time.Time) time.Time {
+	if stop.PlannedAt.Valid {
+		return stop.PlannedAt.Time
+	}
+	return parseArrivalTime(stop.ArrivalTime.String, baseDate)
+}
+
 // parseArrivalTime は "HH:MM" 形式の時刻をパースする
 func parseArrivalTime(timeStr string, baseDate time.Time) time.Time {
 	if timeStr == "" {
@@ -0,0 +1,483 @@
+// Package visits は停車地ごとの到着判定の途中経過を stop_visits に保存し、届いた位置ログの分だけ判定を進める
+// 画面は保存済みの判定結果を読むため、表示のたびに運行の全ログを読み直さない
+package visits
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/judge"
+)
+
+// locks は判定の更新をコースごとに直列にする（受信と画面のポーリングが同時に同じコースを更新しないように）
+// 別の案件・運行日・コースの判定は互いに待たない
+var locks = courseLocks{m: map[courseKey]*courseLock{}}
+
+type courseKey struct {
+	projectID     int64
+	operatingDate string
+	courseName    string
+}
+
+type courseLock struct {
+	mu   sync.Mutex
+	refs int // 使用中・待機中の数（0になったら map から消す）
+}
+
+type courseLocks struct {
+	mu sync.Mutex
+	m  map[courseKey]*courseLock
+}
+
+// lock はコースの判定の更新を始める。返り値の関数で終える
+func (l *courseLocks) lock(sc Scope) (unlock func()) {
+	key := courseKey{projectID: sc.Project.ID, operatingDate: sc.OperatingDate, courseName: sc.CourseName}
+
+	l.mu.Lock()
+	cl, ok := l.m[key]
+	if !ok {
+		cl = &courseLock{}
+		l.m[key] = cl
+	}
+	cl.refs++
+	l.mu.Unlock()
+
+	cl.mu.Lock()
+	return func() {
+		cl.mu.Unlock()
+		l.mu.Lock()
+		cl.refs--
+		if cl.refs == 0 {
+			delete(l.m, key)
+		}
+		l.mu.Unlock()
+	}
+}
+
+// errConflict は判定を読み込んでから保存するまでに、ほかの処理（別のプロセスの cmd/rebuild-visits など）が同じ運行の判定を保存したこと
+var errConflict = errors.New("ほかの処理が同時に判定を更新しました")
+
+// maxAttempts は保存が競合したときに読み込みからやり直す回数
+const maxAttempts = 3
+
+// retryOnConflict は保存が競合したら読み込みからやり直す
+// locks は同じプロセス内でしか効かないため、プロセスをまたぐ競合は進み具合の版で見つける
+func retryOnConflict(fn func() (*judge.Result, error)) (*judge.Result, error) {
+	for attempt := 1; ; attempt++ {
+		result, err := fn()
+		if !errors.Is(err, errConflict) || attempt == maxAttempts {
+			return result, err
+		}
+	}
+}
+
+// Store は判定の途中経過の保存先
+type Store struct {
+	DB   *database.Queries
+	Conn *sql.DB // トランザクション用
+}
+
+// NewStore は新しいStoreを作成する
+func NewStore(db *database.Queries, conn *sql.DB) *Store {
+	return &Store{DB: db, Conn: conn}
+}
+
+// 手動での指定の状態（stop_overrides.status）
+const (
+	StatusArrived    = "arrived"     // 到着済み（到着・出発時刻を指定）
+	StatusNotArrived = "not_arrived" // 判定にかかわらず未到着
+)
+
+// Scope は判定の単位（コースの1運行分）
+type Scope struct {
+	Project       database.Project
+	OperatingDate string
+	CourseName    string
+	Run           *database.CourseRun // nil なら運行日の全ログ
+}
+
+// RunID は判定の保存先の運行ID（運行がなければ0）
+func (sc Scope) RunID() int64 {
+	if sc.Run == nil {
+		return 0
+	}
+	return sc.Run.ID
+}
+
+// Refresh は前回の判定以降に届いたログで判定を進め、現在の判定結果（手動での指定を反映したもの）を返す
+// 判定設定・停車地の座標・運行期間が変わっていたり、過去の時刻のログが後から届いたりした場合は最初から判定し直す
+func (s *Store) Refresh(ctx context.Context, sc Scope, stops []database.RouteStop) (*judge.Result, error) {
+	return s.refresh(ctx, sc, stops, true)
+}
+
+// Load は保存済みの判定結果を返す（新しく届いたログでは判定を進めない）
+// 位置ログの受信のたびに判定を進めているため、定期的な確認では保存済みの結果で足りる。保存済みの判定が使えなければ判定し直す
+func (s *Store) Load(ctx context.Context, sc Scope, stops []database.RouteStop) (*judge.Result, error) {
+	return s.refresh(ctx, sc, stops, false)
+}
+
+// refresh は保存済みの判定を読み込み、scan なら前回の判定以降に届いたログで判定を進める
+func (s *Store) refresh(ctx context.Context, sc Scope, stops []database.RouteStop, scan bool) (*judge.Result, error) {
+	defer locks.lock(sc)()
+	return retryOnConflict(func() (*judge.Result, error) {
+		return s.refreshOnce(ctx, sc, stops, scan)
+	})
+}
+
+func (s *Store) refreshOnce(ctx context.Context, sc Scope, stops []database.RouteStop, scan bool) (*judge.Result, error) {
+	overrides, err := s.overrides(ctx, sc)
+	if err != nil {
+		return nil, err
+	}
+	settings := judge.SettingsFromProject(sc.Project)
+	fp := fingerprint(settings, stops, sc.Run, overrides)
+
+	progress, err := s.DB.GetStopVisitProgress(ctx, database.GetStopVisitProgressParams{
+		ProjectID:     sc.Project.ID,
+		OperatingDate: sc.OperatingDate,
+		CourseName:    sc.CourseName,
+		CourseRunID:   sc.RunID(),
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return s.rebuild(ctx, sc, stops, settings, overrides, fp, 0)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("判定の進み具合の取得に失敗: %w", err)
+	}
+	if progress.Fingerprint != fp {
+		return s.rebuild(ctx, sc, stops, settings, overrides, fp, progress.Version)
+	}
+
+	// 最後に判定したログ（速度の計算と現在位置に使う）。ログが削除されていれば判定し直す
+	var last *database.LocationLog
+	if progress.LatestLogID.Valid {
+		log, err := s.DB.GetLocationLog(ctx, progress.LatestLogID.Int64)
+		if errors.Is(err, sql.ErrNoRows) {
+			return s.rebuild(ctx, sc, stops, settings, overrides, fp, progress.Version)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("位置ログの取得に失敗: %w", err)
+		}
+		last = &log
+	}
+
+	rows, err := s.DB.ListStopVisits(ctx, database.ListStopVisitsParams{
+		ProjectID:     sc.Project.ID,
+		OperatingDate: sc.OperatingDate,
+		CourseName:    sc.CourseName,
+		CourseRunID:   sc.RunID(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("判定の途中経過の取得に失敗: %w", err)
+	}
+	visits := make([]judge.Visit, 0, len(rows))
+	for _, row := range rows {
+		visits = append(visits, judge.Visit{
+			StopID:        row.RouteStopID,
+			EnteredAt:     fromNullTime(row.EnteredAt),
+			StayStartAt:   fromNullTime(row.StayStartAt),
+			ArrivedAt:     fromNullTime(row.ArrivedAt),
+			DepartedAt:    fromNullTime(row.DepartedAt),
+			InRangeSince:  fromNullTime(row.InRangeSince),
+			PendingSince:  fromNullTime(row.PendingSince),
+			PendingWeight: row.PendingWeight,
+		})
+	}
+	tracker := judge.NewTracker(stops, settings, visits, last)
+	tracker.SetOverrides(overrides)
+	if !scan {
+		return tracker.Result(), nil
+	}
+
+	newLogs, err := s.DB.ListLocationLogsByCourseAfterID(ctx, database.ListLocationLogsByCourseAfterIDParams{
+		ProjectID:     sc.Project.ID,
+		OperatingDate: sc.OperatingDate,
+		CourseName:    sc.CourseName,
+		ID:            progress.ScannedLogID,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("位置ログの取得に失敗: %w", err)
+	}
+	if len(newLogs) == 0 {
+		return tracker.Result(), nil
+	}
+
+	scanned := progress.ScannedLogID
+	for _, log := range newLogs {
+		scanned = max(scanned, log.ID)
+	}
+	for _, log := range FilterRunLogs(newLogs, sc.Run) {
+		if prev := tracker.Last(); prev != nil && log.Timestamp.Before(prev.Timestamp) {
+			// 判定済みのログより前の時刻のログ（端末に溜まっていた分など）は順番どおりに判定し直す
+			return s.rebuild(ctx, sc, stops, settings, overrides, fp, progress.Version)
+		}
+		tracker.Add(log)
+	}
+
+	if err := s.save(ctx, sc, tracker, scanned, fp, progress.Version, false); err != nil {
+		return nil, err
+	}
+	return tracker.Result(), nil
+}
+
+// Rebuild は運行のすべてのログで判定し直して保存する（判定設定を変えた後などに使う）
+// サーバーの稼働中に別のプロセスから呼んでも、受信での判定の更新と互いの保存を上書きしない
+func (s *Store) Rebuild(ctx context.Context, sc Scope, stops []database.RouteStop) (*judge.Result, error) {
+	defer locks.lock(sc)()
+	return retryOnConflict(func() (*judge.Result, error) {
+		// 保存時に変わっていないことを確かめるため、ログより先に進み具合の版を読む
+		var version int64
+		progress, err := s.DB.GetStopVisitProgress(ctx, database.GetStopVisitProgressParams{
+			ProjectID:     sc.Project.ID,
+			OperatingDate: sc.OperatingDate,
+			CourseName:    sc.CourseName,
+			CourseRunID:   sc.RunID(),
+		})
+		switch {
+		case err == nil:
+			version = progress.Version
+		case !errors.Is(err, sql.ErrNoRows):
+			return nil, fmt.Errorf("判定の進み具合の取得に失敗: %w", err)
+		}
+
+		overrides, err := s.overrides(ctx, sc)
+		if err != nil {
+			return nil, err
+		}
+		settings := judge.SettingsFromProject(sc.Project)
+		return s.rebuild(ctx, sc, stops, settings, overrides, fingerprint(settings, stops, sc.Run, overrides), version)
+	})
+}
+
+// overrides は運行の停車地の到着・出発の手動での指定を読み込む（判定はこの指定をふまえて進める）
+func (s *Store) overrides(ctx context.Context, sc Scope) ([]judge.Override, error) {
+	rows, err := s.DB.ListStopOverrides(ctx, database.ListStopOverridesParams{
+		ProjectID:     sc.Project.ID,
+		OperatingDate: sc.OperatingDate,
+		CourseName:    sc.CourseName,
+		CourseRunID:   sc.RunID(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("到着・出発の手動での指定の取得に失敗: %w", err)
+	}
+	overrides := make([]judge.Override, 0, len(rows))
+	for _, row := range rows {
+		overrides = append(overrides, toOverride(row))
+	}
+	return overrides, nil
+}
+
+// toOverride は保存済みの手動での指定を判定に渡す形にする
+func toOverride(row database.StopOverride) judge.Override {
+	o := judge.Override{StopID: row.RouteStopID, Arrived: row.Status == StatusArrived}
+	if o.Arrived {
+		o.ArrivalTime = fromNullTime(row.ArrivedAt)
+		o.DepartureTime = fromNullTime(row.DepartedAt)
+	}
+	return o
+}
+
+// rebuild は運行のすべてのログで判定し直す（version は読み込んだ進み具合の版、0は進み具合がなかったこと）
+func (s *Store) rebuild(ctx context.Context, sc Scope, stops []database.RouteStop, settings judge.Settings, overrides []judge.Override, fp string, version int64) (*judge.Result, error) {
+	logs, err := ListRunLogs(ctx, s.DB, sc.Project.ID, sc.OperatingDate, sc.CourseName, nil)
+	if err != nil {
+		return nil, fmt.Errorf("位置ログの取得に失敗: %w", err)
+	}
+	var scanned int64
+	for _, log := range logs {
+		scanned = max(scanned, log.ID)
+	}
+
+	tracker := judge.NewTracker(stops, settings, nil, nil)
+	tracker.SetOverrides(overrides)
+	for _, log := range FilterRunLogs(logs, sc.Run) {
+		tracker.Add(log)
+	}
+
+	if err := s.save(ctx, sc, tracker, scanned, fp, version, true); err != nil {
+		return nil, err
+	}
+	return tracker.Result(), nil
+}
+
+// save は判定の途中経過と進み具合を保存する（replace なら運行の保存済みの判定を消してから保存する）
+// 進み具合の版が version から変わっていれば（version が0なら進み具合が作られていれば）何も保存せず errConflict を返す
+func (s *Store) save(ctx context.Context, sc Scope, tracker *judge.Tracker, scanned int64, fp string, version int64, replace bool) error {
+	tx, err := s.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("トランザクション開始失敗: %w", err)
+	}
+	defer tx.Rollback()
+	qtx := s.DB.WithTx(tx)
+
+	// 進み具合を最初に書き込み、ほかの処理の保存をトランザクションの終わりまで待たせる
+	var latestID sql.NullInt64
+	if last := tracker.Last(); last != nil {
+		latestID = sql.NullInt64{Int64: last.ID, Valid: true}
+	}
+	var saved int64
+	if version == 0 {
+		saved, err = qtx.CreateStopVisitProgress(ctx, database.CreateStopVisitProgressParams{
+			ProjectID:     sc.Project.ID,
+			OperatingDate: sc.OperatingDate,
+			CourseName:    sc.CourseName,
+			CourseRunID:   sc.RunID(),
+			ScannedLogID:  scanned,
+			LatestLogID:   latestID,
+			Fingerprint:   fp,
+		})
+	} else {
+		saved, err = qtx.UpdateStopVisitProgress(ctx, database.UpdateStopVisitProgressParams{
+			ScannedLogID:  scanned,
+			LatestLogID:   latestID,
+			Fingerprint:   fp,
+			ProjectID:     sc.Project.ID,
+			OperatingDate: sc.OperatingDate,
+			CourseName:    sc.CourseName,
+			CourseRunID:   sc.RunID(),
+			Version:       version,
+		})
+	}
+	if err != nil {
+		return fmt.Errorf("判定の進み具合の保存に失敗: %w", err)
+	}
+	if saved == 0 {
+		return errConflict
+	}
+
+	if replace {
+		if err := qtx.DeleteStopVisits(ctx, database.DeleteStopVisitsParams{
+			ProjectID:     sc.Project.ID,
+			OperatingDate: sc.OperatingDate,
+			CourseName:    sc.CourseName,
+			CourseRunID:   sc.RunID(),
+		}); err != nil {
+			return fmt.Errorf("判定の削除に失敗: %w", err)
+		}
+	}
+
+	for _, v := range tracker.Visits() {
+		if err := qtx.UpsertStopVisit(ctx, database.UpsertStopVisitParams{
+			ProjectID:     sc.Project.ID,
+			OperatingDate: sc.OperatingDate,
+			CourseName:    sc.CourseName,
+			CourseRunID:   sc.RunID(),
+			RouteStopID:   v.StopID,
+			EnteredAt:     toNullTime(v.EnteredAt),
+			StayStartAt:   toNullTime(v.StayStartAt),
+			ArrivedAt:     toNullTime(v.ArrivedAt),
+			DepartedAt:    toNullTime(v.DepartedAt),
+			InRangeSince:  toNullTime(v.InRangeSince),
+			PendingSince:  toNullTime(v.PendingSince),
+			PendingWeight: v.PendingWeight,
+		}); err != nil {
+			return fmt.Errorf("判定の保存に失敗: %w", err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// ListRunLogs は運行期間内の位置情報ログを昇順で返す
+// run が nil の場合は運行日の全ログを返す
+func ListRunLogs(ctx context.Context, q *database.Queries, projectID int64, operatingDate, courseName string, run *database.CourseRun) ([]database.LocationLog, error) {
+	logs, err := q.ListLocationLogsByCourse(ctx, database.ListLocationLogsByCourseParams{
+		ProjectID:     projectID,
+		OperatingDate: operatingDate,
+		CourseName:    courseName,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return FilterRunLogs(logs, run), nil
+}
+
+// ListRecentRunLogs は運行期間内の直近に受信した limit 件までのログを時刻の昇順で返す（定期的な確認で全ログを読まないため）
+func ListRecentRunLogs(ctx context.Context, q *database.Queries, projectID int64, operatingDate, courseName string, run *database.CourseRun, limit int64) ([]database.LocationLog, error) {
+	logs, err := q.ListRecentLocationLogsByCourse(ctx, database.ListRecentLocationLogsByCourseParams{
+		ProjectID:     projectID,
+		OperatingDate: operatingDate,
+		CourseName:    courseName,
+		Limit:         limit,
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(logs, func(i, j int) bool {
+		if logs[i].Timestamp.Equal(logs[j].Timestamp) {
+			return logs[i].ID < logs[j].ID
+		}
+		return logs[i].Timestamp.Before(logs[j].Timestamp)
+	})
+	return FilterRunLogs(logs, run), nil
+}
+
+// FilterRunLogs は運行期間内のログだけを返す（run が nil ならそのまま）
+// タイムスタンプは端末ごとにタイムゾーン表記が異なるため、期間の絞り込みはGo側で行う
+func FilterRunLogs(logs []database.LocationLog, run *database.CourseRun) []database.LocationLog {
+	if run == nil {
+		return logs
+	}
+	filtered := make([]database.LocationLog, 0, len(logs))
+	for _, log := range logs {
+		if log.Timestamp.Before(run.StartedAt) {
+			continue
+		}
+		if run.EndedAt.Valid && !log.Timestamp.Before(run.EndedAt.Time) {
+			continue
+		}
+		filtered = append(filtered, log)
+	}
+	return filtered
+}
+
+// fingerprint は判定結果を左右する設定（判定設定・停車地の座標と範囲・エリア・滞在予定・運行期間・手動での指定）から求めた値
+// 手動での指定は順番を考慮した判定に影響するため、指定を変えたら判定し直す
+func fingerprint(settings judge.Settings, stops []database.RouteStop, run *database.CourseRun, overrides []judge.Override) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "settings:%d,%d,%g,%d\n", settings.ThresholdM, settings.StayMinutes, settings.SpeedLimitKmh, settings.SequenceWindow)
+	fmt.Fprintf(h, "fixes:%g,%q,%d,%d,%v\n", settings.MaxAccuracyM, settings.AccuracyMode, settings.MinFixes, settings.ExitMarginM, settings.UseBearing)
+	for _, stop := range stops {
+		fmt.Fprintf(h, "stop:%d,%v,%g,%v,%g,%v,%d,%q,%d\n", stop.ID,
+			stop.Latitude.Valid, stop.Latitude.Float64, stop.Longitude.Valid, stop.Longitude.Float64,
+			stop.GeofenceRadiusM.Valid, stop.GeofenceRadiusM.Int64, stop.GeofencePolygon.String, stop.StayMinutes.Int64)
+	}
+	if run != nil {
+		fmt.Fprintf(h, "run:%d,%d,%v,%d\n", run.ID, run.StartedAt.Unix(), run.EndedAt.Valid, run.EndedAt.Time.Unix())
+	}
+	for _, o := range overrides {
+		fmt.Fprintf(h, "override:%d,%v,%s,%s\n", o.StopID, o.Arrived, clockKey(o.ArrivalTime), clockKey(o.DepartureTime))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// clockKey は指紋に含める時刻（nilは空）
+func clockKey(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return strconv.FormatInt(t.Unix(), 10)
+}
+
+func toNullTime(t *time.Time) sql.NullTime {
+	if t == nil {
+		return sql.NullTime{}
+	}
+	return sql.NullTime{Time: *t, Valid: true}
+}
+
+func fromNullTime(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
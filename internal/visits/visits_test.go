@@ -0,0 +1,171 @@
+package visits
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/naozine/project_crud_with_auth_tmpl/db"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/database"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/judge"
+	"github.com/pressly/goose/v3"
+	_ "modernc.org/sqlite"
+)
+
+// jst は位置ログの時刻に使う日本標準時
+var jst = time.FixedZone("Asia/Tokyo", 9*60*60)
+
+// openDB は path のDBに接続する（サーバーと cmd/rebuild-visits は同じDBファイルに別々に接続する）
+func openDB(t *testing.T, path string) *sql.DB {
+	t.Helper()
+	conn, err := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_pragma=foreign_keys(on)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// fixture は1地点のコースと、その地点に停車した位置ログ
+type fixture struct {
+	path  string
+	conn  *sql.DB
+	scope Scope
+	stops []database.RouteStop
+}
+
+func newFixture(t *testing.T) fixture {
+	t.Helper()
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "app.db")
+	conn := openDB(t, path)
+	goose.SetBaseFS(db.MigrationsFS)
+	goose.SetLogger(goose.NopLogger())
+	if err := goose.SetDialect("sqlite3"); err != nil {
+		t.Fatal(err)
+	}
+	if err := goose.Up(conn, "migrations"); err != nil {
+		t.Fatal(err)
+	}
+
+	q := database.New(conn)
+	project, err := q.CreateProject(ctx, database.CreateProjectParams{
+		Name:                   "テスト",
+		ApiKey:                 "test",
+		ArrivalThresholdMeters: sql.NullInt64{Int64: 100, Valid: true},
+		JudgeStayTimeMinutes:   sql.NullInt64{Int64: 0, Valid: true},
+		JudgeSpeedLimitKmh:     sql.NullFloat64{Float64: 0, Valid: true},
+		JudgeAccuracyMode:      judge.AccuracyDiscard,
+		JudgeMinFixes:          1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.CreateRouteStop(ctx, database.CreateRouteStopParams{
+		ProjectID:     project.ID,
+		OperatingDate: "2025-12-01",
+		CourseName:    "A便",
+		Sequence:      "1",
+		SequenceNo:    1,
+		StopName:      "倉庫",
+		Latitude:      sql.NullFloat64{Float64: 35.6812, Valid: true},
+		Longitude:     sql.NullFloat64{Float64: 139.7671, Valid: true},
+		LoadDirection: "load",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	stops, err := q.ListRouteStopsByProjectDate(ctx, database.ListRouteStopsByProjectDateParams{ProjectID: project.ID, OperatingDate: "2025-12-01"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := fixture{
+		path:  path,
+		conn:  conn,
+		scope: Scope{Project: project, OperatingDate: "2025-12-01", CourseName: "A便"},
+		stops: stops,
+	}
+	for i := 0; i < 3; i++ {
+		f.addLog(t, time.Date(2025, 12, 1, 8, 0, i*10, 0, jst))
+	}
+	return f
+}
+
+// addLog は停車地に停まっている位置ログを1件受信する
+func (f fixture) addLog(t *testing.T, ts time.Time) {
+	t.Helper()
+	if err := database.New(f.conn).CreateLocationLog(context.Background(), database.CreateLocationLogParams{
+		ProjectID:     f.scope.Project.ID,
+		OperatingDate: f.scope.OperatingDate,
+		CourseName:    f.scope.CourseName,
+		Latitude:      35.6812,
+		Longitude:     139.7671,
+		Timestamp:     ts.UTC(),
+		Speed:         sql.NullFloat64{Float64: 0, Valid: true},
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (f fixture) progress(t *testing.T) database.StopVisitProgress {
+	t.Helper()
+	progress, err := database.New(f.conn).GetStopVisitProgress(context.Background(), database.GetStopVisitProgressParams{
+		ProjectID:     f.scope.Project.ID,
+		OperatingDate: f.scope.OperatingDate,
+		CourseName:    f.scope.CourseName,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return progress
+}
+
+func TestSaveConflict(t *testing.T) {
+	ctx := context.Background()
+	f := newFixture(t)
+	server := NewStore(database.New(f.conn), f.conn)
+	// cmd/rebuild-visits は別のプロセスなので、同じDBファイルに別に接続する
+	other := openDB(t, f.path)
+	rebuilder := NewStore(database.New(other), other)
+
+	if _, err := server.Refresh(ctx, f.scope, f.stops); err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+	read := f.progress(t)
+
+	// 受信での判定が進み具合を読んだ後に、別のプロセスが判定し直して保存した
+	if _, err := rebuilder.Rebuild(ctx, f.scope, f.stops); err != nil {
+		t.Fatalf("Rebuild: %v", err)
+	}
+	rebuilt := f.progress(t)
+	if rebuilt.Version != read.Version+1 {
+		t.Fatalf("判定し直した後の版 = %d, want %d", rebuilt.Version, read.Version+1)
+	}
+
+	// 読み込んだ版で保存しようとすると競合になり、何も保存しない
+	tracker := judge.NewTracker(f.stops, judge.SettingsFromProject(f.scope.Project), nil, nil)
+	if err := server.save(ctx, f.scope, tracker, 0, "stale", read.Version, false); !errors.Is(err, errConflict) {
+		t.Fatalf("古い版での保存 = %v, want errConflict", err)
+	}
+	if err := server.save(ctx, f.scope, tracker, 0, "stale", 0, true); !errors.Is(err, errConflict) {
+		t.Fatalf("進み具合がないと読んだ後の保存 = %v, want errConflict", err)
+	}
+	if got := f.progress(t); got.Version != rebuilt.Version || got.Fingerprint != rebuilt.Fingerprint || got.ScannedLogID != rebuilt.ScannedLogID {
+		t.Errorf("競合した保存で進み具合が変わった: %+v, want %+v", got, rebuilt)
+	}
+
+	// 次に届いたログは最新の版から判定を進める
+	f.addLog(t, time.Date(2025, 12, 1, 8, 0, 30, 0, jst))
+	result, err := server.Refresh(ctx, f.scope, f.stops)
+	if err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+	if timing := result.Timings[f.stops[0].ID]; timing == nil || !timing.Arrived {
+		t.Errorf("停車地の判定 = %+v, want 到着", timing)
+	}
+	if got := f.progress(t); got.Version != rebuilt.Version+1 || got.ScannedLogID != 4 {
+		t.Errorf("進み具合 = 版 %d・判定済み %d, want 版 %d・判定済み 4", got.Version, got.ScannedLogID, rebuilt.Version+1)
+	}
+}
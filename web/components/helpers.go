@@ -1,59 +1,25 @@
 package components
 
 import (
+	"database/sql"
 	"fmt"
 	"strconv"
 	"strings"
 	"time"
-)
-
-// StopTiming は停車地の到着・出発時刻（動的計算結果）
-type StopTiming struct {
-	StopID        int64
-	Arrived       bool       // 到着したか
-	ArrivalTime   *time.Time // エリアに入った時刻
-	DepartureTime *time.Time // エリアを出た時刻（nilなら滞在中）
-}
-
-// ArrivalTimeStr は到着時刻を "HH:MM" 形式で返す
-func (t *StopTiming) ArrivalTimeStr() string {
-	if t.ArrivalTime == nil {
-		return ""
-	}
-	jst := time.FixedZone("Asia/Tokyo", 9*60*60)
-	return t.ArrivalTime.In(jst).Format("15:04")
-}
 
-// DepartureTimeStr は出発時刻を "HH:MM" 形式で返す
-func (t *StopTiming) DepartureTimeStr() string {
-	if t.DepartureTime == nil {
-		return ""
-	}
-	jst := time.FixedZone("Asia/Tokyo", 9*60*60)
-	return t.DepartureTime.In(jst).Format("15:04")
-}
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/eta"
+	"github.com/naozine/project_crud_with_auth_tmpl/internal/judge"
+)
 
-// StayDurationStr は滞在時間を "X分" 形式で返す
-// 出発済みなら確定値、滞在中なら現在時刻までの暫定値を表示
-func (t *StopTiming) StayDurationStr() string {
-	if t.ArrivalTime == nil {
-		return "-"
-	}
-	var endTime time.Time
-	if t.DepartureTime != nil {
-		endTime = *t.DepartureTime
-	} else {
-		// 滞在中: 現在時刻までの滞在時間を表示
-		endTime = time.Now()
-	}
-	duration := endTime.Sub(*t.ArrivalTime)
-	return fmt.Sprintf("%d分", int(duration.Minutes()))
-}
+// 到着判定の結果は internal/judge の型をそのまま表示に使う
+type (
+	StopTiming          = judge.StopTiming
+	CurrentLocationInfo = judge.CurrentLocationInfo
+	TruckStatusInfo     = judge.TruckStatusInfo
+)
 
-// IsStaying は現在滞在中かどうかを返す
-func (t *StopTiming) IsStaying() bool {
-	return t.Arrived && t.DepartureTime == nil
-}
+// 到着予測は internal/eta の型をそのまま表示に使う
+type ArrivalPrediction = eta.Prediction
 
 // CalculateStayDuration は到着時刻と出発時刻の差分（分）を計算します
 // timeStr1: 到着時刻 (HH:MM)
@@ -96,3 +62,24 @@ func parseTimeToMinutes(timeStr string) (int, error) {
 	}
 	return hours*60 + minutes, nil
 }
+
+// plannedDayPrefix は到着予定日時が運行日の翌日以降の場合に「翌」などの接頭辞を返す（日付をまたぐ運行の表示用）
+func plannedDayPrefix(operatingDate string, plannedAt sql.NullTime) string {
+	if !plannedAt.Valid {
+		return ""
+	}
+	base, err := time.ParseInLocation("2006-01-02", operatingDate, JST)
+	if err != nil {
+		return ""
+	}
+	planned := plannedAt.Time.In(JST)
+	day := time.Date(planned.Year(), planned.Month(), planned.Day(), 0, 0, 0, 0, JST)
+	switch days := int(day.Sub(base).Hours() / 24); {
+	case days <= 0:
+		return ""
+	case days == 1:
+		return "翌"
+	default:
+		return fmt.Sprintf("%d日後 ", days)
+	}
+}